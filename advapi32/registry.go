@@ -0,0 +1,276 @@
+package advapi32
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// predefined keys
+const (
+	HKEY_CLASSES_ROOT   = syscall.Handle(0x80000000)
+	HKEY_CURRENT_USER   = syscall.Handle(0x80000001)
+	HKEY_LOCAL_MACHINE  = syscall.Handle(0x80000002)
+	HKEY_USERS          = syscall.Handle(0x80000003)
+	HKEY_CURRENT_CONFIG = syscall.Handle(0x80000005)
+)
+
+// access rights
+const (
+	KEY_QUERY_VALUE        = 0x0001
+	KEY_SET_VALUE          = 0x0002
+	KEY_CREATE_SUB_KEY     = 0x0004
+	KEY_ENUMERATE_SUB_KEYS = 0x0008
+	KEY_WOW64_64KEY        = 0x0100
+	KEY_WOW64_32KEY        = 0x0200
+	KEY_READ               = 0x20019
+	KEY_WRITE              = 0x20006
+	KEY_ALL_ACCESS         = 0xF003F
+)
+
+// value types
+const (
+	REG_NONE      = 0
+	REG_SZ        = 1
+	REG_EXPAND_SZ = 2
+	REG_BINARY    = 3
+	REG_DWORD     = 4
+	REG_MULTI_SZ  = 7
+	REG_QWORD     = 11
+)
+
+const (
+	REG_OPTION_NON_VOLATILE = 0x00000000
+
+	ERROR_FILE_NOT_FOUND = syscall.Errno(2)
+	ERROR_MORE_DATA      = syscall.Errno(234)
+	ERROR_NO_MORE_ITEMS  = syscall.Errno(259)
+
+	// longest key name, in characters, plus terminator
+	maxKeyName = 256
+)
+
+var (
+	advRegCloseKey     = advapi32.NewProc("RegCloseKey")
+	advRegCreateKeyEx  = advapi32.NewProc("RegCreateKeyExW")
+	advRegDeleteKeyEx  = advapi32.NewProc("RegDeleteKeyExW")
+	advRegDeleteValue  = advapi32.NewProc("RegDeleteValueW")
+	advRegEnumKeyEx    = advapi32.NewProc("RegEnumKeyExW")
+	advRegOpenKeyEx    = advapi32.NewProc("RegOpenKeyExW")
+	advRegQueryValueEx = advapi32.NewProc("RegQueryValueExW")
+	advRegSetValueEx   = advapi32.NewProc("RegSetValueExW")
+)
+
+// The Reg* functions return their error code rather than setting the last
+// error.
+func regError(ret uintptr) error {
+	if ret != 0 {
+		return syscall.Errno(ret)
+	}
+
+	return nil
+}
+
+// LSTATUS RegCloseKey(
+//   _In_ HKEY hKey
+// );
+// fail != ERROR_SUCCESS
+func RegCloseKey(key syscall.Handle) error {
+	ret, _, _ := advRegCloseKey.Call(uintptr(key))
+	return regError(ret)
+}
+
+// LSTATUS RegCreateKeyExW(
+//   _In_       HKEY                        hKey,
+//   _In_       LPCWSTR                     lpSubKey,
+//   _Reserved_ DWORD                       Reserved,
+//   _In_opt_   LPWSTR                      lpClass,
+//   _In_       DWORD                       dwOptions,
+//   _In_       REGSAM                      samDesired,
+//   _In_opt_   const LPSECURITY_ATTRIBUTES lpSecurityAttributes,
+//   _Out_      PHKEY                       phkResult,
+//   _Out_opt_  LPDWORD                     lpdwDisposition
+// );
+// fail != ERROR_SUCCESS
+func RegCreateKeyEx(key syscall.Handle, subKey string, access uint32) (syscall.Handle, error) {
+	var result syscall.Handle
+
+	ret, _, _ := advRegCreateKeyEx.Call(
+		uintptr(key),
+		uintptr(unsafe.Pointer(syscall.StringToUTF16Ptr(subKey))),
+		0,
+		0,
+		uintptr(REG_OPTION_NON_VOLATILE),
+		uintptr(access),
+		0,
+		uintptr(unsafe.Pointer(&result)),
+		0,
+	)
+
+	if err := regError(ret); err != nil {
+		return 0, err
+	}
+
+	return result, nil
+}
+
+// LSTATUS RegDeleteKeyExW(
+//   _In_       HKEY    hKey,
+//   _In_       LPCWSTR lpSubKey,
+//   _In_       REGSAM  samDesired,
+//   _Reserved_ DWORD   Reserved
+// );
+// fail != ERROR_SUCCESS
+func RegDeleteKeyEx(key syscall.Handle, subKey string, access uint32) error {
+	ret, _, _ := advRegDeleteKeyEx.Call(
+		uintptr(key),
+		uintptr(unsafe.Pointer(syscall.StringToUTF16Ptr(subKey))),
+		uintptr(access),
+		0,
+	)
+
+	return regError(ret)
+}
+
+// LSTATUS RegDeleteValueW(
+//   _In_     HKEY    hKey,
+//   _In_opt_ LPCWSTR lpValueName
+// );
+// fail != ERROR_SUCCESS
+func RegDeleteValue(key syscall.Handle, name string) error {
+	ret, _, _ := advRegDeleteValue.Call(
+		uintptr(key),
+		uintptr(unsafe.Pointer(syscall.StringToUTF16Ptr(name))),
+	)
+
+	return regError(ret)
+}
+
+// LSTATUS RegEnumKeyExW(
+//   _In_        HKEY      hKey,
+//   _In_        DWORD     dwIndex,
+//   _Out_       LPWSTR    lpName,
+//   _Inout_     LPDWORD   lpcchName,
+//   _Reserved_  LPDWORD   lpReserved,
+//   _Inout_opt_ LPWSTR    lpClass,
+//   _Inout_opt_ LPDWORD   lpcchClass,
+//   _Out_opt_   PFILETIME lpftLastWriteTime
+// );
+// fail != ERROR_SUCCESS
+//
+// RegEnumKeyEx returns the name of the subkey at index, or
+// ERROR_NO_MORE_ITEMS past the last one.
+func RegEnumKeyEx(key syscall.Handle, index uint32) (string, error) {
+	var name [maxKeyName]uint16
+	size := uint32(len(name))
+
+	ret, _, _ := advRegEnumKeyEx.Call(
+		uintptr(key),
+		uintptr(index),
+		uintptr(unsafe.Pointer(&name[0])),
+		uintptr(unsafe.Pointer(&size)),
+		0,
+		0,
+		0,
+		0,
+	)
+
+	if err := regError(ret); err != nil {
+		return "", err
+	}
+
+	return syscall.UTF16ToString(name[:size]), nil
+}
+
+// LSTATUS RegOpenKeyExW(
+//   _In_     HKEY    hKey,
+//   _In_opt_ LPCWSTR lpSubKey,
+//   _In_     DWORD   ulOptions,
+//   _In_     REGSAM  samDesired,
+//   _Out_    PHKEY   phkResult
+// );
+// fail != ERROR_SUCCESS
+func RegOpenKeyEx(key syscall.Handle, subKey string, access uint32) (syscall.Handle, error) {
+	var result syscall.Handle
+
+	ret, _, _ := advRegOpenKeyEx.Call(
+		uintptr(key),
+		uintptr(unsafe.Pointer(syscall.StringToUTF16Ptr(subKey))),
+		0,
+		uintptr(access),
+		uintptr(unsafe.Pointer(&result)),
+	)
+
+	if err := regError(ret); err != nil {
+		return 0, err
+	}
+
+	return result, nil
+}
+
+// LSTATUS RegQueryValueExW(
+//   _In_        HKEY    hKey,
+//   _In_opt_    LPCWSTR lpValueName,
+//   _Reserved_  LPDWORD lpReserved,
+//   _Out_opt_   LPDWORD lpType,
+//   _Out_opt_   LPBYTE  lpData,
+//   _Inout_opt_ LPDWORD lpcbData
+// );
+// fail != ERROR_SUCCESS
+//
+// RegQueryValueEx returns the type and raw data of a value.
+func RegQueryValueEx(key syscall.Handle, name string) (uint32, []byte, error) {
+	namePtr := syscall.StringToUTF16Ptr(name)
+	data := make([]byte, 64)
+
+	for {
+		var valType uint32
+		size := uint32(len(data))
+
+		ret, _, _ := advRegQueryValueEx.Call(
+			uintptr(key),
+			uintptr(unsafe.Pointer(namePtr)),
+			0,
+			uintptr(unsafe.Pointer(&valType)),
+			uintptr(unsafe.Pointer(&data[0])),
+			uintptr(unsafe.Pointer(&size)),
+		)
+
+		if syscall.Errno(ret) == ERROR_MORE_DATA {
+			data = make([]byte, size)
+			continue
+		}
+
+		if err := regError(ret); err != nil {
+			return 0, nil, err
+		}
+
+		return valType, data[:size], nil
+	}
+}
+
+// LSTATUS RegSetValueExW(
+//   _In_       HKEY       hKey,
+//   _In_opt_   LPCWSTR    lpValueName,
+//   _Reserved_ DWORD      Reserved,
+//   _In_       DWORD      dwType,
+//   _In_opt_   const BYTE *lpData,
+//   _In_       DWORD      cbData
+// );
+// fail != ERROR_SUCCESS
+func RegSetValueEx(key syscall.Handle, name string, valType uint32, data []byte) error {
+	var dataPtr *byte
+	if len(data) > 0 {
+		dataPtr = &data[0]
+	}
+
+	ret, _, _ := advRegSetValueEx.Call(
+		uintptr(key),
+		uintptr(unsafe.Pointer(syscall.StringToUTF16Ptr(name))),
+		0,
+		uintptr(valType),
+		uintptr(unsafe.Pointer(dataPtr)),
+		uintptr(len(data)),
+	)
+
+	return regError(ret)
+}
@@ -0,0 +1,81 @@
+package advapi32
+
+import (
+	"syscall"
+	"unsafe"
+
+	"github.com/xaevman/win32/kernel32"
+)
+
+const (
+	SDDL_REVISION_1 = 1
+)
+
+var (
+	advConvertStringSecurityDescriptorToSecurityDescriptor = advapi32.NewProc("ConvertStringSecurityDescriptorToSecurityDescriptorW")
+)
+
+// BOOL ConvertStringSecurityDescriptorToSecurityDescriptor(
+//   _In_  LPCTSTR              StringSecurityDescriptor,
+//   _In_  DWORD                StringSDRevision,
+//   _Out_ PSECURITY_DESCRIPTOR *SecurityDescriptor,
+//   _Out_ PULONG               SecurityDescriptorSize
+// );
+// fail == 0
+//
+// The returned descriptor must be freed with kernel32.LocalFree.
+func ConvertStringSecurityDescriptorToSecurityDescriptor(sddl string) (unsafe.Pointer, error) {
+	var sd unsafe.Pointer
+
+	ret, _, err := advConvertStringSecurityDescriptorToSecurityDescriptor.Call(
+		uintptr(unsafe.Pointer(syscall.StringToUTF16Ptr(sddl))),
+		uintptr(SDDL_REVISION_1),
+		uintptr(unsafe.Pointer(&sd)),
+		0,
+	)
+
+	if ret == 0 {
+		return nil, err
+	}
+
+	return sd, nil
+}
+
+// SecurityAttributesFromSDDL builds security attributes from a descriptor
+// in SDDL form, such as "D:(A;;GA;;;SY)(A;;GA;;;BA)(A;;0x100002;;;IU)" to
+// let interactive users wait on and signal an object a service created.
+// Free the result with FreeSecurityAttributes.
+func SecurityAttributesFromSDDL(sddl string, inheritHandle bool) (*syscall.SecurityAttributes, error) {
+	sd, err := ConvertStringSecurityDescriptorToSecurityDescriptor(sddl)
+	if err != nil {
+		return nil, err
+	}
+
+	sa := &syscall.SecurityAttributes{
+		SecurityDescriptor: uintptr(sd),
+	}
+	sa.Length = uint32(unsafe.Sizeof(*sa))
+
+	if inheritHandle {
+		sa.InheritHandle = 1
+	}
+
+	return sa, nil
+}
+
+// FreeSecurityAttributes frees the descriptor of security attributes built
+// by SecurityAttributesFromSDDL.
+func FreeSecurityAttributes(sa *syscall.SecurityAttributes) error {
+	if sa == nil || sa.SecurityDescriptor == 0 {
+		return nil
+	}
+
+	// the descriptor was allocated by the system, so the uintptr field
+	// holds the only reference to it
+	sd := *(*unsafe.Pointer)(unsafe.Pointer(&sa.SecurityDescriptor))
+
+	err := kernel32.LocalFree(sd)
+	sa.SecurityDescriptor = 0
+
+	return err
+}
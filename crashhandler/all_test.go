@@ -0,0 +1,351 @@
+//  ---------------------------------------------------------------------------
+//
+//  all_test.go
+//
+//  Copyright (c) 2016, Jared Chavez.
+//  All rights reserved.
+//
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+//
+//  -----------
+
+package crashhandler
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestMessageRoundTrip(t *testing.T) {
+	messages := []Message{
+		&Register{PID: 42, Annotations: map[string]string{"product": "svc", "version": "1.2"}},
+		&Register{PID: 7},
+		&Registered{HandlerPID: 99},
+		&Crash{Kind: CrashException, ThreadID: 12, ExceptionPointers: 0x7ff6000010, Reason: "EXCEPTION_ACCESS_VIOLATION"},
+		&DumpDone{Path: `C:\dumps\a.dmp`},
+		&DumpDone{Error: "access denied"},
+		&Unregister{},
+		&Error{Text: "bad"},
+	}
+
+	var buf bytes.Buffer
+	for _, m := range messages {
+		if err := WriteMessage(&buf, m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, want := range messages {
+		got, err := ReadMessage(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("got %+v, want %+v", got, want)
+		}
+	}
+
+	if _, err := ReadMessage(&buf); err == nil || err.Error() != "EOF" {
+		t.Errorf("expected EOF, got %v", err)
+	}
+}
+
+func TestReadMessageErrors(t *testing.T) {
+	var frame bytes.Buffer
+	WriteMessage(&frame, &Crash{Kind: CrashGoFatal, Reason: "panic"})
+	good := frame.Bytes()
+
+	corrupt := func(f func(b []byte) []byte) []byte {
+		return f(append([]byte(nil), good...))
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"magic", corrupt(func(b []byte) []byte { b[0] ^= 0xff; return b }), "magic"},
+		{"version", corrupt(func(b []byte) []byte { b[4] = 9; return b }), "version"},
+		{"type", corrupt(func(b []byte) []byte { b[6] = 0x77; return b }), "unknown message"},
+		{"size", corrupt(func(b []byte) []byte { b[11] = 0x10; return b }), "too large"},
+		{"truncated header", good[:5], "truncated"},
+		{"truncated payload", good[:len(good)-2], "payload"},
+		{"string length", corrupt(func(b []byte) []byte { b[len(b)-6] = 0x40; return b }), "decoding"},
+	}
+
+	for _, test := range tests {
+		_, err := ReadMessage(bytes.NewReader(test.data))
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got %v, want error containing %q", test.name, err, test.want)
+		}
+	}
+}
+
+// fakeDumper records the crashes it is asked to dump.
+type fakeDumper struct {
+	crashes []*Crash
+	closed  bool
+	fail    error
+}
+
+func (d *fakeDumper) Dump(crash *Crash) (string, error) {
+	d.crashes = append(d.crashes, crash)
+	if d.fail != nil {
+		return "", d.fail
+	}
+
+	return "dump.dmp", nil
+}
+
+func (d *fakeDumper) Close() error {
+	d.closed = true
+	return nil
+}
+
+// pair connects a client and a session over an in-memory pipe.
+func pair(t *testing.T, dumper *fakeDumper) (*Client, *Session, chan error) {
+	clientConn, serverConn := net.Pipe()
+
+	session := NewSession(func(reg *Register) (Dumper, error) {
+		return dumper, nil
+	})
+	session.HandlerPID = 99
+
+	done := make(chan error, 1)
+	go func() {
+		done <- session.Serve(serverConn)
+		serverConn.Close()
+	}()
+
+	return NewClient(clientConn), session, done
+}
+
+func TestCrashFlow(t *testing.T) {
+	dumper := &fakeDumper{}
+	client, session, done := pair(t, dumper)
+
+	if _, err := client.ReportCrash(&Crash{Kind: CrashException}); err != ErrNotRegistered {
+		t.Fatalf("crash before register: %v", err)
+	}
+
+	if err := client.Register(42, map[string]string{"product": "svc"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if client.State() != ClientRegistered || client.HandlerPID() != 99 {
+		t.Fatalf("state %v handler %d", client.State(), client.HandlerPID())
+	}
+
+	if err := client.Register(42, nil); err != ErrAlreadyRegistered {
+		t.Fatalf("second register: %v", err)
+	}
+
+	// a requested dump keeps the registration
+	res, err := client.ReportCrash(&Crash{Kind: CrashRequested, Reason: "hang"})
+	if err != nil || res.Path != "dump.dmp" || client.State() != ClientRegistered {
+		t.Fatalf("requested dump: %+v %v %v", res, err, client.State())
+	}
+
+	res, err = client.ReportCrash(&Crash{Kind: CrashException, ThreadID: 5, ExceptionPointers: 0x1000})
+	if err != nil || res.Path != "dump.dmp" {
+		t.Fatalf("crash: %+v %v", res, err)
+	}
+
+	if client.State() != ClientCrashed {
+		t.Fatalf("state after crash %v", client.State())
+	}
+
+	if _, err := client.ReportCrash(&Crash{Kind: CrashException}); err != ErrCrashed {
+		t.Fatalf("second crash: %v", err)
+	}
+
+	if err := <-done; err != nil {
+		t.Fatalf("Serve: %v", err)
+	}
+
+	if len(dumper.crashes) != 2 || dumper.crashes[1].ThreadID != 5 || !dumper.closed {
+		t.Fatalf("dumper %+v", dumper)
+	}
+
+	if session.State() != SessionDone || session.Registration().Annotations["product"] != "svc" {
+		t.Fatalf("session %v %+v", session.State(), session.Registration())
+	}
+
+	client.Close()
+}
+
+func TestUnregister(t *testing.T) {
+	dumper := &fakeDumper{}
+	client, _, done := pair(t, dumper)
+
+	if err := client.Register(42, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := client.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := <-done; err != nil {
+		t.Fatalf("Serve: %v", err)
+	}
+
+	if !dumper.closed || len(dumper.crashes) != 0 {
+		t.Fatalf("dumper %+v", dumper)
+	}
+
+	if err := client.Register(42, nil); err != ErrClosed {
+		t.Fatalf("register after close: %v", err)
+	}
+}
+
+func TestClientLost(t *testing.T) {
+	dumper := &fakeDumper{}
+	clientConn, serverConn := net.Pipe()
+
+	session := NewSession(func(reg *Register) (Dumper, error) { return dumper, nil })
+	done := make(chan error, 1)
+	go func() { done <- session.Serve(serverConn) }()
+
+	client := NewClient(clientConn)
+	if err := client.Register(42, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// the client process dies without unregistering
+	clientConn.Close()
+
+	if err := <-done; err != ErrClientLost {
+		t.Fatalf("Serve: %v", err)
+	}
+
+	if !dumper.closed {
+		t.Fatal("dumper not closed")
+	}
+}
+
+func TestDumpFailure(t *testing.T) {
+	dumper := &fakeDumper{fail: errors.New("access denied")}
+	client, _, done := pair(t, dumper)
+
+	if err := client.Register(42, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := client.ReportCrash(&Crash{Kind: CrashGoFatal, Reason: "panic"})
+	if err == nil || res == nil || res.Error != "access denied" {
+		t.Fatalf("crash: %+v %v", res, err)
+	}
+
+	<-done
+}
+
+func TestOversizedReason(t *testing.T) {
+	dumper := &fakeDumper{}
+	client, _, done := pair(t, dumper)
+
+	if err := client.Register(42, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// a multibyte rune straddles the cut
+	reason := "panic: deep\n\n" + strings.Repeat("é", maxPayload)
+
+	if _, err := client.ReportCrash(&Crash{Kind: CrashGoFatal, Reason: reason}); err != nil {
+		t.Fatal(err)
+	}
+
+	<-done
+
+	if len(dumper.crashes) != 1 {
+		t.Fatalf("dumped %d crashes", len(dumper.crashes))
+	}
+
+	got := dumper.crashes[0].Reason
+	if len(got) > maxReason || !strings.HasSuffix(got, truncatedMarker) {
+		t.Errorf("reason of %d bytes not truncated", len(got))
+	}
+
+	if !strings.HasPrefix(got, "panic: deep") || !utf8.ValidString(got) {
+		t.Errorf("truncated reason lost its start or split a rune")
+	}
+
+	if truncateReason("short") != "short" {
+		t.Error("short reason changed")
+	}
+}
+
+func TestSessionRejects(t *testing.T) {
+	factory := func(reg *Register) (Dumper, error) { return &fakeDumper{}, nil }
+
+	tests := []struct {
+		name     string
+		session  *Session
+		messages []Message
+	}{
+		{"crash first", NewSession(factory), []Message{&Crash{Kind: CrashException}}},
+		{"zero pid", NewSession(factory), []Message{&Register{}}},
+		{"register twice", NewSession(factory), []Message{&Register{PID: 1}, &Register{PID: 1}}},
+		{
+			"pid mismatch",
+			&Session{
+				factory:   factory,
+				VerifyPID: func(pid uint32) error { return errors.New("mismatch") },
+			},
+			[]Message{&Register{PID: 1}},
+		},
+		{
+			"factory failure",
+			NewSession(func(reg *Register) (Dumper, error) { return nil, errors.New("no access") }),
+			[]Message{&Register{PID: 1}},
+		},
+	}
+
+	for _, test := range tests {
+		var reply Message
+		var err error
+
+		for _, m := range test.messages {
+			reply, err = test.session.Handle(m)
+		}
+
+		if err == nil {
+			t.Errorf("%s: expected error", test.name)
+		}
+
+		if _, ok := reply.(*Error); !ok {
+			t.Errorf("%s: reply %+v, want Error", test.name, reply)
+		}
+
+		if test.session.State() != SessionDone {
+			t.Errorf("%s: state %v", test.name, test.session.State())
+		}
+	}
+}
+
+func TestHandlerErrorReply(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+
+	session := &Session{
+		factory:   func(reg *Register) (Dumper, error) { return &fakeDumper{}, nil },
+		VerifyPID: func(pid uint32) error { return errors.New("client claims pid 42 but is 43") },
+	}
+	go session.Serve(serverConn)
+
+	client := NewClient(clientConn)
+	err := client.Register(42, nil)
+	if err == nil || !strings.Contains(err.Error(), "but is 43") {
+		t.Fatalf("register: %v", err)
+	}
+
+	if client.State() != ClientIdle {
+		t.Fatalf("state %v", client.State())
+	}
+}
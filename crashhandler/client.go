@@ -0,0 +1,216 @@
+//  ---------------------------------------------------------------------------
+//
+//  client.go
+//
+//  Copyright (c) 2016, Jared Chavez.
+//  All rights reserved.
+//
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+//
+//  -----------
+
+// Package crashhandler writes minidumps of a crashing process from a
+// separate watcher process, in the style of crashpad.
+//
+// A service starts a watcher and registers with it over a connection, a
+// named pipe on Windows. When the service hits an unhandled exception or a
+// fatal Go error it reports the crash and blocks while the watcher dumps
+// it from outside, where the dump cannot be disturbed by the damage that
+// caused the crash.
+//
+// The protocol and the client and session state machines are portable;
+// the pipe transport, exception filter and dump writer are Windows only.
+package crashhandler
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+var (
+	ErrNotRegistered     = errors.New("crashhandler: client is not registered")
+	ErrAlreadyRegistered = errors.New("crashhandler: client is already registered")
+	ErrCrashed           = errors.New("crashhandler: crash already reported")
+	ErrClosed            = errors.New("crashhandler: client is closed")
+)
+
+// ClientState is a state of the client registration state machine:
+//
+//	Idle --Register--> Registered --Crash--> Crashed
+//	                       |  ^
+//	                       |  +--requested dump
+//	                       +--Close--> Closed
+type ClientState int
+
+const (
+	ClientIdle ClientState = iota
+	ClientRegistered
+	ClientCrashed
+	ClientClosed
+)
+
+func (s ClientState) String() string {
+	switch s {
+	case ClientIdle:
+		return "idle"
+	case ClientRegistered:
+		return "registered"
+	case ClientCrashed:
+		return "crashed"
+	case ClientClosed:
+		return "closed"
+	}
+
+	return fmt.Sprintf("ClientState(%d)", int(s))
+}
+
+// Client is the crashing side of a connection to a handler. Its methods
+// are safe for concurrent use; concurrent crashes are serialized and only
+// the first is reported.
+type Client struct {
+	mu         sync.Mutex
+	conn       io.ReadWriteCloser
+	state      ClientState
+	handlerPID uint32
+}
+
+// NewClient returns an unregistered client on conn.
+func NewClient(conn io.ReadWriteCloser) *Client {
+	return &Client{conn: conn}
+}
+
+// State returns the current state.
+func (c *Client) State() ClientState {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.state
+}
+
+// HandlerPID returns the process ID of the handler once registered.
+func (c *Client) HandlerPID() uint32 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.handlerPID
+}
+
+// Register identifies the client process to the handler, which prepares
+// to dump it.
+func (c *Client) Register(pid uint32, annotations map[string]string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch c.state {
+	case ClientRegistered, ClientCrashed:
+		return ErrAlreadyRegistered
+	case ClientClosed:
+		return ErrClosed
+	}
+
+	reply, err := c.roundTrip(&Register{PID: pid, Annotations: annotations})
+	if err != nil {
+		return err
+	}
+
+	registered, ok := reply.(*Registered)
+	if !ok {
+		return unexpected(reply)
+	}
+
+	c.handlerPID = registered.HandlerPID
+	c.state = ClientRegistered
+
+	return nil
+}
+
+// ReportCrash asks the handler to dump the client and waits until the dump
+// is written. Apart from CrashRequested, a crash can be reported once; the
+// process is expected to exit afterwards.
+func (c *Client) ReportCrash(crash *Crash) (*DumpDone, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	switch c.state {
+	case ClientIdle:
+		return nil, ErrNotRegistered
+	case ClientCrashed:
+		return nil, ErrCrashed
+	case ClientClosed:
+		return nil, ErrClosed
+	}
+
+	if crash.Kind != CrashRequested {
+		c.state = ClientCrashed
+	}
+
+	// a deep panic stack must not cost the dump
+	if len(crash.Reason) > maxReason {
+		truncated := *crash
+		truncated.Reason = truncateReason(crash.Reason)
+		crash = &truncated
+	}
+
+	reply, err := c.roundTrip(crash)
+	if err != nil {
+		return nil, err
+	}
+
+	done, ok := reply.(*DumpDone)
+	if !ok {
+		return nil, unexpected(reply)
+	}
+
+	if done.Error != "" {
+		return done, errors.New("crashhandler: " + done.Error)
+	}
+
+	return done, nil
+}
+
+// Close unregisters the client, if registered, and closes the connection.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.state == ClientClosed {
+		return nil
+	}
+
+	var err error
+	if c.state == ClientRegistered {
+		err = WriteMessage(c.conn, &Unregister{})
+	}
+
+	c.state = ClientClosed
+
+	if cerr := c.conn.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+func (c *Client) roundTrip(m Message) (Message, error) {
+	if err := WriteMessage(c.conn, m); err != nil {
+		return nil, err
+	}
+
+	reply, err := ReadMessage(c.conn)
+	if err != nil {
+		return nil, err
+	}
+
+	if e, ok := reply.(*Error); ok {
+		return nil, errors.New("crashhandler: handler: " + e.Text)
+	}
+
+	return reply, nil
+}
+
+func unexpected(m Message) error {
+	return fmt.Errorf("crashhandler: unexpected %v reply", m.Type())
+}
@@ -0,0 +1,238 @@
+//  ---------------------------------------------------------------------------
+//
+//  client_windows.go
+//
+//  Copyright (c) 2016, Jared Chavez.
+//  All rights reserved.
+//
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+//
+//  -----------
+
+package crashhandler
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime/debug"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/xaevman/win32/exception"
+	"github.com/xaevman/win32/kernel32"
+)
+
+// PipeEnv names the environment variable through which Start passes the
+// pipe name to the handler process.
+const PipeEnv = "CRASHHANDLER_PIPE"
+
+// PipeName returns the pipe name used for a client process.
+func PipeName(pid int) string {
+	return fmt.Sprintf(`\\.\pipe\crashhandler-%d`, pid)
+}
+
+// pipeConn is a synchronous named pipe handle.
+type pipeConn syscall.Handle
+
+func (p pipeConn) Read(buffer []byte) (int, error) {
+	var n uint32
+
+	err := syscall.ReadFile(syscall.Handle(p), buffer, &n, nil)
+	if err == syscall.ERROR_BROKEN_PIPE || (err == nil && n == 0 && len(buffer) > 0) {
+		return 0, io.EOF
+	}
+
+	return int(n), err
+}
+
+func (p pipeConn) Write(buffer []byte) (int, error) {
+	var n uint32
+
+	err := syscall.WriteFile(syscall.Handle(p), buffer, &n, nil)
+	return int(n), err
+}
+
+func (p pipeConn) Close() error {
+	return syscall.CloseHandle(syscall.Handle(p))
+}
+
+// Dial connects to the handler listening on name, retrying until timeout
+// while the pipe does not exist yet or all instances are busy. The handler
+// may identify the client but not impersonate it.
+func Dial(name string, timeout time.Duration) (io.ReadWriteCloser, error) {
+	deadline := time.Now().Add(timeout)
+	path := syscall.StringToUTF16Ptr(name)
+
+	for {
+		handle, err := syscall.CreateFile(
+			path,
+			syscall.GENERIC_READ|syscall.GENERIC_WRITE,
+			0,
+			nil,
+			syscall.OPEN_EXISTING,
+			kernel32.SECURITY_SQOS_PRESENT|kernel32.SECURITY_IDENTIFICATION,
+			0,
+		)
+		if err == nil {
+			return pipeConn(handle), nil
+		}
+
+		if err != syscall.ERROR_FILE_NOT_FOUND && err != kernel32.ERROR_PIPE_BUSY {
+			return nil, err
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return nil, fmt.Errorf("connecting to %s: %v", name, err)
+		}
+
+		if err == kernel32.ERROR_PIPE_BUSY {
+			kernel32.WaitNamedPipe(name, uint32(remaining/time.Millisecond))
+		} else {
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+}
+
+// Start launches the handler executable with args, passing it the pipe
+// name in PipeEnv, then connects and registers the current process. The
+// returned client is not yet installed; see Install.
+func Start(
+	handlerPath string,
+	args []string,
+	annotations map[string]string,
+) (*Client, *os.Process, error) {
+	name := PipeName(os.Getpid())
+
+	cmd := exec.Command(handlerPath, args...)
+	cmd.Env = append(os.Environ(), PipeEnv+"="+name)
+	if err := cmd.Start(); err != nil {
+		return nil, nil, err
+	}
+
+	conn, err := Dial(name, 10*time.Second)
+	if err != nil {
+		cmd.Process.Kill()
+		return nil, nil, err
+	}
+
+	client := NewClient(conn)
+	if err := client.Register(uint32(os.Getpid()), annotations); err != nil {
+		client.Close()
+		cmd.Process.Kill()
+		return nil, nil, err
+	}
+
+	return client, cmd.Process, nil
+}
+
+var (
+	installMu      sync.Mutex
+	installed      *Client
+	previousFilter uintptr
+	filterCallback = syscall.NewCallback(exceptionFilter)
+)
+
+// Install makes c the process's crash client by setting an unhandled
+// exception filter that reports the crash and waits for the dump.
+//
+// Exceptions in Go code are handled by the Go runtime and do not reach the
+// filter. To have fatal Go errors dumped as exceptions run with
+// GOTRACEBACK=wer (Go 1.21 and later); panics can be reported with
+// Recover.
+func Install(c *Client) {
+	installMu.Lock()
+	defer installMu.Unlock()
+
+	if installed == nil {
+		previousFilter = kernel32.SetUnhandledExceptionFilter(filterCallback)
+	}
+
+	installed = c
+}
+
+// Uninstall restores the previous unhandled exception filter.
+func Uninstall() {
+	installMu.Lock()
+	defer installMu.Unlock()
+
+	if installed != nil {
+		kernel32.SetUnhandledExceptionFilter(previousFilter)
+		installed = nil
+	}
+}
+
+func exceptionFilter(pointers *kernel32.EXCEPTION_POINTERS) uintptr {
+	installMu.Lock()
+	c, previous := installed, previousFilter
+	installMu.Unlock()
+
+	if c != nil {
+		c.ReportCrash(&Crash{
+			Kind:              CrashException,
+			ThreadID:          kernel32.GetCurrentThreadId(),
+			ExceptionPointers: uint64(uintptr(unsafe.Pointer(pointers))),
+			Reason:            exception.FromNative(pointers.ExceptionRecord).String(),
+		})
+	}
+
+	if previous != 0 {
+		ret, _, _ := syscall.Syscall(previous, 1, uintptr(unsafe.Pointer(pointers)), 0, 0)
+		return ret
+	}
+
+	return kernel32.EXCEPTION_CONTINUE_SEARCH
+}
+
+// Recover reports a Go panic to the installed client and re-panics. It must
+// be deferred directly:
+//
+//	defer crashhandler.Recover()
+func Recover() {
+	r := recover()
+	if r == nil {
+		return
+	}
+
+	installMu.Lock()
+	c := installed
+	installMu.Unlock()
+
+	if c != nil {
+		c.ReportCrash(&Crash{
+			Kind:     CrashGoFatal,
+			ThreadID: kernel32.GetCurrentThreadId(),
+			Reason:   fmt.Sprintf("panic: %v\n\n%s", r, debug.Stack()),
+		})
+	}
+
+	panic(r)
+}
+
+// RequestDump asks the installed client's handler for a dump of the
+// running process without crashing it.
+func RequestDump(reason string) (string, error) {
+	installMu.Lock()
+	c := installed
+	installMu.Unlock()
+
+	if c == nil {
+		return "", ErrNotRegistered
+	}
+
+	done, err := c.ReportCrash(&Crash{
+		Kind:     CrashRequested,
+		ThreadID: kernel32.GetCurrentThreadId(),
+		Reason:   reason,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return done.Path, nil
+}
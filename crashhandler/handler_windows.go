@@ -0,0 +1,281 @@
+//  ---------------------------------------------------------------------------
+//
+//  handler_windows.go
+//
+//  Copyright (c) 2016, Jared Chavez.
+//  All rights reserved.
+//
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+//
+//  -----------
+
+package crashhandler
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/xaevman/win32/dbgHelp"
+	"github.com/xaevman/win32/dumpstore"
+	"github.com/xaevman/win32/kernel32"
+)
+
+// DefaultDumpType is used when Handler.DumpType is zero.
+const DefaultDumpType = dbg.MiniDumpWithDataSegs |
+	dbg.MiniDumpWithHandleData |
+	dbg.MiniDumpWithUnloadedModules |
+	dbg.MiniDumpWithIndirectlyReferencedMemory |
+	dbg.MiniDumpWithProcessThreadData |
+	dbg.MiniDumpWithThreadInfo
+
+// Handler is the watcher side: it listens on a named pipe and dumps the
+// clients that report crashes.
+type Handler struct {
+	// PipeName is the pipe to listen on. Empty uses PipeEnv.
+	PipeName string
+
+	// DumpDir is where dumps are written. Empty uses the temp directory.
+	// It is ignored when Store is set.
+	DumpDir string
+
+	// Store, when set, names, keeps and prunes the dumps. Their Meta holds
+	// the crash's Reason and exception code, and the crash kind as the
+	// crash_kind annotation.
+	Store *dumpstore.Store
+
+	// DumpType is a MINIDUMP_TYPE. Zero uses DefaultDumpType.
+	DumpType uint32
+
+	// OnDump, when set, is called after each dump attempt.
+	OnDump func(reg *Register, crash *Crash, path string, err error)
+}
+
+// ListenAndServe accepts clients until an error occurs, serving each in
+// its own goroutine.
+func (h *Handler) ListenAndServe() error {
+	for first := true; ; first = false {
+		pipe, err := h.accept(first)
+		if err != nil {
+			return err
+		}
+
+		go h.serve(pipe)
+	}
+}
+
+// ServeClient accepts a single client and serves it until it crashes,
+// unregisters or exits. A handler started with Start uses this so that
+// it exits along with its client.
+func (h *Handler) ServeClient() error {
+	pipe, err := h.accept(true)
+	if err != nil {
+		return err
+	}
+
+	return h.serve(pipe)
+}
+
+// accept creates an instance of the pipe and waits for a client. The first
+// instance fails if the name is taken, since the names are predictable and
+// another process could otherwise receive the registration.
+func (h *Handler) accept(first bool) (syscall.Handle, error) {
+	name := h.PipeName
+	if name == "" {
+		name = os.Getenv(PipeEnv)
+	}
+
+	if name == "" {
+		return syscall.InvalidHandle, errors.New("crashhandler: no pipe name")
+	}
+
+	openMode := uint32(kernel32.PIPE_ACCESS_DUPLEX)
+	if first {
+		openMode |= kernel32.FILE_FLAG_FIRST_PIPE_INSTANCE
+	}
+
+	pipe, err := kernel32.CreateNamedPipe(
+		name,
+		openMode,
+		kernel32.PIPE_TYPE_BYTE|kernel32.PIPE_READMODE_BYTE|kernel32.PIPE_WAIT|kernel32.PIPE_REJECT_REMOTE_CLIENTS,
+		kernel32.PIPE_UNLIMITED_INSTANCES,
+		4096,
+		4096,
+		0,
+	)
+	if err != nil {
+		return syscall.InvalidHandle, err
+	}
+
+	if err := kernel32.ConnectNamedPipe(pipe); err != nil {
+		syscall.CloseHandle(pipe)
+		return syscall.InvalidHandle, err
+	}
+
+	return pipe, nil
+}
+
+func (h *Handler) serve(pipe syscall.Handle) error {
+	defer func() {
+		kernel32.DisconnectNamedPipe(pipe)
+		syscall.CloseHandle(pipe)
+	}()
+
+	session := NewSession(h.newDumper)
+	session.HandlerPID = uint32(os.Getpid())
+	session.OnDump = h.OnDump
+	session.VerifyPID = func(pid uint32) error {
+		actual, err := kernel32.GetNamedPipeClientProcessId(pipe)
+		if err != nil {
+			return err
+		}
+
+		if actual != pid {
+			return fmt.Errorf("client claims pid %d but is %d", pid, actual)
+		}
+
+		return nil
+	}
+
+	return session.Serve(pipeConn(pipe))
+}
+
+// processDumper dumps one client through a handle opened at registration.
+type processDumper struct {
+	proc     syscall.Handle
+	reg      *Register
+	dir      string
+	store    *dumpstore.Store
+	dumpType uint32
+}
+
+func (h *Handler) newDumper(reg *Register) (Dumper, error) {
+	proc, err := kernel32.OpenProcess(reg.PID, dbg.MiniDumpAccess)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := h.DumpDir
+	if dir == "" {
+		dir = os.TempDir()
+	}
+
+	dumpType := h.DumpType
+	if dumpType == 0 {
+		dumpType = DefaultDumpType
+	}
+
+	return &processDumper{
+		proc:     proc,
+		reg:      reg,
+		dir:      dir,
+		store:    h.Store,
+		dumpType: dumpType,
+	}, nil
+}
+
+func (d *processDumper) Dump(crash *Crash) (string, error) {
+	var info *dbg.MinidumpExceptionInformation
+	if crash.ExceptionPointers != 0 {
+		info = &dbg.MinidumpExceptionInformation{
+			ThreadId:          crash.ThreadID,
+			ExceptionPointers: crash.ExceptionPointers,
+			ClientPointers:    true,
+		}
+	}
+
+	if d.store != nil {
+		annotations := make(map[string]string, len(d.reg.Annotations)+1)
+		for k, v := range d.reg.Annotations {
+			annotations[k] = v
+		}
+		annotations["crash_kind"] = crash.Kind.String()
+
+		meta := &dumpstore.Meta{
+			Process:     d.reg.Annotations["process"],
+			PID:         d.reg.PID,
+			Reason:      crash.Reason,
+			Annotations: annotations,
+		}
+
+		if crash.ExceptionPointers != 0 {
+			// the dump is still worth writing without the code
+			meta.ExceptionCode, _ = exceptionCode(d.proc, crash.ExceptionPointers)
+		}
+
+		entry, err := d.store.WriteMiniDump(d.proc, d.dumpType, meta, info)
+		if err != nil {
+			return "", err
+		}
+
+		return entry.Path, nil
+	}
+
+	path := filepath.Join(
+		d.dir,
+		fmt.Sprintf("crash-%d-%s.dmp", d.reg.PID, time.Now().Format("20060102-150405.000")),
+	)
+
+	err := dbg.WriteMiniDumpEx(d.proc, d.reg.PID, d.dumpType, path, info)
+	if err != nil {
+		os.Remove(path)
+		return "", err
+	}
+
+	return path, nil
+}
+
+func (d *processDumper) Close() error {
+	return syscall.CloseHandle(d.proc)
+}
+
+// exceptionCode reads the ExceptionCode of the EXCEPTION_RECORD that the
+// EXCEPTION_POINTERS at addr in proc points to. A WOW64 client has 4-byte
+// pointers whatever the handler's own size.
+func exceptionCode(proc syscall.Handle, addr uint64) (uint32, error) {
+	pointerSize := uint64(unsafe.Sizeof(uintptr(0)))
+
+	machine, _, err := kernel32.IsWow64Process2(proc)
+	if err != nil {
+		return 0, err
+	}
+
+	if machine != kernel32.IMAGE_FILE_MACHINE_UNKNOWN {
+		pointerSize = 4
+	}
+
+	pointers, err := kernel32.ReadProcessMemory(proc, addr, pointerSize)
+	if err != nil {
+		return 0, err
+	}
+
+	if uint64(len(pointers)) < pointerSize {
+		return 0, fmt.Errorf("short read of EXCEPTION_POINTERS at 0x%x", addr)
+	}
+
+	// ExceptionRecord is the first member of EXCEPTION_POINTERS, and
+	// ExceptionCode the first of EXCEPTION_RECORD
+	var record uint64
+	if pointerSize == 4 {
+		record = uint64(binary.LittleEndian.Uint32(pointers))
+	} else {
+		record = binary.LittleEndian.Uint64(pointers)
+	}
+
+	code, err := kernel32.ReadProcessMemory(proc, record, 4)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(code) < 4 {
+		return 0, fmt.Errorf("short read of EXCEPTION_RECORD at 0x%x", record)
+	}
+
+	return binary.LittleEndian.Uint32(code), nil
+}
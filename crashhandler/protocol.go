@@ -0,0 +1,394 @@
+//  ---------------------------------------------------------------------------
+//
+//  protocol.go
+//
+//  Copyright (c) 2016, Jared Chavez.
+//  All rights reserved.
+//
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+//
+//  -----------
+
+package crashhandler
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"unicode/utf8"
+)
+
+// ProtocolVersion is carried in every frame; peers reject other versions.
+const ProtocolVersion = 1
+
+const (
+	frameMagic      = 0x48524343 // "CCRH"
+	frameHeaderSize = 12
+
+	// maxPayload bounds a frame so a corrupt length cannot force a large
+	// allocation.
+	maxPayload = 64 * 1024
+
+	// maxReason bounds Crash.Reason, leaving room in the frame for the
+	// other fields.
+	maxReason = maxPayload - 1024
+
+	truncatedMarker = "\n... truncated"
+)
+
+// MsgType identifies a message on the wire.
+type MsgType uint16
+
+const (
+	MsgRegister MsgType = iota + 1
+	MsgRegistered
+	MsgCrash
+	MsgDumpDone
+	MsgUnregister
+	MsgError
+)
+
+func (t MsgType) String() string {
+	switch t {
+	case MsgRegister:
+		return "Register"
+	case MsgRegistered:
+		return "Registered"
+	case MsgCrash:
+		return "Crash"
+	case MsgDumpDone:
+		return "DumpDone"
+	case MsgUnregister:
+		return "Unregister"
+	case MsgError:
+		return "Error"
+	}
+
+	return fmt.Sprintf("MsgType(%d)", uint16(t))
+}
+
+// Message is one of the protocol messages below.
+type Message interface {
+	Type() MsgType
+	encode(e *encoder)
+	decode(d *decoder)
+}
+
+// Register is sent by a client once, right after connecting.
+type Register struct {
+	PID uint32
+
+	// Annotations are attached to every dump of the client, for example
+	// the product name and version.
+	Annotations map[string]string
+}
+
+// Registered acknowledges Register.
+type Registered struct {
+	HandlerPID uint32
+}
+
+// CrashKind says what kind of failure a Crash reports.
+type CrashKind uint16
+
+const (
+	// CrashException is an unhandled structured exception.
+	CrashException CrashKind = iota + 1
+
+	// CrashGoFatal is a Go panic or fatal runtime error.
+	CrashGoFatal
+
+	// CrashRequested is a dump requested without a crash, for example by
+	// a watchdog.
+	CrashRequested
+)
+
+func (k CrashKind) String() string {
+	switch k {
+	case CrashException:
+		return "exception"
+	case CrashGoFatal:
+		return "go-fatal"
+	case CrashRequested:
+		return "requested"
+	}
+
+	return fmt.Sprintf("CrashKind(%d)", uint16(k))
+}
+
+// Crash asks the handler to dump the client.
+type Crash struct {
+	Kind     CrashKind
+	ThreadID uint32
+
+	// ExceptionPointers is the address of an EXCEPTION_POINTERS structure
+	// in the client, or zero when there is no exception.
+	ExceptionPointers uint64
+
+	// Reason is free text, such as a Go panic message. ReportCrash cuts
+	// it to fit in a frame, keeping its start.
+	Reason string
+}
+
+// truncateReason cuts reason to at most maxReason bytes, on a rune
+// boundary, marking that it was cut.
+func truncateReason(reason string) string {
+	if len(reason) <= maxReason {
+		return reason
+	}
+
+	n := maxReason - len(truncatedMarker)
+	for n > 0 && !utf8.RuneStart(reason[n]) {
+		n--
+	}
+
+	return reason[:n] + truncatedMarker
+}
+
+// DumpDone reports the result of a Crash.
+type DumpDone struct {
+	Path  string
+	Error string
+}
+
+// Unregister is sent by a client that is shutting down cleanly.
+type Unregister struct{}
+
+// Error rejects a message that is not valid in the session's state.
+type Error struct {
+	Text string
+}
+
+func (*Register) Type() MsgType   { return MsgRegister }
+func (*Registered) Type() MsgType { return MsgRegistered }
+func (*Crash) Type() MsgType      { return MsgCrash }
+func (*DumpDone) Type() MsgType   { return MsgDumpDone }
+func (*Unregister) Type() MsgType { return MsgUnregister }
+func (*Error) Type() MsgType      { return MsgError }
+
+func (m *Register) encode(e *encoder) {
+	e.uint32(m.PID)
+
+	keys := make([]string, 0, len(m.Annotations))
+	for key := range m.Annotations {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	e.uint32(uint32(len(keys)))
+	for _, key := range keys {
+		e.string(key)
+		e.string(m.Annotations[key])
+	}
+}
+
+func (m *Register) decode(d *decoder) {
+	m.PID = d.uint32()
+
+	count := d.uint32()
+	if count == 0 {
+		return
+	}
+
+	m.Annotations = make(map[string]string)
+	for i := uint32(0); i < count && d.err == nil; i++ {
+		key := d.string()
+		m.Annotations[key] = d.string()
+	}
+}
+
+func (m *Registered) encode(e *encoder) { e.uint32(m.HandlerPID) }
+func (m *Registered) decode(d *decoder) { m.HandlerPID = d.uint32() }
+
+func (m *Crash) encode(e *encoder) {
+	e.uint16(uint16(m.Kind))
+	e.uint32(m.ThreadID)
+	e.uint64(m.ExceptionPointers)
+	e.string(m.Reason)
+}
+
+func (m *Crash) decode(d *decoder) {
+	m.Kind = CrashKind(d.uint16())
+	m.ThreadID = d.uint32()
+	m.ExceptionPointers = d.uint64()
+	m.Reason = d.string()
+}
+
+func (m *DumpDone) encode(e *encoder) {
+	e.string(m.Path)
+	e.string(m.Error)
+}
+
+func (m *DumpDone) decode(d *decoder) {
+	m.Path = d.string()
+	m.Error = d.string()
+}
+
+func (m *Unregister) encode(e *encoder) {}
+func (m *Unregister) decode(d *decoder) {}
+
+func (m *Error) encode(e *encoder) { e.string(m.Text) }
+func (m *Error) decode(d *decoder) { m.Text = d.string() }
+
+// WriteMessage writes one framed message.
+func WriteMessage(w io.Writer, m Message) error {
+	var e encoder
+	m.encode(&e)
+
+	if len(e.buf) > maxPayload {
+		return fmt.Errorf("%v message too large: %d bytes", m.Type(), len(e.buf))
+	}
+
+	frame := make([]byte, frameHeaderSize, frameHeaderSize+len(e.buf))
+	le := binary.LittleEndian
+	le.PutUint32(frame[0:], frameMagic)
+	le.PutUint16(frame[4:], ProtocolVersion)
+	le.PutUint16(frame[6:], uint16(m.Type()))
+	le.PutUint32(frame[8:], uint32(len(e.buf)))
+	frame = append(frame, e.buf...)
+
+	// a single write keeps the frame in one pipe message
+	_, err := w.Write(frame)
+	return err
+}
+
+// ReadMessage reads one framed message. It returns io.EOF only when the
+// peer closed the connection between messages.
+func ReadMessage(r io.Reader) (Message, error) {
+	header := make([]byte, frameHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, errors.New("truncated frame header")
+		}
+		return nil, err
+	}
+
+	le := binary.LittleEndian
+	if le.Uint32(header[0:]) != frameMagic {
+		return nil, errors.New("bad frame magic")
+	}
+
+	if version := le.Uint16(header[4:]); version != ProtocolVersion {
+		return nil, fmt.Errorf("unsupported protocol version %d", version)
+	}
+
+	size := le.Uint32(header[8:])
+	if size > maxPayload {
+		return nil, fmt.Errorf("frame too large: %d bytes", size)
+	}
+
+	m, err := newMessage(MsgType(le.Uint16(header[6:])))
+	if err != nil {
+		return nil, err
+	}
+
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, fmt.Errorf("reading %v payload: %v", m.Type(), err)
+	}
+
+	d := decoder{buf: payload}
+	m.decode(&d)
+	if d.err != nil {
+		return nil, fmt.Errorf("decoding %v: %v", m.Type(), d.err)
+	}
+
+	return m, nil
+}
+
+func newMessage(t MsgType) (Message, error) {
+	switch t {
+	case MsgRegister:
+		return new(Register), nil
+	case MsgRegistered:
+		return new(Registered), nil
+	case MsgCrash:
+		return new(Crash), nil
+	case MsgDumpDone:
+		return new(DumpDone), nil
+	case MsgUnregister:
+		return new(Unregister), nil
+	case MsgError:
+		return new(Error), nil
+	}
+
+	return nil, fmt.Errorf("unknown message type %d", uint16(t))
+}
+
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) uint16(v uint16) {
+	e.buf = append(e.buf, byte(v), byte(v>>8))
+}
+
+func (e *encoder) uint32(v uint32) {
+	e.buf = append(e.buf, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+func (e *encoder) uint64(v uint64) {
+	e.uint32(uint32(v))
+	e.uint32(uint32(v >> 32))
+}
+
+func (e *encoder) string(s string) {
+	e.uint32(uint32(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+// decoder reads fields until the first error, after which every read
+// returns a zero value.
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) next(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+
+	if len(d.buf) < n {
+		d.err = io.ErrUnexpectedEOF
+		return nil
+	}
+
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *decoder) uint16() uint16 {
+	if b := d.next(2); b != nil {
+		return binary.LittleEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (d *decoder) uint32() uint32 {
+	if b := d.next(4); b != nil {
+		return binary.LittleEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (d *decoder) uint64() uint64 {
+	if b := d.next(8); b != nil {
+		return binary.LittleEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (d *decoder) string() string {
+	n := d.uint32()
+	if d.err == nil && int64(n) > int64(len(d.buf)) {
+		d.err = io.ErrUnexpectedEOF
+		return ""
+	}
+
+	return string(d.next(int(n)))
+}
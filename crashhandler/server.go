@@ -0,0 +1,209 @@
+//  ---------------------------------------------------------------------------
+//
+//  server.go
+//
+//  Copyright (c) 2016, Jared Chavez.
+//  All rights reserved.
+//
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+//
+//  -----------
+
+package crashhandler
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrClientLost is returned by Serve when a registered client disconnects
+// without reporting a crash or unregistering, for example because it was
+// killed.
+var ErrClientLost = errors.New("crashhandler: client disconnected while registered")
+
+// Dumper writes dumps of one registered client.
+type Dumper interface {
+	// Dump writes a dump for crash and returns its path.
+	Dump(crash *Crash) (string, error)
+
+	// Close releases the client, for example its process handle.
+	Close() error
+}
+
+// DumperFactory prepares a Dumper when a client registers. Opening the
+// client process at registration, rather than at crash time, keeps dumps
+// working when the crashed process can no longer be opened.
+type DumperFactory func(reg *Register) (Dumper, error)
+
+// SessionState is a state of the handler side of a connection:
+//
+//	AwaitRegister --Register--> Registered --Crash/Unregister--> Done
+//	                                |  ^
+//	                                +--+ requested dump
+type SessionState int
+
+const (
+	SessionAwaitRegister SessionState = iota
+	SessionRegistered
+	SessionDone
+)
+
+func (s SessionState) String() string {
+	switch s {
+	case SessionAwaitRegister:
+		return "await-register"
+	case SessionRegistered:
+		return "registered"
+	case SessionDone:
+		return "done"
+	}
+
+	return fmt.Sprintf("SessionState(%d)", int(s))
+}
+
+// Session is the handler side state machine of one client connection.
+type Session struct {
+	// HandlerPID is reported to the client on registration.
+	HandlerPID uint32
+
+	// VerifyPID, when set, checks the PID claimed by Register, for example
+	// against the process on the other end of a pipe.
+	VerifyPID func(pid uint32) error
+
+	// OnDump, when set, is called after each dump attempt.
+	OnDump func(reg *Register, crash *Crash, path string, err error)
+
+	factory DumperFactory
+	dumper  Dumper
+	state   SessionState
+	reg     *Register
+}
+
+// NewSession returns a session waiting for a client to register.
+func NewSession(factory DumperFactory) *Session {
+	return &Session{factory: factory}
+}
+
+// State returns the current state.
+func (s *Session) State() SessionState {
+	return s.state
+}
+
+// Registration returns the client's Register message once registered.
+func (s *Session) Registration() *Register {
+	return s.reg
+}
+
+// Handle advances the state machine with a message from the client and
+// returns the reply to send, if any. A message that is not valid in the
+// current state is answered with an Error and ends the session.
+func (s *Session) Handle(m Message) (Message, error) {
+	switch s.state {
+	case SessionAwaitRegister:
+		reg, ok := m.(*Register)
+		if !ok {
+			return s.fail(fmt.Errorf("expected Register, got %v", m.Type()))
+		}
+
+		if reg.PID == 0 {
+			return s.fail(errors.New("register without a process ID"))
+		}
+
+		if s.VerifyPID != nil {
+			if err := s.VerifyPID(reg.PID); err != nil {
+				return s.fail(err)
+			}
+		}
+
+		dumper, err := s.factory(reg)
+		if err != nil {
+			return s.fail(err)
+		}
+
+		s.reg = reg
+		s.dumper = dumper
+		s.state = SessionRegistered
+
+		return &Registered{HandlerPID: s.HandlerPID}, nil
+
+	case SessionRegistered:
+		switch msg := m.(type) {
+		case *Crash:
+			path, err := s.dumper.Dump(msg)
+			if s.OnDump != nil {
+				s.OnDump(s.reg, msg, path, err)
+			}
+
+			done := &DumpDone{Path: path}
+			if err != nil {
+				done.Error = err.Error()
+			}
+
+			if msg.Kind != CrashRequested {
+				s.Close()
+			}
+
+			return done, nil
+
+		case *Unregister:
+			s.Close()
+			return nil, nil
+		}
+
+		return s.fail(fmt.Errorf("unexpected %v while registered", m.Type()))
+	}
+
+	return nil, errors.New("crashhandler: session is done")
+}
+
+// Close releases the session's dumper.
+func (s *Session) Close() error {
+	var err error
+	if s.dumper != nil {
+		err = s.dumper.Close()
+		s.dumper = nil
+	}
+
+	s.state = SessionDone
+	return err
+}
+
+func (s *Session) fail(err error) (Message, error) {
+	s.Close()
+	return &Error{Text: err.Error()}, err
+}
+
+// Serve runs a session over conn until the client reports a crash,
+// unregisters, or disconnects.
+func (s *Session) Serve(conn io.ReadWriter) error {
+	defer s.Close()
+
+	for s.state != SessionDone {
+		m, err := ReadMessage(conn)
+		if err == io.EOF {
+			if s.state == SessionRegistered {
+				return ErrClientLost
+			}
+			return nil
+		}
+
+		if err != nil {
+			return err
+		}
+
+		reply, herr := s.Handle(m)
+		if reply != nil {
+			if err := WriteMessage(conn, reply); err != nil {
+				return err
+			}
+		}
+
+		if herr != nil {
+			return herr
+		}
+	}
+
+	return nil
+}
@@ -15,6 +15,7 @@ package dbg
 import (
 	"fmt"
 	"os"
+	"runtime"
 	"strconv"
 	"strings"
 	"syscall"
@@ -305,7 +306,9 @@ var (
 	symEnumSymbolsForAddr  = dbgHelpDll.NewProc("SymEnumSymbolsForAddrW")
 	symFindFileInPath      = dbgHelpDll.NewProc("SymFindFileInPathW")
 	symFromAddr            = dbgHelpDll.NewProc("SymFromAddrW")
+	symFunctionTableAccess = dbgHelpDll.NewProc("SymFunctionTableAccess64")
 	symGetLineFromAddr64   = dbgHelpDll.NewProc("SymGetLineFromAddrW64")
+	symGetModuleBase64     = dbgHelpDll.NewProc("SymGetModuleBase64")
 	symGetModuleInfoW64    = dbgHelpDll.NewProc("SymGetModuleInfoW64")
 	symGetSymFromAddr64    = dbgHelpDll.NewProc("SymGetSymFromAddr64W")
 	symInitialize          = dbgHelpDll.NewProc("SymInitializeW")
@@ -328,20 +331,183 @@ var (
 // );
 // fail == false
 func WriteMiniDump(proc syscall.Handle, pid, dumpType uint32, filePath string) error {
+	return WriteMiniDumpEx(proc, pid, dumpType, filePath, nil)
+}
+
+// MinidumpExceptionInformation describes the exception of a dump written
+// by WriteMiniDumpEx. ExceptionPointers is the address of an
+// EXCEPTION_POINTERS structure; when ClientPointers is set the address is
+// in the dumped process rather than the caller.
+type MinidumpExceptionInformation struct {
+	ThreadId          uint32
+	ExceptionPointers uint64
+	ClientPointers    bool
+}
+
+// marshal lays out MINIDUMP_EXCEPTION_INFORMATION, which dbghelp.h
+// declares under #pragma pack(4), so on 64-bit the pointer directly
+// follows ThreadId without padding.
+func (info *MinidumpExceptionInformation) marshal() []byte {
+	ptrSize := int(unsafe.Sizeof(uintptr(0)))
+	buffer := make([]byte, 4+ptrSize+4)
+
+	*(*uint32)(unsafe.Pointer(&buffer[0])) = info.ThreadId
+	for i := 0; i < ptrSize; i++ {
+		buffer[4+i] = byte(info.ExceptionPointers >> (8 * uint(i)))
+	}
+	if info.ClientPointers {
+		buffer[4+ptrSize] = 1
+	}
+
+	return buffer
+}
+
+// WriteMiniDumpEx writes a dump of proc to filePath, including the
+// exception described by exceptionInfo when it is not nil.
+func WriteMiniDumpEx(
+	proc syscall.Handle,
+	pid, dumpType uint32,
+	filePath string,
+	exceptionInfo *MinidumpExceptionInformation,
+) error {
+	return miniDumpWriteDump(proc, pid, dumpType, filePath, exceptionInfo, nil)
+}
+
+// MiniDumpAccess is the process access WriteMiniDump needs: query and
+// read access, plus handle duplication for MiniDumpWithHandleData.
+const MiniDumpAccess kernel32.ProcessAccess = kernel32.PROCESS_QUERY_INFORMATION |
+	kernel32.PROCESS_VM_READ |
+	kernel32.PROCESS_DUP_HANDLE
+
+// SnapshotAccess is the process access WriteMiniDumpSnapshot needs; cloning
+// the address space requires PROCESS_CREATE_PROCESS.
+const SnapshotAccess = MiniDumpAccess | kernel32.PROCESS_CREATE_PROCESS
+
+// SnapshotCaptureFlags are the PssCaptureSnapshot flags used by
+// WriteMiniDumpSnapshot: a clone of the address space plus the handles and
+// threads that MiniDumpWriteDump reads.
+const SnapshotCaptureFlags = kernel32.PSS_CAPTURE_VA_CLONE |
+	kernel32.PSS_CAPTURE_HANDLES |
+	kernel32.PSS_CAPTURE_HANDLE_NAME_INFORMATION |
+	kernel32.PSS_CAPTURE_HANDLE_BASIC_INFORMATION |
+	kernel32.PSS_CAPTURE_HANDLE_TYPE_SPECIFIC_INFORMATION |
+	kernel32.PSS_CAPTURE_HANDLE_TRACE |
+	kernel32.PSS_CAPTURE_THREADS |
+	kernel32.PSS_CAPTURE_THREAD_CONTEXT |
+	kernel32.PSS_CAPTURE_THREAD_CONTEXT_EXTENDED |
+	kernel32.PSS_CREATE_BREAKAWAY_OPTIONAL |
+	kernel32.PSS_CREATE_BREAKAWAY |
+	kernel32.PSS_CREATE_RELEASE_SECTION |
+	kernel32.PSS_CREATE_USE_VM_ALLOCATIONS
+
+// MINIDUMP_CALLBACK_TYPE values
+const (
+	IsProcessSnapshotCallback = 16
+)
+
+// MINIDUMP_CALLBACK_INFORMATION
+type minidumpCallbackInformation struct {
+	CallbackRoutine uintptr
+	CallbackParam   uintptr
+}
+
+// MINIDUMP_CALLBACK_INPUT, up to the CallbackType that starts its union.
+// The structure is packed to 4 bytes, so the handle is declared as words to
+// keep CallbackType right after it.
+type minidumpCallbackInput struct {
+	ProcessId     uint32
+	ProcessHandle [unsafe.Sizeof(uintptr(0)) / 4]uint32
+	CallbackType  uint32
+}
+
+// MINIDUMP_CALLBACK_OUTPUT, of which only the Status member of its union is
+// used.
+type minidumpCallbackOutput struct {
+	Status int32
+}
+
+// snapshotCallback answers IsProcessSnapshotCallback with S_FALSE, which
+// tells MiniDumpWriteDump that its process handle is a PSS snapshot. It is
+// created once since callbacks are never freed.
+var snapshotCallback = syscall.NewCallback(func(
+	param uintptr,
+	input *minidumpCallbackInput,
+	output *minidumpCallbackOutput,
+) uintptr {
+	if input.CallbackType == IsProcessSnapshotCallback {
+		output.Status = 1 // S_FALSE
+	}
+
+	return 1
+})
+
+// WriteMiniDumpSnapshot writes a dump of proc from a PssCaptureSnapshot
+// clone rather than the live process, so the process is only paused while
+// the snapshot is taken instead of for the whole dump. proc needs
+// SnapshotAccess.
+// Snapshots require Windows 8.1.
+func WriteMiniDumpSnapshot(
+	proc syscall.Handle,
+	pid, dumpType uint32,
+	filePath string,
+	exceptionInfo *MinidumpExceptionInformation,
+) error {
+	snapshot, err := kernel32.PssCaptureSnapshot(
+		proc,
+		SnapshotCaptureFlags,
+		snapshotContextFlags(),
+	)
+	if err != nil {
+		return fmt.Errorf("PssCaptureSnapshot: %v", err)
+	}
+
+	self, _ := syscall.GetCurrentProcess()
+	defer kernel32.PssFreeSnapshot(self, snapshot)
+
+	callback := minidumpCallbackInformation{CallbackRoutine: snapshotCallback}
+
+	return miniDumpWriteDump(snapshot, pid, dumpType, filePath, exceptionInfo, &callback)
+}
+
+// snapshotContextFlags returns CONTEXT_ALL for the native machine, which is
+// what the snapshot records for each thread.
+func snapshotContextFlags() uint32 {
+	switch runtime.GOARCH {
+	case "386":
+		return kernel32.CONTEXT_i386_ALL
+	case "arm64":
+		return kernel32.CONTEXT_ARM64_ALL
+	}
+
+	return kernel32.CONTEXT_ALL
+}
+
+func miniDumpWriteDump(
+	proc syscall.Handle,
+	pid, dumpType uint32,
+	filePath string,
+	exceptionInfo *MinidumpExceptionInformation,
+	callback *minidumpCallbackInformation,
+) error {
 	f, err := os.Create(filePath)
 	if err != nil {
 		return err
 	}
 	defer f.Close()
 
+	var exceptionParam unsafe.Pointer
+	if exceptionInfo != nil {
+		exceptionParam = unsafe.Pointer(&exceptionInfo.marshal()[0])
+	}
+
 	ret, _, err := symMiniDumpWriteDump.Call(
 		uintptr(proc),
 		uintptr(pid),
 		f.Fd(),
 		uintptr(dumpType),
+		uintptr(exceptionParam),
 		uintptr(0),
-		uintptr(0),
-		uintptr(0),
+		uintptr(unsafe.Pointer(callback)),
 	)
 
 	if ret == 0 {
@@ -380,22 +546,24 @@ func ResolveSymbol(proc syscall.Handle, symAddr uint64) *SymbolInfo {
 //     _In_opt_ PTRANSLATE_ADDRESS_ROUTINE64 TranslateAddress
 //     );
 // fail == false
+//
+// The machine type is taken from the context record, which should come from
+// kernel32.GetThreadContext.
 func StackWalk64(
-	imageType int,
 	proc syscall.Handle,
 	threadHandle uintptr,
 	frame *STACKFRAME64,
-	context *kernel32.CONTEXT,
+	context kernel32.ThreadContext,
 ) error {
 	ret, _, err := symStackWalk64.Call(
-		uintptr(imageType),
+		uintptr(context.Machine()),
 		uintptr(proc),
 		threadHandle,
 		uintptr(unsafe.Pointer(frame)),
-		uintptr(unsafe.Pointer(context)),
-		uintptr(0),
-		uintptr(0),
+		uintptr(context.Pointer()),
 		uintptr(0),
+		symFunctionTableAccess.Addr(),
+		symGetModuleBase64.Addr(),
 		uintptr(0),
 	)
 
@@ -406,6 +574,22 @@ func StackWalk64(
 	return nil
 }
 
+// NewStackFrame returns a STACKFRAME64 initialized from the program counter,
+// frame pointer and stack pointer of context, ready for the first call to
+// StackWalk64.
+func NewStackFrame(context kernel32.ThreadContext) *STACKFRAME64 {
+	var frame STACKFRAME64
+
+	frame.AddrPC.Offset = context.PC()
+	frame.AddrPC.Mode = kernel32.AddrModeFlat
+	frame.AddrFrame.Offset = context.FP()
+	frame.AddrFrame.Mode = kernel32.AddrModeFlat
+	frame.AddrStack.Offset = context.SP()
+	frame.AddrStack.Mode = kernel32.AddrModeFlat
+
+	return &frame
+}
+
 func SymUnloadModule(
 	proc syscall.Handle,
 	address uint64,
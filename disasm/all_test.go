@@ -0,0 +1,210 @@
+//  ---------------------------------------------------------------------------
+//
+//  all_test.go
+//
+//  Copyright (c) 2016, Jared Chavez.
+//  All rights reserved.
+//
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+//
+//  -----------
+
+package disasm
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// memory is a readable range of an address space.
+type memory struct {
+	base uint64
+	data []byte
+}
+
+func (m *memory) ReadAt(p []byte, off int64) (int, error) {
+	addr := uint64(off)
+	if addr < m.base || addr >= m.base+uint64(len(m.data)) {
+		return 0, errors.New("unreadable")
+	}
+
+	n := copy(p, m.data[addr-m.base:])
+	if n < len(p) {
+		return n, errors.New("partially unreadable")
+	}
+
+	return n, nil
+}
+
+var x64Code = []byte{
+	0x55,             // 1000 push rbp
+	0x48, 0x89, 0xe5, // 1001 mov rbp, rsp
+	0x48, 0x83, 0xec, 0x10, // 1004 sub rsp, 0x10
+	0xe8, 0x03, 0x00, 0x00, 0x00, // 1008 call 0x1010
+	0x8b, 0x00, // 100d mov eax, dword ptr [rax]
+	0xc3,       // 100f ret
+	0x31, 0xc0, // 1010 xor eax, eax
+	0xc3, // 1012 ret
+}
+
+var arm64Code = []byte{
+	0xfd, 0x7b, 0xbf, 0xa9, // 2000 stp x29, x30, [sp,#-16]!
+	0xfd, 0x03, 0x00, 0x91, // 2004 mov x29, sp
+	0x02, 0x00, 0x00, 0x94, // 2008 bl 0x2010
+	0x00, 0x00, 0x40, 0xf9, // 200c ldr x0, [x0]
+	0xc0, 0x03, 0x5f, 0xd6, // 2010 ret
+}
+
+func symbolize(addr uint64) string {
+	switch addr {
+	case 0x1010, 0x2010:
+		return "app!helper"
+	}
+
+	return ""
+}
+
+func TestAroundX64(t *testing.T) {
+	mem := &memory{base: 0x1000, data: x64Code}
+
+	insts, err := Around(mem, ArchAMD64, 0x100d, &Options{
+		Before:    3,
+		After:     2,
+		Symbolize: symbolize,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []struct {
+		addr uint64
+		text string
+	}{
+		{0x1001, "mov rbp, rsp"},
+		{0x1004, "sub rsp, 0x10"},
+		{0x1008, "call 0x1010"},
+		{0x100d, "mov eax, dword ptr [rax]"},
+		{0x100f, "ret"},
+		{0x1010, "xor eax, eax"},
+	}
+
+	if len(insts) != len(want) {
+		t.Fatalf("got %d instructions, want %d: %+v", len(insts), len(want), insts)
+	}
+
+	for i, w := range want {
+		if insts[i].Addr != w.addr || insts[i].Text != w.text {
+			t.Errorf("inst %d = %#x %q, want %#x %q", i, insts[i].Addr, insts[i].Text, w.addr, w.text)
+		}
+
+		if insts[i].Faulting != (w.addr == 0x100d) {
+			t.Errorf("inst %d faulting = %v", i, insts[i].Faulting)
+		}
+	}
+
+	if insts[2].Target != 0x1010 || insts[2].TargetSym != "app!helper" {
+		t.Errorf("call target = %#x %q", insts[2].Target, insts[2].TargetSym)
+	}
+}
+
+func TestAroundX86(t *testing.T) {
+	// 32-bit: e8 rel32 call, then a faulting mov
+	code := []byte{
+		0x55,       // push ebp
+		0x8b, 0xec, // mov ebp, esp
+		0xe8, 0xf8, 0xff, 0xff, 0xff, // call 0x401000
+		0x8b, 0x00, // mov eax, dword ptr [eax]
+	}
+	mem := &memory{base: 0x401000, data: code}
+
+	insts, err := Around(mem, ArchX86, 0x401008, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(insts) != 4 || !insts[3].Faulting {
+		t.Fatalf("unexpected decoding: %+v", insts)
+	}
+
+	if insts[2].Target != 0x401000 {
+		t.Errorf("call target = %#x", insts[2].Target)
+	}
+}
+
+func TestAroundARM64(t *testing.T) {
+	mem := &memory{base: 0x2000, data: arm64Code}
+
+	insts, err := Around(mem, ArchARM64, 0x200c, &Options{Symbolize: symbolize})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(insts) != 5 {
+		t.Fatalf("got %d instructions: %+v", len(insts), insts)
+	}
+
+	if !insts[3].Faulting || insts[3].Addr != 0x200c {
+		t.Errorf("faulting instruction = %+v", insts[3])
+	}
+
+	if insts[2].Target != 0x2010 || insts[2].TargetSym != "app!helper" || insts[2].Text != "bl 0x2010" {
+		t.Errorf("branch = %+v", insts[2])
+	}
+
+	if insts[4].Text != "ret" {
+		t.Errorf("last = %q", insts[4].Text)
+	}
+}
+
+func TestAroundUnreadableBefore(t *testing.T) {
+	// the faulting instruction is the first readable byte
+	mem := &memory{base: 0x100d, data: x64Code[0xd:]}
+
+	insts, err := Around(mem, ArchAMD64, 0x100d, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(insts) == 0 || !insts[0].Faulting || insts[0].Text != "mov eax, dword ptr [rax]" {
+		t.Fatalf("unexpected decoding: %+v", insts)
+	}
+
+	if _, err := Around(mem, ArchAMD64, 0x9000, nil); err == nil {
+		t.Fatal("expected error for unreadable address")
+	}
+}
+
+func TestFormat(t *testing.T) {
+	mem := &memory{base: 0x1000, data: x64Code}
+
+	insts, err := Around(mem, ArchAMD64, 0x100d, &Options{Before: 1, After: 1, Symbolize: symbolize})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	if err := Format(&buf, ArchAMD64, insts); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "  0000000000001008  e803000000            call 0x1010  ; app!helper\n" +
+		"> 000000000000100d  8b00                  mov eax, dword ptr [rax]\n" +
+		"  000000000000100f  c3                    ret\n"
+
+	if buf.String() != want {
+		t.Fatalf("Format:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestArchForMachine(t *testing.T) {
+	arch, err := ArchForMachine(0xAA64)
+	if err != nil || arch != ArchARM64 {
+		t.Fatalf("ArchForMachine(ARM64) = %v, %v", arch, err)
+	}
+
+	if _, err := ArchForMachine(0x01c4); err == nil {
+		t.Fatal("expected error for ARMNT")
+	}
+}
@@ -0,0 +1,402 @@
+//  ---------------------------------------------------------------------------
+//
+//  disasm.go
+//
+//  Copyright (c) 2016, Jared Chavez.
+//  All rights reserved.
+//
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+//
+//  -----------
+
+// Package disasm disassembles the code surrounding an address, typically the
+// faulting instruction of a crash, for inclusion in crash reports.
+package disasm
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/arch/arm64/arm64asm"
+	"golang.org/x/arch/x86/x86asm"
+)
+
+// Arch selects the instruction set to decode.
+type Arch int
+
+const (
+	ArchX86 Arch = iota
+	ArchAMD64
+	ArchARM64
+)
+
+func (a Arch) String() string {
+	switch a {
+	case ArchX86:
+		return "x86"
+	case ArchAMD64:
+		return "x64"
+	case ArchARM64:
+		return "arm64"
+	}
+
+	return fmt.Sprintf("Arch(%d)", int(a))
+}
+
+// IMAGE_FILE_MACHINE_* values accepted by ArchForMachine.
+const (
+	machineI386  = 0x014c
+	machineAMD64 = 0x8664
+	machineARM64 = 0xAA64
+)
+
+// ArchForMachine maps an IMAGE_FILE_MACHINE_* value, such as the one
+// returned by kernel32.ThreadContext.Machine, to an Arch.
+func ArchForMachine(machine uint16) (Arch, error) {
+	switch machine {
+	case machineI386:
+		return ArchX86, nil
+	case machineAMD64:
+		return ArchAMD64, nil
+	case machineARM64:
+		return ArchARM64, nil
+	}
+
+	return 0, fmt.Errorf("unsupported machine type 0x%x", machine)
+}
+
+// maxInstLen is the longest instruction of each architecture.
+func (a Arch) maxInstLen() int {
+	if a == ArchARM64 {
+		return 4
+	}
+
+	return 15
+}
+
+// Symbolizer returns a name such as "module!function+0x10" for an address,
+// or an empty string if the address is unknown.
+type Symbolizer func(addr uint64) string
+
+// Inst is a single decoded instruction.
+type Inst struct {
+	Addr  uint64
+	Bytes []byte
+	Text  string
+
+	// Target is the destination of a direct branch or call, and TargetSym
+	// its symbol when a Symbolizer was supplied.
+	Target    uint64
+	TargetSym string
+
+	// Faulting marks the instruction at the address passed to Around.
+	Faulting bool
+
+	// Bad is set for bytes that do not decode to an instruction.
+	Bad bool
+}
+
+// Options control Around.
+type Options struct {
+	// Before and After are the number of instructions to show before and
+	// after the faulting instruction. Zero uses 8 and 4.
+	Before int
+	After  int
+
+	// Symbolize, when set, annotates branch targets.
+	Symbolize Symbolizer
+}
+
+// Around reads code surrounding addr from mem, which is addressed by
+// virtual address, and disassembles it. The instruction at addr is marked
+// as faulting. If the memory before addr cannot be read, only addr and the
+// instructions following it are returned.
+func Around(mem io.ReaderAt, arch Arch, addr uint64, opts *Options) ([]Inst, error) {
+	if opts == nil {
+		opts = new(Options)
+	}
+
+	before := opts.Before
+	if before <= 0 {
+		before = 8
+	}
+
+	after := opts.After
+	if after <= 0 {
+		after = 4
+	}
+
+	maxLen := uint64(arch.maxInstLen())
+	backBytes := uint64(before) * maxLen
+	if backBytes > addr {
+		backBytes = addr
+	}
+
+	fwd, err := readPartial(mem, addr, int(uint64(after+1)*maxLen))
+	if err != nil {
+		return nil, fmt.Errorf("reading code at 0x%x: %v", addr, err)
+	}
+
+	back, _ := readBack(mem, addr, int(backBytes))
+
+	code := append(back, fwd...)
+	start := addr - uint64(len(back))
+
+	insts := Decode(code, arch, start, addr, opts.Symbolize)
+
+	fault := -1
+	for i := range insts {
+		if insts[i].Faulting {
+			fault = i
+			break
+		}
+	}
+
+	if fault < 0 {
+		// no decoding lands on addr; fall back to decoding from it
+		insts = Decode(fwd, arch, addr, addr, opts.Symbolize)
+		fault = 0
+	}
+
+	first := fault - before
+	if first < 0 {
+		first = 0
+	}
+
+	last := fault + after + 1
+	if last > len(insts) {
+		last = len(insts)
+	}
+
+	return insts[first:last], nil
+}
+
+// Decode disassembles code, which starts at virtual address start. For
+// variable length instruction sets the decoding start is chosen so that the
+// instruction stream is aligned with sync, which is marked as faulting; pass
+// sync == start to decode from the first byte.
+func Decode(code []byte, arch Arch, start, sync uint64, sym Symbolizer) []Inst {
+	if arch == ArchARM64 {
+		// fixed width, so step back to a 4 byte boundary relative to sync
+		skip := (sync - start) % 4
+		return decodeFrom(code[skip:], arch, start+skip, sync, sym)
+	}
+
+	// Try every start offset and keep the decoding that reaches sync
+	// exactly with the fewest undecodable bytes, preferring the earliest
+	// start for the most context.
+	var best []Inst
+	bestBad := -1
+
+	limit := sync - start
+	if limit > uint64(len(code)) {
+		limit = uint64(len(code))
+	}
+
+	for off := uint64(0); off <= limit; off++ {
+		insts := decodeFrom(code[off:], arch, start+off, sync, nil)
+
+		synced := false
+		bad := 0
+		for i := range insts {
+			if insts[i].Addr >= sync {
+				synced = insts[i].Addr == sync
+				break
+			}
+			if insts[i].Bad {
+				bad++
+			}
+		}
+
+		if !synced {
+			continue
+		}
+
+		if bestBad < 0 || bad < bestBad {
+			bestBad = bad
+			best = decodeFrom(code[off:], arch, start+off, sync, sym)
+		}
+
+		if bad == 0 {
+			break
+		}
+	}
+
+	if best == nil {
+		return decodeFrom(code, arch, start, sync, sym)
+	}
+
+	return best
+}
+
+func decodeFrom(code []byte, arch Arch, pc, fault uint64, sym Symbolizer) []Inst {
+	var insts []Inst
+
+	for len(code) > 0 {
+		inst := decodeOne(code, arch, pc)
+		if inst.Bad && len(code) < arch.maxInstLen() {
+			// most likely an instruction truncated by the read window
+			break
+		}
+
+		inst.Faulting = inst.Addr == fault
+		if inst.Target != 0 && sym != nil {
+			inst.TargetSym = sym(inst.Target)
+		}
+
+		insts = append(insts, inst)
+		code = code[len(inst.Bytes):]
+		pc += uint64(len(inst.Bytes))
+	}
+
+	return insts
+}
+
+func decodeOne(code []byte, arch Arch, pc uint64) Inst {
+	switch arch {
+	case ArchARM64:
+		if len(code) < 4 {
+			return Inst{Addr: pc, Bytes: code, Text: "(bad)", Bad: true}
+		}
+
+		inst, err := arm64asm.Decode(code)
+		if err != nil {
+			return Inst{Addr: pc, Bytes: code[:4], Text: "(bad)", Bad: true}
+		}
+
+		out := Inst{Addr: pc, Bytes: code[:4], Text: arm64asm.GNUSyntax(inst)}
+		if isARM64Branch(inst.Op) {
+			for _, arg := range inst.Args {
+				if rel, ok := arg.(arm64asm.PCRel); ok {
+					out.Target = pc + uint64(rel)
+					out.Text = strings.Replace(out.Text, rel.String(), fmt.Sprintf("%#x", out.Target), 1)
+				}
+			}
+		}
+
+		return out
+	default:
+		mode := 64
+		if arch == ArchX86 {
+			mode = 32
+		}
+
+		inst, err := x86asm.Decode(code, mode)
+		if err != nil || inst.Len == 0 {
+			return Inst{Addr: pc, Bytes: code[:1], Text: "(bad)", Bad: true}
+		}
+
+		out := Inst{
+			Addr:  pc,
+			Bytes: code[:inst.Len],
+			Text:  x86asm.IntelSyntax(inst, pc, nil),
+		}
+
+		for _, arg := range inst.Args {
+			if rel, ok := arg.(x86asm.Rel); ok {
+				out.Target = pc + uint64(inst.Len) + uint64(int64(rel))
+			}
+		}
+
+		if arch == ArchX86 {
+			out.Target &= 0xFFFFFFFF
+		}
+
+		return out
+	}
+}
+
+func isARM64Branch(op arm64asm.Op) bool {
+	switch op {
+	case arm64asm.B, arm64asm.BL, arm64asm.CBZ, arm64asm.CBNZ, arm64asm.TBZ, arm64asm.TBNZ:
+		return true
+	}
+
+	return false
+}
+
+// Format writes instructions one per line, marking the faulting instruction
+// with an arrow and appending symbols for branch targets.
+func Format(w io.Writer, arch Arch, insts []Inst) error {
+	addrWidth := 16
+	if arch == ArchX86 {
+		addrWidth = 8
+	}
+
+	byteWidth := arch.maxInstLen() * 2
+	if byteWidth > 20 {
+		byteWidth = 20
+	}
+
+	bw := bufio.NewWriter(w)
+	for _, inst := range insts {
+		marker := "  "
+		if inst.Faulting {
+			marker = "> "
+		}
+
+		line := fmt.Sprintf(
+			"%s%0*x  %-*s  %s",
+			marker,
+			addrWidth,
+			inst.Addr,
+			byteWidth,
+			hex.EncodeToString(inst.Bytes),
+			inst.Text,
+		)
+
+		if inst.TargetSym != "" {
+			line += "  ; " + inst.TargetSym
+		}
+
+		fmt.Fprintln(bw, strings.TrimRight(line, " "))
+	}
+
+	return bw.Flush()
+}
+
+// readPartial reads up to size bytes at addr, keeping whatever could be read
+// before an error. It fails only if nothing could be read.
+func readPartial(mem io.ReaderAt, addr uint64, size int) ([]byte, error) {
+	buffer := make([]byte, size)
+
+	n, err := mem.ReadAt(buffer, int64(addr))
+	if n == 0 && err != nil {
+		return nil, err
+	}
+
+	return buffer[:n], nil
+}
+
+// readBack reads up to size bytes ending at addr. When the whole range is
+// not readable the start is moved forward a page at a time, since
+// unreadable memory before code ends at a page boundary.
+func readBack(mem io.ReaderAt, addr uint64, size int) ([]byte, error) {
+	const pageSize = 4096
+
+	start := addr - uint64(size)
+	for start < addr {
+		buffer := make([]byte, addr-start)
+
+		n, err := mem.ReadAt(buffer, int64(start))
+		if n == len(buffer) {
+			return buffer, nil
+		}
+
+		if err == nil {
+			err = io.ErrUnexpectedEOF
+		}
+
+		next := (start + pageSize) &^ (pageSize - 1)
+		if next >= addr {
+			return nil, err
+		}
+		start = next
+	}
+
+	return nil, nil
+}
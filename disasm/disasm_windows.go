@@ -0,0 +1,69 @@
+//  ---------------------------------------------------------------------------
+//
+//  disasm_windows.go
+//
+//  Copyright (c) 2016, Jared Chavez.
+//  All rights reserved.
+//
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+//
+//  -----------
+
+package disasm
+
+import (
+	"fmt"
+	"syscall"
+
+	"github.com/xaevman/win32/dbgHelp"
+	"github.com/xaevman/win32/kernel32"
+	"github.com/xaevman/win32/procmem"
+)
+
+// ProcessReader adapts a process handle opened with PROCESS_VM_READ to
+// io.ReaderAt, with offsets interpreted as virtual addresses. A read that
+// runs into an unreadable page returns the readable prefix.
+type ProcessReader syscall.Handle
+
+func (p ProcessReader) ReadAt(buffer []byte, addr int64) (int, error) {
+	return procmem.FromHandle(syscall.Handle(p), nil).ReadAt(buffer, addr)
+}
+
+// SymbolizerFor returns a Symbolizer backed by dbgHelp for a process whose
+// symbols have been initialized with dbg.SymInitialize.
+func SymbolizerFor(proc syscall.Handle) Symbolizer {
+	return func(addr uint64) string {
+		var info dbg.SymbolInfo
+
+		err := dbg.SymFromAddr(proc, addr, &info)
+		if err != nil || info.Name == "" {
+			return ""
+		}
+
+		if info.Offset == 0 {
+			return info.Name
+		}
+
+		return fmt.Sprintf("%s+0x%x", info.Name, info.Offset)
+	}
+}
+
+// AroundThread disassembles the code around the current instruction of a
+// thread in proc, using the thread's context to select the architecture.
+func AroundThread(
+	proc syscall.Handle,
+	context kernel32.ThreadContext,
+	opts *Options,
+) ([]Inst, error) {
+	arch, err := ArchForMachine(context.Machine())
+	if err != nil {
+		return nil, err
+	}
+
+	if opts == nil {
+		opts = &Options{Symbolize: SymbolizerFor(proc)}
+	}
+
+	return Around(ProcessReader(proc), arch, context.PC(), opts)
+}
@@ -0,0 +1,282 @@
+//  ---------------------------------------------------------------------------
+//
+//  all_test.go
+//
+//  Copyright (c) 2016, Jared Chavez.
+//  All rights reserved.
+//
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+//
+//  -----------
+
+package dumpstore
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+var epoch = time.Date(2016, 3, 1, 12, 0, 0, 0, time.UTC)
+
+// newStore returns a store in a temp dir whose clock stands at epoch until
+// moved by add.
+func newStore(t *testing.T, policy Policy) (*Store, func()) {
+	dir, err := ioutil.TempDir("", "dumpstore")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := Open(filepath.Join(dir, "dumps"), policy)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := epoch
+	s.Now = func() time.Time { return now }
+
+	return s, func() { os.RemoveAll(dir) }
+}
+
+// add saves a dump a minute after the store's current time.
+func add(t *testing.T, s *Store, reason string, size int) *Entry {
+	now := s.Now().Add(time.Minute)
+	s.Now = func() time.Time { return now }
+
+	entry, err := s.Save(
+		&Meta{Process: "svc.exe", PID: 42, Reason: reason},
+		strings.NewReader(strings.Repeat("x", size)),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return entry
+}
+
+func files(t *testing.T, s *Store) []string {
+	infos, err := ioutil.ReadDir(s.Dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, info := range infos {
+		names = append(names, info.Name())
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func TestName(t *testing.T) {
+	tests := []struct {
+		meta Meta
+		want string
+	}{
+		{
+			Meta{Process: "svc.exe", PID: 42, Time: epoch, Reason: "crash"},
+			"svc-42-20160301-120000.000-crash",
+		},
+		{
+			Meta{PID: 7, Time: epoch.Add(1500 * time.Millisecond)},
+			"unknown-7-20160301-120001.500",
+		},
+		{
+			Meta{Process: "my app", PID: 1, Time: epoch, Reason: `hang: C:\x/y and a very long explanation`},
+			"my_app-1-20160301-120000.000-hang__C__x_y_and_a_very_long_exp",
+		},
+	}
+
+	for _, test := range tests {
+		if got := Name(&test.meta); got != test.want {
+			t.Errorf("Name = %q, want %q", got, test.want)
+		}
+	}
+}
+
+func TestAdd(t *testing.T) {
+	s, cleanup := newStore(t, Policy{})
+	defer cleanup()
+
+	entry, err := s.Save(&Meta{
+		Process:       "svc.exe",
+		PID:           42,
+		Reason:        "crash",
+		ExceptionCode: 0xC0000005,
+		Build:         map[string]string{"version": "1.2.3"},
+	}, strings.NewReader("MDMP"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"svc-42-20160301-120000.000-crash.dmp",
+		"svc-42-20160301-120000.000-crash.json",
+	}
+	if got := files(t, s); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("files %v, want %v", got, want)
+	}
+
+	entries, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 1 || entries[0].Meta == nil {
+		t.Fatalf("entries %+v", entries)
+	}
+
+	meta := entries[0].Meta
+	if meta.Exception != "EXCEPTION_ACCESS_VIOLATION" || meta.Size != 4 ||
+		meta.File != want[0] || meta.Build["version"] != "1.2.3" || !meta.Time.Equal(entry.Time) {
+		t.Fatalf("meta %+v", meta)
+	}
+}
+
+func TestAddFailure(t *testing.T) {
+	s, cleanup := newStore(t, Policy{})
+	defer cleanup()
+
+	_, err := s.Add(&Meta{Process: "svc", PID: 1}, func(path string) error {
+		ioutil.WriteFile(path, []byte("partial"), 0644)
+		return errors.New("dump failed")
+	})
+	if err == nil {
+		t.Fatal("expected error")
+	}
+
+	if got := files(t, s); len(got) != 0 {
+		t.Fatalf("files left behind: %v", got)
+	}
+}
+
+func TestUniqueName(t *testing.T) {
+	s, cleanup := newStore(t, Policy{})
+	defer cleanup()
+
+	meta := &Meta{Process: "svc", PID: 1, Time: epoch}
+	for i := 0; i < 3; i++ {
+		if _, err := s.Save(meta, strings.NewReader("d")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := []string{
+		"svc-1-20160301-120000.000-1.dmp",
+		"svc-1-20160301-120000.000-1.json",
+		"svc-1-20160301-120000.000-2.dmp",
+		"svc-1-20160301-120000.000-2.json",
+		"svc-1-20160301-120000.000.dmp",
+		"svc-1-20160301-120000.000.json",
+	}
+	if got := files(t, s); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("files %v, want %v", got, want)
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy Policy
+		sizes  []int
+		want   []string
+	}{
+		{"unlimited", Policy{}, []int{1, 1, 1}, []string{"a", "b", "c"}},
+		{"count", Policy{MaxCount: 2}, []int{1, 1, 1}, []string{"b", "c"}},
+		{"bytes", Policy{MaxBytes: 10}, []int{4, 4, 4}, []string{"b", "c"}},
+		{"newest kept", Policy{MaxBytes: 10}, []int{4, 4, 20}, []string{"c"}},
+		// dumps are a minute apart, and the last prune runs at c's time
+		{"age", Policy{MaxAge: 90 * time.Second}, []int{1, 1, 1}, []string{"b", "c"}},
+	}
+
+	for _, test := range tests {
+		s, cleanup := newStore(t, test.policy)
+
+		for i, size := range test.sizes {
+			add(t, s, string(rune('a'+i)), size)
+		}
+
+		entries, err := s.List()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got []string
+		for _, entry := range entries {
+			got = append(got, entry.Meta.Reason)
+		}
+
+		if strings.Join(got, ",") != strings.Join(test.want, ",") {
+			t.Errorf("%s: kept %v, want %v", test.name, got, test.want)
+		}
+
+		if n := len(files(t, s)); n != 2*len(test.want) {
+			t.Errorf("%s: %d files for %d dumps", test.name, n, len(test.want))
+		}
+
+		cleanup()
+	}
+}
+
+func TestPruneLeftovers(t *testing.T) {
+	s, cleanup := newStore(t, Policy{})
+	defer cleanup()
+
+	add(t, s, "a", 1)
+
+	stale := filepath.Join(s.Dir, tempPrefix+"svc-42-20160301-110000.000.dmp")
+	fresh := filepath.Join(s.Dir, tempPrefix+"svc-42-20160301-120000.000.dmp")
+	orphan := filepath.Join(s.Dir, "svc-7-20160301-100000.000-gone.json")
+
+	// files the store did not write are kept, however old
+	config := filepath.Join(s.Dir, "config.json")
+	foreign := filepath.Join(s.Dir, tempPrefix+"editor.dmp")
+	for _, path := range []string{stale, fresh, orphan, config, foreign} {
+		ioutil.WriteFile(path, []byte("x"), 0644)
+	}
+
+	// temp files are judged by mtime against the store clock
+	now := time.Now()
+	s.Now = func() time.Time { return now }
+	os.Chtimes(stale, now.Add(-2*staleTemp), now.Add(-2*staleTemp))
+	os.Chtimes(foreign, now.Add(-2*staleTemp), now.Add(-2*staleTemp))
+
+	if _, err := s.Prune(); err != nil {
+		t.Fatal(err)
+	}
+
+	got := files(t, s)
+	want := []string{
+		tempPrefix + "editor.dmp",
+		tempPrefix + "svc-42-20160301-120000.000.dmp",
+		"config.json",
+		"svc-42-20160301-120100.000-a.dmp",
+		"svc-42-20160301-120100.000-a.json",
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Fatalf("files %v, want %v", got, want)
+	}
+}
+
+func TestListWithoutSidecar(t *testing.T) {
+	s, cleanup := newStore(t, Policy{})
+	defer cleanup()
+
+	ioutil.WriteFile(filepath.Join(s.Dir, "manual.dmp"), []byte("MDMP"), 0644)
+
+	entries, err := s.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(entries) != 1 || entries[0].Meta != nil || entries[0].Size != 4 {
+		t.Fatalf("entries %+v", entries)
+	}
+}
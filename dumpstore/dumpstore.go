@@ -0,0 +1,408 @@
+//  ---------------------------------------------------------------------------
+//
+//  dumpstore.go
+//
+//  Copyright (c) 2016, Jared Chavez.
+//  All rights reserved.
+//
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+//
+//  -----------
+
+// Package dumpstore keeps a directory of crash dumps within count, size and
+// age limits. Dumps are named consistently, written atomically, and
+// described by a JSON sidecar file.
+package dumpstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/xaevman/win32/exception"
+)
+
+const (
+	dumpExt    = ".dmp"
+	sidecarExt = ".json"
+	tempPrefix = ".tmp-"
+
+	// staleTemp is the age after which temp files left by an interrupted
+	// write are removed.
+	staleTemp = time.Hour
+
+	maxReasonLen = 32
+)
+
+// storeName matches the names Name and uniqueName produce, so that cleanup
+// leaves other files in Dir alone.
+var storeName = regexp.MustCompile(`^[A-Za-z0-9._-]+-[0-9]+-[0-9]{8}-[0-9]{6}\.[0-9]{3}(-[A-Za-z0-9._-]+)?$`)
+
+// Meta describes a dump. It is stored in the sidecar file.
+type Meta struct {
+	Process string    `json:"process"`
+	PID     uint32    `json:"pid"`
+	Time    time.Time `json:"time"`
+	Reason  string    `json:"reason,omitempty"`
+
+	// ExceptionCode is the NTSTATUS of the crash, if any, and Exception
+	// its name, filled in from ExceptionCode when empty.
+	ExceptionCode uint32 `json:"exception_code,omitempty"`
+	Exception     string `json:"exception,omitempty"`
+
+	// Build holds build information such as version and commit.
+	Build map[string]string `json:"build,omitempty"`
+
+	// Annotations holds any other key/value data.
+	Annotations map[string]string `json:"annotations,omitempty"`
+
+	// File and Size are set by the store.
+	File string `json:"file"`
+	Size int64  `json:"size"`
+}
+
+// Policy limits the contents of a store. Zero fields are unlimited.
+type Policy struct {
+	MaxCount int
+	MaxBytes int64
+	MaxAge   time.Duration
+}
+
+// Entry is a dump in the store.
+type Entry struct {
+	Path string
+	Size int64
+	Time time.Time
+
+	// Meta is nil when the sidecar is missing or unreadable.
+	Meta *Meta
+}
+
+// Store is a dump directory.
+type Store struct {
+	Dir    string
+	Policy Policy
+
+	// Now returns the current time. Nil uses time.Now.
+	Now func() time.Time
+}
+
+// Open returns a store for dir, creating the directory if needed.
+func Open(dir string, policy Policy) (*Store, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	return &Store{Dir: dir, Policy: policy}, nil
+}
+
+func (s *Store) now() time.Time {
+	if s.Now != nil {
+		return s.Now()
+	}
+
+	return time.Now()
+}
+
+// Name returns the base file name for a dump, without extension:
+// process-pid-timestamp[-reason], with unsafe characters replaced.
+func Name(meta *Meta) string {
+	process := strings.TrimSuffix(meta.Process, filepath.Ext(meta.Process))
+	if process == "" {
+		process = "unknown"
+	}
+
+	name := fmt.Sprintf(
+		"%s-%d-%s",
+		sanitize(process),
+		meta.PID,
+		meta.Time.UTC().Format("20060102-150405.000"),
+	)
+
+	if meta.Reason != "" {
+		reason := sanitize(meta.Reason)
+		if len(reason) > maxReasonLen {
+			reason = reason[:maxReasonLen]
+		}
+		name += "-" + reason
+	}
+
+	return name
+}
+
+func sanitize(s string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		case r == '.', r == '_', r == '-':
+			return r
+		}
+		return '_'
+	}, s)
+}
+
+// Add stores a dump. fill writes the dump to the temp path it is given,
+// for example with dbg.WriteMiniDump; the dump is renamed into place only
+// if fill succeeds. The sidecar is written next, and then the store is
+// pruned. The newest dump is never pruned.
+func (s *Store) Add(meta *Meta, fill func(path string) error) (*Entry, error) {
+	m := *meta
+	if m.Time.IsZero() {
+		m.Time = s.now()
+	}
+
+	if m.ExceptionCode != 0 && m.Exception == "" {
+		m.Exception = exception.Code(m.ExceptionCode).String()
+	}
+
+	base := s.uniqueName(Name(&m))
+	path := filepath.Join(s.Dir, base+dumpExt)
+	temp := filepath.Join(s.Dir, tempPrefix+base+dumpExt)
+
+	if err := fill(temp); err != nil {
+		os.Remove(temp)
+		return nil, err
+	}
+
+	info, err := os.Stat(temp)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.Rename(temp, path); err != nil {
+		os.Remove(temp)
+		return nil, err
+	}
+
+	m.File = base + dumpExt
+	m.Size = info.Size()
+
+	if err := s.writeSidecar(base, &m); err != nil {
+		return nil, err
+	}
+
+	entry := &Entry{Path: path, Size: m.Size, Time: m.Time, Meta: &m}
+
+	if _, err := s.prune(path); err != nil {
+		return entry, err
+	}
+
+	return entry, nil
+}
+
+// Save stores a dump read from r. See Add.
+func (s *Store) Save(meta *Meta, r io.Reader) (*Entry, error) {
+	return s.Add(meta, func(path string) error {
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+
+		_, err = io.Copy(f, r)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+
+		return err
+	})
+}
+
+// uniqueName appends a counter when a dump of the same name exists.
+func (s *Store) uniqueName(base string) string {
+	name := base
+	for i := 1; ; i++ {
+		_, err := os.Stat(filepath.Join(s.Dir, name+dumpExt))
+		if os.IsNotExist(err) {
+			return name
+		}
+
+		name = fmt.Sprintf("%s-%d", base, i)
+	}
+}
+
+func (s *Store) writeSidecar(base string, meta *Meta) error {
+	data, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	temp := filepath.Join(s.Dir, tempPrefix+base+sidecarExt)
+	if err := ioutil.WriteFile(temp, append(data, '\n'), 0644); err != nil {
+		os.Remove(temp)
+		return err
+	}
+
+	return os.Rename(temp, filepath.Join(s.Dir, base+sidecarExt))
+}
+
+// List returns the dumps in the store, oldest first. The time of a dump is
+// taken from its sidecar, or from the file when there is none.
+func (s *Store) List() ([]Entry, error) {
+	infos, err := ioutil.ReadDir(s.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []Entry
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() || strings.HasPrefix(name, tempPrefix) || filepath.Ext(name) != dumpExt {
+			continue
+		}
+
+		entry := Entry{
+			Path: filepath.Join(s.Dir, name),
+			Size: info.Size(),
+			Time: info.ModTime(),
+		}
+
+		if meta, err := s.readSidecar(strings.TrimSuffix(name, dumpExt)); err == nil {
+			entry.Meta = meta
+			if !meta.Time.IsZero() {
+				entry.Time = meta.Time
+			}
+		}
+
+		entries = append(entries, entry)
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		if entries[i].Time.Equal(entries[j].Time) {
+			return entries[i].Path < entries[j].Path
+		}
+		return entries[i].Time.Before(entries[j].Time)
+	})
+
+	return entries, nil
+}
+
+func (s *Store) readSidecar(base string) (*Meta, error) {
+	data, err := ioutil.ReadFile(filepath.Join(s.Dir, base+sidecarExt))
+	if err != nil {
+		return nil, err
+	}
+
+	var meta Meta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, err
+	}
+
+	return &meta, nil
+}
+
+// Prune enforces the policy, removing the oldest dumps first, and returns
+// the removed entries. Temp files left by interrupted writes and sidecars
+// without a dump are removed too.
+func (s *Store) Prune() ([]Entry, error) {
+	return s.prune("")
+}
+
+func (s *Store) prune(keep string) ([]Entry, error) {
+	if err := s.removeLeftovers(); err != nil {
+		return nil, err
+	}
+
+	entries, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+
+	if keep == "" && len(entries) > 0 {
+		keep = entries[len(entries)-1].Path
+	}
+
+	var total int64
+	for _, entry := range entries {
+		total += entry.Size
+	}
+
+	now := s.now()
+	count := len(entries)
+
+	var removed []Entry
+	for _, entry := range entries {
+		expired := s.Policy.MaxAge > 0 && now.Sub(entry.Time) > s.Policy.MaxAge
+		tooMany := s.Policy.MaxCount > 0 && count > s.Policy.MaxCount
+		tooBig := s.Policy.MaxBytes > 0 && total > s.Policy.MaxBytes
+
+		if !expired && !tooMany && !tooBig {
+			continue
+		}
+
+		if entry.Path == keep {
+			continue
+		}
+
+		if err := s.remove(entry.Path); err != nil {
+			return removed, err
+		}
+
+		removed = append(removed, entry)
+		count--
+		total -= entry.Size
+	}
+
+	return removed, nil
+}
+
+// remove deletes a dump and its sidecar.
+func (s *Store) remove(path string) error {
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	err := os.Remove(strings.TrimSuffix(path, dumpExt) + sidecarExt)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
+
+func (s *Store) removeLeftovers() error {
+	infos, err := ioutil.ReadDir(s.Dir)
+	if err != nil {
+		return err
+	}
+
+	now := s.now()
+	for _, info := range infos {
+		name := info.Name()
+		path := filepath.Join(s.Dir, name)
+
+		switch {
+		case info.IsDir():
+			continue
+
+		case strings.HasPrefix(name, tempPrefix):
+			ext := filepath.Ext(name)
+			base := strings.TrimSuffix(strings.TrimPrefix(name, tempPrefix), ext)
+
+			if (ext == dumpExt || ext == sidecarExt) && storeName.MatchString(base) &&
+				now.Sub(info.ModTime()) > staleTemp {
+				os.Remove(path)
+			}
+
+		case filepath.Ext(name) == sidecarExt:
+			if !storeName.MatchString(strings.TrimSuffix(name, sidecarExt)) {
+				continue
+			}
+
+			dump := strings.TrimSuffix(path, sidecarExt) + dumpExt
+			if _, err := os.Stat(dump); os.IsNotExist(err) {
+				os.Remove(path)
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,32 @@
+//  ---------------------------------------------------------------------------
+//
+//  dumpstore_windows.go
+//
+//  Copyright (c) 2016, Jared Chavez.
+//  All rights reserved.
+//
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+//
+//  -----------
+
+package dumpstore
+
+import (
+	"syscall"
+
+	"github.com/xaevman/win32/dbgHelp"
+)
+
+// WriteMiniDump writes a dump of proc into the store. meta.PID is used as
+// the process ID.
+func (s *Store) WriteMiniDump(
+	proc syscall.Handle,
+	dumpType uint32,
+	meta *Meta,
+	exceptionInfo *dbg.MinidumpExceptionInformation,
+) (*Entry, error) {
+	return s.Add(meta, func(path string) error {
+		return dbg.WriteMiniDumpEx(proc, meta.PID, dumpType, path, exceptionInfo)
+	})
+}
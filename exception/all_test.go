@@ -0,0 +1,264 @@
+//  ---------------------------------------------------------------------------
+//
+//  all_test.go
+//
+//  Copyright (c) 2016, Jared Chavez.
+//  All rights reserved.
+//
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+//
+//  -----------
+
+package exception
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func record64(code Code, flags uint32, nested, addr uint64, params ...uint64) []byte {
+	data := make([]byte, Record64Size)
+
+	le := binary.LittleEndian
+	le.PutUint32(data[0:], uint32(code))
+	le.PutUint32(data[4:], flags)
+	le.PutUint64(data[8:], nested)
+	le.PutUint64(data[16:], addr)
+	le.PutUint32(data[24:], uint32(len(params)))
+	for i, p := range params {
+		le.PutUint64(data[32+8*i:], p)
+	}
+
+	return data
+}
+
+func record32(code Code, nested, addr uint32, params ...uint32) []byte {
+	data := make([]byte, Record32Size)
+
+	le := binary.LittleEndian
+	le.PutUint32(data[0:], uint32(code))
+	le.PutUint32(data[8:], nested)
+	le.PutUint32(data[12:], addr)
+	le.PutUint32(data[16:], uint32(len(params)))
+	for i, p := range params {
+		le.PutUint32(data[20+4*i:], p)
+	}
+
+	return data
+}
+
+// memory is an address space with records placed at fixed addresses.
+type memory map[uint64][]byte
+
+func (m memory) ReadAt(p []byte, off int64) (int, error) {
+	data, ok := m[uint64(off)]
+	if !ok {
+		return 0, errors.New("unreadable")
+	}
+
+	return copy(p, data), nil
+}
+
+func TestTableSorted(t *testing.T) {
+	for i := 1; i < len(ntstatusTable); i++ {
+		if ntstatusTable[i-1].code >= ntstatusTable[i].code {
+			t.Fatalf("table out of order at %s", ntstatusTable[i].name)
+		}
+	}
+}
+
+func TestCodeNames(t *testing.T) {
+	tests := []struct {
+		code   Code
+		name   string
+		status string
+	}{
+		{AccessViolation, "EXCEPTION_ACCESS_VIOLATION", "STATUS_ACCESS_VIOLATION"},
+		{Breakpoint, "EXCEPTION_BREAKPOINT", "STATUS_BREAKPOINT"},
+		{StackBufferOverrun, "STATUS_STACK_BUFFER_OVERRUN", "STATUS_STACK_BUFFER_OVERRUN"},
+		{ControlC, "DBG_CONTROL_C", "DBG_CONTROL_C"},
+		{CPlusPlus, "CPP_EH_EXCEPTION", ""},
+		{0, "STATUS_SUCCESS", "STATUS_SUCCESS"},
+	}
+
+	for _, test := range tests {
+		if name := test.code.Name(); name != test.name {
+			t.Errorf("%#x Name = %q, want %q", uint32(test.code), name, test.name)
+		}
+
+		if status := test.code.StatusName(); status != test.status {
+			t.Errorf("%#x StatusName = %q, want %q", uint32(test.code), status, test.status)
+		}
+	}
+
+	if s := Code(0xE0001234).String(); s != "0xE0001234" {
+		t.Errorf("unknown code String = %q", s)
+	}
+
+	if msg := StackBufferOverrun.Message(); !strings.Contains(msg, "stack-based buffer") {
+		t.Errorf("StackBufferOverrun message = %q", msg)
+	}
+
+	if CPlusPlus.Severity() != 3 || !CPlusPlus.IsCustomer() || AccessViolation.IsCustomer() {
+		t.Error("unexpected severity or customer bit")
+	}
+}
+
+func TestRecordString(t *testing.T) {
+	tests := []struct {
+		record Record
+		want   string
+	}{
+		{
+			Record{Code: AccessViolation, Parameters: []uint64{1, 0x10}},
+			"EXCEPTION_ACCESS_VIOLATION writing 0x0000000000000010",
+		},
+		{
+			Record{Code: AccessViolation, Parameters: []uint64{0, 0}},
+			"EXCEPTION_ACCESS_VIOLATION reading 0x0000000000000000",
+		},
+		{
+			Record{Code: AccessViolation, Parameters: []uint64{8, 0x7ff612340000}},
+			"EXCEPTION_ACCESS_VIOLATION executing 0x00007ff612340000",
+		},
+		{
+			Record{Code: InPageError, Parameters: []uint64{0, 0x1000, 0xC000009C}},
+			"EXCEPTION_IN_PAGE_ERROR reading 0x0000000000001000 (STATUS_DEVICE_DATA_ERROR)",
+		},
+		{
+			Record{Code: StackBufferOverrun, Parameters: []uint64{2}},
+			"STATUS_STACK_BUFFER_OVERRUN FAST_FAIL_STACK_COOKIE_CHECK_FAILURE",
+		},
+		{
+			Record{Code: AccessViolation},
+			"EXCEPTION_ACCESS_VIOLATION",
+		},
+		{
+			Record{Code: IntDivideByZero},
+			"EXCEPTION_INT_DIVIDE_BY_ZERO",
+		},
+	}
+
+	for _, test := range tests {
+		if got := test.record.String(); got != test.want {
+			t.Errorf("String = %q, want %q", got, test.want)
+		}
+	}
+}
+
+func TestParseRecord(t *testing.T) {
+	r, err := ParseRecord64(record64(AccessViolation, FlagNoncontinuable, 0x2000, 0x7ff6_0000_1234, 1, 0x10))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r.Code != AccessViolation || !r.Noncontinuable() || r.NestedAddress != 0x2000 ||
+		r.Address != 0x7ff600001234 || len(r.Parameters) != 2 {
+		t.Fatalf("unexpected record %+v", r)
+	}
+
+	r, err = ParseRecord32(record32(AccessViolation, 0, 0x401000, 0, 0xdeadbeef))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if access, addr, ok := r.AccessViolation(); !ok || access != AccessRead || addr != 0xdeadbeef {
+		t.Fatalf("AccessViolation = %v %#x %v", access, addr, ok)
+	}
+
+	bad := record64(AccessViolation, 0, 0, 0)
+	binary.LittleEndian.PutUint32(bad[24:], 16)
+	if _, err := ParseRecord64(bad); err == nil {
+		t.Error("expected error for too many parameters")
+	}
+
+	if _, err := ParseRecord32(make([]byte, 10)); err == nil {
+		t.Error("expected error for short record")
+	}
+}
+
+func TestDecodeNested(t *testing.T) {
+	mem := memory{
+		0x1000: record64(CPlusPlus, FlagNoncontinuable, 0x2000, 0x401000),
+		0x2000: record64(AccessViolation, 0, 0, 0x402000, 1, 0x10),
+	}
+
+	r, err := Decode(mem, 0x1000, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chain := r.Chain()
+	if len(chain) != 2 || chain[1].Code != AccessViolation {
+		t.Fatalf("unexpected chain %+v", chain)
+	}
+
+	desc := r.Describe()
+	if !strings.Contains(desc, "nested: EXCEPTION_ACCESS_VIOLATION writing 0x0000000000000010 at 0x0000000000402000") {
+		t.Errorf("Describe:\n%s", desc)
+	}
+
+	// a cyclic chain is cut off
+	cyclic := memory{0x1000: record32(Breakpoint, 0x1000, 0x401000)}
+	r, err = Decode(cyclic, 0x1000, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n := len(r.Chain()); n != maxNested+1 {
+		t.Errorf("cyclic chain length %d", n)
+	}
+
+	broken := memory{0x1000: record64(Breakpoint, 0, 0x3000, 0x401000)}
+	if _, err := Decode(broken, 0x1000, true); err == nil {
+		t.Error("expected error for unreadable nested record")
+	}
+}
+
+func TestReadDump(t *testing.T) {
+	stream := make([]byte, StreamSize)
+	binary.LittleEndian.PutUint32(stream[0:], 42)
+	copy(stream[8:], record64(AccessViolation, 0, 0, 0x401000, 1, 0x10))
+	binary.LittleEndian.PutUint32(stream[160:], 1232)
+	binary.LittleEndian.PutUint32(stream[164:], 0x400)
+
+	var dump bytes.Buffer
+	le := binary.LittleEndian
+
+	header := make([]byte, dumpHeaderSize)
+	le.PutUint32(header[0:], dumpSignature)
+	le.PutUint32(header[8:], 2)
+	le.PutUint32(header[12:], dumpHeaderSize)
+	dump.Write(header)
+
+	dir := make([]byte, 2*dumpDirectorySize)
+	le.PutUint32(dir[0:], 3) // ThreadListStream
+	le.PutUint32(dir[12:], exceptionStreamType)
+	le.PutUint32(dir[16:], StreamSize)
+	le.PutUint32(dir[20:], uint32(dumpHeaderSize+len(dir)))
+	dump.Write(dir)
+	dump.Write(stream)
+
+	s, err := ReadDump(bytes.NewReader(dump.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if s.ThreadID != 42 || s.ContextSize != 1232 || s.ContextRVA != 0x400 {
+		t.Errorf("unexpected stream %+v", s)
+	}
+
+	if got := s.Record.String(); got != "EXCEPTION_ACCESS_VIOLATION writing 0x0000000000000010" {
+		t.Errorf("record = %q", got)
+	}
+
+	le.PutUint32(dir[12:], 7)
+	empty := append(append(header, dir...), stream...)
+	if _, err := ReadDump(bytes.NewReader(empty)); err != ErrNoException {
+		t.Errorf("ReadDump without exception = %v", err)
+	}
+}
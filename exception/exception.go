@@ -0,0 +1,332 @@
+//  ---------------------------------------------------------------------------
+//
+//  exception.go
+//
+//  Copyright (c) 2016, Jared Chavez.
+//  All rights reserved.
+//
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+//
+//  -----------
+
+// Package exception decodes Windows exception records, as found in
+// minidump exception streams and debug events, into readable form.
+package exception
+
+//go:generate go run gen_ntstatus.go -o ntstatus.go $NTSTATUS_H
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type ntstatusEntry struct {
+	code    uint32
+	name    string
+	message string
+}
+
+// Code is an exception code, which is an NTSTATUS value.
+type Code uint32
+
+// Common exception codes.
+const (
+	AccessViolation      Code = 0xC0000005
+	InPageError          Code = 0xC0000006
+	InvalidHandle        Code = 0xC0000008
+	NoMemory             Code = 0xC0000017
+	IllegalInstruction   Code = 0xC000001D
+	NoncontinuableExcept Code = 0xC0000025
+	InvalidDisposition   Code = 0xC0000026
+	ArrayBoundsExceeded  Code = 0xC000008C
+	FltDenormalOperand   Code = 0xC000008D
+	FltDivideByZero      Code = 0xC000008E
+	FltInexactResult     Code = 0xC000008F
+	FltInvalidOperation  Code = 0xC0000090
+	FltOverflow          Code = 0xC0000091
+	FltStackCheck        Code = 0xC0000092
+	FltUnderflow         Code = 0xC0000093
+	IntDivideByZero      Code = 0xC0000094
+	IntOverflow          Code = 0xC0000095
+	PrivInstruction      Code = 0xC0000096
+	StackOverflow        Code = 0xC00000FD
+	PossibleDeadlock     Code = 0xC0000194
+	StackBufferOverrun   Code = 0xC0000409
+	InvalidCRuntimeParam Code = 0xC0000417
+	FailFast             Code = 0xC0000602
+	GuardPage            Code = 0x80000001
+	DatatypeMisalignment Code = 0x80000002
+	Breakpoint           Code = 0x80000003
+	SingleStep           Code = 0x80000004
+	WX86Breakpoint       Code = 0x4000001F
+	ControlC             Code = 0x40010005
+	CPlusPlus            Code = 0xE06D7363
+	CLR                  Code = 0xE0434352
+	SetThreadName        Code = 0x406D1388
+	HeapCorruption       Code = 0xC0000374
+	UnwindConsolidate    Code = 0x80000029
+	AssertionFailure     Code = 0xC0000420
+	DebugOutputStringA   Code = 0x40010006
+	DebugOutputStringW   Code = 0x4001000A
+)
+
+// exceptionNames are the names used by winnt.h and the C runtimes, which
+// are preferred to the NTSTATUS name of the same code.
+var exceptionNames = map[Code]string{
+	AccessViolation:      "EXCEPTION_ACCESS_VIOLATION",
+	InPageError:          "EXCEPTION_IN_PAGE_ERROR",
+	InvalidHandle:        "EXCEPTION_INVALID_HANDLE",
+	IllegalInstruction:   "EXCEPTION_ILLEGAL_INSTRUCTION",
+	NoncontinuableExcept: "EXCEPTION_NONCONTINUABLE_EXCEPTION",
+	InvalidDisposition:   "EXCEPTION_INVALID_DISPOSITION",
+	ArrayBoundsExceeded:  "EXCEPTION_ARRAY_BOUNDS_EXCEEDED",
+	FltDenormalOperand:   "EXCEPTION_FLT_DENORMAL_OPERAND",
+	FltDivideByZero:      "EXCEPTION_FLT_DIVIDE_BY_ZERO",
+	FltInexactResult:     "EXCEPTION_FLT_INEXACT_RESULT",
+	FltInvalidOperation:  "EXCEPTION_FLT_INVALID_OPERATION",
+	FltOverflow:          "EXCEPTION_FLT_OVERFLOW",
+	FltStackCheck:        "EXCEPTION_FLT_STACK_CHECK",
+	FltUnderflow:         "EXCEPTION_FLT_UNDERFLOW",
+	IntDivideByZero:      "EXCEPTION_INT_DIVIDE_BY_ZERO",
+	IntOverflow:          "EXCEPTION_INT_OVERFLOW",
+	PrivInstruction:      "EXCEPTION_PRIV_INSTRUCTION",
+	StackOverflow:        "EXCEPTION_STACK_OVERFLOW",
+	PossibleDeadlock:     "EXCEPTION_POSSIBLE_DEADLOCK",
+	GuardPage:            "EXCEPTION_GUARD_PAGE",
+	DatatypeMisalignment: "EXCEPTION_DATATYPE_MISALIGNMENT",
+	Breakpoint:           "EXCEPTION_BREAKPOINT",
+	SingleStep:           "EXCEPTION_SINGLE_STEP",
+	CPlusPlus:            "CPP_EH_EXCEPTION",
+	CLR:                  "CLR_EXCEPTION",
+	SetThreadName:        "MS_VC_EXCEPTION",
+}
+
+// lookup finds the NTSTATUS table entry of a code.
+func (c Code) lookup() *ntstatusEntry {
+	i := sort.Search(len(ntstatusTable), func(i int) bool {
+		return ntstatusTable[i].code >= uint32(c)
+	})
+
+	if i < len(ntstatusTable) && ntstatusTable[i].code == uint32(c) {
+		return &ntstatusTable[i]
+	}
+
+	return nil
+}
+
+// Name returns the symbolic name of the code, preferring the EXCEPTION_*
+// name where there is one, or an empty string for an unknown code.
+func (c Code) Name() string {
+	if name, ok := exceptionNames[c]; ok {
+		return name
+	}
+
+	return c.StatusName()
+}
+
+// StatusName returns the STATUS_* name of the code from ntstatus.h.
+func (c Code) StatusName() string {
+	if entry := c.lookup(); entry != nil {
+		return entry.name
+	}
+
+	return ""
+}
+
+// Message returns the ntstatus.h message text of the code, or an empty
+// string if the table has none for it. Messages may contain printf style
+// inserts such as %p, which are left unexpanded.
+func (c Code) Message() string {
+	if entry := c.lookup(); entry != nil {
+		return entry.message
+	}
+
+	return ""
+}
+
+// Severity returns the top two bits of the code: 0 for success, 1 for
+// informational, 2 for warning and 3 for error.
+func (c Code) Severity() int {
+	return int(c >> 30)
+}
+
+// IsCustomer reports whether the customer bit is set, as it is for
+// software exceptions such as C++ and CLR exceptions.
+func (c Code) IsCustomer() bool {
+	return c&0x20000000 != 0
+}
+
+func (c Code) String() string {
+	if name := c.Name(); name != "" {
+		return name
+	}
+
+	return fmt.Sprintf("0x%08X", uint32(c))
+}
+
+// EXCEPTION_NONCONTINUABLE in Record.Flags.
+const FlagNoncontinuable = 0x1
+
+// maxParameters is EXCEPTION_MAXIMUM_PARAMETERS.
+const maxParameters = 15
+
+// AccessType is the first parameter of access violations and in-page
+// errors.
+type AccessType uint64
+
+const (
+	AccessRead    AccessType = 0
+	AccessWrite   AccessType = 1
+	AccessExecute AccessType = 8
+)
+
+func (a AccessType) String() string {
+	switch a {
+	case AccessRead:
+		return "reading"
+	case AccessWrite:
+		return "writing"
+	case AccessExecute:
+		return "executing"
+	}
+
+	return fmt.Sprintf("access type %d", uint64(a))
+}
+
+// Fast fail codes passed as the first parameter of
+// STATUS_STACK_BUFFER_OVERRUN and STATUS_FAIL_FAST_EXCEPTION.
+var fastFailNames = []string{
+	"FAST_FAIL_LEGACY_GS_VIOLATION",
+	"FAST_FAIL_VTGUARD_CHECK_FAILURE",
+	"FAST_FAIL_STACK_COOKIE_CHECK_FAILURE",
+	"FAST_FAIL_CORRUPT_LIST_ENTRY",
+	"FAST_FAIL_INCORRECT_STACK",
+	"FAST_FAIL_INVALID_ARG",
+	"FAST_FAIL_GS_COOKIE_INIT",
+	"FAST_FAIL_FATAL_APP_EXIT",
+	"FAST_FAIL_RANGE_CHECK_FAILURE",
+	"FAST_FAIL_UNSAFE_REGISTRY_ACCESS",
+	"FAST_FAIL_GUARD_ICALL_CHECK_FAILURE",
+	"FAST_FAIL_GUARD_WRITE_CHECK_FAILURE",
+	"FAST_FAIL_INVALID_FIBER_SWITCH",
+	"FAST_FAIL_INVALID_SET_OF_CONTEXT",
+	"FAST_FAIL_INVALID_REFERENCE_COUNT",
+}
+
+// FastFailName returns the FAST_FAIL_* name of a fast fail code.
+func FastFailName(code uint64) string {
+	if code < uint64(len(fastFailNames)) {
+		return fastFailNames[code]
+	}
+
+	return fmt.Sprintf("FAST_FAIL_%d", code)
+}
+
+// Record is a decoded EXCEPTION_RECORD.
+type Record struct {
+	Code    Code
+	Flags   uint32
+	Address uint64
+
+	// Parameters holds the first NumberParameters entries of
+	// ExceptionInformation.
+	Parameters []uint64
+
+	// NestedAddress is the address of the nested record in the faulting
+	// process, and Nested the nested record once it has been resolved.
+	NestedAddress uint64
+	Nested        *Record
+}
+
+// Noncontinuable reports whether EXCEPTION_NONCONTINUABLE is set.
+func (r *Record) Noncontinuable() bool {
+	return r.Flags&FlagNoncontinuable != 0
+}
+
+// AccessViolation returns the access type and the inaccessible address of
+// an access violation or in-page error.
+func (r *Record) AccessViolation() (AccessType, uint64, bool) {
+	if (r.Code != AccessViolation && r.Code != InPageError) || len(r.Parameters) < 2 {
+		return 0, 0, false
+	}
+
+	return AccessType(r.Parameters[0]), r.Parameters[1], true
+}
+
+// InPageStatus returns the NTSTATUS of the I/O failure behind an in-page
+// error.
+func (r *Record) InPageStatus() (Code, bool) {
+	if r.Code != InPageError || len(r.Parameters) < 3 {
+		return 0, false
+	}
+
+	return Code(r.Parameters[2]), true
+}
+
+// FastFail returns the fast fail code of a __fastfail exception.
+func (r *Record) FastFail() (uint64, bool) {
+	if (r.Code != StackBufferOverrun && r.Code != FailFast) || len(r.Parameters) < 1 {
+		return 0, false
+	}
+
+	return r.Parameters[0], true
+}
+
+// Chain returns the record followed by its resolved nested records.
+func (r *Record) Chain() []*Record {
+	var chain []*Record
+	for rec := r; rec != nil && len(chain) <= maxNested; rec = rec.Nested {
+		chain = append(chain, rec)
+	}
+
+	return chain
+}
+
+// String renders the record on one line, for example
+// "EXCEPTION_ACCESS_VIOLATION writing 0x0000000000000010".
+func (r *Record) String() string {
+	if access, addr, ok := r.AccessViolation(); ok {
+		s := fmt.Sprintf("%v %v 0x%016x", r.Code, access, addr)
+		if status, ok := r.InPageStatus(); ok {
+			s += fmt.Sprintf(" (%v)", status)
+		}
+
+		return s
+	}
+
+	if code, ok := r.FastFail(); ok {
+		return fmt.Sprintf("%v %s", r.Code, FastFailName(code))
+	}
+
+	return r.Code.String()
+}
+
+// Describe renders the record and its nested records on multiple lines,
+// including the exception address, flags and the ntstatus.h message.
+func (r *Record) Describe() string {
+	var b strings.Builder
+
+	for i, rec := range r.Chain() {
+		if i > 0 {
+			b.WriteString("nested: ")
+		}
+
+		fmt.Fprintf(&b, "%v at 0x%016x", rec, rec.Address)
+		if rec.Noncontinuable() {
+			b.WriteString(" (noncontinuable)")
+		}
+		b.WriteByte('\n')
+
+		if msg := rec.Code.Message(); msg != "" && !strings.Contains(msg, "%") {
+			fmt.Fprintf(&b, "  %s\n", msg)
+		}
+
+		for j, param := range rec.Parameters {
+			fmt.Fprintf(&b, "  param[%d] 0x%x\n", j, param)
+		}
+	}
+
+	return b.String()
+}
@@ -0,0 +1,67 @@
+//  ---------------------------------------------------------------------------
+//
+//  exception_windows.go
+//
+//  Copyright (c) 2016, Jared Chavez.
+//  All rights reserved.
+//
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+//
+//  -----------
+
+package exception
+
+import (
+	"errors"
+	"io"
+	"unsafe"
+
+	"github.com/xaevman/win32/kernel32"
+)
+
+// FromNative converts an exception record of the calling process's own
+// layout, as found in EXCEPTION_POINTERS or a debug event.
+func FromNative(native *kernel32.EXCEPTION_RECORD) *Record {
+	r := &Record{
+		Code:          Code(native.ExceptionCode),
+		Flags:         native.ExceptionFlags,
+		Address:       uint64(native.ExceptionAddress),
+		NestedAddress: uint64(native.ExceptionRecord),
+	}
+
+	count := native.NumberParameters
+	if count > maxParameters {
+		count = maxParameters
+	}
+
+	for i := uint32(0); i < count; i++ {
+		r.Parameters = append(r.Parameters, uint64(native.ExceptionInformation[i]))
+	}
+
+	return r
+}
+
+// FromDebugEvent decodes the exception of an EXCEPTION_DEBUG_EVENT and
+// reports whether it is a first chance exception. Nested records are read
+// from mem, the debuggee's address space, when it is not nil.
+//
+// The debugger and debuggee must have the same pointer size; WOW64
+// debuggees report through the native layout of the debugger.
+func FromDebugEvent(event *kernel32.DEBUG_EVENT, mem io.ReaderAt) (*Record, bool, error) {
+	if event.DebugEventCode != kernel32.EXCEPTION_DEBUG_EVENT {
+		return nil, false, errors.New("not an exception debug event")
+	}
+
+	info := event.Exception()
+	r := FromNative(&info.ExceptionRecord)
+
+	if mem != nil {
+		is64 := unsafe.Sizeof(uintptr(0)) == 8
+		if err := ResolveNested(r, mem, is64); err != nil {
+			return r, info.FirstChance != 0, err
+		}
+	}
+
+	return r, info.FirstChance != 0, nil
+}
@@ -0,0 +1,167 @@
+//  ---------------------------------------------------------------------------
+//
+//  gen_ntstatus.go
+//
+//  Copyright (c) 2016, Jared Chavez.
+//  All rights reserved.
+//
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+//
+//  -----------
+
+//go:build ignore
+// +build ignore
+
+// gen_ntstatus generates ntstatus.go from the ntstatus.h header of the
+// Windows SDK:
+//
+//	go run gen_ntstatus.go -o ntstatus.go "%WindowsSdkDir%\Include\<ver>\shared\ntstatus.h"
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var defineRe = regexp.MustCompile(`^#define\s+(STATUS_\w+|DBG_\w+|RPC_NT_\w+)\s+\(\(NTSTATUS\)(0x[0-9A-Fa-f]+)L?\)`)
+
+type status struct {
+	code    uint32
+	name    string
+	message string
+}
+
+func main() {
+	out := flag.String("o", "ntstatus.go", "output file")
+	flag.Parse()
+
+	if flag.NArg() != 1 {
+		log.Fatal("usage: gen_ntstatus [-o file] ntstatus.h")
+	}
+
+	statuses, err := parse(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// the SDK header has message text for every code; a header without it
+	// is not one to generate from
+	var missing []string
+	for _, s := range statuses {
+		if s.message == "" {
+			missing = append(missing, s.name)
+		}
+	}
+
+	if len(missing) > 0 {
+		log.Fatalf("%s: %d of %d codes have no message text, such as %s",
+			flag.Arg(0), len(missing), len(statuses), missing[0])
+	}
+
+	src, err := generate(statuses)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	err = ioutil.WriteFile(*out, src, 0644)
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+// parse reads the MessageText comment blocks and #define lines of
+// ntstatus.h. Where several names share a code the first one wins.
+func parse(path string) ([]status, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var statuses []status
+	seen := make(map[uint32]bool)
+
+	var message []string
+	inText := false
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case line == "// MessageText:":
+			inText = true
+			message = message[:0]
+			continue
+		case inText && strings.HasPrefix(line, "//"):
+			text := strings.TrimSpace(strings.TrimPrefix(line, "//"))
+			if text != "" {
+				message = append(message, text)
+			}
+			continue
+		}
+		inText = false
+
+		m := defineRe.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+
+		code, err := strconv.ParseUint(m[2], 0, 32)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", m[1], err)
+		}
+
+		msg := strings.Join(message, " ")
+		message = message[:0]
+
+		if seen[uint32(code)] {
+			continue
+		}
+		seen[uint32(code)] = true
+
+		statuses = append(statuses, status{
+			code:    uint32(code),
+			name:    m[1],
+			message: msg,
+		})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].code < statuses[j].code
+	})
+
+	return statuses, nil
+}
+
+func generate(statuses []status) ([]byte, error) {
+	var buf bytes.Buffer
+
+	fmt.Fprintln(&buf, "// Code generated by gen_ntstatus.go from ntstatus.h; DO NOT EDIT.")
+	fmt.Fprintln(&buf)
+	fmt.Fprintln(&buf, "package exception")
+	fmt.Fprintln(&buf)
+	fmt.Fprintln(&buf, "// ntstatusTable is sorted by code.")
+	fmt.Fprintln(&buf, "var ntstatusTable = []ntstatusEntry{")
+	for _, s := range statuses {
+		fmt.Fprintf(&buf, "\t{0x%08X, %q, %q},\n", s.code, s.name, s.message)
+	}
+	fmt.Fprintln(&buf, "}")
+
+	return format.Source(buf.Bytes())
+}
@@ -0,0 +1,226 @@
+//  ---------------------------------------------------------------------------
+//
+//  record.go
+//
+//  Copyright (c) 2016, Jared Chavez.
+//  All rights reserved.
+//
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+//
+//  -----------
+
+package exception
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+const (
+	// Record64Size is the size of EXCEPTION_RECORD64, which is also the
+	// MINIDUMP_EXCEPTION layout.
+	Record64Size = 152
+
+	// Record32Size is the size of EXCEPTION_RECORD32.
+	Record32Size = 80
+
+	// StreamSize is the size of MINIDUMP_EXCEPTION_STREAM.
+	StreamSize = 168
+)
+
+// maxNested bounds how many nested records are followed, guarding against
+// corrupt or cyclic chains.
+const maxNested = 16
+
+// ParseRecord64 decodes an EXCEPTION_RECORD64 or MINIDUMP_EXCEPTION.
+func ParseRecord64(data []byte) (*Record, error) {
+	if len(data) < Record64Size {
+		return nil, fmt.Errorf("exception record too short: %d bytes", len(data))
+	}
+
+	le := binary.LittleEndian
+
+	r := &Record{
+		Code:          Code(le.Uint32(data[0:])),
+		Flags:         le.Uint32(data[4:]),
+		NestedAddress: le.Uint64(data[8:]),
+		Address:       le.Uint64(data[16:]),
+	}
+
+	count := le.Uint32(data[24:])
+	if count > maxParameters {
+		return nil, fmt.Errorf("exception record has %d parameters", count)
+	}
+
+	for i := uint32(0); i < count; i++ {
+		r.Parameters = append(r.Parameters, le.Uint64(data[32+8*i:]))
+	}
+
+	return r, nil
+}
+
+// ParseRecord32 decodes an EXCEPTION_RECORD32.
+func ParseRecord32(data []byte) (*Record, error) {
+	if len(data) < Record32Size {
+		return nil, fmt.Errorf("exception record too short: %d bytes", len(data))
+	}
+
+	le := binary.LittleEndian
+
+	r := &Record{
+		Code:          Code(le.Uint32(data[0:])),
+		Flags:         le.Uint32(data[4:]),
+		NestedAddress: uint64(le.Uint32(data[8:])),
+		Address:       uint64(le.Uint32(data[12:])),
+	}
+
+	count := le.Uint32(data[16:])
+	if count > maxParameters {
+		return nil, fmt.Errorf("exception record has %d parameters", count)
+	}
+
+	for i := uint32(0); i < count; i++ {
+		r.Parameters = append(r.Parameters, uint64(le.Uint32(data[20+4*i:])))
+	}
+
+	return r, nil
+}
+
+// Decode reads the exception record at addr from mem, which is addressed
+// by virtual address, and follows its nested records. is64 selects the
+// record layout of the faulting process.
+func Decode(mem io.ReaderAt, addr uint64, is64 bool) (*Record, error) {
+	r, err := readRecord(mem, addr, is64)
+	if err != nil {
+		return nil, err
+	}
+
+	return r, ResolveNested(r, mem, is64)
+}
+
+// ResolveNested reads the nested records of r from mem. Records already
+// resolved are kept, and the chain is cut off after a fixed depth.
+func ResolveNested(r *Record, mem io.ReaderAt, is64 bool) error {
+	rec := r
+	for depth := 0; depth < maxNested; depth++ {
+		if rec.Nested == nil {
+			if rec.NestedAddress == 0 {
+				return nil
+			}
+
+			nested, err := readRecord(mem, rec.NestedAddress, is64)
+			if err != nil {
+				return fmt.Errorf("reading nested record at 0x%x: %v", rec.NestedAddress, err)
+			}
+			rec.Nested = nested
+		}
+
+		rec = rec.Nested
+	}
+
+	return nil
+}
+
+func readRecord(mem io.ReaderAt, addr uint64, is64 bool) (*Record, error) {
+	size := Record32Size
+	if is64 {
+		size = Record64Size
+	}
+
+	buffer := make([]byte, size)
+	if _, err := mem.ReadAt(buffer, int64(addr)); err != nil {
+		return nil, err
+	}
+
+	if is64 {
+		return ParseRecord64(buffer)
+	}
+
+	return ParseRecord32(buffer)
+}
+
+// Stream is a decoded MINIDUMP_EXCEPTION_STREAM.
+type Stream struct {
+	ThreadID uint32
+	Record   *Record
+
+	// ContextSize and ContextRVA locate the faulting thread's CONTEXT in
+	// the dump.
+	ContextSize uint32
+	ContextRVA  uint32
+}
+
+// ParseStream decodes a MINIDUMP_EXCEPTION_STREAM. Nested records are not
+// stored in minidumps, so only NestedAddress is set on the record; it can be
+// resolved with ResolveNested if the dump includes the memory.
+func ParseStream(data []byte) (*Stream, error) {
+	if len(data) < StreamSize {
+		return nil, fmt.Errorf("exception stream too short: %d bytes", len(data))
+	}
+
+	le := binary.LittleEndian
+
+	record, err := ParseRecord64(data[8 : 8+Record64Size])
+	if err != nil {
+		return nil, err
+	}
+
+	return &Stream{
+		ThreadID:    le.Uint32(data[0:]),
+		Record:      record,
+		ContextSize: le.Uint32(data[160:]),
+		ContextRVA:  le.Uint32(data[164:]),
+	}, nil
+}
+
+// minidump header and directory constants.
+const (
+	dumpSignature       = 0x504D444D // "MDMP"
+	dumpHeaderSize      = 32
+	dumpDirectorySize   = 12
+	exceptionStreamType = 6
+)
+
+// ErrNoException is returned by ReadDump for a dump without an exception
+// stream.
+var ErrNoException = errors.New("dump has no exception stream")
+
+// ReadDump finds and decodes the exception stream of a minidump file.
+func ReadDump(dump io.ReaderAt) (*Stream, error) {
+	header := make([]byte, dumpHeaderSize)
+	if _, err := dump.ReadAt(header, 0); err != nil {
+		return nil, fmt.Errorf("reading dump header: %v", err)
+	}
+
+	le := binary.LittleEndian
+	if le.Uint32(header) != dumpSignature {
+		return nil, errors.New("not a minidump")
+	}
+
+	count := le.Uint32(header[8:])
+	dirRVA := le.Uint32(header[12:])
+
+	entry := make([]byte, dumpDirectorySize)
+	for i := uint32(0); i < count; i++ {
+		_, err := dump.ReadAt(entry, int64(dirRVA)+int64(i)*dumpDirectorySize)
+		if err != nil {
+			return nil, fmt.Errorf("reading dump directory: %v", err)
+		}
+
+		if le.Uint32(entry) != exceptionStreamType {
+			continue
+		}
+
+		data := make([]byte, le.Uint32(entry[4:]))
+		if _, err := dump.ReadAt(data, int64(le.Uint32(entry[8:]))); err != nil {
+			return nil, fmt.Errorf("reading exception stream: %v", err)
+		}
+
+		return ParseStream(data)
+	}
+
+	return nil, ErrNoException
+}
@@ -1,3 +1,5 @@
 module github.com/xaevman/win32
 
 go 1.13
+
+require golang.org/x/arch v0.4.0
@@ -0,0 +1,117 @@
+package kernel32
+
+import (
+	"fmt"
+	"strings"
+	"syscall"
+)
+
+const (
+	ERROR_ACCESS_DENIED = syscall.Errno(5)
+)
+
+// ProcessAccess is a process access mask for OpenProcess, built from the
+// PROCESS_* and standard rights constants.
+type ProcessAccess uint32
+
+// ThreadAccess is a thread access mask for OpenThread, built from the
+// THREAD_* and standard rights constants.
+type ThreadAccess uint32
+
+type accessName struct {
+	bit  uint32
+	name string
+}
+
+var standardRightNames = []accessName{
+	{DELETE, "DELETE"},
+	{READ_CONTROL, "READ_CONTROL"},
+	{WRITE_DAC, "WRITE_DAC"},
+	{WRITE_OWNER, "WRITE_OWNER"},
+	{SYNCHRONIZE, "SYNCHRONIZE"},
+}
+
+var processRightNames = []accessName{
+	{PROCESS_TERMINATE, "PROCESS_TERMINATE"},
+	{PROCESS_CREATE_THREAD, "PROCESS_CREATE_THREAD"},
+	{PROCESS_SET_SESSIONID, "PROCESS_SET_SESSIONID"},
+	{PROCESS_VM_OPERATION, "PROCESS_VM_OPERATION"},
+	{PROCESS_VM_READ, "PROCESS_VM_READ"},
+	{PROCESS_VM_WRITE, "PROCESS_VM_WRITE"},
+	{PROCESS_DUP_HANDLE, "PROCESS_DUP_HANDLE"},
+	{PROCESS_CREATE_PROCESS, "PROCESS_CREATE_PROCESS"},
+	{PROCESS_SET_QUOTA, "PROCESS_SET_QUOTA"},
+	{PROCESS_SET_INFORMATION, "PROCESS_SET_INFORMATION"},
+	{PROCESS_QUERY_INFORMATION, "PROCESS_QUERY_INFORMATION"},
+	{PROCESS_SUSPEND_RESUME, "PROCESS_SUSPEND_RESUME"},
+	{PROCESS_QUERY_LIMITED_INFORMATION, "PROCESS_QUERY_LIMITED_INFORMATION"},
+	{PROCESS_SET_LIMITED_INFORMATION, "PROCESS_SET_LIMITED_INFORMATION"},
+}
+
+var threadRightNames = []accessName{
+	{THREAD_TERMINATE, "THREAD_TERMINATE"},
+	{THREAD_SUSPEND_RESUME, "THREAD_SUSPEND_RESUME"},
+	{THREAD_GET_CONTEXT, "THREAD_GET_CONTEXT"},
+	{THREAD_SET_CONTEXT, "THREAD_SET_CONTEXT"},
+	{THREAD_SET_INFORMATION, "THREAD_SET_INFORMATION"},
+	{THREAD_QUERY_INFORMATION, "THREAD_QUERY_INFORMATION"},
+	{THREAD_SET_THREAD_TOKEN, "THREAD_SET_THREAD_TOKEN"},
+	{THREAD_IMPERSONATE, "THREAD_IMPERSONATE"},
+	{THREAD_DIRECT_IMPERSONATION, "THREAD_DIRECT_IMPERSONATION"},
+	{THREAD_SET_LIMITED_INFORMATION, "THREAD_SET_LIMITED_INFORMATION"},
+	{THREAD_QUERY_LIMITED_INFORMATION, "THREAD_QUERY_LIMITED_INFORMATION"},
+	{THREAD_RESUME, "THREAD_RESUME"},
+}
+
+// formatAccess names the bits of mask, with any left over in hex.
+func formatAccess(mask, all uint32, allName string, specific []accessName) string {
+	if mask == all {
+		return allName
+	}
+
+	if mask == 0 {
+		return "0"
+	}
+
+	var names []string
+	for _, table := range [][]accessName{specific, standardRightNames} {
+		for _, right := range table {
+			if mask&right.bit != 0 {
+				names = append(names, right.name)
+				mask &^= right.bit
+			}
+		}
+	}
+
+	if mask != 0 {
+		names = append(names, fmt.Sprintf("0x%x", mask))
+	}
+
+	return strings.Join(names, "|")
+}
+
+func (a ProcessAccess) String() string {
+	return formatAccess(uint32(a), PROCESS_ALL_ACCESS, "PROCESS_ALL_ACCESS", processRightNames)
+}
+
+func (a ThreadAccess) String() string {
+	return formatAccess(uint32(a), THREAD_ALL_ACCESS, "THREAD_ALL_ACCESS", threadRightNames)
+}
+
+// limitedFallback returns the rights to retry with when access is denied:
+// access with PROCESS_QUERY_INFORMATION downgraded to
+// PROCESS_QUERY_LIMITED_INFORMATION, or then just the latter.
+func (a ProcessAccess) limitedFallback() []ProcessAccess {
+	var fallback []ProcessAccess
+
+	if a&PROCESS_QUERY_INFORMATION != 0 {
+		fallback = append(fallback, a&^PROCESS_QUERY_INFORMATION|PROCESS_QUERY_LIMITED_INFORMATION)
+	}
+
+	if a != PROCESS_QUERY_LIMITED_INFORMATION &&
+		(len(fallback) == 0 || fallback[0] != PROCESS_QUERY_LIMITED_INFORMATION) {
+		fallback = append(fallback, PROCESS_QUERY_LIMITED_INFORMATION)
+	}
+
+	return fallback
+}
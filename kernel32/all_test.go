@@ -0,0 +1,348 @@
+package kernel32
+
+import (
+	"syscall"
+	"testing"
+	"time"
+	"unsafe"
+)
+
+type layoutCheck struct {
+	name string
+	got  uintptr
+	want uintptr
+}
+
+func checkLayout(t *testing.T, checks []layoutCheck) {
+	for _, c := range checks {
+		if c.got != c.want {
+			t.Errorf("%s = %#x, want %#x", c.name, c.got, c.want)
+		}
+	}
+}
+
+func TestAMD64ContextLayout(t *testing.T) {
+	var c CONTEXT
+
+	checkLayout(t, []layoutCheck{
+		{"sizeof(CONTEXT)", unsafe.Sizeof(c), 0x4d0},
+		{"ContextFlags", unsafe.Offsetof(c.ContextFlags), 0x30},
+		{"SegCs", unsafe.Offsetof(c.SegCs), 0x38},
+		{"EFlags", unsafe.Offsetof(c.EFlags), 0x44},
+		{"Dr0", unsafe.Offsetof(c.Dr0), 0x48},
+		{"Rax", unsafe.Offsetof(c.Rax), 0x78},
+		{"Rsp", unsafe.Offsetof(c.Rsp), 0x98},
+		{"Rbp", unsafe.Offsetof(c.Rbp), 0xa0},
+		{"Rip", unsafe.Offsetof(c.Rip), 0xf8},
+		{"FltSave", unsafe.Offsetof(c.FltSave), 0x100},
+		{"VectorRegister", unsafe.Offsetof(c.VectorRegister), 0x300},
+		{"VectorControl", unsafe.Offsetof(c.VectorControl), 0x4a0},
+		{"LastExceptionFromRip", unsafe.Offsetof(c.LastExceptionFromRip), 0x4c8},
+	})
+}
+
+func TestX86ContextLayout(t *testing.T) {
+	var c WOW64_CONTEXT
+
+	checkLayout(t, []layoutCheck{
+		{"sizeof(WOW64_CONTEXT)", unsafe.Sizeof(c), 0x2cc},
+		{"sizeof(FLOATING_SAVE_AREA)", unsafe.Sizeof(c.FloatSave), 0x70},
+		{"Dr7", unsafe.Offsetof(c.Dr7), 0x18},
+		{"FloatSave", unsafe.Offsetof(c.FloatSave), 0x1c},
+		{"SegGs", unsafe.Offsetof(c.SegGs), 0x8c},
+		{"Edi", unsafe.Offsetof(c.Edi), 0x9c},
+		{"Eax", unsafe.Offsetof(c.Eax), 0xb0},
+		{"Ebp", unsafe.Offsetof(c.Ebp), 0xb4},
+		{"Eip", unsafe.Offsetof(c.Eip), 0xb8},
+		{"EFlags", unsafe.Offsetof(c.EFlags), 0xc0},
+		{"Esp", unsafe.Offsetof(c.Esp), 0xc4},
+		{"ExtendedRegisters", unsafe.Offsetof(c.ExtendedRegisters), 0xcc},
+	})
+}
+
+func TestARM64ContextLayout(t *testing.T) {
+	var c ARM64_NT_CONTEXT
+
+	checkLayout(t, []layoutCheck{
+		{"sizeof(ARM64_NT_CONTEXT)", unsafe.Sizeof(c), 0x390},
+		{"Cpsr", unsafe.Offsetof(c.Cpsr), 0x4},
+		{"X", unsafe.Offsetof(c.X), 0x8},
+		{"Sp", unsafe.Offsetof(c.Sp), 0x100},
+		{"Pc", unsafe.Offsetof(c.Pc), 0x108},
+		{"V", unsafe.Offsetof(c.V), 0x110},
+		{"Fpcr", unsafe.Offsetof(c.Fpcr), 0x310},
+		{"Fpsr", unsafe.Offsetof(c.Fpsr), 0x314},
+		{"Bcr", unsafe.Offsetof(c.Bcr), 0x318},
+		{"Bvr", unsafe.Offsetof(c.Bvr), 0x338},
+		{"Wcr", unsafe.Offsetof(c.Wcr), 0x378},
+		{"Wvr", unsafe.Offsetof(c.Wvr), 0x380},
+	})
+}
+
+func TestNewContext(t *testing.T) {
+	for _, machine := range []uint16{
+		IMAGE_FILE_MACHINE_AMD64,
+		IMAGE_FILE_MACHINE_ARM64,
+		IMAGE_FILE_MACHINE_I386,
+	} {
+		ctx := NewContext(machine)
+		if ctx == nil {
+			t.Fatalf("NewContext(%#x) returned nil", machine)
+		}
+
+		if ctx.Machine() != machine {
+			t.Errorf("NewContext(%#x).Machine() = %#x", machine, ctx.Machine())
+		}
+
+		if uintptr(ctx.Pointer())&15 != 0 {
+			t.Errorf("NewContext(%#x) is not 16 byte aligned", machine)
+		}
+	}
+
+	if NewContext(IMAGE_FILE_MACHINE_UNKNOWN) != nil {
+		t.Error("NewContext(unknown) should return nil")
+	}
+}
+
+func TestToolhelpLayout(t *testing.T) {
+	var p PROCESSENTRY32W
+	var m MODULEENTRY32W
+	var hl HEAPLIST32
+	var he HEAPENTRY32
+
+	// sizes from tlhelp32.h for 64 and 32-bit builds
+	if unsafe.Sizeof(uintptr(0)) == 8 {
+		checkLayout(t, []layoutCheck{
+			{"sizeof(PROCESSENTRY32W)", unsafe.Sizeof(p), 0x238},
+			{"szExeFile", unsafe.Offsetof(p.ExeFile), 0x2c},
+			{"sizeof(MODULEENTRY32W)", unsafe.Sizeof(m), 0x438},
+			{"modBaseAddr", unsafe.Offsetof(m.ModBaseAddr), 0x18},
+			{"szModule", unsafe.Offsetof(m.ModuleName), 0x30},
+			{"sizeof(HEAPLIST32)", unsafe.Sizeof(hl), 0x20},
+			{"sizeof(HEAPENTRY32)", unsafe.Sizeof(he), 0x38},
+		})
+		return
+	}
+
+	checkLayout(t, []layoutCheck{
+		{"sizeof(PROCESSENTRY32W)", unsafe.Sizeof(p), 0x22c},
+		{"szExeFile", unsafe.Offsetof(p.ExeFile), 0x24},
+		{"sizeof(MODULEENTRY32W)", unsafe.Sizeof(m), 0x428},
+		{"modBaseAddr", unsafe.Offsetof(m.ModBaseAddr), 0x14},
+		{"szModule", unsafe.Offsetof(m.ModuleName), 0x20},
+		{"sizeof(HEAPLIST32)", unsafe.Sizeof(hl), 0x10},
+		{"sizeof(HEAPENTRY32)", unsafe.Sizeof(he), 0x24},
+	})
+}
+
+func TestAccessString(t *testing.T) {
+	tests := []struct {
+		got  string
+		want string
+	}{
+		{ProcessAccess(PROCESS_ALL_ACCESS).String(), "PROCESS_ALL_ACCESS"},
+		{ProcessAccess(PROCESS_VM_READ | PROCESS_QUERY_INFORMATION).String(), "PROCESS_VM_READ|PROCESS_QUERY_INFORMATION"},
+		{ProcessAccess(PROCESS_QUERY_LIMITED_INFORMATION | SYNCHRONIZE).String(), "PROCESS_QUERY_LIMITED_INFORMATION|SYNCHRONIZE"},
+		{ProcessAccess(0x40000000).String(), "0x40000000"},
+		{ThreadAccess(THREAD_GET_CONTEXT | THREAD_SUSPEND_RESUME).String(), "THREAD_SUSPEND_RESUME|THREAD_GET_CONTEXT"},
+		{ThreadAccess(THREAD_ALL_ACCESS).String(), "THREAD_ALL_ACCESS"},
+	}
+
+	for _, test := range tests {
+		if test.got != test.want {
+			t.Errorf("String() = %q, want %q", test.got, test.want)
+		}
+	}
+}
+
+func TestLimitedFallback(t *testing.T) {
+	tests := []struct {
+		access ProcessAccess
+		want   []ProcessAccess
+	}{
+		{
+			PROCESS_QUERY_INFORMATION | PROCESS_VM_READ,
+			[]ProcessAccess{PROCESS_QUERY_LIMITED_INFORMATION | PROCESS_VM_READ, PROCESS_QUERY_LIMITED_INFORMATION},
+		},
+		{PROCESS_QUERY_INFORMATION, []ProcessAccess{PROCESS_QUERY_LIMITED_INFORMATION}},
+		{PROCESS_VM_READ, []ProcessAccess{PROCESS_QUERY_LIMITED_INFORMATION}},
+		{PROCESS_QUERY_LIMITED_INFORMATION, nil},
+	}
+
+	for _, test := range tests {
+		got := test.access.limitedFallback()
+		if len(got) != len(test.want) {
+			t.Errorf("%v: fallback %v, want %v", test.access, got, test.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("%v: fallback %v, want %v", test.access, got, test.want)
+			}
+		}
+	}
+}
+
+func TestJobLayout(t *testing.T) {
+	var ext JOBOBJECT_EXTENDED_LIMIT_INFORMATION
+	var acct JOBOBJECT_BASIC_AND_IO_ACCOUNTING_INFORMATION
+	var cpu JOBOBJECT_CPU_RATE_CONTROL_INFORMATION
+	var port JOBOBJECT_ASSOCIATE_COMPLETION_PORT
+
+	// sizes from winnt.h for 64 and 32-bit builds
+	if unsafe.Sizeof(uintptr(0)) == 8 {
+		checkLayout(t, []layoutCheck{
+			{"sizeof(JOBOBJECT_EXTENDED_LIMIT_INFORMATION)", unsafe.Sizeof(ext), 0x90},
+			{"IoInfo", unsafe.Offsetof(ext.IoInfo), 0x40},
+			{"ProcessMemoryLimit", unsafe.Offsetof(ext.ProcessMemoryLimit), 0x70},
+			{"sizeof(JOBOBJECT_BASIC_AND_IO_ACCOUNTING_INFORMATION)", unsafe.Sizeof(acct), 0x60},
+			{"sizeof(JOBOBJECT_CPU_RATE_CONTROL_INFORMATION)", unsafe.Sizeof(cpu), 0x8},
+			{"sizeof(JOBOBJECT_ASSOCIATE_COMPLETION_PORT)", unsafe.Sizeof(port), 0x10},
+		})
+		return
+	}
+
+	checkLayout(t, []layoutCheck{
+		{"sizeof(JOBOBJECT_EXTENDED_LIMIT_INFORMATION)", unsafe.Sizeof(ext), 0x70},
+		{"IoInfo", unsafe.Offsetof(ext.IoInfo), 0x30},
+		{"ProcessMemoryLimit", unsafe.Offsetof(ext.ProcessMemoryLimit), 0x60},
+		{"sizeof(JOBOBJECT_BASIC_AND_IO_ACCOUNTING_INFORMATION)", unsafe.Sizeof(acct), 0x60},
+		{"sizeof(JOBOBJECT_CPU_RATE_CONTROL_INFORMATION)", unsafe.Sizeof(cpu), 0x8},
+		{"sizeof(JOBOBJECT_ASSOCIATE_COMPLETION_PORT)", unsafe.Sizeof(port), 0x8},
+	})
+}
+
+func TestJobLimits(t *testing.T) {
+	limits := JobLimits{
+		ProcessMemory:   64 << 20,
+		ActiveProcesses: 4,
+		KillOnClose:     true,
+	}
+
+	info := limits.extended()
+
+	wantFlags := uint32(JOB_OBJECT_LIMIT_PROCESS_MEMORY |
+		JOB_OBJECT_LIMIT_ACTIVE_PROCESS |
+		JOB_OBJECT_LIMIT_KILL_ON_JOB_CLOSE)
+
+	if info.BasicLimitInformation.LimitFlags != wantFlags {
+		t.Errorf("LimitFlags = %#x, want %#x", info.BasicLimitInformation.LimitFlags, wantFlags)
+	}
+
+	if info.ProcessMemoryLimit != 64<<20 || info.JobMemoryLimit != 0 ||
+		info.BasicLimitInformation.ActiveProcessLimit != 4 {
+		t.Errorf("limits = %+v", info)
+	}
+
+	if (&JobLimits{}).extended().BasicLimitInformation.LimitFlags != 0 {
+		t.Error("zero JobLimits should set no flags")
+	}
+}
+
+func TestCpuRate(t *testing.T) {
+	tests := []struct {
+		percent float64
+		want    uint32
+		ok      bool
+	}{
+		{100, 10000, true},
+		{25, 2500, true},
+		{0.01, 1, true},
+		{0, 0, false},
+		{100.5, 0, false},
+	}
+
+	for _, test := range tests {
+		got, err := cpuRate(test.percent)
+		if got != test.want || (err == nil) != test.ok {
+			t.Errorf("cpuRate(%v) = %d, %v", test.percent, got, err)
+		}
+	}
+}
+
+func TestJobMessageString(t *testing.T) {
+	if s := JobMessage(JOB_OBJECT_MSG_EXIT_PROCESS).String(); s != "EXIT_PROCESS" {
+		t.Errorf("String() = %q", s)
+	}
+
+	if s := JobMessage(99).String(); s != "JobMessage(99)" {
+		t.Errorf("String() = %q", s)
+	}
+}
+
+func TestWaitIndex(t *testing.T) {
+	tests := []struct {
+		ret  uint32
+		want int
+		err  error
+	}{
+		{WAIT_OBJECT_0, 0, nil},
+		{WAIT_OBJECT_0 + 2, 2, nil},
+		{WAIT_ABANDONED_0 + 1, 1, ErrAbandoned},
+		{WAIT_FAILED, -1, syscall.ERROR_ACCESS_DENIED},
+	}
+
+	for _, test := range tests {
+		got, err := waitIndex(test.ret, 3, syscall.ERROR_ACCESS_DENIED)
+		if got != test.want || err != test.err {
+			t.Errorf("waitIndex(%#x) = %d, %v", test.ret, got, err)
+		}
+	}
+
+	if _, err := waitIndex(WAIT_OBJECT_0+3, 3, nil); err == nil {
+		t.Error("expected error for out of range index")
+	}
+
+	if _, err := waitIndex(WAIT_TIMEOUT, 3, nil); err == nil {
+		t.Error("expected error for timeout")
+	}
+}
+
+func TestWaitDurations(t *testing.T) {
+	tests := []struct {
+		d    time.Duration
+		want uint32
+	}{
+		{-time.Second, 0},
+		{0, 0},
+		{time.Microsecond, 1},
+		{1500 * time.Millisecond, 1500},
+		{1<<63 - 1, syscall.INFINITE - 1},
+	}
+
+	for _, test := range tests {
+		if got := milliseconds(test.d); got != test.want {
+			t.Errorf("milliseconds(%v) = %d, want %d", test.d, got, test.want)
+		}
+	}
+
+	if due := relativeDueTime(time.Second); due != -10000000 {
+		t.Errorf("relativeDueTime(1s) = %d", due)
+	}
+
+	if due := relativeDueTime(0); due != -1 {
+		t.Errorf("relativeDueTime(0) = %d", due)
+	}
+}
+
+func TestFutexValue(t *testing.T) {
+	var f Futex
+
+	f.Store(3)
+	if v := f.Add(2); v != 5 || f.Load() != 5 {
+		t.Errorf("Add = %d, Load = %d", v, f.Load())
+	}
+
+	if f.CompareAndSwap(4, 9) || !f.CompareAndSwap(5, 9) || f.Load() != 9 {
+		t.Errorf("CompareAndSwap left %d", f.Load())
+	}
+
+	// neither wait reaches WaitOnAddress
+	if changed, err := f.Wait(1, time.Second); !changed || err != nil {
+		t.Errorf("Wait on a changed value = %v, %v", changed, err)
+	}
+
+	if changed, err := f.Wait(9, 0); changed || err != nil {
+		t.Errorf("Wait with no timeout left = %v, %v", changed, err)
+	}
+}
@@ -0,0 +1,214 @@
+package kernel32
+
+import (
+	"unsafe"
+)
+
+const (
+	IMAGE_FILE_MACHINE_UNKNOWN = 0
+	IMAGE_FILE_MACHINE_I386    = 0x014c
+	IMAGE_FILE_MACHINE_ARMNT   = 0x01c4
+	IMAGE_FILE_MACHINE_AMD64   = 0x8664
+	IMAGE_FILE_MACHINE_ARM64   = 0xAA64
+
+	CONTEXT_i386                    = 0x00010000
+	CONTEXT_i386_CONTROL            = (CONTEXT_i386 | 0x00000001)
+	CONTEXT_i386_INTEGER            = (CONTEXT_i386 | 0x00000002)
+	CONTEXT_i386_SEGMENTS           = (CONTEXT_i386 | 0x00000004)
+	CONTEXT_i386_FLOATING_POINT     = (CONTEXT_i386 | 0x00000008)
+	CONTEXT_i386_DEBUG_REGISTERS    = (CONTEXT_i386 | 0x00000010)
+	CONTEXT_i386_EXTENDED_REGISTERS = (CONTEXT_i386 | 0x00000020)
+	CONTEXT_i386_FULL               = (CONTEXT_i386_CONTROL | CONTEXT_i386_INTEGER | CONTEXT_i386_SEGMENTS)
+	CONTEXT_i386_ALL                = (CONTEXT_i386_FULL | CONTEXT_i386_FLOATING_POINT | CONTEXT_i386_DEBUG_REGISTERS | CONTEXT_i386_EXTENDED_REGISTERS)
+
+	WOW64_CONTEXT_i386                = CONTEXT_i386
+	WOW64_CONTEXT_CONTROL             = CONTEXT_i386_CONTROL
+	WOW64_CONTEXT_INTEGER             = CONTEXT_i386_INTEGER
+	WOW64_CONTEXT_SEGMENTS            = CONTEXT_i386_SEGMENTS
+	WOW64_CONTEXT_FLOATING_POINT      = CONTEXT_i386_FLOATING_POINT
+	WOW64_CONTEXT_DEBUG_REGISTERS     = CONTEXT_i386_DEBUG_REGISTERS
+	WOW64_CONTEXT_EXTENDED_REGISTERS  = CONTEXT_i386_EXTENDED_REGISTERS
+	WOW64_CONTEXT_FULL                = CONTEXT_i386_FULL
+	WOW64_CONTEXT_ALL                 = CONTEXT_i386_ALL
+	WOW64_SIZE_OF_80387_REGISTERS     = 80
+	WOW64_MAXIMUM_SUPPORTED_EXTENSION = 512
+
+	CONTEXT_ARM64                 = 0x00400000
+	CONTEXT_ARM64_CONTROL         = (CONTEXT_ARM64 | 0x00000001)
+	CONTEXT_ARM64_INTEGER         = (CONTEXT_ARM64 | 0x00000002)
+	CONTEXT_ARM64_FLOATING_POINT  = (CONTEXT_ARM64 | 0x00000004)
+	CONTEXT_ARM64_DEBUG_REGISTERS = (CONTEXT_ARM64 | 0x00000008)
+	CONTEXT_ARM64_X18             = (CONTEXT_ARM64 | 0x00000010)
+	CONTEXT_ARM64_FULL            = (CONTEXT_ARM64_CONTROL | CONTEXT_ARM64_INTEGER | CONTEXT_ARM64_FLOATING_POINT)
+	CONTEXT_ARM64_ALL             = (CONTEXT_ARM64_FULL | CONTEXT_ARM64_DEBUG_REGISTERS | CONTEXT_ARM64_X18)
+
+	ARM64_MAX_BREAKPOINTS = 8
+	ARM64_MAX_WATCHPOINTS = 2
+)
+
+// ThreadContext is a machine specific thread context record. *CONTEXT,
+// *X86_CONTEXT and *ARM64_NT_CONTEXT implement it.
+type ThreadContext interface {
+	// Machine returns the IMAGE_FILE_MACHINE_* type the record describes,
+	// as passed to StackWalk64.
+	Machine() uint16
+
+	PC() uint64
+	SP() uint64
+	FP() uint64
+
+	// Pointer returns the address of the raw record.
+	Pointer() unsafe.Pointer
+}
+
+type FLOATING_SAVE_AREA struct {
+	ControlWord   uint32
+	StatusWord    uint32
+	TagWord       uint32
+	ErrorOffset   uint32
+	ErrorSelector uint32
+	DataOffset    uint32
+	DataSelector  uint32
+	RegisterArea  [WOW64_SIZE_OF_80387_REGISTERS]byte
+	Cr0NpxState   uint32
+}
+
+type WOW64_FLOATING_SAVE_AREA = FLOATING_SAVE_AREA
+
+// X86_CONTEXT is the CONTEXT record of 32-bit x86 threads, both native and
+// running under WOW64.
+type X86_CONTEXT struct {
+	ContextFlags uint32
+
+	//
+	// Debug registers
+	//
+	Dr0 uint32
+	Dr1 uint32
+	Dr2 uint32
+	Dr3 uint32
+	Dr6 uint32
+	Dr7 uint32
+
+	//
+	// Floating point state.
+	//
+	FloatSave FLOATING_SAVE_AREA
+
+	//
+	// Segment registers.
+	//
+	SegGs uint32
+	SegFs uint32
+	SegEs uint32
+	SegDs uint32
+
+	//
+	// Integer registers.
+	//
+	Edi uint32
+	Esi uint32
+	Ebx uint32
+	Edx uint32
+	Ecx uint32
+	Eax uint32
+
+	//
+	// Control registers.
+	//
+	Ebp    uint32
+	Eip    uint32
+	SegCs  uint32
+	EFlags uint32
+	Esp    uint32
+	SegSs  uint32
+
+	ExtendedRegisters [WOW64_MAXIMUM_SUPPORTED_EXTENSION]byte
+}
+
+type WOW64_CONTEXT = X86_CONTEXT
+
+func (c *X86_CONTEXT) Machine() uint16         { return IMAGE_FILE_MACHINE_I386 }
+func (c *X86_CONTEXT) PC() uint64              { return uint64(c.Eip) }
+func (c *X86_CONTEXT) SP() uint64              { return uint64(c.Esp) }
+func (c *X86_CONTEXT) FP() uint64              { return uint64(c.Ebp) }
+func (c *X86_CONTEXT) Pointer() unsafe.Pointer { return unsafe.Pointer(c) }
+
+type ARM64_NT_NEON128 struct {
+	Low  uint64
+	High int64
+}
+
+// ARM64_NT_CONTEXT is the CONTEXT record of ARM64 threads.
+type ARM64_NT_CONTEXT struct {
+	//
+	// Control flags.
+	//
+	ContextFlags uint32
+
+	//
+	// Integer registers. X[29] is the frame pointer, X[30] the link
+	// register.
+	//
+	Cpsr uint32
+	X    [31]uint64
+	Sp   uint64
+	Pc   uint64
+
+	//
+	// Floating point/NEON registers.
+	//
+	V    [32]ARM64_NT_NEON128
+	Fpcr uint32
+	Fpsr uint32
+
+	//
+	// Debug registers.
+	//
+	Bcr [ARM64_MAX_BREAKPOINTS]uint32
+	Bvr [ARM64_MAX_BREAKPOINTS]uint64
+	Wcr [ARM64_MAX_WATCHPOINTS]uint32
+	Wvr [ARM64_MAX_WATCHPOINTS]uint64
+}
+
+func (c *ARM64_NT_CONTEXT) Machine() uint16         { return IMAGE_FILE_MACHINE_ARM64 }
+func (c *ARM64_NT_CONTEXT) PC() uint64              { return c.Pc }
+func (c *ARM64_NT_CONTEXT) SP() uint64              { return c.Sp }
+func (c *ARM64_NT_CONTEXT) FP() uint64              { return c.X[29] }
+func (c *ARM64_NT_CONTEXT) Pointer() unsafe.Pointer { return unsafe.Pointer(c) }
+
+func (c *CONTEXT) Machine() uint16         { return IMAGE_FILE_MACHINE_AMD64 }
+func (c *CONTEXT) PC() uint64              { return c.Rip }
+func (c *CONTEXT) SP() uint64              { return c.Rsp }
+func (c *CONTEXT) FP() uint64              { return c.Rbp }
+func (c *CONTEXT) Pointer() unsafe.Pointer { return unsafe.Pointer(c) }
+
+// NewContext allocates a zeroed context record for the given machine type,
+// with ContextFlags requesting the full register set. Records are 16 byte
+// aligned as GetThreadContext requires on 64-bit Windows.
+func NewContext(machine uint16) ThreadContext {
+	switch machine {
+	case IMAGE_FILE_MACHINE_AMD64:
+		ctx := (*CONTEXT)(alignedAlloc(unsafe.Sizeof(CONTEXT{})))
+		ctx.ContextFlags = CONTEXT_FULL
+		return ctx
+	case IMAGE_FILE_MACHINE_ARM64:
+		ctx := (*ARM64_NT_CONTEXT)(alignedAlloc(unsafe.Sizeof(ARM64_NT_CONTEXT{})))
+		ctx.ContextFlags = CONTEXT_ARM64_FULL
+		return ctx
+	case IMAGE_FILE_MACHINE_I386:
+		ctx := (*X86_CONTEXT)(alignedAlloc(unsafe.Sizeof(X86_CONTEXT{})))
+		ctx.ContextFlags = CONTEXT_i386_FULL
+		return ctx
+	}
+
+	return nil
+}
+
+// alignedAlloc returns size zeroed bytes aligned to 16 bytes.
+func alignedAlloc(size uintptr) unsafe.Pointer {
+	buffer := make([]byte, size+15)
+	offset := (16 - uintptr(unsafe.Pointer(&buffer[0]))&15) & 15
+
+	return unsafe.Pointer(&buffer[offset])
+}
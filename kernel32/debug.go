@@ -0,0 +1,134 @@
+package kernel32
+
+import (
+	"unsafe"
+)
+
+const (
+	EXCEPTION_MAXIMUM_PARAMETERS = 15
+
+	EXCEPTION_DEBUG_EVENT      = 1
+	CREATE_THREAD_DEBUG_EVENT  = 2
+	CREATE_PROCESS_DEBUG_EVENT = 3
+	EXIT_THREAD_DEBUG_EVENT    = 4
+	EXIT_PROCESS_DEBUG_EVENT   = 5
+	LOAD_DLL_DEBUG_EVENT       = 6
+	UNLOAD_DLL_DEBUG_EVENT     = 7
+	OUTPUT_DEBUG_STRING_EVENT  = 8
+	RIP_EVENT                  = 9
+
+	DBG_CONTINUE              = 0x00010002
+	DBG_EXCEPTION_HANDLED     = 0x00010001
+	DBG_EXCEPTION_NOT_HANDLED = 0x80010001
+	DBG_REPLY_LATER           = 0x40010001
+)
+
+// EXCEPTION_RECORD is the native exception record of the calling process,
+// matching EXCEPTION_RECORD64 on 64-bit and EXCEPTION_RECORD32 on 32-bit
+// Windows.
+type EXCEPTION_RECORD struct {
+	ExceptionCode        uint32
+	ExceptionFlags       uint32
+	ExceptionRecord      uintptr
+	ExceptionAddress     uintptr
+	NumberParameters     uint32
+	ExceptionInformation [EXCEPTION_MAXIMUM_PARAMETERS]uintptr
+}
+
+type EXCEPTION_DEBUG_INFO struct {
+	ExceptionRecord EXCEPTION_RECORD
+	FirstChance     uint32
+}
+
+type EXCEPTION_POINTERS struct {
+	ExceptionRecord *EXCEPTION_RECORD
+	ContextRecord   unsafe.Pointer
+}
+
+// DEBUG_EVENT holds the event specific union in U, which is sized for the
+// largest member, EXCEPTION_DEBUG_INFO, on both 32 and 64-bit Windows.
+type DEBUG_EVENT struct {
+	DebugEventCode uint32
+	ProcessId      uint32
+	ThreadId       uint32
+	U              [21]uintptr
+}
+
+// Exception returns the union as EXCEPTION_DEBUG_INFO. It is only valid for
+// EXCEPTION_DEBUG_EVENT.
+func (e *DEBUG_EVENT) Exception() *EXCEPTION_DEBUG_INFO {
+	return (*EXCEPTION_DEBUG_INFO)(unsafe.Pointer(&e.U[0]))
+}
+
+var (
+	k32ContinueDebugEvent     = kernel32Dll.NewProc("ContinueDebugEvent")
+	k32DebugActiveProcess     = kernel32Dll.NewProc("DebugActiveProcess")
+	k32DebugActiveProcessStop = kernel32Dll.NewProc("DebugActiveProcessStop")
+	k32WaitForDebugEvent      = kernel32Dll.NewProc("WaitForDebugEvent")
+)
+
+// BOOL WINAPI ContinueDebugEvent(
+//   _In_ DWORD dwProcessId,
+//   _In_ DWORD dwThreadId,
+//   _In_ DWORD dwContinueStatus
+// );
+// fail == 0
+func ContinueDebugEvent(pid, tid, continueStatus uint32) error {
+	ret, _, err := k32ContinueDebugEvent.Call(
+		uintptr(pid),
+		uintptr(tid),
+		uintptr(continueStatus),
+	)
+
+	if ret == 0 {
+		return err
+	}
+
+	return nil
+}
+
+// BOOL WINAPI DebugActiveProcess(
+//   _In_ DWORD dwProcessId
+// );
+// fail == 0
+func DebugActiveProcess(pid uint32) error {
+	ret, _, err := k32DebugActiveProcess.Call(uintptr(pid))
+	if ret == 0 {
+		return err
+	}
+
+	return nil
+}
+
+// BOOL WINAPI DebugActiveProcessStop(
+//   _In_ DWORD dwProcessId
+// );
+// fail == 0
+func DebugActiveProcessStop(pid uint32) error {
+	ret, _, err := k32DebugActiveProcessStop.Call(uintptr(pid))
+	if ret == 0 {
+		return err
+	}
+
+	return nil
+}
+
+// BOOL WINAPI WaitForDebugEvent(
+//   _Out_ LPDEBUG_EVENT lpDebugEvent,
+//   _In_  DWORD         dwMilliseconds
+// );
+// fail == 0
+func WaitForDebugEvent(timeout uint32) (*DEBUG_EVENT, error) {
+	var event DEBUG_EVENT
+
+	ret, _, err := k32WaitForDebugEvent.Call(
+		uintptr(unsafe.Pointer(&event)),
+		uintptr(timeout),
+	)
+
+	if ret == 0 {
+		return nil, err
+	}
+
+	return &event, nil
+}
@@ -0,0 +1,153 @@
+package kernel32
+
+import (
+	"sync/atomic"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const (
+	ERROR_TIMEOUT = syscall.Errno(1460)
+)
+
+var (
+	// WaitOnAddress and friends are not exported by kernel32.dll itself
+	synchDll = syscall.NewLazyDLL("api-ms-win-core-synch-l1-2-0.dll")
+
+	k32WaitOnAddress       = synchDll.NewProc("WaitOnAddress")
+	k32WakeByAddressAll    = synchDll.NewProc("WakeByAddressAll")
+	k32WakeByAddressSingle = synchDll.NewProc("WakeByAddressSingle")
+)
+
+// BOOL WINAPI WaitOnAddress(
+//   _In_     VOID   volatile *Address,
+//   _In_     PVOID           CompareAddress,
+//   _In_     SIZE_T          AddressSize,
+//   _In_opt_ DWORD           dwMilliseconds
+// );
+// fail == 0
+//
+// WaitOnAddress waits while the byte at watch equals the byte at compare.
+// It returns ERROR_TIMEOUT when timeout passes, and may return early
+// without the value having changed.
+func WaitOnAddress(watch, compare *byte, timeout uint32) error {
+	return waitOnAddress(unsafe.Pointer(watch), unsafe.Pointer(compare), 1, timeout)
+}
+
+// WaitOnAddress16 waits while the value at watch is compare. See
+// WaitOnAddress.
+func WaitOnAddress16(watch *uint16, compare uint16, timeout uint32) error {
+	return waitOnAddress(unsafe.Pointer(watch), unsafe.Pointer(&compare), 2, timeout)
+}
+
+// WaitOnAddress32 waits while the value at watch is compare. See
+// WaitOnAddress.
+func WaitOnAddress32(watch *uint32, compare uint32, timeout uint32) error {
+	return waitOnAddress(unsafe.Pointer(watch), unsafe.Pointer(&compare), 4, timeout)
+}
+
+// WaitOnAddress64 waits while the value at watch is compare. See
+// WaitOnAddress.
+func WaitOnAddress64(watch *uint64, compare uint64, timeout uint32) error {
+	return waitOnAddress(unsafe.Pointer(watch), unsafe.Pointer(&compare), 8, timeout)
+}
+
+func waitOnAddress(watch, compare unsafe.Pointer, size uintptr, timeout uint32) error {
+	ret, _, err := k32WaitOnAddress.Call(
+		uintptr(watch),
+		uintptr(compare),
+		size,
+		uintptr(timeout),
+	)
+
+	if ret == 0 {
+		return err
+	}
+
+	return nil
+}
+
+// VOID WINAPI WakeByAddressSingle(
+//   _In_ PVOID Address
+// );
+func WakeByAddressSingle(addr unsafe.Pointer) {
+	k32WakeByAddressSingle.Call(uintptr(addr))
+}
+
+// VOID WINAPI WakeByAddressAll(
+//   _In_ PVOID Address
+// );
+func WakeByAddressAll(addr unsafe.Pointer) {
+	k32WakeByAddressAll.Call(uintptr(addr))
+}
+
+// Futex is a 32-bit value that goroutines can wait on to change, without
+// the kernel objects and handles an Event needs. WaitOnAddress only works
+// within a process, so a Futex cannot be shared through mapped memory with
+// another process. The zero value is ready to use.
+//
+// Waiters are woken only by WakeOne and WakeAll, so a change must be
+// followed by one of them. Store, Add and CompareAndSwap do not wake.
+type Futex struct {
+	v uint32
+}
+
+// Load returns the value.
+func (f *Futex) Load() uint32 {
+	return atomic.LoadUint32(&f.v)
+}
+
+// Store sets the value.
+func (f *Futex) Store(v uint32) {
+	atomic.StoreUint32(&f.v, v)
+}
+
+// Add adds delta to the value and returns the new value.
+func (f *Futex) Add(delta uint32) uint32 {
+	return atomic.AddUint32(&f.v, delta)
+}
+
+// CompareAndSwap sets the value to new if it is old.
+func (f *Futex) CompareAndSwap(old, new uint32) bool {
+	return atomic.CompareAndSwapUint32(&f.v, old, new)
+}
+
+// Wait blocks while the value is old, for up to timeout, or forever if
+// timeout is negative. It reports whether the value changed; false means
+// the timeout passed. Early wakeups are absorbed.
+func (f *Futex) Wait(old uint32, timeout time.Duration) (bool, error) {
+	var deadline time.Time
+	if timeout >= 0 {
+		deadline = time.Now().Add(timeout)
+	}
+
+	for f.Load() == old {
+		wait := uint32(syscall.INFINITE)
+		if timeout >= 0 {
+			remaining := time.Until(deadline)
+			if remaining <= 0 {
+				return false, nil
+			}
+
+			wait = milliseconds(remaining)
+		}
+
+		err := WaitOnAddress32(&f.v, old, wait)
+		if err != nil && err != ERROR_TIMEOUT {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
+
+// WakeOne wakes one goroutine waiting on the futex.
+func (f *Futex) WakeOne() {
+	WakeByAddressSingle(unsafe.Pointer(&f.v))
+}
+
+// WakeAll wakes every goroutine waiting on the futex.
+func (f *Futex) WakeAll() {
+	WakeByAddressAll(unsafe.Pointer(&f.v))
+}
@@ -0,0 +1,185 @@
+//  ---------------------------------------------------------------------------
+//
+//  all_test.go
+//
+//  Copyright (c) 2016, Jared Chavez.
+//  All rights reserved.
+//
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+//
+//  -----------
+
+package instance
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"net"
+	"reflect"
+	"testing"
+)
+
+func TestNames(t *testing.T) {
+	tests := []struct {
+		scope Scope
+		mutex string
+		pipe  string
+	}{
+		{ScopeSession, `Local\MyTool`, `\\.\pipe\MyTool-instance-3`},
+		{ScopeMachine, `Global\MyTool`, `\\.\pipe\MyTool-instance`},
+	}
+
+	for _, test := range tests {
+		mutex, err := MutexName(test.scope, "MyTool")
+		if mutex != test.mutex || err != nil {
+			t.Errorf("MutexName(%v) = %q, %v", test.scope, mutex, err)
+		}
+
+		pipe, err := PipeName(test.scope, "MyTool", 3)
+		if pipe != test.pipe || err != nil {
+			t.Errorf("PipeName(%v) = %q, %v", test.scope, pipe, err)
+		}
+	}
+
+	for _, name := range []string{"", `Global\MyTool`} {
+		if _, err := MutexName(ScopeSession, name); err == nil {
+			t.Errorf("MutexName(%q) should fail", name)
+		}
+	}
+
+	if _, err := PipeName(Scope(7), "MyTool", 0); err == nil {
+		t.Error("expected error for bad scope")
+	}
+}
+
+func TestRequestRoundTrip(t *testing.T) {
+	reqs := []*Request{
+		{PID: 4242, Dir: `C:\work`, Args: []string{"-open", `docs\readme.txt`, ""}},
+		{PID: 1},
+	}
+
+	for _, req := range reqs {
+		var buf bytes.Buffer
+		if err := writeRequest(&buf, req); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := readRequest(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !reflect.DeepEqual(got, req) {
+			t.Errorf("got %+v, want %+v", got, req)
+		}
+	}
+}
+
+func TestReadRequestErrors(t *testing.T) {
+	var valid bytes.Buffer
+	writeRequest(&valid, &Request{PID: 1, Dir: "d", Args: []string{"a"}})
+	frame := valid.Bytes()
+
+	corrupt := func(f func(b []byte) []byte) []byte {
+		return f(append([]byte(nil), frame...))
+	}
+
+	tests := map[string][]byte{
+		"empty": nil,
+		"magic": corrupt(func(b []byte) []byte {
+			b[0] ^= 0xff
+			return b
+		}),
+		"version": corrupt(func(b []byte) []byte {
+			binary.LittleEndian.PutUint16(b[4:], 9)
+			return b
+		}),
+		"too large": corrupt(func(b []byte) []byte {
+			binary.LittleEndian.PutUint32(b[6:], maxPayload+1)
+			return b
+		}),
+		"truncated": frame[:len(frame)-1],
+		"argument count": corrupt(func(b []byte) []byte {
+			// the count follows the pid and the one byte directory
+			binary.LittleEndian.PutUint32(b[headerSize+9:], 1<<30)
+			return b
+		}),
+		"trailing bytes": corrupt(func(b []byte) []byte {
+			binary.LittleEndian.PutUint32(b[6:], uint32(len(b)-headerSize+1))
+			return append(b, 0)
+		}),
+	}
+
+	for name, data := range tests {
+		if _, err := readRequest(bytes.NewReader(data)); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestForward(t *testing.T) {
+	var handled []*Request
+	s := &server{handle: func(req *Request) { handled = append(handled, req) }}
+
+	client, conn := net.Pipe()
+	defer client.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- s.serveConn(conn)
+		conn.Close()
+	}()
+
+	req := &Request{PID: 7, Dir: `C:\`, Args: []string{"x"}}
+	if err := forward(client, req); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+
+	if len(handled) != 1 || !reflect.DeepEqual(handled[0], req) {
+		t.Errorf("handled %+v", handled)
+	}
+}
+
+func TestForwardRejected(t *testing.T) {
+	errSession := errors.New("wrong session")
+	s := &server{
+		verify: func(req *Request) error { return errSession },
+		handle: func(req *Request) { t.Error("rejected request was handled") },
+	}
+
+	client, conn := net.Pipe()
+	defer client.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		done <- s.serveConn(conn)
+		conn.Close()
+	}()
+
+	if err := forward(client, &Request{PID: 7}); err != ErrRejected {
+		t.Errorf("forward = %v, want ErrRejected", err)
+	}
+
+	if err := <-done; err != errSession {
+		t.Errorf("serveConn = %v", err)
+	}
+}
+
+func TestReadReply(t *testing.T) {
+	var buf bytes.Buffer
+	writeReply(&buf, 5)
+
+	if code, err := readReply(&buf); code != 5 || err != nil {
+		t.Errorf("readReply = %d, %v", code, err)
+	}
+
+	if _, err := readReply(bytes.NewReader(make([]byte, 8))); err == nil {
+		t.Error("expected error for bad magic")
+	}
+}
@@ -0,0 +1,338 @@
+//  ---------------------------------------------------------------------------
+//
+//  instance.go
+//
+//  Copyright (c) 2016, Jared Chavez.
+//  All rights reserved.
+//
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+//
+//  -----------
+
+// Package instance keeps an application to a single instance per session or
+// per machine. The first instance owns a named mutex; later instances fail
+// to acquire it and can forward their arguments to the first over a named
+// pipe before exiting.
+//
+// The names and the forwarding protocol are portable; the mutex and the
+// pipe are Windows only.
+package instance
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// ErrAlreadyRunning is returned by Acquire when another instance owns the
+// mutex.
+var ErrAlreadyRunning = errors.New("instance: already running")
+
+// ErrRejected is returned by Forward when the first instance refused the
+// request, for example because it came from another session.
+var ErrRejected = errors.New("instance: forwarded arguments rejected")
+
+// Scope is the namespace in which an instance is unique.
+type Scope int
+
+const (
+	// ScopeSession allows one instance per logon session, using the
+	// Local\ namespace.
+	ScopeSession Scope = iota
+
+	// ScopeMachine allows one instance on the machine, using the Global\
+	// namespace. Sharing the mutex between users needs a security
+	// descriptor that grants them access.
+	ScopeMachine
+)
+
+func (s Scope) String() string {
+	switch s {
+	case ScopeSession:
+		return "session"
+	case ScopeMachine:
+		return "machine"
+	}
+
+	return fmt.Sprintf("Scope(%d)", int(s))
+}
+
+// MutexName returns the kernel object name of the mutex guarding name. It
+// is the plain name in the scope's namespace, so that components written in
+// other languages can share the guard.
+func MutexName(scope Scope, name string) (string, error) {
+	if err := checkName(name); err != nil {
+		return "", err
+	}
+
+	switch scope {
+	case ScopeSession:
+		return `Local\` + name, nil
+	case ScopeMachine:
+		return `Global\` + name, nil
+	}
+
+	return "", fmt.Errorf("instance: bad scope %v", scope)
+}
+
+// PipeName returns the name of the pipe on which the first instance of name
+// in session listens. Pipes have no session namespace, so a session scoped
+// pipe carries the session id.
+func PipeName(scope Scope, name string, session uint32) (string, error) {
+	if err := checkName(name); err != nil {
+		return "", err
+	}
+
+	switch scope {
+	case ScopeSession:
+		return fmt.Sprintf(`\\.\pipe\%s-instance-%d`, name, session), nil
+	case ScopeMachine:
+		return fmt.Sprintf(`\\.\pipe\%s-instance`, name), nil
+	}
+
+	return "", fmt.Errorf("instance: bad scope %v", scope)
+}
+
+func checkName(name string) error {
+	if name == "" || strings.ContainsRune(name, '\\') {
+		return fmt.Errorf("instance: bad name %q", name)
+	}
+
+	return nil
+}
+
+// Request is what a later instance forwards to the first.
+type Request struct {
+	// PID is the process id of the sender.
+	PID uint32
+
+	// Dir is the working directory of the sender, against which relative
+	// paths in Args resolve.
+	Dir string
+
+	Args []string
+}
+
+const (
+	protocolVersion = 1
+
+	requestMagic = 0x52495753 // "SWIR"
+	replyMagic   = 0x50495753 // "SWIP"
+	headerSize   = 10
+
+	// maxPayload bounds a request so a corrupt length cannot force a
+	// large allocation. It is well above the 32767 character limit of a
+	// Windows command line.
+	maxPayload = 256 * 1024
+)
+
+// reply codes
+const (
+	replyAccepted = 0
+	replyRejected = 1
+)
+
+// writeRequest writes req as one frame: a header with the magic, the
+// version and the payload size, then the payload.
+func writeRequest(w io.Writer, req *Request) error {
+	payload := make([]byte, 0, 64)
+	payload = appendUint32(payload, req.PID)
+	payload = appendString(payload, req.Dir)
+	payload = appendUint32(payload, uint32(len(req.Args)))
+	for _, arg := range req.Args {
+		payload = appendString(payload, arg)
+	}
+
+	if len(payload) > maxPayload {
+		return fmt.Errorf("instance: request too large: %d bytes", len(payload))
+	}
+
+	frame := make([]byte, headerSize, headerSize+len(payload))
+	binary.LittleEndian.PutUint32(frame[0:], requestMagic)
+	binary.LittleEndian.PutUint16(frame[4:], protocolVersion)
+	binary.LittleEndian.PutUint32(frame[6:], uint32(len(payload)))
+	frame = append(frame, payload...)
+
+	// a single write keeps the frame in one pipe message
+	_, err := w.Write(frame)
+	return err
+}
+
+func readRequest(r io.Reader) (*Request, error) {
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, fmt.Errorf("instance: reading request header: %v", err)
+	}
+
+	if binary.LittleEndian.Uint32(header[0:]) != requestMagic {
+		return nil, errors.New("instance: bad request magic")
+	}
+
+	if version := binary.LittleEndian.Uint16(header[4:]); version != protocolVersion {
+		return nil, fmt.Errorf("instance: unsupported protocol version %d", version)
+	}
+
+	size := binary.LittleEndian.Uint32(header[6:])
+	if size > maxPayload {
+		return nil, fmt.Errorf("instance: request too large: %d bytes", size)
+	}
+
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, fmt.Errorf("instance: reading request: %v", err)
+	}
+
+	d := decoder{buf: payload}
+	req := &Request{PID: d.uint32(), Dir: d.string()}
+
+	// every argument takes at least its length
+	count := d.uint32()
+	if d.err == nil && uint64(count)*4 > uint64(len(d.buf)) {
+		d.err = io.ErrUnexpectedEOF
+	}
+
+	for i := uint32(0); i < count && d.err == nil; i++ {
+		req.Args = append(req.Args, d.string())
+	}
+
+	if d.err == nil && len(d.buf) != 0 {
+		d.err = fmt.Errorf("%d trailing bytes", len(d.buf))
+	}
+
+	if d.err != nil {
+		return nil, fmt.Errorf("instance: decoding request: %v", d.err)
+	}
+
+	return req, nil
+}
+
+func writeReply(w io.Writer, code uint32) error {
+	var b [8]byte
+	binary.LittleEndian.PutUint32(b[0:], replyMagic)
+	binary.LittleEndian.PutUint32(b[4:], code)
+
+	_, err := w.Write(b[:])
+	return err
+}
+
+func readReply(r io.Reader) (uint32, error) {
+	var b [8]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return 0, fmt.Errorf("instance: reading reply: %v", err)
+	}
+
+	if binary.LittleEndian.Uint32(b[0:]) != replyMagic {
+		return 0, errors.New("instance: bad reply magic")
+	}
+
+	return binary.LittleEndian.Uint32(b[4:]), nil
+}
+
+// forward sends req over conn and waits for the first instance to accept
+// it.
+func forward(conn io.ReadWriter, req *Request) error {
+	if err := writeRequest(conn, req); err != nil {
+		return err
+	}
+
+	code, err := readReply(conn)
+	if err != nil {
+		return err
+	}
+
+	switch code {
+	case replyAccepted:
+		return nil
+	case replyRejected:
+		return ErrRejected
+	}
+
+	return fmt.Errorf("instance: unknown reply %d", code)
+}
+
+// server is the first instance's side of the protocol.
+type server struct {
+	// verify, when set, checks a request before it is accepted.
+	verify func(req *Request) error
+
+	handle func(req *Request)
+}
+
+// serveConn serves the single request of a connection. The request is
+// acknowledged before it is handled, so a slow handler does not hold up
+// the sender's exit.
+func (s *server) serveConn(conn io.ReadWriter) error {
+	req, err := readRequest(conn)
+	if err != nil {
+		return err
+	}
+
+	if s.verify != nil {
+		if err := s.verify(req); err != nil {
+			writeReply(conn, replyRejected)
+			return err
+		}
+	}
+
+	if err := writeReply(conn, replyAccepted); err != nil {
+		return err
+	}
+
+	if s.handle != nil {
+		s.handle(req)
+	}
+
+	return nil
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+func appendString(b []byte, s string) []byte {
+	b = appendUint32(b, uint32(len(s)))
+	return append(b, s...)
+}
+
+// decoder reads fields until the first error, after which every read
+// returns a zero value.
+type decoder struct {
+	buf []byte
+	err error
+}
+
+func (d *decoder) uint32() uint32 {
+	if d.err != nil {
+		return 0
+	}
+
+	if len(d.buf) < 4 {
+		d.err = io.ErrUnexpectedEOF
+		return 0
+	}
+
+	v := binary.LittleEndian.Uint32(d.buf)
+	d.buf = d.buf[4:]
+
+	return v
+}
+
+func (d *decoder) string() string {
+	n := d.uint32()
+	if d.err != nil {
+		return ""
+	}
+
+	if uint64(n) > uint64(len(d.buf)) {
+		d.err = io.ErrUnexpectedEOF
+		return ""
+	}
+
+	s := string(d.buf[:n])
+	d.buf = d.buf[n:]
+
+	return s
+}
@@ -0,0 +1,451 @@
+//  ---------------------------------------------------------------------------
+//
+//  instance_windows.go
+//
+//  Copyright (c) 2016, Jared Chavez.
+//  All rights reserved.
+//
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+//
+//  -----------
+
+package instance
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime"
+	"syscall"
+	"time"
+
+	"github.com/xaevman/win32/kernel32"
+)
+
+// requestTimeout bounds the reading and answering of a forwarded request,
+// so that a client that connects and stalls cannot hold the pipe.
+const requestTimeout = 5 * time.Second
+
+// Options configure Acquire. A nil *Options guards the session without
+// listening for forwarded arguments.
+type Options struct {
+	Scope Scope
+
+	// Security is applied to the mutex. A machine scoped guard shared by
+	// several users needs one that grants them SYNCHRONIZE, or the later
+	// instances of other users cannot open it and take the guard as held.
+	Security *syscall.SecurityAttributes
+
+	// OnForward, when set, makes the first instance listen for the
+	// arguments of later instances. Requests are handled one at a time on
+	// the listener's goroutine; OnForward must not call Release.
+	OnForward func(req *Request)
+}
+
+// Guard is held by the first instance.
+type Guard struct {
+	name      string
+	mutex     *kernel32.Mutex
+	abandoned bool
+
+	release chan struct{}
+	owned   chan error
+
+	pipeName string
+	pipe     syscall.Handle
+	event    syscall.Handle
+	stop     chan struct{}
+	stopped  chan struct{}
+}
+
+// Acquire makes the calling process the instance of name in opts.Scope, or
+// returns ErrAlreadyRunning if another process is. Once acquired, the guard
+// is held until Release or until the process exits.
+func Acquire(name string, opts *Options) (*Guard, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	mutexName, err := MutexName(opts.Scope, name)
+	if err != nil {
+		return nil, err
+	}
+
+	mutex, err := kernel32.NewMutex(mutexName, &kernel32.MutexOptions{
+		ObjectAttributes: kernel32.ObjectAttributes{
+			Security: opts.Security,
+			Access:   kernel32.SYNCHRONIZE | kernel32.MUTEX_MODIFY_STATE,
+		},
+	})
+	if err == syscall.ERROR_ACCESS_DENIED {
+		// the mutex exists, created by someone who did not share it
+		return nil, ErrAlreadyRunning
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	g := &Guard{
+		name:    name,
+		mutex:   mutex,
+		release: make(chan struct{}),
+		owned:   make(chan error, 1),
+		stop:    make(chan struct{}),
+	}
+
+	acquired := make(chan error)
+	go g.own(acquired)
+
+	if err := <-acquired; err != nil {
+		mutex.Close()
+		return nil, err
+	}
+
+	if opts.OnForward != nil {
+		if err := g.listen(opts); err != nil {
+			g.Release()
+			return nil, err
+		}
+	}
+
+	return g, nil
+}
+
+// own acquires the mutex and holds it until Release. A mutex belongs to the
+// OS thread that acquired it, so one locked thread does both.
+func (g *Guard) own(acquired chan<- error) {
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+
+	ok, err := g.mutex.WaitTimeout(0)
+	if err == kernel32.ErrAbandoned {
+		// the previous instance died holding the guard, which is now
+		// ours
+		g.abandoned, ok, err = true, true, nil
+	}
+
+	if err == nil && !ok {
+		err = ErrAlreadyRunning
+	}
+
+	acquired <- err
+	if err != nil {
+		return
+	}
+
+	<-g.release
+	g.owned <- g.mutex.Release()
+}
+
+// Abandoned reports whether the previous instance exited without releasing
+// the guard, usually because it crashed. State it shared with later
+// instances, such as files, may be inconsistent.
+func (g *Guard) Abandoned() bool {
+	return g.abandoned
+}
+
+// Release stops listening for forwarded arguments and releases the guard,
+// letting another instance start.
+func (g *Guard) Release() error {
+	if g.mutex == nil {
+		return nil
+	}
+
+	// the pipe is closed before the mutex is released, so that the next
+	// instance can create it and no request reaches this one
+	close(g.stop)
+	if g.stopped != nil {
+		<-g.stopped
+	}
+
+	close(g.release)
+	err := <-g.owned
+
+	if closeErr := g.mutex.Close(); err == nil {
+		err = closeErr
+	}
+
+	g.mutex = nil
+
+	return err
+}
+
+// listen creates the pipe and serves it in the background. The pipe is
+// created as the first instance of its name, so that no other process can
+// have created it to intercept the requests.
+func (g *Guard) listen(opts *Options) error {
+	session, err := kernel32.ProcessIdToSessionId(uint32(os.Getpid()))
+	if err != nil {
+		return err
+	}
+
+	if g.pipeName, err = PipeName(opts.Scope, g.name, session); err != nil {
+		return err
+	}
+
+	g.event, err = kernel32.CreateEventEx(nil, "", kernel32.CREATE_EVENT_MANUAL_RESET, kernel32.EVENT_ALL_ACCESS)
+	if err != nil {
+		return err
+	}
+
+	g.pipe, err = kernel32.CreateNamedPipe(
+		g.pipeName,
+		kernel32.PIPE_ACCESS_DUPLEX|kernel32.FILE_FLAG_FIRST_PIPE_INSTANCE|kernel32.FILE_FLAG_OVERLAPPED,
+		kernel32.PIPE_TYPE_BYTE|kernel32.PIPE_READMODE_BYTE|kernel32.PIPE_WAIT|kernel32.PIPE_REJECT_REMOTE_CLIENTS,
+		1,
+		4096,
+		4096,
+		0,
+	)
+	if err != nil {
+		syscall.CloseHandle(g.event)
+		return fmt.Errorf("instance: creating %s: %v", g.pipeName, err)
+	}
+
+	s := &server{handle: opts.OnForward}
+	s.verify = func(req *Request) error {
+		return verifyClient(g.pipe, req, opts.Scope, session)
+	}
+
+	g.stopped = make(chan struct{})
+	go g.serve(s)
+
+	return nil
+}
+
+func (g *Guard) serve(s *server) {
+	defer close(g.stopped)
+	defer syscall.CloseHandle(g.event)
+	defer syscall.CloseHandle(g.pipe)
+
+	conn := &serverConn{handle: g.pipe, event: g.event, stop: g.stop}
+
+	for !g.stopping() {
+		err := conn.connect()
+		if err == nil {
+			g.serveRequest(s, conn)
+		}
+
+		kernel32.DisconnectNamedPipe(g.pipe)
+	}
+}
+
+// serveRequest serves the connected client, giving up after
+// requestTimeout.
+func (g *Guard) serveRequest(s *server, conn *serverConn) {
+	expired := make(chan struct{})
+	timer := time.AfterFunc(requestTimeout, func() { close(expired) })
+	defer timer.Stop()
+
+	conn.expired = expired
+	s.serveConn(conn)
+	conn.expired = nil
+}
+
+func (g *Guard) stopping() bool {
+	select {
+	case <-g.stop:
+		return true
+	default:
+		return false
+	}
+}
+
+// verifyClient checks that req comes from the process it claims, in the
+// same session for a session scoped guard.
+func verifyClient(pipe syscall.Handle, req *Request, scope Scope, session uint32) error {
+	pid, err := kernel32.GetNamedPipeClientProcessId(pipe)
+	if err != nil {
+		return err
+	}
+
+	if pid != req.PID {
+		return fmt.Errorf("instance: client claims pid %d but is %d", req.PID, pid)
+	}
+
+	if scope != ScopeSession {
+		return nil
+	}
+
+	clientSession, err := kernel32.ProcessIdToSessionId(pid)
+	if err != nil {
+		return err
+	}
+
+	if clientSession != session {
+		return fmt.Errorf("instance: client %d is in session %d", pid, clientSession)
+	}
+
+	return nil
+}
+
+// Forward sends args to the first instance of name in scope, along with the
+// current process id and working directory, and waits up to timeout for it
+// to accept them.
+func Forward(name string, scope Scope, args []string, timeout time.Duration) error {
+	session, err := kernel32.ProcessIdToSessionId(uint32(os.Getpid()))
+	if err != nil {
+		return err
+	}
+
+	pipeName, err := PipeName(scope, name, session)
+	if err != nil {
+		return err
+	}
+
+	dir, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+
+	conn, err := dial(pipeName, timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	return forward(conn, &Request{
+		PID:  uint32(os.Getpid()),
+		Dir:  dir,
+		Args: args,
+	})
+}
+
+// serverConn is the first instance's end of the pipe, opened for
+// overlapped I/O so that Release and the request timeout can cancel a
+// client that stalls.
+type serverConn struct {
+	handle syscall.Handle
+	event  syscall.Handle
+
+	// stop and expired, which may be nil, cancel the pending operation
+	// when closed.
+	stop    <-chan struct{}
+	expired <-chan struct{}
+}
+
+func (c *serverConn) connect() error {
+	ov := &syscall.Overlapped{HEvent: c.event}
+
+	err := kernel32.ConnectNamedPipeOverlapped(c.handle, ov)
+	if err == syscall.ERROR_IO_PENDING {
+		_, err = c.wait(ov)
+	}
+
+	return err
+}
+
+func (c *serverConn) Read(buffer []byte) (int, error) {
+	var n uint32
+	ov := &syscall.Overlapped{HEvent: c.event}
+
+	err := syscall.ReadFile(c.handle, buffer, &n, ov)
+	if err == syscall.ERROR_IO_PENDING {
+		n, err = c.wait(ov)
+	}
+
+	if err == syscall.ERROR_BROKEN_PIPE || (err == nil && n == 0 && len(buffer) > 0) {
+		return 0, io.EOF
+	}
+
+	return int(n), err
+}
+
+func (c *serverConn) Write(buffer []byte) (int, error) {
+	var n uint32
+	ov := &syscall.Overlapped{HEvent: c.event}
+
+	err := syscall.WriteFile(c.handle, buffer, &n, ov)
+	if err == syscall.ERROR_IO_PENDING {
+		n, err = c.wait(ov)
+	}
+
+	return int(n), err
+}
+
+// wait waits for the pending operation ov, canceling it when stop or
+// expired is closed. The operation must finish, even when canceled, before
+// ov and its buffer can be released.
+func (c *serverConn) wait(ov *syscall.Overlapped) (uint32, error) {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-c.stop:
+		case <-c.expired:
+		case <-done:
+			return
+		}
+
+		syscall.CancelIoEx(c.handle, ov)
+	}()
+
+	n, err := kernel32.GetOverlappedResult(c.handle, ov, true)
+	close(done)
+
+	return n, err
+}
+
+// pipeConn is a synchronous named pipe handle.
+type pipeConn syscall.Handle
+
+func (p pipeConn) Read(buffer []byte) (int, error) {
+	var n uint32
+
+	err := syscall.ReadFile(syscall.Handle(p), buffer, &n, nil)
+	if err == syscall.ERROR_BROKEN_PIPE || (err == nil && n == 0 && len(buffer) > 0) {
+		return 0, io.EOF
+	}
+
+	return int(n), err
+}
+
+func (p pipeConn) Write(buffer []byte) (int, error) {
+	var n uint32
+
+	err := syscall.WriteFile(syscall.Handle(p), buffer, &n, nil)
+	return int(n), err
+}
+
+func (p pipeConn) Close() error {
+	return syscall.CloseHandle(syscall.Handle(p))
+}
+
+// dial connects to the pipe name, retrying until timeout while the first
+// instance has not created it yet or is serving another request. The first
+// instance may identify the caller but not impersonate it.
+func dial(name string, timeout time.Duration) (io.ReadWriteCloser, error) {
+	deadline := time.Now().Add(timeout)
+	path := syscall.StringToUTF16Ptr(name)
+
+	for {
+		handle, err := syscall.CreateFile(
+			path,
+			syscall.GENERIC_READ|syscall.GENERIC_WRITE,
+			0,
+			nil,
+			syscall.OPEN_EXISTING,
+			kernel32.SECURITY_SQOS_PRESENT|kernel32.SECURITY_IDENTIFICATION,
+			0,
+		)
+		if err == nil {
+			return pipeConn(handle), nil
+		}
+
+		if err != syscall.ERROR_FILE_NOT_FOUND && err != kernel32.ERROR_PIPE_BUSY {
+			return nil, err
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return nil, fmt.Errorf("instance: connecting to %s: %v", name, err)
+		}
+
+		if err == kernel32.ERROR_PIPE_BUSY {
+			kernel32.WaitNamedPipe(name, uint32(remaining/time.Millisecond))
+		} else {
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+}
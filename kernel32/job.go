@@ -0,0 +1,732 @@
+package kernel32
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"syscall"
+	"unsafe"
+)
+
+// JOBOBJECTINFOCLASS
+const (
+	JobObjectBasicAccountingInformation         = 1
+	JobObjectBasicLimitInformation              = 2
+	JobObjectBasicProcessIdList                 = 3
+	JobObjectBasicUIRestrictions                = 4
+	JobObjectAssociateCompletionPortInformation = 7
+	JobObjectBasicAndIoAccountingInformation    = 8
+	JobObjectExtendedLimitInformation           = 9
+	JobObjectCpuRateControlInformation          = 15
+)
+
+// JOBOBJECT_BASIC_LIMIT_INFORMATION LimitFlags
+const (
+	JOB_OBJECT_LIMIT_WORKINGSET                 = 0x00000001
+	JOB_OBJECT_LIMIT_PROCESS_TIME               = 0x00000002
+	JOB_OBJECT_LIMIT_JOB_TIME                   = 0x00000004
+	JOB_OBJECT_LIMIT_ACTIVE_PROCESS             = 0x00000008
+	JOB_OBJECT_LIMIT_AFFINITY                   = 0x00000010
+	JOB_OBJECT_LIMIT_PRIORITY_CLASS             = 0x00000020
+	JOB_OBJECT_LIMIT_PRESERVE_JOB_TIME          = 0x00000040
+	JOB_OBJECT_LIMIT_SCHEDULING_CLASS           = 0x00000080
+	JOB_OBJECT_LIMIT_PROCESS_MEMORY             = 0x00000100
+	JOB_OBJECT_LIMIT_JOB_MEMORY                 = 0x00000200
+	JOB_OBJECT_LIMIT_DIE_ON_UNHANDLED_EXCEPTION = 0x00000400
+	JOB_OBJECT_LIMIT_BREAKAWAY_OK               = 0x00000800
+	JOB_OBJECT_LIMIT_SILENT_BREAKAWAY_OK        = 0x00001000
+	JOB_OBJECT_LIMIT_KILL_ON_JOB_CLOSE          = 0x00002000
+)
+
+// JOBOBJECT_BASIC_UI_RESTRICTIONS UIRestrictionsClass
+const (
+	JOB_OBJECT_UILIMIT_HANDLES          = 0x00000001
+	JOB_OBJECT_UILIMIT_READCLIPBOARD    = 0x00000002
+	JOB_OBJECT_UILIMIT_WRITECLIPBOARD   = 0x00000004
+	JOB_OBJECT_UILIMIT_SYSTEMPARAMETERS = 0x00000008
+	JOB_OBJECT_UILIMIT_DISPLAYSETTINGS  = 0x00000010
+	JOB_OBJECT_UILIMIT_GLOBALATOMS      = 0x00000020
+	JOB_OBJECT_UILIMIT_DESKTOP          = 0x00000040
+	JOB_OBJECT_UILIMIT_EXITWINDOWS      = 0x00000080
+	JOB_OBJECT_UILIMIT_ALL              = 0x000000FF
+)
+
+// JOBOBJECT_CPU_RATE_CONTROL_INFORMATION ControlFlags
+const (
+	JOB_OBJECT_CPU_RATE_CONTROL_ENABLE       = 0x00000001
+	JOB_OBJECT_CPU_RATE_CONTROL_WEIGHT_BASED = 0x00000002
+	JOB_OBJECT_CPU_RATE_CONTROL_HARD_CAP     = 0x00000004
+	JOB_OBJECT_CPU_RATE_CONTROL_NOTIFY       = 0x00000008
+	JOB_OBJECT_CPU_RATE_CONTROL_MIN_MAX_RATE = 0x00000010
+)
+
+// job notification messages
+const (
+	JOB_OBJECT_MSG_END_OF_JOB_TIME       = 1
+	JOB_OBJECT_MSG_END_OF_PROCESS_TIME   = 2
+	JOB_OBJECT_MSG_ACTIVE_PROCESS_LIMIT  = 3
+	JOB_OBJECT_MSG_ACTIVE_PROCESS_ZERO   = 4
+	JOB_OBJECT_MSG_NEW_PROCESS           = 6
+	JOB_OBJECT_MSG_EXIT_PROCESS          = 7
+	JOB_OBJECT_MSG_ABNORMAL_EXIT_PROCESS = 8
+	JOB_OBJECT_MSG_PROCESS_MEMORY_LIMIT  = 9
+	JOB_OBJECT_MSG_JOB_MEMORY_LIMIT      = 10
+	JOB_OBJECT_MSG_NOTIFICATION_LIMIT    = 11
+	JOB_OBJECT_MSG_JOB_CYCLE_TIME_LIMIT  = 12
+	JOB_OBJECT_MSG_SILO_TERMINATED       = 13
+)
+
+type IO_COUNTERS struct {
+	ReadOperationCount  uint64
+	WriteOperationCount uint64
+	OtherOperationCount uint64
+	ReadTransferCount   uint64
+	WriteTransferCount  uint64
+	OtherTransferCount  uint64
+}
+
+// JOBOBJECT_BASIC_LIMIT_INFORMATION. The time limits are in 100ns units.
+type JOBOBJECT_BASIC_LIMIT_INFORMATION struct {
+	PerProcessUserTimeLimit int64
+	PerJobUserTimeLimit     int64
+	LimitFlags              uint32
+	MinimumWorkingSetSize   uintptr
+	MaximumWorkingSetSize   uintptr
+	ActiveProcessLimit      uint32
+	Affinity                uintptr
+	PriorityClass           uint32
+	SchedulingClass         uint32
+}
+
+type JOBOBJECT_EXTENDED_LIMIT_INFORMATION struct {
+	BasicLimitInformation JOBOBJECT_BASIC_LIMIT_INFORMATION
+
+	// C aligns the LARGE_INTEGERs above, and so the structure, to 8 bytes
+	// on 32-bit targets too, where Go aligns int64 to 4
+	_ [8 - unsafe.Sizeof(uintptr(0))]byte
+
+	IoInfo                IO_COUNTERS
+	ProcessMemoryLimit    uintptr
+	JobMemoryLimit        uintptr
+	PeakProcessMemoryUsed uintptr
+	PeakJobMemoryUsed     uintptr
+}
+
+// JOBOBJECT_BASIC_ACCOUNTING_INFORMATION. The times are in 100ns units.
+type JOBOBJECT_BASIC_ACCOUNTING_INFORMATION struct {
+	TotalUserTime             int64
+	TotalKernelTime           int64
+	ThisPeriodTotalUserTime   int64
+	ThisPeriodTotalKernelTime int64
+	TotalPageFaultCount       uint32
+	TotalProcesses            uint32
+	ActiveProcesses           uint32
+	TotalTerminatedProcesses  uint32
+}
+
+type JOBOBJECT_BASIC_AND_IO_ACCOUNTING_INFORMATION struct {
+	BasicInfo JOBOBJECT_BASIC_ACCOUNTING_INFORMATION
+	IoInfo    IO_COUNTERS
+}
+
+type JOBOBJECT_BASIC_UI_RESTRICTIONS struct {
+	UIRestrictionsClass uint32
+}
+
+// JOBOBJECT_CPU_RATE_CONTROL_INFORMATION. Rate is the union of CpuRate,
+// in hundredths of a percent, Weight, from 1 to 9, and MinRate and MaxRate
+// in its low and high words.
+type JOBOBJECT_CPU_RATE_CONTROL_INFORMATION struct {
+	ControlFlags uint32
+	Rate         uint32
+}
+
+type JOBOBJECT_ASSOCIATE_COMPLETION_PORT struct {
+	CompletionKey  uintptr
+	CompletionPort syscall.Handle
+}
+
+var (
+	k32AssignProcessToJobObject   = kernel32Dll.NewProc("AssignProcessToJobObject")
+	k32CreateIoCompletionPort     = kernel32Dll.NewProc("CreateIoCompletionPort")
+	k32CreateJobObject            = kernel32Dll.NewProc("CreateJobObjectW")
+	k32GetQueuedCompletionStatus  = kernel32Dll.NewProc("GetQueuedCompletionStatus")
+	k32IsProcessInJob             = kernel32Dll.NewProc("IsProcessInJob")
+	k32PostQueuedCompletionStatus = kernel32Dll.NewProc("PostQueuedCompletionStatus")
+	k32QueryInformationJobObject  = kernel32Dll.NewProc("QueryInformationJobObject")
+	k32SetInformationJobObject    = kernel32Dll.NewProc("SetInformationJobObject")
+	k32TerminateJobObject         = kernel32Dll.NewProc("TerminateJobObject")
+)
+
+// HANDLE WINAPI CreateJobObject(
+//   _In_opt_ LPSECURITY_ATTRIBUTES lpJobAttributes,
+//   _In_opt_ LPCTSTR               lpName
+// );
+// fail == NULL
+func CreateJobObject(name string) (syscall.Handle, error) {
+	var namePtr *uint16
+	if name != "" {
+		namePtr = syscall.StringToUTF16Ptr(name)
+	}
+
+	ret, _, err := k32CreateJobObject.Call(0, uintptr(unsafe.Pointer(namePtr)))
+	if ret == 0 {
+		return 0, err
+	}
+
+	return syscall.Handle(ret), nil
+}
+
+// BOOL WINAPI AssignProcessToJobObject(
+//   _In_ HANDLE hJob,
+//   _In_ HANDLE hProcess
+// );
+// fail == 0
+//
+// proc needs PROCESS_SET_QUOTA and PROCESS_TERMINATE access.
+func AssignProcessToJobObject(job, proc syscall.Handle) error {
+	ret, _, err := k32AssignProcessToJobObject.Call(uintptr(job), uintptr(proc))
+	if ret == 0 {
+		return err
+	}
+
+	return nil
+}
+
+// BOOL WINAPI SetInformationJobObject(
+//   _In_ HANDLE             hJob,
+//   _In_ JOBOBJECTINFOCLASS JobObjectInfoClass,
+//   _In_ LPVOID             lpJobObjectInfo,
+//   _In_ DWORD              cbJobObjectInfoLength
+// );
+// fail == 0
+func SetInformationJobObject(job syscall.Handle, class uint32, info unsafe.Pointer, size uint32) error {
+	ret, _, err := k32SetInformationJobObject.Call(
+		uintptr(job),
+		uintptr(class),
+		uintptr(info),
+		uintptr(size),
+	)
+
+	if ret == 0 {
+		return err
+	}
+
+	return nil
+}
+
+// BOOL WINAPI QueryInformationJobObject(
+//   _In_opt_  HANDLE             hJob,
+//   _In_      JOBOBJECTINFOCLASS JobObjectInfoClass,
+//   _Out_     LPVOID             lpJobObjectInfo,
+//   _In_      DWORD              cbJobObjectInfoLength,
+//   _Out_opt_ LPDWORD            lpReturnLength
+// );
+// fail == 0
+func QueryInformationJobObject(job syscall.Handle, class uint32, info unsafe.Pointer, size uint32) (uint32, error) {
+	var returnLength uint32
+
+	ret, _, err := k32QueryInformationJobObject.Call(
+		uintptr(job),
+		uintptr(class),
+		uintptr(info),
+		uintptr(size),
+		uintptr(unsafe.Pointer(&returnLength)),
+	)
+
+	if ret == 0 {
+		return returnLength, err
+	}
+
+	return returnLength, nil
+}
+
+// BOOL WINAPI TerminateJobObject(
+//   _In_ HANDLE hJob,
+//   _In_ UINT   uExitCode
+// );
+// fail == 0
+func TerminateJobObject(job syscall.Handle, exitCode uint32) error {
+	ret, _, err := k32TerminateJobObject.Call(uintptr(job), uintptr(exitCode))
+	if ret == 0 {
+		return err
+	}
+
+	return nil
+}
+
+// BOOL WINAPI IsProcessInJob(
+//   _In_     HANDLE ProcessHandle,
+//   _In_opt_ HANDLE JobHandle,
+//   _Out_    PBOOL  Result
+// );
+// fail == 0
+//
+// A zero job asks whether the process is in any job.
+func IsProcessInJob(proc, job syscall.Handle) (bool, error) {
+	var result int32
+
+	ret, _, err := k32IsProcessInJob.Call(
+		uintptr(proc),
+		uintptr(job),
+		uintptr(unsafe.Pointer(&result)),
+	)
+
+	if ret == 0 {
+		return false, err
+	}
+
+	return result != 0, nil
+}
+
+// HANDLE WINAPI CreateIoCompletionPort(
+//   _In_     HANDLE    FileHandle,
+//   _In_opt_ HANDLE    ExistingCompletionPort,
+//   _In_     ULONG_PTR CompletionKey,
+//   _In_     DWORD     NumberOfConcurrentThreads
+// );
+// fail == NULL
+func CreateIoCompletionPort(file, port syscall.Handle, key uintptr, threads uint32) (syscall.Handle, error) {
+	ret, _, err := k32CreateIoCompletionPort.Call(
+		uintptr(file),
+		uintptr(port),
+		key,
+		uintptr(threads),
+	)
+
+	if ret == 0 {
+		return 0, err
+	}
+
+	return syscall.Handle(ret), nil
+}
+
+// BOOL WINAPI GetQueuedCompletionStatus(
+//   _In_  HANDLE       CompletionPort,
+//   _Out_ LPDWORD      lpNumberOfBytes,
+//   _Out_ PULONG_PTR   lpCompletionKey,
+//   _Out_ LPOVERLAPPED *lpOverlapped,
+//   _In_  DWORD        dwMilliseconds
+// );
+// fail == 0
+//
+// Unlike syscall.GetQueuedCompletionStatus, the key is pointer sized, and
+// overlapped is returned as a plain value: for job notifications it holds
+// a process ID rather than a pointer.
+func GetQueuedCompletionStatus(port syscall.Handle, timeout uint32) (bytes uint32, key uintptr, overlapped uintptr, err error) {
+	ret, _, callErr := k32GetQueuedCompletionStatus.Call(
+		uintptr(port),
+		uintptr(unsafe.Pointer(&bytes)),
+		uintptr(unsafe.Pointer(&key)),
+		uintptr(unsafe.Pointer(&overlapped)),
+		uintptr(timeout),
+	)
+
+	if ret == 0 {
+		return bytes, key, overlapped, callErr
+	}
+
+	return bytes, key, overlapped, nil
+}
+
+// BOOL WINAPI PostQueuedCompletionStatus(
+//   _In_     HANDLE       CompletionPort,
+//   _In_     DWORD        dwNumberOfBytesTransferred,
+//   _In_     ULONG_PTR    dwCompletionKey,
+//   _In_opt_ LPOVERLAPPED lpOverlapped
+// );
+// fail == 0
+func PostQueuedCompletionStatus(port syscall.Handle, bytes uint32, key, overlapped uintptr) error {
+	ret, _, err := k32PostQueuedCompletionStatus.Call(
+		uintptr(port),
+		uintptr(bytes),
+		key,
+		overlapped,
+	)
+
+	if ret == 0 {
+		return err
+	}
+
+	return nil
+}
+
+// JobMessage is a JOB_OBJECT_MSG_* notification.
+type JobMessage uint32
+
+var jobMessageNames = map[JobMessage]string{
+	JOB_OBJECT_MSG_END_OF_JOB_TIME:       "END_OF_JOB_TIME",
+	JOB_OBJECT_MSG_END_OF_PROCESS_TIME:   "END_OF_PROCESS_TIME",
+	JOB_OBJECT_MSG_ACTIVE_PROCESS_LIMIT:  "ACTIVE_PROCESS_LIMIT",
+	JOB_OBJECT_MSG_ACTIVE_PROCESS_ZERO:   "ACTIVE_PROCESS_ZERO",
+	JOB_OBJECT_MSG_NEW_PROCESS:           "NEW_PROCESS",
+	JOB_OBJECT_MSG_EXIT_PROCESS:          "EXIT_PROCESS",
+	JOB_OBJECT_MSG_ABNORMAL_EXIT_PROCESS: "ABNORMAL_EXIT_PROCESS",
+	JOB_OBJECT_MSG_PROCESS_MEMORY_LIMIT:  "PROCESS_MEMORY_LIMIT",
+	JOB_OBJECT_MSG_JOB_MEMORY_LIMIT:      "JOB_MEMORY_LIMIT",
+	JOB_OBJECT_MSG_NOTIFICATION_LIMIT:    "NOTIFICATION_LIMIT",
+	JOB_OBJECT_MSG_JOB_CYCLE_TIME_LIMIT:  "JOB_CYCLE_TIME_LIMIT",
+	JOB_OBJECT_MSG_SILO_TERMINATED:       "SILO_TERMINATED",
+}
+
+func (m JobMessage) String() string {
+	if name, ok := jobMessageNames[m]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("JobMessage(%d)", uint32(m))
+}
+
+// JobNotification is a message posted by a job to its completion port.
+// ProcessID is set for the per-process messages, such as NEW_PROCESS and
+// EXIT_PROCESS.
+type JobNotification struct {
+	Message   JobMessage
+	ProcessID uint32
+}
+
+// JobLimits is the commonly used subset of the extended limits. Zero
+// fields are not limited.
+type JobLimits struct {
+	// ProcessMemory and JobMemory limit the committed bytes of each
+	// process and of the job as a whole.
+	ProcessMemory uintptr
+	JobMemory     uintptr
+
+	// ActiveProcesses limits the number of processes in the job; further
+	// processes fail to start.
+	ActiveProcesses uint32
+
+	// KillOnClose terminates every process in the job when the last
+	// handle to it is closed, including when the owner crashes.
+	KillOnClose bool
+
+	// DieOnUnhandledException suppresses the error reporting dialog for
+	// processes in the job.
+	DieOnUnhandledException bool
+
+	// BreakawayOK allows children created with CREATE_BREAKAWAY_FROM_JOB
+	// to leave the job.
+	BreakawayOK bool
+}
+
+func (l *JobLimits) extended() *JOBOBJECT_EXTENDED_LIMIT_INFORMATION {
+	var info JOBOBJECT_EXTENDED_LIMIT_INFORMATION
+	basic := &info.BasicLimitInformation
+
+	if l.ProcessMemory != 0 {
+		basic.LimitFlags |= JOB_OBJECT_LIMIT_PROCESS_MEMORY
+		info.ProcessMemoryLimit = l.ProcessMemory
+	}
+
+	if l.JobMemory != 0 {
+		basic.LimitFlags |= JOB_OBJECT_LIMIT_JOB_MEMORY
+		info.JobMemoryLimit = l.JobMemory
+	}
+
+	if l.ActiveProcesses != 0 {
+		basic.LimitFlags |= JOB_OBJECT_LIMIT_ACTIVE_PROCESS
+		basic.ActiveProcessLimit = l.ActiveProcesses
+	}
+
+	if l.KillOnClose {
+		basic.LimitFlags |= JOB_OBJECT_LIMIT_KILL_ON_JOB_CLOSE
+	}
+
+	if l.DieOnUnhandledException {
+		basic.LimitFlags |= JOB_OBJECT_LIMIT_DIE_ON_UNHANDLED_EXCEPTION
+	}
+
+	if l.BreakawayOK {
+		basic.LimitFlags |= JOB_OBJECT_LIMIT_BREAKAWAY_OK
+	}
+
+	return &info
+}
+
+// cpuRate converts a percentage of the machine's CPU to the hundredths of
+// a percent CpuRate is given in.
+func cpuRate(percent float64) (uint32, error) {
+	rate := math.Round(percent * 100)
+	if rate < 1 || rate > 10000 {
+		return 0, fmt.Errorf("cpu rate %v%% out of range", percent)
+	}
+
+	return uint32(rate), nil
+}
+
+// completion keys of the job's port
+const (
+	jobKeyNotification = 1
+	jobKeyStop         = 2
+)
+
+// Job is a job object, which groups processes so that they can be limited,
+// accounted and terminated together.
+type Job struct {
+	handle syscall.Handle
+
+	mu     sync.Mutex
+	port   syscall.Handle
+	notify chan JobNotification
+
+	// stopped is closed when the notification pump has returned, after
+	// which Close may close the port.
+	stopped chan struct{}
+}
+
+// NewJob creates a job object. An empty name creates an unnamed job.
+func NewJob(name string) (*Job, error) {
+	handle, err := CreateJobObject(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Job{handle: handle}, nil
+}
+
+// Handle returns the job handle.
+func (j *Job) Handle() syscall.Handle {
+	return j.handle
+}
+
+// Assign adds a process to the job. Processes it creates afterwards join
+// the job too, unless they break away.
+func (j *Job) Assign(proc syscall.Handle) error {
+	return AssignProcessToJobObject(j.handle, proc)
+}
+
+// AssignPid opens process pid and adds it to the job.
+func (j *Job) AssignPid(pid uint32) error {
+	proc, err := OpenProcess(pid, PROCESS_SET_QUOTA|PROCESS_TERMINATE)
+	if err != nil {
+		return err
+	}
+	defer syscall.CloseHandle(proc)
+
+	return j.Assign(proc)
+}
+
+// Contains reports whether proc is in the job.
+func (j *Job) Contains(proc syscall.Handle) (bool, error) {
+	return IsProcessInJob(proc, j.handle)
+}
+
+// SetLimits replaces the job's basic and extended limits with limits.
+func (j *Job) SetLimits(limits *JobLimits) error {
+	return j.SetExtendedLimits(limits.extended())
+}
+
+// ExtendedLimits returns the job's limits and peak memory use.
+func (j *Job) ExtendedLimits() (*JOBOBJECT_EXTENDED_LIMIT_INFORMATION, error) {
+	var info JOBOBJECT_EXTENDED_LIMIT_INFORMATION
+
+	_, err := QueryInformationJobObject(
+		j.handle,
+		JobObjectExtendedLimitInformation,
+		unsafe.Pointer(&info),
+		uint32(unsafe.Sizeof(info)),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &info, nil
+}
+
+// SetExtendedLimits sets the job's limits from info.
+func (j *Job) SetExtendedLimits(info *JOBOBJECT_EXTENDED_LIMIT_INFORMATION) error {
+	return SetInformationJobObject(
+		j.handle,
+		JobObjectExtendedLimitInformation,
+		unsafe.Pointer(info),
+		uint32(unsafe.Sizeof(*info)),
+	)
+}
+
+// SetUIRestrictions sets the JOB_OBJECT_UILIMIT_* restrictions of the job.
+func (j *Job) SetUIRestrictions(flags uint32) error {
+	info := JOBOBJECT_BASIC_UI_RESTRICTIONS{UIRestrictionsClass: flags}
+
+	return SetInformationJobObject(
+		j.handle,
+		JobObjectBasicUIRestrictions,
+		unsafe.Pointer(&info),
+		uint32(unsafe.Sizeof(info)),
+	)
+}
+
+// SetCpuRate sets the job's CPU rate control, which requires Windows 8.
+func (j *Job) SetCpuRate(info *JOBOBJECT_CPU_RATE_CONTROL_INFORMATION) error {
+	return SetInformationJobObject(
+		j.handle,
+		JobObjectCpuRateControlInformation,
+		unsafe.Pointer(info),
+		uint32(unsafe.Sizeof(*info)),
+	)
+}
+
+// SetCpuHardCap caps the job at percent of the machine's total CPU time,
+// from 0.01 to 100.
+func (j *Job) SetCpuHardCap(percent float64) error {
+	rate, err := cpuRate(percent)
+	if err != nil {
+		return err
+	}
+
+	return j.SetCpuRate(&JOBOBJECT_CPU_RATE_CONTROL_INFORMATION{
+		ControlFlags: JOB_OBJECT_CPU_RATE_CONTROL_ENABLE | JOB_OBJECT_CPU_RATE_CONTROL_HARD_CAP,
+		Rate:         rate,
+	})
+}
+
+// Accounting returns the job's CPU time, process counts and I/O.
+func (j *Job) Accounting() (*JOBOBJECT_BASIC_AND_IO_ACCOUNTING_INFORMATION, error) {
+	var info JOBOBJECT_BASIC_AND_IO_ACCOUNTING_INFORMATION
+
+	_, err := QueryInformationJobObject(
+		j.handle,
+		JobObjectBasicAndIoAccountingInformation,
+		unsafe.Pointer(&info),
+		uint32(unsafe.Sizeof(info)),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return &info, nil
+}
+
+// ProcessIDs returns the IDs of the processes in the job.
+func (j *Job) ProcessIDs() ([]uint32, error) {
+	// JOBOBJECT_BASIC_PROCESS_ID_LIST is two DWORD counts followed by
+	// ULONG_PTR IDs
+	ptrSize := unsafe.Sizeof(uintptr(0))
+	header := 2 * unsafe.Sizeof(uint32(0))
+
+	for capacity := 64; ; capacity *= 2 {
+		buffer := make([]uintptr, (header/ptrSize)+uintptr(capacity))
+
+		_, err := QueryInformationJobObject(
+			j.handle,
+			JobObjectBasicProcessIdList,
+			unsafe.Pointer(&buffer[0]),
+			uint32(uintptr(len(buffer))*ptrSize),
+		)
+		if err == syscall.ERROR_MORE_DATA {
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		counts := (*[2]uint32)(unsafe.Pointer(&buffer[0]))
+		ids := buffer[header/ptrSize:][:counts[1]]
+
+		pids := make([]uint32, len(ids))
+		for i, id := range ids {
+			pids[i] = uint32(id)
+		}
+
+		return pids, nil
+	}
+}
+
+// Terminate terminates every process in the job with exitCode.
+func (j *Job) Terminate(exitCode uint32) error {
+	return TerminateJobObject(j.handle, exitCode)
+}
+
+// Notifications associates a completion port with the job and returns a
+// channel of its messages, which is closed by Close. Call it before
+// assigning processes to see their NEW_PROCESS messages. Messages are
+// dropped if the channel is not drained.
+func (j *Job) Notifications() (<-chan JobNotification, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.notify != nil {
+		return j.notify, nil
+	}
+
+	port, err := CreateIoCompletionPort(syscall.InvalidHandle, 0, 0, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	assoc := JOBOBJECT_ASSOCIATE_COMPLETION_PORT{
+		CompletionKey:  jobKeyNotification,
+		CompletionPort: port,
+	}
+
+	err = SetInformationJobObject(
+		j.handle,
+		JobObjectAssociateCompletionPortInformation,
+		unsafe.Pointer(&assoc),
+		uint32(unsafe.Sizeof(assoc)),
+	)
+	if err != nil {
+		syscall.CloseHandle(port)
+		return nil, err
+	}
+
+	j.port = port
+	j.notify = make(chan JobNotification, 64)
+	j.stopped = make(chan struct{})
+
+	go j.pump(port, j.notify, j.stopped)
+
+	return j.notify, nil
+}
+
+// pump forwards the port's messages until Close posts jobKeyStop. The port
+// belongs to Close, which waits for stopped before closing it.
+func (j *Job) pump(port syscall.Handle, notify chan<- JobNotification, stopped chan<- struct{}) {
+	defer close(stopped)
+	defer close(notify)
+
+	for {
+		message, key, overlapped, err := GetQueuedCompletionStatus(port, syscall.INFINITE)
+		if err != nil || key == jobKeyStop {
+			return
+		}
+
+		select {
+		case notify <- JobNotification{Message: JobMessage(message), ProcessID: uint32(overlapped)}:
+		default:
+			// a full channel drops the message rather than holding up
+			// the port
+		}
+	}
+}
+
+// Close closes the job handle, which terminates the job's processes if it
+// was the last handle and KillOnClose is set, and stops notifications.
+func (j *Job) Close() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if j.stopped != nil {
+		if err := PostQueuedCompletionStatus(j.port, 0, jobKeyStop, 0); err != nil {
+			// closing the port fails the pump's wait instead
+			syscall.CloseHandle(j.port)
+			<-j.stopped
+		} else {
+			<-j.stopped
+			syscall.CloseHandle(j.port)
+		}
+
+		j.port = 0
+		j.stopped = nil
+	}
+
+	if j.handle == 0 {
+		return nil
+	}
+
+	err := syscall.CloseHandle(j.handle)
+	j.handle = 0
+
+	return err
+}
@@ -2,6 +2,7 @@ package kernel32
 
 import (
 	"fmt"
+	"runtime"
 	"syscall"
 	"unsafe"
 )
@@ -208,11 +209,17 @@ var (
 	k32CloseHandle              = kernel32Dll.NewProc("CloseHandle")
 	k32CreateEvent              = kernel32Dll.NewProc("CreateEventExW")
 	k32CreateToolhelp32Snapshot = kernel32Dll.NewProc("CreateToolhelp32Snapshot")
+	k32GetProcessIdOfThread     = kernel32Dll.NewProc("GetProcessIdOfThread")
 	k32GetThreadContext         = kernel32Dll.NewProc("GetThreadContext")
+	k32GetThreadDescription     = kernel32Dll.NewProc("GetThreadDescription")
+	k32GetThreadTimes           = kernel32Dll.NewProc("GetThreadTimes")
+	k32IsWow64Process           = kernel32Dll.NewProc("IsWow64Process")
+	k32IsWow64Process2          = kernel32Dll.NewProc("IsWow64Process2")
 	k32LocalFree                = kernel32Dll.NewProc("LocalFree")
 	k32OpenEvent                = kernel32Dll.NewProc("OpenEventW")
 	k32OpenProcess              = kernel32Dll.NewProc("OpenProcess")
 	k32OpenThread               = kernel32Dll.NewProc("OpenThread")
+	k32ProcessIdToSessionId     = kernel32Dll.NewProc("ProcessIdToSessionId")
 	k32ResumeThread             = kernel32Dll.NewProc("ResumeThread")
 	k32SuspendThread            = kernel32Dll.NewProc("SuspendThread")
 	k32Thread32First            = kernel32Dll.NewProc("Thread32First")
@@ -220,31 +227,9 @@ var (
 	k32ReadProcessMemory        = kernel32Dll.NewProc("ReadProcessMemory")
 	k32SetEvent                 = kernel32Dll.NewProc("SetEvent")
 	k32WriteProcessMemory       = kernel32Dll.NewProc("WriteProcessMemory")
-	k32WaitOnAddress            = kernel32Dll.NewProc("WaitOnAddress")
+	k32Wow64GetThreadContext    = kernel32Dll.NewProc("Wow64GetThreadContext")
 )
 
-// BOOL  WINAPI WaitOnAddress(
-//   _In_     VOID   volatile *Address,
-//   _In_     PVOID           CompareAddress,
-//   _In_     SIZE_T          AddressSize,
-//   _In_opt_ DWORD           dwMilliseconds
-// );
-// fail == 0
-func WaitOnAddress(watch, compare *byte, timeout uint32) error {
-	ret, _, err := k32WaitOnAddress.Call(
-		uintptr(unsafe.Pointer(watch)),
-		uintptr(unsafe.Pointer(compare)),
-		unsafe.Sizeof(watch),
-		uintptr(timeout),
-	)
-
-	if ret == 0 {
-		return err
-	}
-
-	return nil
-}
-
 // BOOL WINAPI WriteProcessMemory(
 //   _In_  HANDLE  hProcess,
 //   _In_  LPVOID  lpBaseAddress,
@@ -274,6 +259,19 @@ func WriteProcessMemory(proc syscall.Handle, addr, size, buffer uintptr) error {
 	return nil
 }
 
+// BOOL WINAPI CloseHandle(
+//   _In_ HANDLE hObject
+// );
+// fail == 0
+func CloseHandle(handle uintptr) error {
+	ret, _, err := k32CloseHandle.Call(handle)
+	if ret == 0 {
+		return err
+	}
+
+	return nil
+}
+
 // HANDLE WINAPI CreateEventEx(
 //   _In_opt_ LPSECURITY_ATTRIBUTES lpEventAttributes,
 //   _In_opt_ LPCTSTR               lpName,
@@ -344,21 +342,38 @@ func SetEvent(event uintptr) error {
 // fail == 0
 func ReadProcessMemory(proc syscall.Handle, addr uint64, size uint64) ([]byte, error) {
 	buffer := make([]byte, size)
-	var bytesRead uint64
+
+	n, err := ReadProcessMemoryInto(proc, addr, buffer)
+	if err != nil {
+		return nil, err
+	}
+
+	return buffer[:n], nil
+}
+
+// ReadProcessMemoryInto reads into buffer rather than allocating, and
+// returns the number of bytes read. A read that reaches an unreadable page
+// fails with ERROR_PARTIAL_COPY, possibly after reading a prefix of buffer.
+func ReadProcessMemoryInto(proc syscall.Handle, addr uint64, buffer []byte) (int, error) {
+	if len(buffer) == 0 {
+		return 0, nil
+	}
+
+	var bytesRead uintptr
 
 	ret, _, err := k32ReadProcessMemory.Call(
 		uintptr(proc),
 		uintptr(addr),
 		uintptr(unsafe.Pointer(&buffer[0])),
-		uintptr(size),
+		uintptr(len(buffer)),
 		uintptr(unsafe.Pointer(&bytesRead)),
 	)
 
 	if ret == 0 {
-		return nil, err
+		return int(bytesRead), err
 	}
 
-	return buffer, nil
+	return int(bytesRead), nil
 }
 
 // HANDLE WINAPI CreateToolhelp32Snapshot(
@@ -366,6 +381,9 @@ func ReadProcessMemory(proc syscall.Handle, addr uint64, size uint64) ([]byte, e
 //   _In_ DWORD th32ProcessID
 // );
 // fail == INVALID_HANDLE_VALUE
+//
+// Deprecated: use NewSnapshot(TH32CS_SNAPTHREAD, pid) and Snapshot.Threads,
+// which return a typed handle with a Close method.
 func CreateThreadSnapshot(pid uint32) (int64, error) {
 	ret, _, err := k32CreateToolhelp32Snapshot.Call(
 		uintptr(TH32CS_SNAPTHREAD),
@@ -383,20 +401,227 @@ func CreateThreadSnapshot(pid uint32) (int64, error) {
 //   _Inout_ LPCONTEXT lpContext
 // );
 // fail == 0
-func GetThreadContext(threadHandle uintptr) (*CONTEXT, error) {
-	var context CONTEXT
-	context.ContextFlags = CONTEXT_FULL
+//
+// The record type is chosen from the machine type of the thread's process:
+// WOW64 threads are read with Wow64GetThreadContext into a *WOW64_CONTEXT,
+// native threads into the host's *CONTEXT, *ARM64_NT_CONTEXT or
+// *X86_CONTEXT.
+func GetThreadContext(threadHandle uintptr) (ThreadContext, error) {
+	machine, wow64, err := threadMachine(threadHandle)
+	if err != nil {
+		return nil, err
+	}
+
+	if wow64 {
+		return Wow64GetThreadContext(threadHandle)
+	}
+
+	context := NewContext(machine)
+	if context == nil {
+		return nil, fmt.Errorf("Unsupported machine type 0x%x", machine)
+	}
 
 	ret, _, err := k32GetThreadContext.Call(
 		threadHandle,
-		uintptr(unsafe.Pointer(&context)),
+		uintptr(context.Pointer()),
+	)
+
+	if ret == 0 {
+		return nil, err
+	}
+
+	return context, nil
+}
+
+// BOOL WINAPI Wow64GetThreadContext(
+//   _In_    HANDLE         hThread,
+//   _Inout_ PWOW64_CONTEXT lpContext
+// );
+// fail == 0
+func Wow64GetThreadContext(threadHandle uintptr) (*WOW64_CONTEXT, error) {
+	context := NewContext(IMAGE_FILE_MACHINE_I386).(*WOW64_CONTEXT)
+
+	ret, _, err := k32Wow64GetThreadContext.Call(
+		threadHandle,
+		uintptr(unsafe.Pointer(context)),
 	)
 
 	if ret == 0 {
 		return nil, err
 	}
 
-	return &context, nil
+	return context, nil
+}
+
+// BOOL WINAPI IsWow64Process2(
+//   _In_      HANDLE hProcess,
+//   _Out_     USHORT *pProcessMachine,
+//   _Out_opt_ USHORT *pNativeMachine
+// );
+// fail == 0
+//
+// processMachine is IMAGE_FILE_MACHINE_UNKNOWN for processes that are not
+// running under WOW64. On systems that predate IsWow64Process2 the result is
+// derived from IsWow64Process, which only recognizes x86 guests.
+func IsWow64Process2(proc syscall.Handle) (processMachine, nativeMachine uint16, err error) {
+	if k32IsWow64Process2.Find() != nil {
+		var wow64 int32
+
+		ret, _, callErr := k32IsWow64Process.Call(
+			uintptr(proc),
+			uintptr(unsafe.Pointer(&wow64)),
+		)
+
+		if ret == 0 {
+			return 0, 0, callErr
+		}
+
+		nativeMachine = hostMachine()
+		if wow64 != 0 {
+			processMachine = IMAGE_FILE_MACHINE_I386
+			nativeMachine = IMAGE_FILE_MACHINE_AMD64
+		}
+
+		return processMachine, nativeMachine, nil
+	}
+
+	ret, _, callErr := k32IsWow64Process2.Call(
+		uintptr(proc),
+		uintptr(unsafe.Pointer(&processMachine)),
+		uintptr(unsafe.Pointer(&nativeMachine)),
+	)
+
+	if ret == 0 {
+		return 0, 0, callErr
+	}
+
+	return processMachine, nativeMachine, nil
+}
+
+// DWORD WINAPI GetProcessIdOfThread(
+//   _In_ HANDLE Thread
+// );
+// fail == 0
+func GetProcessIdOfThread(threadHandle uintptr) (uint32, error) {
+	ret, _, err := k32GetProcessIdOfThread.Call(threadHandle)
+	if ret == 0 {
+		return 0, err
+	}
+
+	return uint32(ret), nil
+}
+
+// threadMachine returns the machine type of the context record to read for
+// the given thread, and whether it must be read through the WOW64 API.
+func threadMachine(threadHandle uintptr) (uint16, bool, error) {
+	pid, err := GetProcessIdOfThread(threadHandle)
+	if err != nil {
+		return 0, false, err
+	}
+
+	proc, err := OpenProcess(pid, PROCESS_QUERY_LIMITED_INFORMATION)
+	if err != nil {
+		return 0, false, err
+	}
+	defer syscall.CloseHandle(proc)
+
+	processMachine, _, err := IsWow64Process2(proc)
+	if err != nil {
+		return 0, false, err
+	}
+
+	host := hostMachine()
+
+	switch {
+	case processMachine == IMAGE_FILE_MACHINE_UNKNOWN:
+		// a native process, which 32-bit callers can only inspect when
+		// the whole system is 32-bit
+		if host == IMAGE_FILE_MACHINE_I386 && isWow64Self() {
+			return 0, false, fmt.Errorf("Cannot read a 64-bit thread context from a 32-bit process")
+		}
+		return host, false, nil
+	case processMachine == IMAGE_FILE_MACHINE_I386 && host == IMAGE_FILE_MACHINE_I386:
+		return host, false, nil
+	case processMachine == IMAGE_FILE_MACHINE_I386:
+		return IMAGE_FILE_MACHINE_I386, true, nil
+	}
+
+	return 0, false, fmt.Errorf("Unsupported WOW64 guest machine type 0x%x", processMachine)
+}
+
+// hostMachine returns the machine type of the calling process.
+func hostMachine() uint16 {
+	switch runtime.GOARCH {
+	case "386":
+		return IMAGE_FILE_MACHINE_I386
+	case "arm64":
+		return IMAGE_FILE_MACHINE_ARM64
+	case "arm":
+		return IMAGE_FILE_MACHINE_ARMNT
+	}
+
+	return IMAGE_FILE_MACHINE_AMD64
+}
+
+// isWow64Self reports whether the calling process runs under WOW64.
+func isWow64Self() bool {
+	self, err := syscall.GetCurrentProcess()
+	if err != nil {
+		return false
+	}
+
+	processMachine, _, err := IsWow64Process2(self)
+
+	return err == nil && processMachine != IMAGE_FILE_MACHINE_UNKNOWN
+}
+
+// HRESULT WINAPI GetThreadDescription(
+//   _In_  HANDLE hThread,
+//   _Out_ PWSTR  *ppszThreadDescription
+// );
+// fail == FAILED(hr)
+func GetThreadDescription(threadHandle uintptr) (string, error) {
+	if err := k32GetThreadDescription.Find(); err != nil {
+		return "", err
+	}
+
+	var desc *uint16
+
+	ret, _, _ := k32GetThreadDescription.Call(
+		threadHandle,
+		uintptr(unsafe.Pointer(&desc)),
+	)
+
+	if int32(ret) < 0 {
+		return "", syscall.Errno(ret & 0xFFFF)
+	}
+	defer LocalFree(unsafe.Pointer(desc))
+
+	return syscall.UTF16ToString((*[1 << 16]uint16)(unsafe.Pointer(desc))[:]), nil
+}
+
+// BOOL WINAPI GetThreadTimes(
+//   _In_  HANDLE     hThread,
+//   _Out_ LPFILETIME lpCreationTime,
+//   _Out_ LPFILETIME lpExitTime,
+//   _Out_ LPFILETIME lpKernelTime,
+//   _Out_ LPFILETIME lpUserTime
+// );
+// fail == 0
+func GetThreadTimes(threadHandle uintptr) (creation, exit, kernel, user syscall.Filetime, err error) {
+	ret, _, callErr := k32GetThreadTimes.Call(
+		threadHandle,
+		uintptr(unsafe.Pointer(&creation)),
+		uintptr(unsafe.Pointer(&exit)),
+		uintptr(unsafe.Pointer(&kernel)),
+		uintptr(unsafe.Pointer(&user)),
+	)
+
+	if ret == 0 {
+		err = callErr
+	}
+
+	return
 }
 
 func LocalFree(p unsafe.Pointer) error {
@@ -408,9 +633,19 @@ func LocalFree(p unsafe.Pointer) error {
 	return nil
 }
 
-func OpenProcess(pid uint32) (syscall.Handle, error) {
+// HANDLE WINAPI OpenProcess(
+//   _In_ DWORD dwDesiredAccess,
+//   _In_ BOOL  bInheritHandle,
+//   _In_ DWORD dwProcessId
+// );
+// fail == 0
+//
+// Request only the rights needed: PROCESS_ALL_ACCESS is denied for
+// protected processes and, without the debug privilege, for processes of
+// other users.
+func OpenProcess(pid uint32, access ProcessAccess) (syscall.Handle, error) {
 	ret, _, err := k32OpenProcess.Call(
-		uintptr(PROCESS_ALL_ACCESS),
+		uintptr(access),
 		0,
 		uintptr(pid),
 	)
@@ -422,15 +657,36 @@ func OpenProcess(pid uint32) (syscall.Handle, error) {
 	return syscall.Handle(ret), nil
 }
 
+// OpenProcessFallback opens pid with access and, if that is denied, tries
+// again with PROCESS_QUERY_LIMITED_INFORMATION in place of
+// PROCESS_QUERY_INFORMATION and finally with
+// PROCESS_QUERY_LIMITED_INFORMATION alone. It returns the rights that were
+// granted, which callers should check before using the handle for anything
+// beyond limited queries.
+func OpenProcessFallback(pid uint32, access ProcessAccess) (syscall.Handle, ProcessAccess, error) {
+	proc, err := OpenProcess(pid, access)
+	if err == nil || err != ERROR_ACCESS_DENIED {
+		return proc, access, err
+	}
+
+	for _, limited := range access.limitedFallback() {
+		if proc, lerr := OpenProcess(pid, limited); lerr == nil {
+			return proc, limited, nil
+		}
+	}
+
+	return syscall.InvalidHandle, 0, err
+}
+
 // HANDLE WINAPI OpenThread(
 //   _In_ DWORD dwDesiredAccess,
 //   _In_ BOOL  bInheritHandle,
 //   _In_ DWORD dwThreadId
 // );
 // fail == 0
-func OpenThread(threadId uint32) (uintptr, error) {
+func OpenThread(threadId uint32, access ThreadAccess) (uintptr, error) {
 	ret, _, err := k32OpenThread.Call(
-		THREAD_ALL_ACCESS,
+		uintptr(access),
 		uintptr(0),
 		uintptr(threadId),
 	)
@@ -442,6 +698,26 @@ func OpenThread(threadId uint32) (uintptr, error) {
 	return ret, nil
 }
 
+// BOOL WINAPI ProcessIdToSessionId(
+//   _In_  DWORD dwProcessId,
+//   _Out_ DWORD *pSessionId
+// );
+// fail == 0
+func ProcessIdToSessionId(pid uint32) (uint32, error) {
+	var session uint32
+
+	ret, _, err := k32ProcessIdToSessionId.Call(
+		uintptr(pid),
+		uintptr(unsafe.Pointer(&session)),
+	)
+
+	if ret == 0 {
+		return 0, err
+	}
+
+	return session, nil
+}
+
 // DWORD WINAPI ResumeThread(
 //   _In_ HANDLE hThread
 // );
@@ -0,0 +1,266 @@
+package kernel32
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+const (
+	FILE_MAP_COPY       = 0x00000001
+	FILE_MAP_WRITE      = 0x00000002
+	FILE_MAP_READ       = 0x00000004
+	FILE_MAP_ALL_ACCESS = 0x000F001F
+
+	SEC_COMMIT  = 0x08000000
+	SEC_RESERVE = 0x04000000
+
+	// maxView bounds the byte slices MapView returns.
+	maxView = 1 << 30
+)
+
+var (
+	k32CreateFileMapping = kernel32Dll.NewProc("CreateFileMappingW")
+	k32MapViewOfFile     = kernel32Dll.NewProc("MapViewOfFile")
+	k32OpenFileMapping   = kernel32Dll.NewProc("OpenFileMappingW")
+	k32UnmapViewOfFile   = kernel32Dll.NewProc("UnmapViewOfFile")
+)
+
+// HANDLE WINAPI CreateFileMapping(
+//   _In_     HANDLE                hFile,
+//   _In_opt_ LPSECURITY_ATTRIBUTES lpAttributes,
+//   _In_     DWORD                 flProtect,
+//   _In_     DWORD                 dwMaximumSizeHigh,
+//   _In_     DWORD                 dwMaximumSizeLow,
+//   _In_opt_ LPCTSTR               lpName
+// );
+// fail == 0
+//
+// Pass syscall.InvalidHandle as file for a section backed by the paging
+// file. CreateFileMapping returns syscall.ERROR_ALREADY_EXISTS along with
+// the handle when a named section already existed, in which case size is
+// ignored.
+func CreateFileMapping(
+	file syscall.Handle,
+	sa *syscall.SecurityAttributes,
+	protect uint32,
+	size uint64,
+	name string,
+) (syscall.Handle, error) {
+	ret, _, err := k32CreateFileMapping.Call(
+		uintptr(file),
+		uintptr(unsafe.Pointer(sa)),
+		uintptr(protect),
+		uintptr(size>>32),
+		uintptr(uint32(size)),
+		uintptr(unsafe.Pointer(optionalString(name))),
+	)
+
+	if ret == 0 {
+		return 0, err
+	}
+
+	if err == syscall.ERROR_ALREADY_EXISTS {
+		return syscall.Handle(ret), err
+	}
+
+	return syscall.Handle(ret), nil
+}
+
+// HANDLE WINAPI OpenFileMapping(
+//   _In_ DWORD   dwDesiredAccess,
+//   _In_ BOOL    bInheritHandle,
+//   _In_ LPCTSTR lpName
+// );
+// fail == 0
+func OpenFileMapping(access uint32, inheritHandle bool, name string) (syscall.Handle, error) {
+	return openObject(k32OpenFileMapping, access, inheritHandle, name)
+}
+
+// LPVOID WINAPI MapViewOfFile(
+//   _In_ HANDLE hFileMappingObject,
+//   _In_ DWORD  dwDesiredAccess,
+//   _In_ DWORD  dwFileOffsetHigh,
+//   _In_ DWORD  dwFileOffsetLow,
+//   _In_ SIZE_T dwNumberOfBytesToMap
+// );
+// fail == NULL
+//
+// offset must be a multiple of the allocation granularity, 64KB. A size of
+// zero maps the rest of the section.
+func MapViewOfFile(mapping syscall.Handle, access uint32, offset uint64, size uintptr) (uintptr, error) {
+	ret, _, err := k32MapViewOfFile.Call(
+		uintptr(mapping),
+		uintptr(access),
+		uintptr(offset>>32),
+		uintptr(uint32(offset)),
+		size,
+	)
+
+	if ret == 0 {
+		return 0, err
+	}
+
+	return ret, nil
+}
+
+// BOOL WINAPI UnmapViewOfFile(
+//   _In_ LPCVOID lpBaseAddress
+// );
+// fail == 0
+func UnmapViewOfFile(addr uintptr) error {
+	ret, _, err := k32UnmapViewOfFile.Call(addr)
+	if ret == 0 {
+		return err
+	}
+
+	return nil
+}
+
+// MapView maps a view of mapping and returns it as a byte slice, which
+// must be released with UnmapView and not used after. A size of zero maps
+// the rest of the section, rounded up to whole pages.
+func MapView(mapping syscall.Handle, access uint32, offset uint64, size int) ([]byte, error) {
+	if size < 0 || size > maxView {
+		return nil, fmt.Errorf("cannot map a view of %d bytes", size)
+	}
+
+	addr, err := MapViewOfFile(mapping, access, offset, uintptr(size))
+	if err != nil {
+		return nil, err
+	}
+
+	if size == 0 {
+		proc, _ := syscall.GetCurrentProcess()
+
+		info, err := VirtualQueryEx(proc, addr)
+		if err != nil {
+			UnmapViewOfFile(addr)
+			return nil, err
+		}
+
+		if info.RegionSize > maxView {
+			UnmapViewOfFile(addr)
+			return nil, fmt.Errorf("cannot map a view of %d bytes", info.RegionSize)
+		}
+
+		size = int(info.RegionSize)
+	}
+
+	// the view is outside the Go heap, so the pointer is converted
+	// through memory rather than from the uintptr directly
+	base := *(*unsafe.Pointer)(unsafe.Pointer(&addr))
+
+	return (*[maxView]byte)(base)[:size:size], nil
+}
+
+// UnmapView unmaps a view returned by MapView.
+func UnmapView(view []byte) error {
+	if len(view) == 0 {
+		return nil
+	}
+
+	return UnmapViewOfFile(uintptr(unsafe.Pointer(&view[0])))
+}
+
+// SharedMemory is a named section backed by the paging file, mapped into
+// the process.
+type SharedMemory struct {
+	handle  syscall.Handle
+	existed bool
+	view    []byte
+}
+
+// NewSharedMemory creates or opens the section name and maps size bytes of
+// it for reading and writing. An empty name creates an unnamed section,
+// shared only through inherited or duplicated handles. attrs.Access is not
+// used, since a creator always has full access.
+func NewSharedMemory(name string, size int, attrs *ObjectAttributes) (*SharedMemory, error) {
+	if attrs == nil {
+		attrs = &ObjectAttributes{}
+	}
+
+	if size <= 0 {
+		return nil, fmt.Errorf("bad shared memory size %d", size)
+	}
+
+	handle, err := CreateFileMapping(
+		syscall.InvalidHandle,
+		attrs.Security,
+		PAGE_READWRITE|SEC_COMMIT,
+		uint64(size),
+		name,
+	)
+
+	existed := err == syscall.ERROR_ALREADY_EXISTS
+	if err != nil && !existed {
+		return nil, err
+	}
+
+	m, err := mapShared(handle, FILE_MAP_READ|FILE_MAP_WRITE, size)
+	if err != nil {
+		return nil, err
+	}
+
+	m.existed = existed
+
+	return m, nil
+}
+
+// OpenSharedMemory opens the existing section name and maps size bytes of
+// it, or all of it if size is zero. access is FILE_MAP_READ, FILE_MAP_WRITE
+// or both.
+func OpenSharedMemory(name string, access uint32, size int) (*SharedMemory, error) {
+	handle, err := OpenFileMapping(access, false, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return mapShared(handle, access, size)
+}
+
+func mapShared(handle syscall.Handle, access uint32, size int) (*SharedMemory, error) {
+	view, err := MapView(handle, access, 0, size)
+	if err != nil {
+		syscall.CloseHandle(handle)
+		return nil, err
+	}
+
+	return &SharedMemory{handle: handle, view: view}, nil
+}
+
+// Handle returns the section handle.
+func (m *SharedMemory) Handle() syscall.Handle {
+	return m.handle
+}
+
+// Existed reports whether a named section already existed when it was
+// created, with the size its creator gave it. NewSharedMemory fails if
+// that is smaller than the size asked for.
+func (m *SharedMemory) Existed() bool {
+	return m.existed
+}
+
+// Bytes returns the mapped view. It must not be used after Close.
+func (m *SharedMemory) Bytes() []byte {
+	return m.view
+}
+
+// Close unmaps the view and closes the section handle. The section is
+// destroyed once every process has closed it.
+func (m *SharedMemory) Close() error {
+	if m.handle == 0 {
+		return nil
+	}
+
+	err := UnmapView(m.view)
+	m.view = nil
+
+	if closeErr := syscall.CloseHandle(m.handle); err == nil {
+		err = closeErr
+	}
+
+	m.handle = 0
+
+	return err
+}
@@ -0,0 +1,146 @@
+package kernel32
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// MEMORY_BASIC_INFORMATION State
+const (
+	MEM_COMMIT  = 0x00001000
+	MEM_RESERVE = 0x00002000
+	MEM_FREE    = 0x00010000
+)
+
+// MEMORY_BASIC_INFORMATION Type
+const (
+	MEM_PRIVATE = 0x00020000
+	MEM_MAPPED  = 0x00040000
+	MEM_IMAGE   = 0x01000000
+)
+
+// memory protection constants
+const (
+	PAGE_NOACCESS          = 0x001
+	PAGE_READONLY          = 0x002
+	PAGE_READWRITE         = 0x004
+	PAGE_WRITECOPY         = 0x008
+	PAGE_EXECUTE           = 0x010
+	PAGE_EXECUTE_READ      = 0x020
+	PAGE_EXECUTE_READWRITE = 0x040
+	PAGE_EXECUTE_WRITECOPY = 0x080
+	PAGE_GUARD             = 0x100
+	PAGE_NOCACHE           = 0x200
+	PAGE_WRITECOMBINE      = 0x400
+
+	ERROR_INVALID_PARAMETER = syscall.Errno(87)
+	ERROR_PARTIAL_COPY      = syscall.Errno(299)
+)
+
+// MEMORY_BASIC_INFORMATION64 is the 64-bit layout of
+// MEMORY_BASIC_INFORMATION, which VirtualQueryEx returns. On 32-bit builds
+// the call fills the 32-bit layout, which is widened into this one.
+type MEMORY_BASIC_INFORMATION64 struct {
+	BaseAddress       uint64
+	AllocationBase    uint64
+	AllocationProtect uint32
+	PartitionId       uint16
+	_                 uint16
+	RegionSize        uint64
+	State             uint32
+	Protect           uint32
+	Type              uint32
+	_                 uint32
+}
+
+// MEMORY_BASIC_INFORMATION as declared for 32-bit callers.
+type memoryBasicInformation32 struct {
+	BaseAddress       uint32
+	AllocationBase    uint32
+	AllocationProtect uint32
+	RegionSize        uint32
+	State             uint32
+	Protect           uint32
+	Type              uint32
+}
+
+var (
+	k32QueryDosDevice = kernel32Dll.NewProc("QueryDosDeviceW")
+	k32VirtualQueryEx = kernel32Dll.NewProc("VirtualQueryEx")
+)
+
+// SIZE_T WINAPI VirtualQueryEx(
+//   _In_     HANDLE                    hProcess,
+//   _In_opt_ LPCVOID                   lpAddress,
+//   _Out_    PMEMORY_BASIC_INFORMATION lpBuffer,
+//   _In_     SIZE_T                    dwLength
+// );
+// fail == 0
+//
+// VirtualQueryEx describes the region containing addr. Past the highest
+// user-mode address it fails with ERROR_INVALID_PARAMETER. proc needs
+// PROCESS_QUERY_INFORMATION.
+func VirtualQueryEx(proc syscall.Handle, addr uintptr) (MEMORY_BASIC_INFORMATION64, error) {
+	if unsafe.Sizeof(uintptr(0)) == 8 {
+		var info MEMORY_BASIC_INFORMATION64
+
+		ret, _, err := k32VirtualQueryEx.Call(
+			uintptr(proc),
+			addr,
+			uintptr(unsafe.Pointer(&info)),
+			unsafe.Sizeof(info),
+		)
+		if ret == 0 {
+			return info, err
+		}
+
+		return info, nil
+	}
+
+	var info32 memoryBasicInformation32
+
+	ret, _, err := k32VirtualQueryEx.Call(
+		uintptr(proc),
+		addr,
+		uintptr(unsafe.Pointer(&info32)),
+		unsafe.Sizeof(info32),
+	)
+	if ret == 0 {
+		return MEMORY_BASIC_INFORMATION64{}, err
+	}
+
+	return MEMORY_BASIC_INFORMATION64{
+		BaseAddress:       uint64(info32.BaseAddress),
+		AllocationBase:    uint64(info32.AllocationBase),
+		AllocationProtect: info32.AllocationProtect,
+		RegionSize:        uint64(info32.RegionSize),
+		State:             info32.State,
+		Protect:           info32.Protect,
+		Type:              info32.Type,
+	}, nil
+}
+
+// DWORD WINAPI QueryDosDevice(
+//   _In_opt_ LPCTSTR lpDeviceName,
+//   _Out_    LPTSTR  lpTargetPath,
+//   _In_     DWORD   ucchMax
+// );
+// fail == 0
+//
+// QueryDosDevice returns the first target of an MS-DOS device name such as
+// "C:", for example \Device\HarddiskVolume3.
+func QueryDosDevice(name string) (string, error) {
+	buffer := make([]uint16, MAX_PATH)
+
+	ret, _, err := k32QueryDosDevice.Call(
+		uintptr(unsafe.Pointer(syscall.StringToUTF16Ptr(name))),
+		uintptr(unsafe.Pointer(&buffer[0])),
+		uintptr(len(buffer)),
+	)
+
+	if ret == 0 {
+		return "", err
+	}
+
+	return syscall.UTF16ToString(buffer), nil
+}
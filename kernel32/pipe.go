@@ -0,0 +1,296 @@
+package kernel32
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+const (
+	PIPE_ACCESS_INBOUND  = 0x00000001
+	PIPE_ACCESS_OUTBOUND = 0x00000002
+	PIPE_ACCESS_DUPLEX   = 0x00000003
+
+	PIPE_TYPE_BYTE             = 0x00000000
+	PIPE_TYPE_MESSAGE          = 0x00000004
+	PIPE_READMODE_BYTE         = 0x00000000
+	PIPE_READMODE_MESSAGE      = 0x00000002
+	PIPE_WAIT                  = 0x00000000
+	PIPE_NOWAIT                = 0x00000001
+	PIPE_ACCEPT_REMOTE_CLIENTS = 0x00000000
+	PIPE_REJECT_REMOTE_CLIENTS = 0x00000008
+
+	PIPE_UNLIMITED_INSTANCES = 255
+
+	PIPE_CLIENT_END = 0x00000000
+	PIPE_SERVER_END = 0x00000001
+
+	FILE_FLAG_FIRST_PIPE_INSTANCE = 0x00080000
+	FILE_FLAG_OVERLAPPED          = 0x40000000
+
+	// CreateFile flags that limit how far a pipe server may impersonate
+	// the client.
+	SECURITY_ANONYMOUS      = 0x00000000
+	SECURITY_IDENTIFICATION = 0x00010000
+	SECURITY_IMPERSONATION  = 0x00020000
+	SECURITY_DELEGATION     = 0x00030000
+	SECURITY_SQOS_PRESENT   = 0x00100000
+
+	NMPWAIT_USE_DEFAULT_WAIT = 0x00000000
+	NMPWAIT_WAIT_FOREVER     = 0xFFFFFFFF
+
+	ERROR_PIPE_BUSY          = syscall.Errno(231)
+	ERROR_NO_DATA            = syscall.Errno(232)
+	ERROR_PIPE_NOT_CONNECTED = syscall.Errno(233)
+	ERROR_PIPE_CONNECTED     = syscall.Errno(535)
+	ERROR_PIPE_LISTENING     = syscall.Errno(536)
+
+	EXCEPTION_EXECUTE_HANDLER    = 1
+	EXCEPTION_CONTINUE_SEARCH    = 0
+	EXCEPTION_CONTINUE_EXECUTION = 0xFFFFFFFF
+)
+
+var (
+	k32ConnectNamedPipe            = kernel32Dll.NewProc("ConnectNamedPipe")
+	k32CreateNamedPipe             = kernel32Dll.NewProc("CreateNamedPipeW")
+	k32DisconnectNamedPipe         = kernel32Dll.NewProc("DisconnectNamedPipe")
+	k32GetCurrentThreadId          = kernel32Dll.NewProc("GetCurrentThreadId")
+	k32GetNamedPipeClientProcessId = kernel32Dll.NewProc("GetNamedPipeClientProcessId")
+	k32GetNamedPipeInfo            = kernel32Dll.NewProc("GetNamedPipeInfo")
+	k32GetOverlappedResult         = kernel32Dll.NewProc("GetOverlappedResult")
+	k32SetNamedPipeHandleState     = kernel32Dll.NewProc("SetNamedPipeHandleState")
+	k32SetUnhandledExceptionFilter = kernel32Dll.NewProc("SetUnhandledExceptionFilter")
+	k32WaitNamedPipe               = kernel32Dll.NewProc("WaitNamedPipeW")
+)
+
+// BOOL WINAPI ConnectNamedPipe(
+//   _In_        HANDLE       hNamedPipe,
+//   _Inout_opt_ LPOVERLAPPED lpOverlapped
+// );
+// fail == 0
+//
+// A client that connected between CreateNamedPipe and ConnectNamedPipe is
+// reported as success rather than ERROR_PIPE_CONNECTED.
+func ConnectNamedPipe(pipe syscall.Handle) error {
+	ret, _, err := k32ConnectNamedPipe.Call(uintptr(pipe), 0)
+	if ret == 0 && err != ERROR_PIPE_CONNECTED {
+		return err
+	}
+
+	return nil
+}
+
+// ConnectNamedPipeOverlapped starts waiting for a client on a pipe opened
+// with FILE_FLAG_OVERLAPPED. It returns syscall.ERROR_IO_PENDING while the
+// wait is in progress; complete it with GetOverlappedResult. A client that
+// is already connected is reported as success.
+func ConnectNamedPipeOverlapped(pipe syscall.Handle, overlapped *syscall.Overlapped) error {
+	ret, _, err := k32ConnectNamedPipe.Call(
+		uintptr(pipe),
+		uintptr(unsafe.Pointer(overlapped)),
+	)
+
+	if ret == 0 && err != ERROR_PIPE_CONNECTED {
+		return err
+	}
+
+	return nil
+}
+
+// HANDLE WINAPI CreateNamedPipe(
+//   _In_     LPCTSTR               lpName,
+//   _In_     DWORD                 dwOpenMode,
+//   _In_     DWORD                 dwPipeMode,
+//   _In_     DWORD                 nMaxInstances,
+//   _In_     DWORD                 nOutBufferSize,
+//   _In_     DWORD                 nInBufferSize,
+//   _In_     DWORD                 nDefaultTimeOut,
+//   _In_opt_ LPSECURITY_ATTRIBUTES lpSecurityAttributes
+// );
+// fail == INVALID_HANDLE_VALUE
+func CreateNamedPipe(
+	name string,
+	openMode, pipeMode, maxInstances, outBufferSize, inBufferSize, defaultTimeout uint32,
+) (syscall.Handle, error) {
+	return CreateNamedPipeWithSecurity(
+		name,
+		openMode,
+		pipeMode,
+		maxInstances,
+		outBufferSize,
+		inBufferSize,
+		defaultTimeout,
+		nil,
+	)
+}
+
+// CreateNamedPipeWithSecurity is CreateNamedPipe with lpSecurityAttributes.
+// A nil sa gives the pipe the default security descriptor, which grants
+// Everyone only read access.
+func CreateNamedPipeWithSecurity(
+	name string,
+	openMode, pipeMode, maxInstances, outBufferSize, inBufferSize, defaultTimeout uint32,
+	sa *syscall.SecurityAttributes,
+) (syscall.Handle, error) {
+	ret, _, err := k32CreateNamedPipe.Call(
+		uintptr(unsafe.Pointer(syscall.StringToUTF16Ptr(name))),
+		uintptr(openMode),
+		uintptr(pipeMode),
+		uintptr(maxInstances),
+		uintptr(outBufferSize),
+		uintptr(inBufferSize),
+		uintptr(defaultTimeout),
+		uintptr(unsafe.Pointer(sa)),
+	)
+
+	if syscall.Handle(ret) == syscall.InvalidHandle {
+		return syscall.InvalidHandle, err
+	}
+
+	return syscall.Handle(ret), nil
+}
+
+// BOOL WINAPI DisconnectNamedPipe(
+//   _In_ HANDLE hNamedPipe
+// );
+// fail == 0
+func DisconnectNamedPipe(pipe syscall.Handle) error {
+	ret, _, err := k32DisconnectNamedPipe.Call(uintptr(pipe))
+	if ret == 0 {
+		return err
+	}
+
+	return nil
+}
+
+// DWORD WINAPI GetCurrentThreadId(void);
+func GetCurrentThreadId() uint32 {
+	ret, _, _ := k32GetCurrentThreadId.Call()
+	return uint32(ret)
+}
+
+// BOOL WINAPI GetNamedPipeClientProcessId(
+//   _In_  HANDLE Pipe,
+//   _Out_ PULONG ClientProcessId
+// );
+// fail == 0
+func GetNamedPipeClientProcessId(pipe syscall.Handle) (uint32, error) {
+	var pid uint32
+
+	ret, _, err := k32GetNamedPipeClientProcessId.Call(
+		uintptr(pipe),
+		uintptr(unsafe.Pointer(&pid)),
+	)
+
+	if ret == 0 {
+		return 0, err
+	}
+
+	return pid, nil
+}
+
+// BOOL WINAPI GetNamedPipeInfo(
+//   _In_      HANDLE  hNamedPipe,
+//   _Out_opt_ LPDWORD lpFlags,
+//   _Out_opt_ LPDWORD lpOutBufferSize,
+//   _Out_opt_ LPDWORD lpInBufferSize,
+//   _Out_opt_ LPDWORD lpMaxInstances
+// );
+// fail == 0
+func GetNamedPipeInfo(pipe syscall.Handle) (flags, outBufferSize, inBufferSize, maxInstances uint32, err error) {
+	ret, _, callErr := k32GetNamedPipeInfo.Call(
+		uintptr(pipe),
+		uintptr(unsafe.Pointer(&flags)),
+		uintptr(unsafe.Pointer(&outBufferSize)),
+		uintptr(unsafe.Pointer(&inBufferSize)),
+		uintptr(unsafe.Pointer(&maxInstances)),
+	)
+
+	if ret == 0 {
+		return 0, 0, 0, 0, callErr
+	}
+
+	return flags, outBufferSize, inBufferSize, maxInstances, nil
+}
+
+// BOOL WINAPI GetOverlappedResult(
+//   _In_  HANDLE       hFile,
+//   _In_  LPOVERLAPPED lpOverlapped,
+//   _Out_ LPDWORD      lpNumberOfBytesTransferred,
+//   _In_  BOOL         bWait
+// );
+// fail == 0
+//
+// The byte count is returned along with errors such as
+// syscall.ERROR_MORE_DATA, which complete a message mode read partially.
+func GetOverlappedResult(handle syscall.Handle, overlapped *syscall.Overlapped, wait bool) (uint32, error) {
+	var transferred uint32
+
+	block := 0
+	if wait {
+		block = 1
+	}
+
+	ret, _, err := k32GetOverlappedResult.Call(
+		uintptr(handle),
+		uintptr(unsafe.Pointer(overlapped)),
+		uintptr(unsafe.Pointer(&transferred)),
+		uintptr(block),
+	)
+
+	if ret == 0 {
+		return transferred, err
+	}
+
+	return transferred, nil
+}
+
+// BOOL WINAPI SetNamedPipeHandleState(
+//   _In_     HANDLE  hNamedPipe,
+//   _In_opt_ LPDWORD lpMode,
+//   _In_opt_ LPDWORD lpMaxCollectionCount,
+//   _In_opt_ LPDWORD lpCollectDataTimeout
+// );
+// fail == 0
+func SetNamedPipeHandleState(pipe syscall.Handle, mode uint32) error {
+	ret, _, err := k32SetNamedPipeHandleState.Call(
+		uintptr(pipe),
+		uintptr(unsafe.Pointer(&mode)),
+		0,
+		0,
+	)
+
+	if ret == 0 {
+		return err
+	}
+
+	return nil
+}
+
+// LPTOP_LEVEL_EXCEPTION_FILTER WINAPI SetUnhandledExceptionFilter(
+//   _In_ LPTOP_LEVEL_EXCEPTION_FILTER lpTopLevelExceptionFilter
+// );
+//
+// filter is a callback created with syscall.NewCallback taking a
+// *EXCEPTION_POINTERS. The previous filter is returned.
+func SetUnhandledExceptionFilter(filter uintptr) uintptr {
+	ret, _, _ := k32SetUnhandledExceptionFilter.Call(filter)
+	return ret
+}
+
+// BOOL WINAPI WaitNamedPipe(
+//   _In_ LPCTSTR lpNamedPipeName,
+//   _In_ DWORD   nTimeOut
+// );
+// fail == 0
+func WaitNamedPipe(name string, timeout uint32) error {
+	ret, _, err := k32WaitNamedPipe.Call(
+		uintptr(unsafe.Pointer(syscall.StringToUTF16Ptr(name))),
+		uintptr(timeout),
+	)
+
+	if ret == 0 {
+		return err
+	}
+
+	return nil
+}
@@ -0,0 +1,83 @@
+package kernel32
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+// PSS_CAPTURE_FLAGS
+const (
+	PSS_CAPTURE_NONE                             = 0x00000000
+	PSS_CAPTURE_VA_CLONE                         = 0x00000001
+	PSS_CAPTURE_HANDLES                          = 0x00000004
+	PSS_CAPTURE_HANDLE_NAME_INFORMATION          = 0x00000008
+	PSS_CAPTURE_HANDLE_BASIC_INFORMATION         = 0x00000010
+	PSS_CAPTURE_HANDLE_TYPE_SPECIFIC_INFORMATION = 0x00000020
+	PSS_CAPTURE_HANDLE_TRACE                     = 0x00000040
+	PSS_CAPTURE_THREADS                          = 0x00000080
+	PSS_CAPTURE_THREAD_CONTEXT                   = 0x00000100
+	PSS_CAPTURE_THREAD_CONTEXT_EXTENDED          = 0x00000200
+	PSS_CAPTURE_VA_SPACE                         = 0x00000800
+	PSS_CAPTURE_VA_SPACE_SECTION_INFORMATION     = 0x00001000
+	PSS_CAPTURE_IPT_TRACE                        = 0x00002000
+	PSS_CREATE_BREAKAWAY_OPTIONAL                = 0x04000000
+	PSS_CREATE_BREAKAWAY                         = 0x08000000
+	PSS_CREATE_FORCE_BREAKAWAY                   = 0x10000000
+	PSS_CREATE_USE_VM_ALLOCATIONS                = 0x20000000
+	PSS_CREATE_MEASURE_PERFORMANCE               = 0x40000000
+	PSS_CREATE_RELEASE_SECTION                   = 0x80000000
+)
+
+var (
+	k32PssCaptureSnapshot = kernel32Dll.NewProc("PssCaptureSnapshot")
+	k32PssFreeSnapshot    = kernel32Dll.NewProc("PssFreeSnapshot")
+)
+
+// DWORD PssCaptureSnapshot(
+//   _In_     HANDLE            ProcessHandle,
+//   _In_     PSS_CAPTURE_FLAGS CaptureFlags,
+//   _In_opt_ DWORD             ThreadContextFlags,
+//   _Out_    HPSS              *SnapshotHandle
+// );
+// fail != ERROR_SUCCESS
+//
+// PssCaptureSnapshot requires Windows 8.1. With PSS_CAPTURE_VA_CLONE the
+// process is only paused while its address space is cloned, and proc needs
+// PROCESS_CREATE_PROCESS access.
+func PssCaptureSnapshot(proc syscall.Handle, flags, threadContextFlags uint32) (syscall.Handle, error) {
+	if err := k32PssCaptureSnapshot.Find(); err != nil {
+		return 0, err
+	}
+
+	var snapshot syscall.Handle
+
+	ret, _, _ := k32PssCaptureSnapshot.Call(
+		uintptr(proc),
+		uintptr(flags),
+		uintptr(threadContextFlags),
+		uintptr(unsafe.Pointer(&snapshot)),
+	)
+
+	if ret != 0 {
+		return 0, syscall.Errno(ret)
+	}
+
+	return snapshot, nil
+}
+
+// DWORD PssFreeSnapshot(
+//   _In_ HANDLE ProcessHandle,
+//   _In_ HPSS   SnapshotHandle
+// );
+// fail != ERROR_SUCCESS
+//
+// proc is the process holding the snapshot handle, which is normally the
+// current process rather than the one captured.
+func PssFreeSnapshot(proc, snapshot syscall.Handle) error {
+	ret, _, _ := k32PssFreeSnapshot.Call(uintptr(proc), uintptr(snapshot))
+	if ret != 0 {
+		return syscall.Errno(ret)
+	}
+
+	return nil
+}
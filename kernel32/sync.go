@@ -0,0 +1,828 @@
+package kernel32
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const (
+	// CreateEventEx flags
+	CREATE_EVENT_MANUAL_RESET = 0x00000001
+	CREATE_EVENT_INITIAL_SET  = 0x00000002
+
+	// CreateMutexEx flags
+	CREATE_MUTEX_INITIAL_OWNER = 0x00000001
+
+	// CreateWaitableTimerEx flags
+	CREATE_WAITABLE_TIMER_MANUAL_RESET    = 0x00000001
+	CREATE_WAITABLE_TIMER_HIGH_RESOLUTION = 0x00000002
+
+	MUTEX_MODIFY_STATE = 0x0001
+	MUTEX_ALL_ACCESS   = (STANDARD_RIGHTS_REQUIRED | SYNCHRONIZE | 0x1)
+
+	SEMAPHORE_MODIFY_STATE = 0x0002
+	SEMAPHORE_ALL_ACCESS   = (STANDARD_RIGHTS_REQUIRED | SYNCHRONIZE | 0x3)
+
+	TIMER_QUERY_STATE  = 0x0001
+	TIMER_MODIFY_STATE = 0x0002
+	TIMER_ALL_ACCESS   = (STANDARD_RIGHTS_REQUIRED | SYNCHRONIZE | 0x3)
+
+	// WaitForMultipleObjects results
+	WAIT_OBJECT_0    = 0x00000000
+	WAIT_ABANDONED_0 = 0x00000080
+	WAIT_TIMEOUT     = 0x00000102
+	WAIT_FAILED      = 0xFFFFFFFF
+
+	MAXIMUM_WAIT_OBJECTS = 64
+
+	// waitAllSlice is how long WaitAll blocks between checks of its
+	// context.
+	waitAllSlice = 50 * time.Millisecond
+)
+
+// ErrAbandoned is returned by waits satisfied by a mutex whose owning
+// thread exited without releasing it. The wait still acquires the mutex,
+// but the state it guards may be inconsistent.
+var ErrAbandoned = errors.New("kernel32: wait satisfied by an abandoned mutex")
+
+var (
+	k32CancelWaitableTimer    = kernel32Dll.NewProc("CancelWaitableTimer")
+	k32CreateMutexEx          = kernel32Dll.NewProc("CreateMutexExW")
+	k32CreateSemaphoreEx      = kernel32Dll.NewProc("CreateSemaphoreExW")
+	k32CreateWaitableTimerEx  = kernel32Dll.NewProc("CreateWaitableTimerExW")
+	k32OpenMutex              = kernel32Dll.NewProc("OpenMutexW")
+	k32OpenSemaphore          = kernel32Dll.NewProc("OpenSemaphoreW")
+	k32OpenWaitableTimer      = kernel32Dll.NewProc("OpenWaitableTimerW")
+	k32PulseEvent             = kernel32Dll.NewProc("PulseEvent")
+	k32ReleaseMutex           = kernel32Dll.NewProc("ReleaseMutex")
+	k32ReleaseSemaphore       = kernel32Dll.NewProc("ReleaseSemaphore")
+	k32ResetEvent             = kernel32Dll.NewProc("ResetEvent")
+	k32SetWaitableTimer       = kernel32Dll.NewProc("SetWaitableTimer")
+	k32WaitForMultipleObjects = kernel32Dll.NewProc("WaitForMultipleObjects")
+	k32WaitForSingleObject    = kernel32Dll.NewProc("WaitForSingleObject")
+)
+
+// HANDLE WINAPI CreateEventEx(
+//   _In_opt_ LPSECURITY_ATTRIBUTES lpEventAttributes,
+//   _In_opt_ LPCTSTR               lpName,
+//   _In_     DWORD                 dwFlags,
+//   _In_     DWORD                 dwDesiredAccess
+// );
+// fail == 0
+//
+// CreateEventEx returns syscall.ERROR_ALREADY_EXISTS along with the handle
+// when a named event already existed.
+func CreateEventEx(sa *syscall.SecurityAttributes, name string, flags, access uint32) (syscall.Handle, error) {
+	return createObject(k32CreateEvent, sa, name, flags, access)
+}
+
+// HANDLE WINAPI CreateMutexEx(
+//   _In_opt_ LPSECURITY_ATTRIBUTES lpMutexAttributes,
+//   _In_opt_ LPCTSTR               lpName,
+//   _In_     DWORD                 dwFlags,
+//   _In_     DWORD                 dwDesiredAccess
+// );
+// fail == 0
+//
+// CreateMutexEx returns syscall.ERROR_ALREADY_EXISTS along with the handle
+// when a named mutex already existed.
+func CreateMutexEx(sa *syscall.SecurityAttributes, name string, flags, access uint32) (syscall.Handle, error) {
+	return createObject(k32CreateMutexEx, sa, name, flags, access)
+}
+
+// HANDLE WINAPI CreateWaitableTimerEx(
+//   _In_opt_ LPSECURITY_ATTRIBUTES lpTimerAttributes,
+//   _In_opt_ LPCTSTR               lpTimerName,
+//   _In_     DWORD                 dwFlags,
+//   _In_     DWORD                 dwDesiredAccess
+// );
+// fail == 0
+//
+// CreateWaitableTimerEx returns syscall.ERROR_ALREADY_EXISTS along with the
+// handle when a named timer already existed.
+func CreateWaitableTimerEx(sa *syscall.SecurityAttributes, name string, flags, access uint32) (syscall.Handle, error) {
+	return createObject(k32CreateWaitableTimerEx, sa, name, flags, access)
+}
+
+func createObject(proc *syscall.LazyProc, sa *syscall.SecurityAttributes, name string, flags, access uint32) (syscall.Handle, error) {
+	ret, _, err := proc.Call(
+		uintptr(unsafe.Pointer(sa)),
+		uintptr(unsafe.Pointer(optionalString(name))),
+		uintptr(flags),
+		uintptr(access),
+	)
+
+	if ret == 0 {
+		return 0, err
+	}
+
+	if err == syscall.ERROR_ALREADY_EXISTS {
+		return syscall.Handle(ret), err
+	}
+
+	return syscall.Handle(ret), nil
+}
+
+// HANDLE WINAPI CreateSemaphoreEx(
+//   _In_opt_   LPSECURITY_ATTRIBUTES lpSemaphoreAttributes,
+//   _In_       LONG                  lInitialCount,
+//   _In_       LONG                  lMaximumCount,
+//   _In_opt_   LPCTSTR               lpName,
+//   _Reserved_ DWORD                 dwFlags,
+//   _In_       DWORD                 dwDesiredAccess
+// );
+// fail == 0
+//
+// CreateSemaphoreEx returns syscall.ERROR_ALREADY_EXISTS along with the
+// handle when a named semaphore already existed.
+func CreateSemaphoreEx(sa *syscall.SecurityAttributes, initial, maximum int32, name string, access uint32) (syscall.Handle, error) {
+	ret, _, err := k32CreateSemaphoreEx.Call(
+		uintptr(unsafe.Pointer(sa)),
+		uintptr(initial),
+		uintptr(maximum),
+		uintptr(unsafe.Pointer(optionalString(name))),
+		0,
+		uintptr(access),
+	)
+
+	if ret == 0 {
+		return 0, err
+	}
+
+	if err == syscall.ERROR_ALREADY_EXISTS {
+		return syscall.Handle(ret), err
+	}
+
+	return syscall.Handle(ret), nil
+}
+
+// HANDLE WINAPI OpenMutex(
+//   _In_ DWORD   dwDesiredAccess,
+//   _In_ BOOL    bInheritHandle,
+//   _In_ LPCTSTR lpName
+// );
+// fail == 0
+func OpenMutex(access uint32, inheritHandle bool, name string) (syscall.Handle, error) {
+	return openObject(k32OpenMutex, access, inheritHandle, name)
+}
+
+// HANDLE WINAPI OpenSemaphore(
+//   _In_ DWORD   dwDesiredAccess,
+//   _In_ BOOL    bInheritHandle,
+//   _In_ LPCTSTR lpName
+// );
+// fail == 0
+func OpenSemaphore(access uint32, inheritHandle bool, name string) (syscall.Handle, error) {
+	return openObject(k32OpenSemaphore, access, inheritHandle, name)
+}
+
+// HANDLE WINAPI OpenWaitableTimer(
+//   _In_ DWORD   dwDesiredAccess,
+//   _In_ BOOL    bInheritHandle,
+//   _In_ LPCTSTR lpTimerName
+// );
+// fail == 0
+func OpenWaitableTimer(access uint32, inheritHandle bool, name string) (syscall.Handle, error) {
+	return openObject(k32OpenWaitableTimer, access, inheritHandle, name)
+}
+
+func openObject(proc *syscall.LazyProc, access uint32, inheritHandle bool, name string) (syscall.Handle, error) {
+	inherit := 0
+	if inheritHandle {
+		inherit = 1
+	}
+
+	ret, _, err := proc.Call(
+		uintptr(access),
+		uintptr(inherit),
+		uintptr(unsafe.Pointer(syscall.StringToUTF16Ptr(name))),
+	)
+
+	if ret == 0 {
+		return 0, err
+	}
+
+	return syscall.Handle(ret), nil
+}
+
+func optionalString(s string) *uint16 {
+	if s == "" {
+		return nil
+	}
+
+	return syscall.StringToUTF16Ptr(s)
+}
+
+// BOOL WINAPI ResetEvent(
+//   _In_ HANDLE hEvent
+// );
+// fail == 0
+func ResetEvent(event syscall.Handle) error {
+	ret, _, err := k32ResetEvent.Call(uintptr(event))
+	if ret == 0 {
+		return err
+	}
+
+	return nil
+}
+
+// BOOL WINAPI PulseEvent(
+//   _In_ HANDLE hEvent
+// );
+// fail == 0
+//
+// PulseEvent is unreliable, since a waiter briefly removed from the wait by
+// a kernel APC misses the pulse. It exists for legacy peers.
+func PulseEvent(event syscall.Handle) error {
+	ret, _, err := k32PulseEvent.Call(uintptr(event))
+	if ret == 0 {
+		return err
+	}
+
+	return nil
+}
+
+// BOOL WINAPI ReleaseMutex(
+//   _In_ HANDLE hMutex
+// );
+// fail == 0
+func ReleaseMutex(mutex syscall.Handle) error {
+	ret, _, err := k32ReleaseMutex.Call(uintptr(mutex))
+	if ret == 0 {
+		return err
+	}
+
+	return nil
+}
+
+// BOOL WINAPI ReleaseSemaphore(
+//   _In_      HANDLE hSemaphore,
+//   _In_      LONG   lReleaseCount,
+//   _Out_opt_ LPLONG lpPreviousCount
+// );
+// fail == 0
+func ReleaseSemaphore(semaphore syscall.Handle, count int32) (int32, error) {
+	var previous int32
+
+	ret, _, err := k32ReleaseSemaphore.Call(
+		uintptr(semaphore),
+		uintptr(count),
+		uintptr(unsafe.Pointer(&previous)),
+	)
+
+	if ret == 0 {
+		return 0, err
+	}
+
+	return previous, nil
+}
+
+// BOOL WINAPI SetWaitableTimer(
+//   _In_     HANDLE           hTimer,
+//   _In_     const LARGE_INTEGER *pDueTime,
+//   _In_     LONG             lPeriod,
+//   _In_opt_ PTIMERAPCROUTINE pfnCompletionRoutine,
+//   _In_opt_ LPVOID           lpArgToCompletionRoutine,
+//   _In_     BOOL             fResume
+// );
+// fail == 0
+//
+// A positive dueTime is an absolute FILETIME, a negative one is relative,
+// both in 100ns units. period is in milliseconds, zero for a one-shot timer.
+func SetWaitableTimer(timer syscall.Handle, dueTime int64, period int32) error {
+	ret, _, err := k32SetWaitableTimer.Call(
+		uintptr(timer),
+		uintptr(unsafe.Pointer(&dueTime)),
+		uintptr(period),
+		0,
+		0,
+		0,
+	)
+
+	if ret == 0 {
+		return err
+	}
+
+	return nil
+}
+
+// BOOL WINAPI CancelWaitableTimer(
+//   _In_ HANDLE hTimer
+// );
+// fail == 0
+func CancelWaitableTimer(timer syscall.Handle) error {
+	ret, _, err := k32CancelWaitableTimer.Call(uintptr(timer))
+	if ret == 0 {
+		return err
+	}
+
+	return nil
+}
+
+// DWORD WINAPI WaitForSingleObject(
+//   _In_ HANDLE hHandle,
+//   _In_ DWORD  dwMilliseconds
+// );
+// fail == WAIT_FAILED
+func WaitForSingleObject(handle syscall.Handle, timeout uint32) (uint32, error) {
+	ret, _, err := k32WaitForSingleObject.Call(uintptr(handle), uintptr(timeout))
+	if uint32(ret) == WAIT_FAILED {
+		return WAIT_FAILED, err
+	}
+
+	return uint32(ret), nil
+}
+
+// DWORD WINAPI WaitForMultipleObjects(
+//   _In_       DWORD  nCount,
+//   _In_ const HANDLE *lpHandles,
+//   _In_       BOOL   bWaitAll,
+//   _In_       DWORD  dwMilliseconds
+// );
+// fail == WAIT_FAILED
+func WaitForMultipleObjects(handles []syscall.Handle, waitAll bool, timeout uint32) (uint32, error) {
+	if len(handles) == 0 || len(handles) > MAXIMUM_WAIT_OBJECTS {
+		return WAIT_FAILED, fmt.Errorf("cannot wait for %d objects", len(handles))
+	}
+
+	all := 0
+	if waitAll {
+		all = 1
+	}
+
+	ret, _, err := k32WaitForMultipleObjects.Call(
+		uintptr(len(handles)),
+		uintptr(unsafe.Pointer(&handles[0])),
+		uintptr(all),
+		uintptr(timeout),
+	)
+
+	if uint32(ret) == WAIT_FAILED {
+		return WAIT_FAILED, err
+	}
+
+	return uint32(ret), nil
+}
+
+// Waitable is a kernel object that can be waited on.
+type Waitable interface {
+	Handle() syscall.Handle
+}
+
+// ObjectAttributes are the options shared by the synchronization object
+// constructors.
+type ObjectAttributes struct {
+	// Security is passed as lpSecurityAttributes. Services sharing
+	// Global\ objects with user sessions need a descriptor that grants
+	// those users access, such as one built with
+	// advapi32.SecurityAttributesFromSDDL.
+	Security *syscall.SecurityAttributes
+
+	// Access is the access requested for the handle. Zero requests all
+	// access.
+	Access uint32
+}
+
+func (a *ObjectAttributes) access(all uint32) uint32 {
+	if a.Access == 0 {
+		return all
+	}
+
+	return a.Access
+}
+
+// object is the handle shared by the synchronization object types.
+type object struct {
+	handle  syscall.Handle
+	existed bool
+}
+
+func newObject(handle syscall.Handle, err error) (object, error) {
+	if err == syscall.ERROR_ALREADY_EXISTS {
+		return object{handle: handle, existed: true}, nil
+	}
+
+	return object{handle: handle}, err
+}
+
+// Handle returns the object's handle.
+func (o *object) Handle() syscall.Handle {
+	return o.handle
+}
+
+// Existed reports whether a named object already existed when it was
+// created, in which case its creation options were ignored.
+func (o *object) Existed() bool {
+	return o.existed
+}
+
+// Close closes the handle. The object is destroyed with its last handle.
+func (o *object) Close() error {
+	if o.handle == 0 {
+		return nil
+	}
+
+	err := syscall.CloseHandle(o.handle)
+	o.handle = 0
+
+	return err
+}
+
+// Wait waits for the object to be signaled or ctx to be done. See WaitAny.
+func (o *object) Wait(ctx context.Context) error {
+	_, err := WaitAny(ctx, o)
+	return err
+}
+
+// WaitTimeout waits up to timeout for the object to be signaled, and
+// reports whether it was. It avoids the cancel event that Wait needs.
+func (o *object) WaitTimeout(timeout time.Duration) (bool, error) {
+	ret, err := WaitForSingleObject(o.handle, milliseconds(timeout))
+
+	switch ret {
+	case WAIT_OBJECT_0:
+		return true, nil
+	case WAIT_ABANDONED_0:
+		return true, ErrAbandoned
+	case WAIT_TIMEOUT:
+		return false, nil
+	}
+
+	return false, err
+}
+
+func milliseconds(d time.Duration) uint32 {
+	if d < 0 {
+		return 0
+	}
+
+	ms := (d + time.Millisecond - 1) / time.Millisecond
+	if ms >= syscall.INFINITE {
+		return syscall.INFINITE - 1
+	}
+
+	return uint32(ms)
+}
+
+// WaitAny waits for any of objects to be signaled, or for ctx to be done,
+// and returns the index of the signaled object. Waiting on a cancellable
+// context adds an internal cancel event to the wait, so at most
+// MAXIMUM_WAIT_OBJECTS-1 objects can be given. When the object is a mutex
+// abandoned by its owner, the index is returned with ErrAbandoned.
+func WaitAny(ctx context.Context, objects ...Waitable) (int, error) {
+	handles := make([]syscall.Handle, len(objects), len(objects)+1)
+	for i, o := range objects {
+		handles[i] = o.Handle()
+	}
+
+	if err := ctx.Err(); err != nil {
+		return -1, err
+	}
+
+	if ctx.Done() == nil {
+		ret, err := WaitForMultipleObjects(handles, false, syscall.INFINITE)
+		return waitIndex(ret, len(handles), err)
+	}
+
+	cancel, err := CreateEventEx(nil, "", CREATE_EVENT_MANUAL_RESET, EVENT_ALL_ACCESS)
+	if err != nil {
+		return -1, err
+	}
+	defer syscall.CloseHandle(cancel)
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+
+		select {
+		case <-ctx.Done():
+			SetEvent(uintptr(cancel))
+		case <-stop:
+		}
+	}()
+
+	// the cancel event goes last, so an object signaled at the same time
+	// wins and its signal is not lost
+	ret, err := WaitForMultipleObjects(append(handles, cancel), false, syscall.INFINITE)
+
+	close(stop)
+	wg.Wait()
+
+	if ret == WAIT_OBJECT_0+uint32(len(handles)) {
+		return -1, ctx.Err()
+	}
+
+	return waitIndex(ret, len(handles), err)
+}
+
+// WaitAll waits for all of objects to be signaled at once, or for ctx to
+// be done. A wait for all objects cannot include a cancel event, which
+// would have to be signaled too, so a cancellable wait is made in short
+// slices between which ctx is checked.
+func WaitAll(ctx context.Context, objects ...Waitable) error {
+	handles := make([]syscall.Handle, len(objects))
+	for i, o := range objects {
+		handles[i] = o.Handle()
+	}
+
+	timeout := uint32(syscall.INFINITE)
+	if ctx.Done() != nil {
+		timeout = milliseconds(waitAllSlice)
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		ret, err := WaitForMultipleObjects(handles, true, timeout)
+		if ret == WAIT_TIMEOUT {
+			continue
+		}
+
+		_, err = waitIndex(ret, len(handles), err)
+
+		return err
+	}
+}
+
+// waitIndex decodes a WaitForMultipleObjects result for n handles.
+func waitIndex(ret uint32, n int, err error) (int, error) {
+	switch {
+	case ret == WAIT_FAILED:
+		return -1, err
+	case ret < WAIT_OBJECT_0+uint32(n):
+		return int(ret - WAIT_OBJECT_0), nil
+	case ret >= WAIT_ABANDONED_0 && ret < WAIT_ABANDONED_0+uint32(n):
+		return int(ret - WAIT_ABANDONED_0), ErrAbandoned
+	}
+
+	return -1, fmt.Errorf("unexpected wait result 0x%x", ret)
+}
+
+// Event is an event object.
+type Event struct {
+	object
+}
+
+// EventOptions configure NewEvent. A nil *EventOptions creates an
+// auto-reset event that is initially not signaled.
+type EventOptions struct {
+	ObjectAttributes
+
+	// ManualReset keeps the event signaled until Reset, releasing every
+	// waiter, rather than releasing one waiter and resetting.
+	ManualReset bool
+
+	// Signaled creates the event signaled.
+	Signaled bool
+}
+
+// NewEvent creates or opens an event. An empty name creates an unnamed
+// event.
+func NewEvent(name string, opts *EventOptions) (*Event, error) {
+	if opts == nil {
+		opts = &EventOptions{}
+	}
+
+	var flags uint32
+	if opts.ManualReset {
+		flags |= CREATE_EVENT_MANUAL_RESET
+	}
+
+	if opts.Signaled {
+		flags |= CREATE_EVENT_INITIAL_SET
+	}
+
+	obj, err := newObject(CreateEventEx(opts.Security, name, flags, opts.access(EVENT_ALL_ACCESS)))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Event{obj}, nil
+}
+
+// OpenNamedEvent opens an existing named event. Signaling it needs
+// EVENT_MODIFY_STATE and waiting on it SYNCHRONIZE.
+func OpenNamedEvent(name string, access uint32) (*Event, error) {
+	handle, err := openObject(k32OpenEvent, access, false, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Event{object{handle: handle}}, nil
+}
+
+// Set signals the event.
+func (e *Event) Set() error {
+	return SetEvent(uintptr(e.handle))
+}
+
+// Reset clears the event.
+func (e *Event) Reset() error {
+	return ResetEvent(e.handle)
+}
+
+// Pulse signals and clears the event, releasing the threads waiting at that
+// moment. See PulseEvent.
+func (e *Event) Pulse() error {
+	return PulseEvent(e.handle)
+}
+
+// Mutex is a mutex object.
+//
+// A mutex is owned by the OS thread that acquired it, not the goroutine,
+// so bracket Wait and Release with runtime.LockOSThread and
+// runtime.UnlockOSThread.
+type Mutex struct {
+	object
+}
+
+// MutexOptions configure NewMutex. A nil *MutexOptions creates an unowned
+// mutex.
+type MutexOptions struct {
+	ObjectAttributes
+
+	// Owned makes the calling thread the initial owner.
+	Owned bool
+}
+
+// NewMutex creates or opens a mutex. An empty name creates an unnamed
+// mutex.
+func NewMutex(name string, opts *MutexOptions) (*Mutex, error) {
+	if opts == nil {
+		opts = &MutexOptions{}
+	}
+
+	var flags uint32
+	if opts.Owned {
+		flags |= CREATE_MUTEX_INITIAL_OWNER
+	}
+
+	obj, err := newObject(CreateMutexEx(opts.Security, name, flags, opts.access(MUTEX_ALL_ACCESS)))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Mutex{obj}, nil
+}
+
+// OpenNamedMutex opens an existing named mutex. Acquiring it needs
+// SYNCHRONIZE and releasing it MUTEX_MODIFY_STATE.
+func OpenNamedMutex(name string, access uint32) (*Mutex, error) {
+	handle, err := OpenMutex(access, false, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Mutex{object{handle: handle}}, nil
+}
+
+// Release releases the mutex, which must be owned by the calling thread.
+func (m *Mutex) Release() error {
+	return ReleaseMutex(m.handle)
+}
+
+// Semaphore is a semaphore object.
+type Semaphore struct {
+	object
+}
+
+// SemaphoreOptions configure NewSemaphore.
+type SemaphoreOptions struct {
+	ObjectAttributes
+
+	// Initial is the initial count.
+	Initial int32
+}
+
+// NewSemaphore creates or opens a semaphore with the given maximum count.
+// An empty name creates an unnamed semaphore.
+func NewSemaphore(name string, maximum int32, opts *SemaphoreOptions) (*Semaphore, error) {
+	if opts == nil {
+		opts = &SemaphoreOptions{}
+	}
+
+	obj, err := newObject(CreateSemaphoreEx(
+		opts.Security,
+		opts.Initial,
+		maximum,
+		name,
+		opts.access(SEMAPHORE_ALL_ACCESS),
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	return &Semaphore{obj}, nil
+}
+
+// OpenNamedSemaphore opens an existing named semaphore. Acquiring it needs
+// SYNCHRONIZE and releasing it SEMAPHORE_MODIFY_STATE.
+func OpenNamedSemaphore(name string, access uint32) (*Semaphore, error) {
+	handle, err := OpenSemaphore(access, false, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Semaphore{object{handle: handle}}, nil
+}
+
+// Release adds count to the semaphore and returns the previous count.
+func (s *Semaphore) Release(count int32) (int32, error) {
+	return ReleaseSemaphore(s.handle, count)
+}
+
+// WaitableTimer is a waitable timer object.
+type WaitableTimer struct {
+	object
+}
+
+// WaitableTimerOptions configure NewWaitableTimer. A nil
+// *WaitableTimerOptions creates an auto-reset timer.
+type WaitableTimerOptions struct {
+	ObjectAttributes
+
+	// ManualReset keeps the timer signaled until it is set again,
+	// releasing every waiter.
+	ManualReset bool
+
+	// HighResolution requests a high resolution timer, which requires
+	// Windows 10 1803 and cannot be named.
+	HighResolution bool
+}
+
+// NewWaitableTimer creates or opens a waitable timer. An empty name creates
+// an unnamed timer.
+func NewWaitableTimer(name string, opts *WaitableTimerOptions) (*WaitableTimer, error) {
+	if opts == nil {
+		opts = &WaitableTimerOptions{}
+	}
+
+	var flags uint32
+	if opts.ManualReset {
+		flags |= CREATE_WAITABLE_TIMER_MANUAL_RESET
+	}
+
+	if opts.HighResolution {
+		flags |= CREATE_WAITABLE_TIMER_HIGH_RESOLUTION
+	}
+
+	obj, err := newObject(CreateWaitableTimerEx(opts.Security, name, flags, opts.access(TIMER_ALL_ACCESS)))
+	if err != nil {
+		return nil, err
+	}
+
+	return &WaitableTimer{obj}, nil
+}
+
+// OpenNamedWaitableTimer opens an existing named waitable timer. Setting it
+// needs TIMER_MODIFY_STATE and waiting on it SYNCHRONIZE.
+func OpenNamedWaitableTimer(name string, access uint32) (*WaitableTimer, error) {
+	handle, err := OpenWaitableTimer(access, false, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &WaitableTimer{object{handle: handle}}, nil
+}
+
+// Set arms the timer to signal after due and then every period, or once if
+// period is zero.
+func (t *WaitableTimer) Set(due, period time.Duration) error {
+	return SetWaitableTimer(t.handle, relativeDueTime(due), int32(milliseconds(period)))
+}
+
+// relativeDueTime converts d to a SetWaitableTimer relative due time.
+func relativeDueTime(d time.Duration) int64 {
+	// negative due times are relative, but zero would be absolute
+	due := -int64(d / 100)
+	if due >= 0 {
+		return -1
+	}
+
+	return due
+}
+
+// SetAt arms the timer to signal at the wall clock time at, and then every
+// period, or once if period is zero. Unlike Set, the due time follows
+// changes to the system clock.
+func (t *WaitableTimer) SetAt(at time.Time, period time.Duration) error {
+	ft := syscall.NsecToFiletime(at.UnixNano())
+	due := int64(ft.HighDateTime)<<32 | int64(ft.LowDateTime)
+
+	return SetWaitableTimer(t.handle, due, int32(milliseconds(period)))
+}
+
+// Cancel disarms the timer without changing its signaled state.
+func (t *WaitableTimer) Cancel() error {
+	return CancelWaitableTimer(t.handle)
+}
@@ -0,0 +1,384 @@
+package kernel32
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+const (
+	MAX_MODULE_NAME32 = 255
+
+	// HEAPLIST32 flags
+	HF32_DEFAULT = 1
+	HF32_SHARED  = 2
+
+	// HEAPENTRY32 flags
+	LF32_FIXED    = 0x00000001
+	LF32_FREE     = 0x00000002
+	LF32_MOVEABLE = 0x00000004
+
+	ERROR_BAD_LENGTH = syscall.Errno(24)
+
+	// snapshotRetries bounds the retries of a module snapshot that fails
+	// with ERROR_BAD_LENGTH while the process loads or unloads modules.
+	snapshotRetries = 8
+)
+
+type PROCESSENTRY32W struct {
+	Size            uint32
+	Usage           uint32
+	ProcessID       uint32
+	DefaultHeapID   uintptr
+	ModuleID        uint32
+	Threads         uint32
+	ParentProcessID uint32
+	PriClassBase    int32
+	Flags           uint32
+	ExeFile         [MAX_PATH]uint16
+}
+
+// Name returns the executable file name.
+func (e *PROCESSENTRY32W) Name() string {
+	return syscall.UTF16ToString(e.ExeFile[:])
+}
+
+type MODULEENTRY32W struct {
+	Size         uint32
+	ModuleID     uint32
+	ProcessID    uint32
+	GlblcntUsage uint32
+	ProccntUsage uint32
+	ModBaseAddr  uintptr
+	ModBaseSize  uint32
+	Module       syscall.Handle
+	ModuleName   [MAX_MODULE_NAME32 + 1]uint16
+	ExePath      [MAX_PATH]uint16
+}
+
+// Name returns the module file name.
+func (e *MODULEENTRY32W) Name() string {
+	return syscall.UTF16ToString(e.ModuleName[:])
+}
+
+// Path returns the module's full path.
+func (e *MODULEENTRY32W) Path() string {
+	return syscall.UTF16ToString(e.ExePath[:])
+}
+
+type HEAPLIST32 struct {
+	Size      uintptr
+	ProcessID uint32
+	HeapID    uintptr
+	Flags     uint32
+}
+
+type HEAPENTRY32 struct {
+	Size      uintptr
+	Handle    syscall.Handle
+	Address   uintptr
+	BlockSize uintptr
+	Flags     uint32
+	LockCount uint32
+	Resvd     uint32
+	ProcessID uint32
+	HeapID    uintptr
+}
+
+var (
+	k32Heap32First     = kernel32Dll.NewProc("Heap32First")
+	k32Heap32ListFirst = kernel32Dll.NewProc("Heap32ListFirst")
+	k32Heap32ListNext  = kernel32Dll.NewProc("Heap32ListNext")
+	k32Heap32Next      = kernel32Dll.NewProc("Heap32Next")
+	k32Module32First   = kernel32Dll.NewProc("Module32FirstW")
+	k32Module32Next    = kernel32Dll.NewProc("Module32NextW")
+	k32Process32First  = kernel32Dll.NewProc("Process32FirstW")
+	k32Process32Next   = kernel32Dll.NewProc("Process32NextW")
+)
+
+// HANDLE WINAPI CreateToolhelp32Snapshot(
+//   _In_ DWORD dwFlags,
+//   _In_ DWORD th32ProcessID
+// );
+// fail == INVALID_HANDLE_VALUE
+func CreateToolhelp32Snapshot(flags, pid uint32) (syscall.Handle, error) {
+	ret, _, err := k32CreateToolhelp32Snapshot.Call(
+		uintptr(flags),
+		uintptr(pid),
+	)
+	if syscall.Handle(ret) == syscall.InvalidHandle {
+		return syscall.InvalidHandle, err
+	}
+
+	return syscall.Handle(ret), nil
+}
+
+// Snapshot is a Toolhelp32 snapshot of processes, threads, modules or
+// heaps, depending on the TH32CS_* flags it was taken with.
+type Snapshot struct {
+	handle syscall.Handle
+}
+
+// NewSnapshot takes a snapshot. pid selects the process for
+// TH32CS_SNAPMODULE, TH32CS_SNAPMODULE32 and TH32CS_SNAPHEAPLIST, zero
+// meaning the current process; processes and threads are always listed for
+// the whole system.
+func NewSnapshot(flags, pid uint32) (*Snapshot, error) {
+	var handle syscall.Handle
+	var err error
+
+	for i := 0; i < snapshotRetries; i++ {
+		handle, err = CreateToolhelp32Snapshot(flags, pid)
+		if err != ERROR_BAD_LENGTH {
+			break
+		}
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return &Snapshot{handle: handle}, nil
+}
+
+// Handle returns the snapshot handle.
+func (s *Snapshot) Handle() syscall.Handle {
+	return s.handle
+}
+
+// Close releases the snapshot.
+func (s *Snapshot) Close() error {
+	if s.handle == syscall.InvalidHandle {
+		return nil
+	}
+
+	err := syscall.CloseHandle(s.handle)
+	s.handle = syscall.InvalidHandle
+
+	return err
+}
+
+// walk drives a First/Next pair for the typed iterators below. Running off
+// the end is ERROR_NO_MORE_FILES, which is not reported by Err.
+type walk struct {
+	first   func() error
+	next    func() error
+	started bool
+	err     error
+}
+
+func (w *walk) step() bool {
+	if w.err != nil {
+		return false
+	}
+
+	if w.started {
+		w.err = w.next()
+	} else {
+		w.started = true
+		w.err = w.first()
+	}
+
+	return w.err == nil
+}
+
+// Err returns the error that ended the iteration, if any.
+func (w *walk) Err() error {
+	if w.err == syscall.ERROR_NO_MORE_FILES {
+		return nil
+	}
+
+	return w.err
+}
+
+func toolhelpCall(proc *syscall.LazyProc, args ...uintptr) error {
+	ret, _, err := proc.Call(args...)
+	if ret == 0 {
+		return err
+	}
+
+	return nil
+}
+
+// ProcessIter iterates over PROCESSENTRY32W records:
+//
+//	it := snapshot.Processes()
+//	for it.Next() {
+//	    entry := it.Entry()
+//	}
+//	err := it.Err()
+type ProcessIter struct {
+	walk
+	entry PROCESSENTRY32W
+}
+
+// Processes iterates over the processes in a TH32CS_SNAPPROCESS snapshot.
+func (s *Snapshot) Processes() *ProcessIter {
+	it := &ProcessIter{}
+	call := func(proc *syscall.LazyProc) func() error {
+		return func() error {
+			it.entry.Size = uint32(unsafe.Sizeof(it.entry))
+			return toolhelpCall(proc, uintptr(s.handle), uintptr(unsafe.Pointer(&it.entry)))
+		}
+	}
+
+	it.first = call(k32Process32First)
+	it.next = call(k32Process32Next)
+
+	return it
+}
+
+// Next advances to the next process.
+func (it *ProcessIter) Next() bool {
+	return it.step()
+}
+
+// Entry returns the current process. It is overwritten by Next.
+func (it *ProcessIter) Entry() *PROCESSENTRY32W {
+	return &it.entry
+}
+
+// ThreadIter iterates over THREADENTRY32 records.
+type ThreadIter struct {
+	walk
+	entry THREADENTRY32
+}
+
+// Threads iterates over the threads in a TH32CS_SNAPTHREAD snapshot. The
+// snapshot holds every thread in the system; filter on OwnerProcessID.
+func (s *Snapshot) Threads() *ThreadIter {
+	it := &ThreadIter{}
+	call := func(proc *syscall.LazyProc) func() error {
+		return func() error {
+			it.entry.Size = uint32(unsafe.Sizeof(it.entry))
+			return toolhelpCall(proc, uintptr(s.handle), uintptr(unsafe.Pointer(&it.entry)))
+		}
+	}
+
+	it.first = call(k32Thread32First)
+	it.next = call(k32Thread32Next)
+
+	return it
+}
+
+// Next advances to the next thread.
+func (it *ThreadIter) Next() bool {
+	return it.step()
+}
+
+// Entry returns the current thread. It is overwritten by Next.
+func (it *ThreadIter) Entry() *THREADENTRY32 {
+	return &it.entry
+}
+
+// ModuleIter iterates over MODULEENTRY32W records.
+type ModuleIter struct {
+	walk
+	entry MODULEENTRY32W
+}
+
+// Modules iterates over the modules in a TH32CS_SNAPMODULE or
+// TH32CS_SNAPMODULE32 snapshot.
+func (s *Snapshot) Modules() *ModuleIter {
+	it := &ModuleIter{}
+	call := func(proc *syscall.LazyProc) func() error {
+		return func() error {
+			it.entry.Size = uint32(unsafe.Sizeof(it.entry))
+			return toolhelpCall(proc, uintptr(s.handle), uintptr(unsafe.Pointer(&it.entry)))
+		}
+	}
+
+	it.first = call(k32Module32First)
+	it.next = call(k32Module32Next)
+
+	return it
+}
+
+// Next advances to the next module.
+func (it *ModuleIter) Next() bool {
+	return it.step()
+}
+
+// Entry returns the current module. It is overwritten by Next.
+func (it *ModuleIter) Entry() *MODULEENTRY32W {
+	return &it.entry
+}
+
+// HeapListIter iterates over HEAPLIST32 records.
+type HeapListIter struct {
+	walk
+	entry HEAPLIST32
+}
+
+// HeapLists iterates over the heaps in a TH32CS_SNAPHEAPLIST snapshot.
+func (s *Snapshot) HeapLists() *HeapListIter {
+	it := &HeapListIter{}
+	call := func(proc *syscall.LazyProc) func() error {
+		return func() error {
+			it.entry.Size = unsafe.Sizeof(it.entry)
+			return toolhelpCall(proc, uintptr(s.handle), uintptr(unsafe.Pointer(&it.entry)))
+		}
+	}
+
+	it.first = call(k32Heap32ListFirst)
+	it.next = call(k32Heap32ListNext)
+
+	return it
+}
+
+// Next advances to the next heap.
+func (it *HeapListIter) Next() bool {
+	return it.step()
+}
+
+// Entry returns the current heap. It is overwritten by Next.
+func (it *HeapListIter) Entry() *HEAPLIST32 {
+	return &it.entry
+}
+
+// HeapIter iterates over the HEAPENTRY32 blocks of one heap.
+type HeapIter struct {
+	walk
+	entry HEAPENTRY32
+}
+
+// Heap iterates over the blocks of a heap returned by HeapLists. Walking a
+// heap reads the target process block by block, and is slow for large
+// heaps.
+func (s *Snapshot) Heap(list *HEAPLIST32) *HeapIter {
+	it := &HeapIter{}
+	pid, heapID := list.ProcessID, list.HeapID
+
+	// BOOL WINAPI Heap32First(
+	//   _Inout_ LPHEAPENTRY32 lphe,
+	//   _In_    DWORD         th32ProcessID,
+	//   _In_    ULONG_PTR     th32HeapID
+	// );
+	it.first = func() error {
+		it.entry.Size = unsafe.Sizeof(it.entry)
+		return toolhelpCall(
+			k32Heap32First,
+			uintptr(unsafe.Pointer(&it.entry)),
+			uintptr(pid),
+			heapID,
+		)
+	}
+
+	// BOOL WINAPI Heap32Next(
+	//   _Out_ LPHEAPENTRY32 lphe
+	// );
+	it.next = func() error {
+		return toolhelpCall(k32Heap32Next, uintptr(unsafe.Pointer(&it.entry)))
+	}
+
+	return it
+}
+
+// Next advances to the next block.
+func (it *HeapIter) Next() bool {
+	return it.step()
+}
+
+// Entry returns the current block. It is overwritten by Next.
+func (it *HeapIter) Entry() *HEAPENTRY32 {
+	return &it.entry
+}
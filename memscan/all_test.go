@@ -0,0 +1,256 @@
+//  ---------------------------------------------------------------------------
+//
+//  all_test.go
+//
+//  Copyright (c) 2016, Jared Chavez.
+//  All rights reserved.
+//
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+//
+//  -----------
+
+package memscan
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/xaevman/win32/vmmap"
+)
+
+// fakeMemory is a sparse address space backed by byte slices.
+type fakeMemory struct {
+	segments map[uint64][]byte
+
+	// bad addresses fail to read, as decommitted pages do.
+	bad map[uint64]bool
+}
+
+func (m *fakeMemory) ReadAt(p []byte, off int64) (int, error) {
+	addr := uint64(off)
+	if m.bad[addr] {
+		return 0, errors.New("partial copy")
+	}
+
+	for base, data := range m.segments {
+		if addr >= base && addr < base+uint64(len(data)) {
+			n := copy(p, data[addr-base:])
+			if n < len(p) {
+				return n, errors.New("partial copy")
+			}
+
+			return n, nil
+		}
+	}
+
+	return 0, errors.New("not mapped")
+}
+
+func (m *fakeMemory) region(base uint64, protect vmmap.Protect) vmmap.Region {
+	return vmmap.Region{
+		Base:    base,
+		Size:    uint64(len(m.segments[base])),
+		State:   vmmap.StateCommit,
+		Protect: protect,
+		Type:    vmmap.TypePrivate,
+	}
+}
+
+func addrs(matches []Match) []uint64 {
+	var out []uint64
+	for _, match := range matches {
+		out = append(out, match.Addr)
+	}
+
+	return out
+}
+
+func TestParsePattern(t *testing.T) {
+	p, err := ParsePattern("48 8b ?? ? E8")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p.String() != "48 8b ?? ? E8" || p.Len() != 5 {
+		t.Fatalf("got %q len %d", p.String(), p.Len())
+	}
+
+	if !p.Match([]byte{0x48, 0x8b, 0x01, 0x02, 0xe8}) {
+		t.Error("expected match")
+	}
+
+	if p.Match([]byte{0x48, 0x8b, 0x01, 0x02, 0xe9}) {
+		t.Error("unexpected match")
+	}
+
+	for _, bad := range []string{"", "?? ??", "4", "48 8G", "480"} {
+		if _, err := ParsePattern(bad); err == nil {
+			t.Errorf("ParsePattern(%q): expected error", bad)
+		}
+	}
+}
+
+func TestIndex(t *testing.T) {
+	data := []byte{0x00, 0x48, 0x00, 0x48, 0x8b, 0xff, 0xe8, 0x48}
+
+	tests := []struct {
+		p    *Pattern
+		want int
+	}{
+		{MustParsePattern("48 8B ?? E8"), 3},
+		{MustParsePattern("?? 48 8B"), 2},
+		{MustParsePattern("E8 48 ??"), -1},
+		{Bytes([]byte{0xff, 0xe8}), 5},
+		{String("H"), 1},
+	}
+
+	for _, test := range tests {
+		if got := test.p.Index(data); got != test.want {
+			t.Errorf("%v: Index = %d, want %d", test.p, got, test.want)
+		}
+	}
+}
+
+func TestUTF16(t *testing.T) {
+	p := UTF16("pa€")
+
+	want := []byte{'p', 0, 'a', 0, 0xac, 0x20}
+	if !p.Match(want) || p.Len() != len(want) {
+		t.Fatalf("UTF16 does not match %x", want)
+	}
+
+	if p.String() != `L"pa€"` {
+		t.Fatalf("String = %q", p.String())
+	}
+}
+
+func TestScan(t *testing.T) {
+	secret := []byte("hunter2")
+
+	heap := make([]byte, 64)
+	copy(heap[5:], secret)  // within the first chunk
+	copy(heap[14:], secret) // spans the first and second chunks
+	copy(heap[60:], "hunt") // spans into the next region
+
+	next := make([]byte, 16)
+	copy(next, "er2")
+
+	mem := &fakeMemory{segments: map[uint64][]byte{
+		0x1000: heap,
+		0x1040: next,
+		0x2000: []byte("hunter2 hunter2"),
+		0x3000: []byte("hunter2"),
+	}}
+
+	regions := []vmmap.Region{
+		mem.region(0x1000, vmmap.ProtectReadWrite),
+		mem.region(0x1040, vmmap.ProtectReadOnly),
+		mem.region(0x2000, vmmap.ProtectReadWrite|vmmap.ProtectGuard),
+		mem.region(0x3000, vmmap.ProtectNoAccess),
+	}
+
+	matches, err := Scan(context.Background(), regions, mem, []*Pattern{String("hunter2")}, &Options{ChunkSize: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []uint64{0x1005, 0x100e, 0x103c}
+	if got := addrs(matches); !reflect.DeepEqual(got, want) {
+		t.Fatalf("matches at %x, want %x", got, want)
+	}
+
+	if matches[2].Region.Base != 0x1040 {
+		t.Errorf("spanning match in region %x, want 0x1040", matches[2].Region.Base)
+	}
+}
+
+func TestScanOverlapping(t *testing.T) {
+	mem := &fakeMemory{segments: map[uint64][]byte{
+		0x1000: []byte("aaaaaaaa"),
+	}}
+
+	regions := []vmmap.Region{mem.region(0x1000, vmmap.ProtectReadOnly)}
+	patterns := []*Pattern{String("aaa"), String("a")}
+
+	matches, err := Scan(context.Background(), regions, mem, patterns, &Options{ChunkSize: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counts := make(map[*Pattern]int)
+	for _, match := range matches {
+		counts[match.Pattern]++
+	}
+
+	if counts[patterns[0]] != 6 || counts[patterns[1]] != 8 {
+		t.Fatalf("got %d and %d matches, want 6 and 8", counts[patterns[0]], counts[patterns[1]])
+	}
+}
+
+func TestScanReadErrors(t *testing.T) {
+	data := make([]byte, 48)
+	copy(data[14:], "key!") // spans into the unreadable chunk
+	copy(data[30:], "key!") // spans out of it
+	copy(data[40:], "key!")
+
+	mem := &fakeMemory{
+		segments: map[uint64][]byte{0x1000: data},
+		bad:      map[uint64]bool{0x1010: true},
+	}
+
+	regions := []vmmap.Region{mem.region(0x1000, vmmap.ProtectReadWrite)}
+
+	matches, err := Scan(context.Background(), regions, mem, []*Pattern{String("key!")}, &Options{ChunkSize: 16})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []uint64{0x1028}
+	if got := addrs(matches); !reflect.DeepEqual(got, want) {
+		t.Fatalf("matches at %x, want %x", got, want)
+	}
+}
+
+func TestScanOptions(t *testing.T) {
+	mem := &fakeMemory{segments: map[uint64][]byte{
+		0x1000: []byte("xx MZ xx MZ"),
+		0x2000: []byte("MZ"),
+	}}
+
+	regions := []vmmap.Region{
+		mem.region(0x1000, vmmap.ProtectReadOnly),
+		mem.region(0x2000, vmmap.ProtectReadOnly),
+	}
+
+	patterns := []*Pattern{MustParsePattern("4D 5A")}
+
+	matches, err := Scan(context.Background(), regions, mem, patterns, &Options{Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := addrs(matches); !reflect.DeepEqual(got, []uint64{0x1003}) {
+		t.Fatalf("Limit: matches at %x", got)
+	}
+
+	filter := func(region vmmap.Region) bool { return region.Base == 0x2000 }
+
+	matches, err = Scan(context.Background(), regions, mem, patterns, &Options{Filter: filter})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := addrs(matches); !reflect.DeepEqual(got, []uint64{0x2000}) {
+		t.Fatalf("Filter: matches at %x", got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := Scan(ctx, regions, mem, patterns, nil); err != context.Canceled {
+		t.Fatalf("canceled scan returned %v", err)
+	}
+}
@@ -0,0 +1,184 @@
+//  ---------------------------------------------------------------------------
+//
+//  memscan.go
+//
+//  Copyright (c) 2016, Jared Chavez.
+//  All rights reserved.
+//
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+//
+//  -----------
+
+// Package memscan searches the memory of a process for byte patterns, such
+// as leaked secrets, file magic or known vtable pointers.
+package memscan
+
+import (
+	"context"
+	"io"
+	"sort"
+
+	"github.com/xaevman/win32/vmmap"
+)
+
+// DefaultChunkSize is the read size used when Options.ChunkSize is zero.
+const DefaultChunkSize = 1 << 20
+
+// Match is one occurrence of a pattern.
+type Match struct {
+	Pattern *Pattern
+	Addr    uint64
+
+	// Region is the region holding the end of the match, which for a
+	// match spanning regions is not the one holding Addr.
+	Region vmmap.Region
+}
+
+// Options control a scan. A nil *Options uses the defaults.
+type Options struct {
+	// ChunkSize is the number of bytes read at a time.
+	ChunkSize int
+
+	// Filter, when set, selects which of the committed, readable regions
+	// are scanned.
+	Filter func(region vmmap.Region) bool
+
+	// Limit stops the scan after this many matches. Zero means no limit.
+	Limit int
+}
+
+// Scannable reports whether region is committed and readable without
+// faulting on a guard page.
+func Scannable(region vmmap.Region) bool {
+	return region.State == vmmap.StateCommit && region.Protect.Readable()
+}
+
+// Scan searches the scannable regions of an address space for patterns,
+// reading memory through r at offsets equal to addresses. Matches that span
+// chunks, or adjacent regions, are found, and overlapping matches are all
+// reported. Matches are returned in address order.
+//
+// Memory can be decommitted between listing the regions and reading them,
+// so a chunk that fails to read is skipped rather than ending the scan.
+func Scan(ctx context.Context, regions []vmmap.Region, r io.ReaderAt, patterns []*Pattern, opts *Options) ([]Match, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	chunkSize := opts.ChunkSize
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+
+	s := scan{patterns: patterns, limit: opts.Limit}
+	for _, p := range patterns {
+		if p.Len()-1 > s.overlap {
+			s.overlap = p.Len() - 1
+		}
+	}
+
+	buf := make([]byte, s.overlap+chunkSize)
+
+	for _, region := range regions {
+		if !Scannable(region) || (opts.Filter != nil && !opts.Filter(region)) {
+			continue
+		}
+
+		for addr := region.Base; addr < region.End(); addr += uint64(chunkSize) {
+			if err := ctx.Err(); err != nil {
+				return s.sorted(), err
+			}
+
+			size := uint64(chunkSize)
+			if region.End()-addr < size {
+				size = region.End() - addr
+			}
+
+			if addr != s.end {
+				s.carry = 0
+			}
+
+			n, err := r.ReadAt(buf[s.carry:s.carry+int(size)], int64(addr))
+			if n > 0 {
+				s.search(buf, addr, n, region)
+			}
+
+			if err != nil && n < int(size) {
+				s.carry = 0
+			}
+
+			if s.full() {
+				return s.sorted(), nil
+			}
+		}
+	}
+
+	return s.sorted(), nil
+}
+
+// scan carries the tail of each chunk into the next, so that a match
+// ending in the new data is found even when it started in the old.
+type scan struct {
+	patterns []*Pattern
+	limit    int
+	matches  []Match
+
+	// overlap is the longest pattern length less one, the most of a match
+	// that can lie before a chunk.
+	overlap int
+
+	// carry bytes at the start of the buffer precede address end.
+	carry int
+	end   uint64
+}
+
+// search searches buf, which holds carry bytes and then n bytes read from
+// addr, and then keeps the tail of buf for the next chunk.
+func (s *scan) search(buf []byte, addr uint64, n int, region vmmap.Region) {
+	data := buf[:s.carry+n]
+	start := addr - uint64(s.carry)
+
+	for _, p := range s.patterns {
+		// a match entirely within the carried bytes was already reported
+		from := s.carry - p.Len() + 1
+		if from < 0 {
+			from = 0
+		}
+
+		for off := from; !s.full(); off++ {
+			i := p.Index(data[off:])
+			if i < 0 {
+				break
+			}
+
+			off += i
+			s.matches = append(s.matches, Match{
+				Pattern: p,
+				Addr:    start + uint64(off),
+				Region:  region,
+			})
+		}
+	}
+
+	keep := s.overlap
+	if keep > len(data) {
+		keep = len(data)
+	}
+
+	copy(buf, data[len(data)-keep:])
+	s.carry = keep
+	s.end = addr + uint64(n)
+}
+
+func (s *scan) sorted() []Match {
+	sort.SliceStable(s.matches, func(i, j int) bool {
+		return s.matches[i].Addr < s.matches[j].Addr
+	})
+
+	return s.matches
+}
+
+func (s *scan) full() bool {
+	return s.limit > 0 && len(s.matches) >= s.limit
+}
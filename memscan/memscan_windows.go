@@ -0,0 +1,41 @@
+//  ---------------------------------------------------------------------------
+//
+//  memscan_windows.go
+//
+//  Copyright (c) 2016, Jared Chavez.
+//  All rights reserved.
+//
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+//
+//  -----------
+
+package memscan
+
+import (
+	"context"
+	"syscall"
+
+	"github.com/xaevman/win32/kernel32"
+	"github.com/xaevman/win32/procmem"
+	"github.com/xaevman/win32/vmmap"
+)
+
+// processAccess covers listing the regions and reading them.
+const processAccess = kernel32.PROCESS_QUERY_INFORMATION | kernel32.PROCESS_VM_READ
+
+// ScanProcess searches the memory of process pid for patterns.
+func ScanProcess(ctx context.Context, pid uint32, patterns []*Pattern, opts *Options) ([]Match, error) {
+	proc, err := kernel32.OpenProcess(pid, processAccess)
+	if err != nil {
+		return nil, err
+	}
+	defer syscall.CloseHandle(proc)
+
+	regions, err := vmmap.Regions(vmmap.NewProcess(proc))
+	if err != nil {
+		return nil, err
+	}
+
+	return Scan(ctx, regions, procmem.FromHandle(proc, nil), patterns, opts)
+}
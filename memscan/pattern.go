@@ -0,0 +1,189 @@
+//  ---------------------------------------------------------------------------
+//
+//  pattern.go
+//
+//  Copyright (c) 2016, Jared Chavez.
+//  All rights reserved.
+//
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+//
+//  -----------
+
+package memscan
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// Pattern is a byte sequence to search for, in which some bytes may be
+// wildcards.
+type Pattern struct {
+	name  string
+	bytes []byte
+
+	// wild marks the wildcard bytes; nil when there are none.
+	wild []bool
+
+	// anchor is the offset of the first fixed byte, which is searched for
+	// with bytes.IndexByte before the rest of the pattern is compared.
+	anchor int
+}
+
+// ParsePattern parses an IDA-style signature of hex bytes separated by
+// spaces, in which "?" or "??" matches any byte:
+//
+//	48 8B ?? ?? E8
+func ParsePattern(sig string) (*Pattern, error) {
+	fields := strings.Fields(sig)
+	if len(fields) == 0 {
+		return nil, errors.New("memscan: empty pattern")
+	}
+
+	p := &Pattern{
+		name:  strings.Join(fields, " "),
+		bytes: make([]byte, len(fields)),
+		wild:  make([]bool, len(fields)),
+	}
+
+	for i, field := range fields {
+		if field == "?" || field == "??" {
+			p.wild[i] = true
+			continue
+		}
+
+		if len(field) != 2 {
+			return nil, fmt.Errorf("memscan: bad byte %q in pattern %q", field, sig)
+		}
+
+		b, err := strconv.ParseUint(field, 16, 8)
+		if err != nil {
+			return nil, fmt.Errorf("memscan: bad byte %q in pattern %q", field, sig)
+		}
+
+		p.bytes[i] = byte(b)
+	}
+
+	p.anchor = -1
+	for i, wild := range p.wild {
+		if !wild {
+			p.anchor = i
+			break
+		}
+	}
+
+	if p.anchor < 0 {
+		return nil, fmt.Errorf("memscan: pattern %q has no fixed bytes", sig)
+	}
+
+	return p, nil
+}
+
+// MustParsePattern is like ParsePattern but panics if sig is invalid.
+func MustParsePattern(sig string) *Pattern {
+	p, err := ParsePattern(sig)
+	if err != nil {
+		panic(err)
+	}
+
+	return p
+}
+
+// Bytes returns a pattern matching b exactly.
+func Bytes(b []byte) *Pattern {
+	if len(b) == 0 {
+		panic("memscan: empty pattern")
+	}
+
+	hex := make([]string, len(b))
+	for i, c := range b {
+		hex[i] = fmt.Sprintf("%02X", c)
+	}
+
+	return &Pattern{
+		name:  strings.Join(hex, " "),
+		bytes: append([]byte(nil), b...),
+	}
+}
+
+// String returns a pattern matching s encoded as UTF-8.
+func String(s string) *Pattern {
+	p := Bytes([]byte(s))
+	p.name = strconv.Quote(s)
+
+	return p
+}
+
+// UTF16 returns a pattern matching s encoded as little-endian UTF-16,
+// without a terminator, as Windows stores wide strings.
+func UTF16(s string) *Pattern {
+	units := utf16.Encode([]rune(s))
+
+	b := make([]byte, 0, 2*len(units))
+	for _, unit := range units {
+		b = append(b, byte(unit), byte(unit>>8))
+	}
+
+	p := Bytes(b)
+	p.name = "L" + strconv.Quote(s)
+
+	return p
+}
+
+// String returns the pattern in the form it was created from.
+func (p *Pattern) String() string {
+	return p.name
+}
+
+// Len returns the number of bytes the pattern matches.
+func (p *Pattern) Len() int {
+	return len(p.bytes)
+}
+
+// Match reports whether data begins with the pattern.
+func (p *Pattern) Match(data []byte) bool {
+	if len(data) < len(p.bytes) {
+		return false
+	}
+
+	if p.wild == nil {
+		return bytes.Equal(data[:len(p.bytes)], p.bytes)
+	}
+
+	for i, b := range p.bytes {
+		if !p.wild[i] && data[i] != b {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Index returns the offset of the first match in data, or -1.
+func (p *Pattern) Index(data []byte) int {
+	if p.wild == nil {
+		return bytes.Index(data, p.bytes)
+	}
+
+	last := len(data) - len(p.bytes)
+	first := p.bytes[p.anchor]
+
+	for i := 0; i <= last; i++ {
+		n := bytes.IndexByte(data[i+p.anchor:last+p.anchor+1], first)
+		if n < 0 {
+			return -1
+		}
+
+		i += n
+		if p.Match(data[i:]) {
+			return i
+		}
+	}
+
+	return -1
+}
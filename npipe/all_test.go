@@ -0,0 +1,449 @@
+//  ---------------------------------------------------------------------------
+//
+//  all_test.go
+//
+//  Copyright (c) 2016, Jared Chavez.
+//  All rights reserved.
+//
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+//
+//  -----------
+
+package npipe
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fragment is a piece of a message; more says the message continues in the
+// next fragment.
+type fragment struct {
+	data []byte
+	more bool
+}
+
+// fakeEnd is one end of an in-memory pipe. Writes block until the peer
+// reads them.
+type fakeEnd struct {
+	message bool
+	in      chan fragment
+	peer    *fakeEnd
+
+	pending []byte
+	more    bool
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+func fakePair(message bool) (*fakeEnd, *fakeEnd) {
+	a := &fakeEnd{message: message, in: make(chan fragment), closed: make(chan struct{})}
+	b := &fakeEnd{message: message, in: make(chan fragment), closed: make(chan struct{})}
+	a.peer, b.peer = b, a
+
+	return a, b
+}
+
+func (e *fakeEnd) Read(p []byte, cancel <-chan struct{}) (int, error) {
+	// an empty pending slice waits for the next message, or for the next
+	// fragment of the current one
+	if len(e.pending) == 0 {
+		select {
+		case f := <-e.in:
+			e.pending, e.more = f.data, f.more
+		case <-e.peer.closed:
+			return 0, io.EOF
+		case <-cancel:
+			return 0, errCanceled
+		case <-e.closed:
+			return 0, errCanceled
+		}
+	}
+
+	n := copy(p, e.pending)
+	e.pending = e.pending[n:]
+
+	if e.message && (len(e.pending) > 0 || e.more) {
+		return n, errMoreData
+	}
+
+	return n, nil
+}
+
+func (e *fakeEnd) Write(p []byte, cancel <-chan struct{}) (int, error) {
+	f := fragment{data: append([]byte(nil), p...)}
+
+	select {
+	case e.peer.in <- f:
+		return len(p), nil
+	case <-e.peer.closed:
+		return 0, io.ErrClosedPipe
+	case <-cancel:
+		return 0, errCanceled
+	case <-e.closed:
+		return 0, errCanceled
+	}
+}
+
+func (e *fakeEnd) Close() error {
+	e.closeOnce.Do(func() { close(e.closed) })
+	return nil
+}
+
+func connPair(message bool) (*Conn, *Conn) {
+	a, b := fakePair(message)
+	return newConn(a, message, "a", "b"), newConn(b, message, "b", "a")
+}
+
+func isTimeout(err error) bool {
+	ne, ok := err.(net.Error)
+	return ok && ne.Timeout()
+}
+
+func TestByteMode(t *testing.T) {
+	client, server := connPair(false)
+	defer client.Close()
+	defer server.Close()
+
+	go client.Write([]byte("hello world"))
+
+	var got []byte
+	buf := make([]byte, 4)
+
+	for len(got) < len("hello world") {
+		n, err := server.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got = append(got, buf[:n]...)
+	}
+
+	if string(got) != "hello world" {
+		t.Errorf("read %q", got)
+	}
+
+	if _, err := server.ReadMessage(); err != ErrNotMessageMode {
+		t.Errorf("ReadMessage = %v, want ErrNotMessageMode", err)
+	}
+}
+
+func TestMessageMode(t *testing.T) {
+	client, server := connPair(true)
+	defer client.Close()
+	defer server.Close()
+
+	long := bytes.Repeat([]byte("0123456789"), messageChunk/5)
+	messages := [][]byte{[]byte("abc"), {}, long}
+
+	go func() {
+		for _, m := range messages {
+			client.Write(m)
+		}
+		client.Write([]byte("tail"))
+	}()
+
+	for i, want := range messages {
+		got, err := server.ReadMessage()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !bytes.Equal(got, want) {
+			t.Errorf("message %d: got %d bytes, want %d", i, len(got), len(want))
+		}
+	}
+
+	// Read returns a long message in parts without error
+	buf := make([]byte, 3)
+	if n, err := server.Read(buf); n != 3 || err != nil || string(buf) != "tai" {
+		t.Errorf("Read = %d, %v, %q", n, err, buf[:n])
+	}
+
+	if n, err := server.Read(buf); n != 1 || err != nil || buf[0] != 'l' {
+		t.Errorf("Read = %d, %v, %q", n, err, buf[:n])
+	}
+}
+
+func TestMessageResumesAfterTimeout(t *testing.T) {
+	a, b := fakePair(true)
+	server := newConn(b, true, "b", "a")
+	defer server.Close()
+
+	go func() { a.peer.in <- fragment{data: []byte("first "), more: true} }()
+
+	server.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	if _, err := server.ReadMessage(); !isTimeout(err) {
+		t.Fatalf("ReadMessage = %v, want timeout", err)
+	}
+
+	server.SetReadDeadline(time.Time{})
+	go func() { a.peer.in <- fragment{data: []byte("half")} }()
+
+	got, err := server.ReadMessage()
+	if err != nil || string(got) != "first half" {
+		t.Errorf("ReadMessage = %q, %v", got, err)
+	}
+
+	// a peer that closes mid-message truncates it
+	go func() {
+		a.peer.in <- fragment{data: []byte("cut"), more: true}
+		a.Close()
+	}()
+
+	if _, err := server.ReadMessage(); err != io.ErrUnexpectedEOF {
+		t.Errorf("ReadMessage = %v, want io.ErrUnexpectedEOF", err)
+	}
+}
+
+func TestReadDeadline(t *testing.T) {
+	client, server := connPair(false)
+	defer client.Close()
+	defer server.Close()
+
+	buf := make([]byte, 8)
+
+	server.SetReadDeadline(time.Now().Add(-time.Second))
+	if _, err := server.Read(buf); !isTimeout(err) {
+		t.Errorf("Read past deadline = %v", err)
+	}
+
+	server.SetReadDeadline(time.Now().Add(20 * time.Millisecond))
+	start := time.Now()
+	if _, err := server.Read(buf); !isTimeout(err) {
+		t.Errorf("Read = %v, want timeout", err)
+	}
+
+	if elapsed := time.Since(start); elapsed < 15*time.Millisecond {
+		t.Errorf("Read timed out after %v", elapsed)
+	}
+
+	// clearing the deadline makes reads block again
+	server.SetReadDeadline(time.Time{})
+	go client.Write([]byte("ok"))
+
+	if n, err := server.Read(buf); err != nil || string(buf[:n]) != "ok" {
+		t.Errorf("Read = %q, %v", buf[:n], err)
+	}
+}
+
+func TestDeadlineAppliesToPendingRead(t *testing.T) {
+	client, server := connPair(false)
+	defer client.Close()
+	defer server.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := server.Read(make([]byte, 8))
+		done <- err
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	server.SetDeadline(time.Now())
+
+	select {
+	case err := <-done:
+		if !isTimeout(err) {
+			t.Errorf("Read = %v, want timeout", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("pending Read ignored the new deadline")
+	}
+}
+
+func TestWriteDeadline(t *testing.T) {
+	client, server := connPair(false)
+	defer client.Close()
+	defer server.Close()
+
+	// nothing reads, so the write blocks until its deadline
+	client.SetWriteDeadline(time.Now().Add(20 * time.Millisecond))
+	if n, err := client.Write([]byte("x")); n != 0 || !isTimeout(err) {
+		t.Errorf("Write = %d, %v", n, err)
+	}
+}
+
+func TestClose(t *testing.T) {
+	client, server := connPair(false)
+	defer client.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := server.Read(make([]byte, 8))
+		done <- err
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	server.Close()
+
+	if err := <-done; err != ErrClosed {
+		t.Errorf("pending Read = %v, want ErrClosed", err)
+	}
+
+	if _, err := server.Write([]byte("x")); err != ErrClosed {
+		t.Errorf("Write after Close = %v", err)
+	}
+
+	if err := server.Close(); err != nil {
+		t.Errorf("second Close = %v", err)
+	}
+
+	if _, err := client.Read(make([]byte, 8)); err != io.EOF {
+		t.Errorf("peer Read = %v, want io.EOF", err)
+	}
+}
+
+func TestDeadlineReset(t *testing.T) {
+	d := newDeadline()
+
+	d.set(time.Now().Add(-time.Second))
+	if !isClosed(d.wait()) {
+		t.Fatal("past deadline not expired")
+	}
+
+	d.set(time.Now().Add(time.Hour))
+	if isClosed(d.wait()) {
+		t.Fatal("future deadline expired")
+	}
+
+	d.set(time.Now().Add(10 * time.Millisecond))
+	select {
+	case <-d.wait():
+	case <-time.After(5 * time.Second):
+		t.Fatal("deadline never expired")
+	}
+
+	d.set(time.Time{})
+	if isClosed(d.wait()) {
+		t.Fatal("cleared deadline expired")
+	}
+}
+
+// fakeAcceptor hands out the server ends of pairs made by dial.
+type fakeAcceptor struct {
+	pending chan *fakeEnd
+	closes  int
+}
+
+func newFakeAcceptor() *fakeAcceptor {
+	return &fakeAcceptor{pending: make(chan *fakeEnd)}
+}
+
+func (a *fakeAcceptor) accept(cancel <-chan struct{}) (transport, error) {
+	select {
+	case end := <-a.pending:
+		return end, nil
+	case <-cancel:
+		return nil, errCanceled
+	}
+}
+
+func (a *fakeAcceptor) close() error {
+	a.closes++
+	return nil
+}
+
+func (a *fakeAcceptor) dial(ctx context.Context) (net.Conn, error) {
+	client, server := fakePair(false)
+
+	select {
+	case a.pending <- server:
+		return newConn(client, false, "pipe", "pipe"), nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func TestListener(t *testing.T) {
+	a := newFakeAcceptor()
+	l := newListener(a, `\\.\pipe\test`, false)
+
+	if l.Addr().Network() != "pipe" || l.Addr().String() != `\\.\pipe\test` {
+		t.Errorf("Addr = %v %v", l.Addr().Network(), l.Addr())
+	}
+
+	go a.dial(context.Background())
+
+	conn, err := l.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := l.Accept()
+		done <- err
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	l.Close()
+
+	if err := <-done; err != ErrClosed {
+		t.Errorf("pending Accept = %v, want ErrClosed", err)
+	}
+
+	if _, err := l.Accept(); err != ErrClosed {
+		t.Errorf("Accept after Close = %v", err)
+	}
+
+	l.Close()
+	if a.closes != 1 {
+		t.Errorf("acceptor closed %d times", a.closes)
+	}
+}
+
+func TestHTTP(t *testing.T) {
+	a := newFakeAcceptor()
+	l := newListener(a, `\\.\pipe\http`, false)
+
+	server := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, "hello %s", r.URL.Path[1:])
+		}),
+	}
+	go server.Serve(l)
+	defer server.Close()
+
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return a.dial(ctx)
+			},
+		},
+	}
+
+	for _, name := range []string{"one", "two"} {
+		resp, err := client.Get("http://pipe/" + name)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+
+		if err != nil || string(body) != "hello "+name {
+			t.Errorf("GET /%s = %q, %v", name, body, err)
+		}
+	}
+}
+
+func TestBufferSizes(t *testing.T) {
+	if in, out := (&Config{}).bufferSizes(); in != DefaultBufferSize || out != DefaultBufferSize {
+		t.Errorf("default sizes = %d, %d", in, out)
+	}
+
+	if in, out := (&Config{InputBufferSize: 512, OutputBufferSize: 1024}).bufferSizes(); in != 512 || out != 1024 {
+		t.Errorf("sizes = %d, %d", in, out)
+	}
+}
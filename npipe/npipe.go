@@ -0,0 +1,442 @@
+//  ---------------------------------------------------------------------------
+//
+//  npipe.go
+//
+//  Copyright (c) 2016, Jared Chavez.
+//  All rights reserved.
+//
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+//
+//  -----------
+
+// Package npipe is a named pipe transport: Listen returns a net.Listener
+// and Dial a net.Conn, so local IPC can run net/http or gRPC unchanged.
+//
+// The connection, deadline and listener logic is portable and runs over a
+// transport; the overlapped I/O transport is Windows only.
+package npipe
+
+import (
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultBufferSize is the pipe buffer size used when Config leaves
+	// it zero.
+	DefaultBufferSize = 64 * 1024
+
+	// messageChunk is the read size ReadMessage grows messages by.
+	messageChunk = 4096
+)
+
+// ErrClosed is returned by operations on a closed Conn or Listener.
+var ErrClosed = errors.New("npipe: use of closed pipe")
+
+// ErrNotMessageMode is returned by ReadMessage on a byte mode pipe.
+var ErrNotMessageMode = errors.New("npipe: pipe is not in message mode")
+
+// ErrTimeout is returned by operations that passed their deadline. It is a
+// net.Error whose Timeout method reports true.
+var ErrTimeout error = timeoutError{}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "npipe: i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+var (
+	// errCanceled is returned by a transport whose operation was
+	// abandoned through its cancel channel or by Close.
+	errCanceled = errors.New("npipe: operation canceled")
+
+	// errMoreData is returned by a message mode transport read that
+	// filled its buffer before the end of the message. The rest of the
+	// message is returned by the next read.
+	errMoreData = errors.New("npipe: more data in message")
+)
+
+// Config configures Listen. A nil *Config listens in byte mode with the
+// default buffer sizes and security.
+type Config struct {
+	// SecurityDescriptor is an SDDL string applied to the pipe, for
+	// example "D:P(A;;GA;;;SY)(A;;GA;;;BA)(A;;GRGW;;;IU)" to let
+	// interactive users connect to a service. Empty uses the default
+	// descriptor, which lets only the owner, administrators and
+	// LocalSystem write.
+	SecurityDescriptor string
+
+	// MessageMode makes every Write a message, read whole by ReadMessage.
+	// Read still works, returning a message over several calls when the
+	// buffer is short.
+	MessageMode bool
+
+	// InputBufferSize and OutputBufferSize are advisory sizes of the
+	// pipe's buffers. Zero uses DefaultBufferSize.
+	InputBufferSize  uint32
+	OutputBufferSize uint32
+
+	// AcceptRemoteClients allows clients on other machines, which are
+	// rejected by default.
+	AcceptRemoteClients bool
+}
+
+func (c *Config) bufferSizes() (in, out uint32) {
+	in, out = c.InputBufferSize, c.OutputBufferSize
+	if in == 0 {
+		in = DefaultBufferSize
+	}
+
+	if out == 0 {
+		out = DefaultBufferSize
+	}
+
+	return in, out
+}
+
+// Addr is the path of a pipe, such as `\\.\pipe\name`.
+type Addr string
+
+func (a Addr) Network() string { return "pipe" }
+func (a Addr) String() string  { return string(a) }
+
+// transport is one end of a connected pipe.
+type transport interface {
+	// Read reads into p. A message mode read that does not take the whole
+	// message returns errMoreData with the bytes read. Closing cancel
+	// abandons the read with errCanceled.
+	Read(p []byte, cancel <-chan struct{}) (int, error)
+
+	// Write writes p, as one message in message mode. Closing cancel
+	// abandons the write with errCanceled and the bytes written.
+	Write(p []byte, cancel <-chan struct{}) (int, error)
+
+	// Close cancels pending operations, waits for them and closes the
+	// pipe.
+	Close() error
+}
+
+// deadline is a cancel channel closed when a deadline passes. Setting a
+// new deadline applies to operations already waiting, as net.Conn
+// requires.
+type deadline struct {
+	mu     sync.Mutex
+	timer  *time.Timer
+	cancel chan struct{}
+}
+
+func newDeadline() *deadline {
+	return &deadline{cancel: make(chan struct{})}
+}
+
+// set sets the deadline to t, or clears it if t is zero.
+func (d *deadline) set(t time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.timer != nil && !d.timer.Stop() {
+		// the timer fired; wait for it to close the channel
+		<-d.cancel
+	}
+	d.timer = nil
+
+	closed := isClosed(d.cancel)
+
+	if t.IsZero() {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+		return
+	}
+
+	if dur := time.Until(t); dur > 0 {
+		if closed {
+			d.cancel = make(chan struct{})
+		}
+
+		cancel := d.cancel
+		d.timer = time.AfterFunc(dur, func() { close(cancel) })
+		return
+	}
+
+	if !closed {
+		close(d.cancel)
+	}
+}
+
+// wait returns the channel closed when the deadline passes.
+func (d *deadline) wait() chan struct{} {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return d.cancel
+}
+
+func isClosed(c chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
+
+// Conn is a connected pipe. It implements net.Conn.
+type Conn struct {
+	t       transport
+	message bool
+	local   Addr
+	remote  Addr
+
+	readDeadline  *deadline
+	writeDeadline *deadline
+
+	// messageMu serializes ReadMessage, which keeps the part of a message
+	// read before a timeout in partial.
+	messageMu sync.Mutex
+	partial   []byte
+
+	closeOnce sync.Once
+	closed    chan struct{}
+	closeErr  error
+}
+
+func newConn(t transport, message bool, local, remote Addr) *Conn {
+	return &Conn{
+		t:             t,
+		message:       message,
+		local:         local,
+		remote:        remote,
+		readDeadline:  newDeadline(),
+		writeDeadline: newDeadline(),
+		closed:        make(chan struct{}),
+	}
+}
+
+// Read reads data from the pipe. In message mode it reads one message, or
+// the next part of one that is longer than p, and skips empty messages.
+func (c *Conn) Read(p []byte) (int, error) {
+	for {
+		n, err := c.read(p)
+		if err == errMoreData {
+			return n, nil
+		}
+
+		if n == 0 && err == nil && len(p) > 0 {
+			continue
+		}
+
+		return n, err
+	}
+}
+
+// ReadMessage reads one whole message of a message mode pipe. A message
+// interrupted by a timeout is kept, and the next call continues it.
+func (c *Conn) ReadMessage() ([]byte, error) {
+	if !c.message {
+		return nil, ErrNotMessageMode
+	}
+
+	c.messageMu.Lock()
+	defer c.messageMu.Unlock()
+
+	chunk := make([]byte, messageChunk)
+
+	for {
+		n, err := c.read(chunk)
+		c.partial = append(c.partial, chunk[:n]...)
+
+		if err == errMoreData {
+			continue
+		}
+
+		if err != nil {
+			if err == io.EOF && len(c.partial) > 0 {
+				err = io.ErrUnexpectedEOF
+			}
+
+			return nil, err
+		}
+
+		message := c.partial
+		c.partial = nil
+
+		if message == nil {
+			message = []byte{}
+		}
+
+		return message, nil
+	}
+}
+
+func (c *Conn) read(p []byte) (int, error) {
+	if isClosed(c.closed) {
+		return 0, ErrClosed
+	}
+
+	cancel := c.readDeadline.wait()
+	if isClosed(cancel) {
+		return 0, ErrTimeout
+	}
+
+	n, err := c.t.Read(p, cancel)
+
+	return n, c.mapError(err)
+}
+
+// Write writes p, as one message in message mode.
+func (c *Conn) Write(p []byte) (int, error) {
+	if isClosed(c.closed) {
+		return 0, ErrClosed
+	}
+
+	written := 0
+
+	for {
+		cancel := c.writeDeadline.wait()
+		if isClosed(cancel) {
+			return written, ErrTimeout
+		}
+
+		n, err := c.t.Write(p[written:], cancel)
+		written += n
+
+		if err != nil {
+			return written, c.mapError(err)
+		}
+
+		if written == len(p) {
+			return written, nil
+		}
+
+		// a message cannot be continued by another write
+		if c.message || n == 0 {
+			return written, io.ErrShortWrite
+		}
+	}
+}
+
+func (c *Conn) mapError(err error) error {
+	if err != errCanceled {
+		return err
+	}
+
+	if isClosed(c.closed) {
+		return ErrClosed
+	}
+
+	return ErrTimeout
+}
+
+// Close closes the pipe, unblocking pending reads and writes with
+// ErrClosed.
+func (c *Conn) Close() error {
+	c.closeOnce.Do(func() {
+		close(c.closed)
+		c.closeErr = c.t.Close()
+	})
+
+	return c.closeErr
+}
+
+func (c *Conn) LocalAddr() net.Addr  { return c.local }
+func (c *Conn) RemoteAddr() net.Addr { return c.remote }
+
+func (c *Conn) SetDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	c.writeDeadline.set(t)
+
+	return nil
+}
+
+func (c *Conn) SetReadDeadline(t time.Time) error {
+	c.readDeadline.set(t)
+	return nil
+}
+
+func (c *Conn) SetWriteDeadline(t time.Time) error {
+	c.writeDeadline.set(t)
+	return nil
+}
+
+// acceptor creates and connects the server ends of a pipe.
+type acceptor interface {
+	// accept waits for a client, abandoning the wait with errCanceled
+	// when cancel is closed.
+	accept(cancel <-chan struct{}) (transport, error)
+
+	// close releases the pipe instance waiting for a client. It is not
+	// called while accept runs.
+	close() error
+}
+
+// Listener accepts pipe connections. It implements net.Listener.
+type Listener struct {
+	a       acceptor
+	addr    Addr
+	message bool
+
+	// acceptMu serializes accept, which connects one pipe instance at a
+	// time.
+	acceptMu sync.Mutex
+
+	closeOnce sync.Once
+	closed    chan struct{}
+	closeErr  error
+}
+
+func newListener(a acceptor, addr Addr, message bool) *Listener {
+	return &Listener{
+		a:       a,
+		addr:    addr,
+		message: message,
+		closed:  make(chan struct{}),
+	}
+}
+
+// Accept waits for the next client.
+func (l *Listener) Accept() (net.Conn, error) {
+	l.acceptMu.Lock()
+	defer l.acceptMu.Unlock()
+
+	if isClosed(l.closed) {
+		return nil, ErrClosed
+	}
+
+	t, err := l.a.accept(l.closed)
+	if err == errCanceled {
+		return nil, ErrClosed
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return newConn(t, l.message, l.addr, l.addr), nil
+}
+
+// Close stops listening, unblocking Accept with ErrClosed. Accepted
+// connections stay open.
+func (l *Listener) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.closed)
+
+		// wait for a canceled accept to return before releasing the
+		// instance it was using
+		l.acceptMu.Lock()
+		l.closeErr = l.a.close()
+		l.acceptMu.Unlock()
+	})
+
+	return l.closeErr
+}
+
+// Addr returns the pipe path.
+func (l *Listener) Addr() net.Addr {
+	return l.addr
+}
@@ -0,0 +1,383 @@
+//  ---------------------------------------------------------------------------
+//
+//  npipe_windows.go
+//
+//  Copyright (c) 2016, Jared Chavez.
+//  All rights reserved.
+//
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+//
+//  -----------
+
+package npipe
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/xaevman/win32/advapi32"
+	"github.com/xaevman/win32/kernel32"
+)
+
+// dialWaitSlice bounds each WaitNamedPipe of Dial, so that a canceled
+// context is noticed.
+const dialWaitSlice = 250 * time.Millisecond
+
+// Listen creates the pipe at path, such as `\\.\pipe\name`, and returns a
+// listener for it. Listen fails if another process already serves path.
+func Listen(path string, config *Config) (net.Listener, error) {
+	if config == nil {
+		config = &Config{}
+	}
+
+	a := &pipeAcceptor{path: path, config: config}
+
+	if config.SecurityDescriptor != "" {
+		sa, err := advapi32.SecurityAttributesFromSDDL(config.SecurityDescriptor, false)
+		if err != nil {
+			return nil, &net.OpError{Op: "listen", Net: "pipe", Addr: Addr(path), Err: err}
+		}
+
+		a.sa = sa
+	}
+
+	event, err := kernel32.CreateEventEx(nil, "", kernel32.CREATE_EVENT_MANUAL_RESET, kernel32.EVENT_ALL_ACCESS)
+	if err != nil {
+		advapi32.FreeSecurityAttributes(a.sa)
+		return nil, err
+	}
+
+	a.event = event
+
+	// the first instance fails if the name is taken, rather than joining
+	// another server's pipe
+	if a.next, err = a.create(true); err != nil {
+		a.close()
+		return nil, &net.OpError{Op: "listen", Net: "pipe", Addr: Addr(path), Err: err}
+	}
+
+	return newListener(a, Addr(path), config.MessageMode), nil
+}
+
+// Dial connects to the pipe at path, waiting while all of its instances are
+// busy until ctx is done. A pipe that does not exist fails at once, as a
+// refused connection would. The server may identify the client but not
+// impersonate it, so the pipe may be served by another user.
+func Dial(ctx context.Context, path string) (net.Conn, error) {
+	name := syscall.StringToUTF16Ptr(path)
+
+	for {
+		handle, err := syscall.CreateFile(
+			name,
+			syscall.GENERIC_READ|syscall.GENERIC_WRITE,
+			0,
+			nil,
+			syscall.OPEN_EXISTING,
+			syscall.FILE_FLAG_OVERLAPPED|kernel32.SECURITY_SQOS_PRESENT|kernel32.SECURITY_IDENTIFICATION,
+			0,
+		)
+		if err == nil {
+			return newClientConn(handle, path)
+		}
+
+		if err != kernel32.ERROR_PIPE_BUSY {
+			return nil, &net.OpError{Op: "dial", Net: "pipe", Addr: Addr(path), Err: err}
+		}
+
+		wait := dialWaitSlice
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			wait = time.Until(deadline)
+		}
+
+		if err := ctx.Err(); err != nil || wait <= 0 {
+			if err == nil {
+				err = context.DeadlineExceeded
+			}
+
+			return nil, &net.OpError{Op: "dial", Net: "pipe", Addr: Addr(path), Err: err}
+		}
+
+		// an instance freed and taken by another client before the
+		// CreateFile above fails the wait; the loop retries either way
+		kernel32.WaitNamedPipe(path, milliseconds(wait))
+	}
+}
+
+func newClientConn(handle syscall.Handle, path string) (net.Conn, error) {
+	flags, _, _, _, err := kernel32.GetNamedPipeInfo(handle)
+	if err != nil {
+		syscall.CloseHandle(handle)
+		return nil, err
+	}
+
+	message := flags&kernel32.PIPE_TYPE_MESSAGE != 0
+	if message {
+		// clients open pipes in byte read mode
+		if err := kernel32.SetNamedPipeHandleState(handle, kernel32.PIPE_READMODE_MESSAGE); err != nil {
+			syscall.CloseHandle(handle)
+			return nil, err
+		}
+	}
+
+	p, err := newPipe(handle)
+	if err != nil {
+		syscall.CloseHandle(handle)
+		return nil, err
+	}
+
+	return newConn(p, message, Addr(path), Addr(path)), nil
+}
+
+func milliseconds(d time.Duration) uint32 {
+	ms := (d + time.Millisecond - 1) / time.Millisecond
+	if ms < 1 {
+		return 1
+	}
+
+	return uint32(ms)
+}
+
+// pipeAcceptor creates the server instances of a pipe.
+type pipeAcceptor struct {
+	path   string
+	config *Config
+	sa     *syscall.SecurityAttributes
+
+	// event signals the overlapped connects
+	event syscall.Handle
+
+	// next is the instance that clients connect to, created before the
+	// previous client is returned so that the pipe never disappears.
+	next syscall.Handle
+}
+
+func (a *pipeAcceptor) create(first bool) (syscall.Handle, error) {
+	openMode := uint32(kernel32.PIPE_ACCESS_DUPLEX | kernel32.FILE_FLAG_OVERLAPPED)
+	if first {
+		openMode |= kernel32.FILE_FLAG_FIRST_PIPE_INSTANCE
+	}
+
+	pipeMode := uint32(kernel32.PIPE_TYPE_BYTE | kernel32.PIPE_READMODE_BYTE | kernel32.PIPE_WAIT)
+	if a.config.MessageMode {
+		pipeMode = kernel32.PIPE_TYPE_MESSAGE | kernel32.PIPE_READMODE_MESSAGE | kernel32.PIPE_WAIT
+	}
+
+	if !a.config.AcceptRemoteClients {
+		pipeMode |= kernel32.PIPE_REJECT_REMOTE_CLIENTS
+	}
+
+	in, out := a.config.bufferSizes()
+
+	return kernel32.CreateNamedPipeWithSecurity(
+		a.path,
+		openMode,
+		pipeMode,
+		kernel32.PIPE_UNLIMITED_INSTANCES,
+		out,
+		in,
+		0,
+		a.sa,
+	)
+}
+
+func (a *pipeAcceptor) accept(cancel <-chan struct{}) (transport, error) {
+	for {
+		if a.next == 0 {
+			next, err := a.create(false)
+			if err != nil {
+				return nil, err
+			}
+
+			a.next = next
+		}
+
+		ov := &syscall.Overlapped{HEvent: a.event}
+
+		err := kernel32.ConnectNamedPipeOverlapped(a.next, ov)
+		if err == syscall.ERROR_IO_PENDING {
+			_, err = waitOverlapped(a.next, ov, cancel, nil)
+		}
+
+		if err == errCanceled {
+			return nil, err
+		}
+
+		if err == kernel32.ERROR_NO_DATA {
+			// the client connected and closed before the connect
+			// completed
+			syscall.CloseHandle(a.next)
+			a.next = 0
+			continue
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		p, err := newPipe(a.next)
+		if err != nil {
+			kernel32.DisconnectNamedPipe(a.next)
+			syscall.CloseHandle(a.next)
+			a.next = 0
+
+			return nil, err
+		}
+
+		// a failure is reported by the next accept
+		a.next, _ = a.create(false)
+
+		return p, nil
+	}
+}
+
+func (a *pipeAcceptor) close() error {
+	var err error
+
+	if a.next != 0 {
+		err = syscall.CloseHandle(a.next)
+		a.next = 0
+	}
+
+	if a.event != 0 {
+		syscall.CloseHandle(a.event)
+		a.event = 0
+	}
+
+	advapi32.FreeSecurityAttributes(a.sa)
+
+	return err
+}
+
+// pipe is an end of a pipe opened for overlapped I/O. Reads and writes
+// each have an event, so that one of each can be in flight.
+type pipe struct {
+	handle syscall.Handle
+
+	readMu     sync.Mutex
+	readEvent  syscall.Handle
+	writeMu    sync.Mutex
+	writeEvent syscall.Handle
+
+	closeOnce sync.Once
+	closing   chan struct{}
+}
+
+func newPipe(handle syscall.Handle) (*pipe, error) {
+	readEvent, err := kernel32.CreateEventEx(nil, "", kernel32.CREATE_EVENT_MANUAL_RESET, kernel32.EVENT_ALL_ACCESS)
+	if err != nil {
+		return nil, err
+	}
+
+	writeEvent, err := kernel32.CreateEventEx(nil, "", kernel32.CREATE_EVENT_MANUAL_RESET, kernel32.EVENT_ALL_ACCESS)
+	if err != nil {
+		syscall.CloseHandle(readEvent)
+		return nil, err
+	}
+
+	return &pipe{
+		handle:     handle,
+		readEvent:  readEvent,
+		writeEvent: writeEvent,
+		closing:    make(chan struct{}),
+	}, nil
+}
+
+func (p *pipe) Read(b []byte, cancel <-chan struct{}) (int, error) {
+	p.readMu.Lock()
+	defer p.readMu.Unlock()
+
+	if isClosed(p.closing) {
+		return 0, errCanceled
+	}
+
+	var n uint32
+	ov := &syscall.Overlapped{HEvent: p.readEvent}
+
+	err := syscall.ReadFile(p.handle, b, &n, ov)
+	if err == syscall.ERROR_IO_PENDING {
+		n, err = waitOverlapped(p.handle, ov, cancel, p.closing)
+	}
+
+	switch err {
+	case syscall.ERROR_MORE_DATA:
+		err = errMoreData
+	case syscall.ERROR_BROKEN_PIPE, kernel32.ERROR_PIPE_NOT_CONNECTED:
+		err = io.EOF
+	}
+
+	return int(n), err
+}
+
+func (p *pipe) Write(b []byte, cancel <-chan struct{}) (int, error) {
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+
+	if isClosed(p.closing) {
+		return 0, errCanceled
+	}
+
+	var n uint32
+	ov := &syscall.Overlapped{HEvent: p.writeEvent}
+
+	err := syscall.WriteFile(p.handle, b, &n, ov)
+	if err == syscall.ERROR_IO_PENDING {
+		n, err = waitOverlapped(p.handle, ov, cancel, p.closing)
+	}
+
+	return int(n), err
+}
+
+func (p *pipe) Close() error {
+	p.closeOnce.Do(func() {
+		// every pending operation watches closing and cancels itself
+		close(p.closing)
+	})
+
+	p.readMu.Lock()
+	defer p.readMu.Unlock()
+	p.writeMu.Lock()
+	defer p.writeMu.Unlock()
+
+	if p.handle == 0 {
+		return nil
+	}
+
+	err := syscall.CloseHandle(p.handle)
+	syscall.CloseHandle(p.readEvent)
+	syscall.CloseHandle(p.writeEvent)
+	p.handle = 0
+
+	return err
+}
+
+// waitOverlapped waits for the pending operation ov on handle, canceling it
+// when cancel or closing is closed. Either may be nil.
+func waitOverlapped(handle syscall.Handle, ov *syscall.Overlapped, cancel, closing <-chan struct{}) (uint32, error) {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-cancel:
+		case <-closing:
+		case <-done:
+			return
+		}
+
+		syscall.CancelIoEx(handle, ov)
+	}()
+
+	// the operation must finish, even when canceled, before ov and its
+	// buffer can be released
+	n, err := kernel32.GetOverlappedResult(handle, ov, true)
+	close(done)
+
+	if err == syscall.ERROR_OPERATION_ABORTED {
+		err = errCanceled
+	}
+
+	return n, err
+}
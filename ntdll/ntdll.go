@@ -0,0 +1,95 @@
+package ntdll
+
+import (
+	"fmt"
+	"syscall"
+	"unsafe"
+)
+
+// PROCESSINFOCLASS
+const (
+	ProcessBasicInformation = 0
+	ProcessWow64Information = 26
+)
+
+// NTSTATUS is the status returned by native API functions. Values with the
+// top two bits set are errors.
+type NTSTATUS uint32
+
+const (
+	STATUS_SUCCESS = NTSTATUS(0)
+)
+
+func (s NTSTATUS) Error() string {
+	return fmt.Sprintf("NTSTATUS 0x%08x", uint32(s))
+}
+
+type PROCESS_BASIC_INFORMATION struct {
+	ExitStatus                   NTSTATUS
+	PebBaseAddress               uintptr
+	AffinityMask                 uintptr
+	BasePriority                 int32
+	UniqueProcessId              uintptr
+	InheritedFromUniqueProcessId uintptr
+}
+
+var (
+	ntdllDll = syscall.NewLazyDLL("ntdll.dll")
+
+	ntNtQueryInformationProcess = ntdllDll.NewProc("NtQueryInformationProcess")
+)
+
+// NTSTATUS WINAPI NtQueryInformationProcess(
+//   _In_      HANDLE           ProcessHandle,
+//   _In_      PROCESSINFOCLASS ProcessInformationClass,
+//   _Out_     PVOID            ProcessInformation,
+//   _In_      ULONG            ProcessInformationLength,
+//   _Out_opt_ PULONG           ReturnLength
+// );
+// fail != STATUS_SUCCESS
+func NtQueryInformationProcess(proc syscall.Handle, class uint32, info unsafe.Pointer, size uint32) error {
+	ret, _, _ := ntNtQueryInformationProcess.Call(
+		uintptr(proc),
+		uintptr(class),
+		uintptr(info),
+		uintptr(size),
+		0,
+	)
+
+	if NTSTATUS(ret) != STATUS_SUCCESS {
+		return NTSTATUS(ret)
+	}
+
+	return nil
+}
+
+// QueryBasicInformation returns the ProcessBasicInformation of proc, which
+// needs PROCESS_QUERY_LIMITED_INFORMATION. PebBaseAddress is the PEB of the
+// caller's bitness.
+func QueryBasicInformation(proc syscall.Handle) (PROCESS_BASIC_INFORMATION, error) {
+	var info PROCESS_BASIC_INFORMATION
+
+	err := NtQueryInformationProcess(
+		proc,
+		ProcessBasicInformation,
+		unsafe.Pointer(&info),
+		uint32(unsafe.Sizeof(info)),
+	)
+
+	return info, err
+}
+
+// QueryWow64Information returns the address of the 32-bit PEB of a WOW64
+// process, or zero for a native process.
+func QueryWow64Information(proc syscall.Handle) (uintptr, error) {
+	var peb32 uintptr
+
+	err := NtQueryInformationProcess(
+		proc,
+		ProcessWow64Information,
+		unsafe.Pointer(&peb32),
+		uint32(unsafe.Sizeof(peb32)),
+	)
+
+	return peb32, err
+}
@@ -0,0 +1,296 @@
+//  ---------------------------------------------------------------------------
+//
+//  all_test.go
+//
+//  Copyright (c) 2016, Jared Chavez.
+//  All rights reserved.
+//
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+//
+//  -----------
+
+package peb
+
+import (
+	"encoding/binary"
+	"errors"
+	"reflect"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/xaevman/win32/procmem"
+)
+
+// image is a synthetic, sparse process memory image.
+type image struct {
+	pages   map[uint64][]byte
+	ptrSize int
+}
+
+func newImage(ptrSize int) *image {
+	return &image{pages: make(map[uint64][]byte), ptrSize: ptrSize}
+}
+
+func (m *image) ReadMemory(addr uint64, p []byte) (int, error) {
+	for i := range p {
+		a := addr + uint64(i)
+		page, ok := m.pages[a&^(procmem.PageSize-1)]
+		if !ok {
+			return 0, errors.New("partial copy")
+		}
+
+		p[i] = page[a%procmem.PageSize]
+	}
+
+	return len(p), nil
+}
+
+func (m *image) write(addr uint64, data []byte) {
+	for i, b := range data {
+		a := addr + uint64(i)
+		base := a &^ (procmem.PageSize - 1)
+
+		page, ok := m.pages[base]
+		if !ok {
+			page = make([]byte, procmem.PageSize)
+			m.pages[base] = page
+		}
+
+		page[a-base] = b
+	}
+}
+
+func (m *image) u16(addr uint64, v uint16) {
+	var b [2]byte
+	binary.LittleEndian.PutUint16(b[:], v)
+	m.write(addr, b[:])
+}
+
+func (m *image) u32(addr uint64, v uint32) {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	m.write(addr, b[:])
+}
+
+func (m *image) ptr(addr, v uint64) {
+	if m.ptrSize == 4 {
+		m.u32(addr, uint32(v))
+		return
+	}
+
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], v)
+	m.write(addr, b[:])
+}
+
+func utf16le(s string) []byte {
+	var b []byte
+	for _, unit := range utf16.Encode([]rune(s)) {
+		b = append(b, byte(unit), byte(unit>>8))
+	}
+
+	return b
+}
+
+// str writes a UNICODE_STRING at addr whose buffer is at buf.
+func (m *image) str(addr, buf uint64, s string) {
+	data := utf16le(s)
+
+	m.u16(addr, uint16(len(data)))
+	m.u16(addr+2, uint16(len(data)+2))
+	m.ptr(addr+uint64(m.ptrSize), buf)
+	m.write(buf, append(data, 0, 0))
+}
+
+// offsets are the documented structure offsets for one bitness, written
+// out independently of the package's layout tables.
+type offsets struct {
+	pebImageBase, pebLdr, pebParams                               uint64
+	ldrInLoadOrder                                                uint64
+	dllBase, entryPoint, sizeOfImage, fullName, baseName          uint64
+	curDir, imagePath, cmdLine, environment, environmentSize, end uint64
+}
+
+var testOffsets = map[int]offsets{
+	4: {0x08, 0x0c, 0x10, 0x0c, 0x18, 0x1c, 0x20, 0x24, 0x2c, 0x24, 0x38, 0x40, 0x48, 0x290, 0x2a0},
+	8: {0x10, 0x18, 0x20, 0x10, 0x30, 0x38, 0x40, 0x48, 0x58, 0x38, 0x60, 0x70, 0x80, 0x3f0, 0x400},
+}
+
+const (
+	pebAddr     = 0x7ff0000
+	ldrAddr     = 0x100000
+	paramsAddr  = 0x200000
+	envAddr     = 0x300000
+	stringsAddr = 0x400000
+)
+
+var testEnv = []string{"=C:=C:\\work", "Path=C:\\Windows", "TEMP=C:\\Temp"}
+
+var testModules = []Module{
+	{Base: 0x400000, Size: 0x5000, EntryPoint: 0x401000, Name: "app.exe", Path: `C:\work\app.exe`},
+	{Base: 0x77000000, Size: 0x1a0000, EntryPoint: 0, Name: "ntdll.dll", Path: `C:\Windows\SYSTEM32\ntdll.dll`},
+}
+
+// buildImage writes a PEB with process parameters and a loader list. With
+// sized false, the parameters predate EnvironmentSize.
+func buildImage(ptrSize int, sized bool) *image {
+	m := newImage(ptrSize)
+	o := testOffsets[ptrSize]
+	next := uint64(stringsAddr)
+
+	alloc := func(s string) uint64 {
+		addr := next
+		next += uint64(len(utf16le(s))+2+15) &^ 15
+		return addr
+	}
+
+	m.ptr(pebAddr+o.pebImageBase, 0x400000)
+	m.ptr(pebAddr+o.pebLdr, ldrAddr)
+	m.ptr(pebAddr+o.pebParams, paramsAddr)
+
+	m.u32(paramsAddr, uint32(o.end))
+	m.u32(paramsAddr+4, uint32(o.end))
+	if !sized {
+		m.u32(paramsAddr+4, uint32(o.environmentSize))
+	}
+
+	m.str(paramsAddr+o.curDir, alloc(`C:\work\`), `C:\work\`)
+	m.str(paramsAddr+o.imagePath, alloc(`C:\work\app.exe`), `C:\work\app.exe`)
+	m.str(paramsAddr+o.cmdLine, alloc(`"C:\work\app.exe" -v`), `"C:\work\app.exe" -v`)
+
+	var block []byte
+	for _, kv := range testEnv {
+		block = append(block, utf16le(kv)...)
+		block = append(block, 0, 0)
+	}
+	block = append(block, 0, 0)
+
+	m.write(envAddr, block)
+	m.ptr(paramsAddr+o.environment, envAddr)
+	if sized {
+		m.ptr(paramsAddr+o.environmentSize, uint64(len(block)))
+	} else {
+		// garbage past the end of the short structure
+		m.ptr(paramsAddr+o.environmentSize, 0xdead)
+	}
+
+	// the list head is in PEB_LDR_DATA; each entry's Flink is its first
+	// field
+	head := uint64(ldrAddr + o.ldrInLoadOrder)
+	prev := head
+
+	for i, module := range testModules {
+		entry := uint64(0x500000 + 0x1000*i)
+
+		m.ptr(prev, entry)
+		m.ptr(entry+o.dllBase, module.Base)
+		m.ptr(entry+o.entryPoint, module.EntryPoint)
+		m.u32(entry+o.sizeOfImage, module.Size)
+		m.str(entry+o.fullName, alloc(module.Path), module.Path)
+		m.str(entry+o.baseName, alloc(module.Name), module.Name)
+
+		prev = entry
+	}
+
+	m.ptr(prev, head)
+
+	return m
+}
+
+func TestRead(t *testing.T) {
+	for _, ptrSize := range []int{4, 8} {
+		for _, sized := range []bool{true, false} {
+			m := buildImage(ptrSize, sized)
+
+			info, err := Read(procmem.New(m, nil), pebAddr, ptrSize)
+			if err != nil {
+				t.Fatalf("%d-byte pointers: %v", ptrSize, err)
+			}
+
+			want := &Info{
+				PEB:              pebAddr,
+				PtrSize:          ptrSize,
+				ImageBase:        0x400000,
+				ImagePath:        `C:\work\app.exe`,
+				CommandLine:      `"C:\work\app.exe" -v`,
+				CurrentDirectory: `C:\work\`,
+				Environment:      testEnv,
+				Modules:          testModules,
+			}
+
+			if !reflect.DeepEqual(info, want) {
+				t.Errorf("%d-byte pointers, sized %v:\n got %+v\nwant %+v", ptrSize, sized, info, want)
+			}
+		}
+	}
+}
+
+func TestReadCircularModules(t *testing.T) {
+	m := buildImage(8, true)
+	o := testOffsets[8]
+
+	// point the last entry back at the first rather than at the head
+	m.ptr(0x501000, 0x500000)
+
+	info, err := Read(procmem.New(m, nil), pebAddr, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(info.Modules) != maxModules {
+		t.Fatalf("got %d modules, want the limit %d", len(info.Modules), maxModules)
+	}
+
+	// a process that has not initialized its loader yet
+	m.ptr(pebAddr+o.pebLdr, 0)
+
+	info, err = Read(procmem.New(m, nil), pebAddr, 8)
+	if err != nil || info.Modules != nil {
+		t.Fatalf("Read without loader data = %v, %v", info.Modules, err)
+	}
+}
+
+func TestReadErrors(t *testing.T) {
+	m := buildImage(8, true)
+
+	if _, err := Read(procmem.New(m, nil), pebAddr, 2); err == nil {
+		t.Error("expected error for bad pointer size")
+	}
+
+	if _, err := Read(procmem.New(m, nil), 0x9990000, 8); err == nil {
+		t.Error("expected error for unreadable PEB")
+	}
+
+	// an unterminated environment running into an unreadable page
+	m.u32(paramsAddr+4, 0x100)
+	m.write(envAddr, []byte{'A', 0, '=', 0})
+	page := m.pages[envAddr]
+	for i := 4; i < len(page); i++ {
+		page[i] = 'x'
+	}
+
+	if _, err := Read(procmem.New(m, nil), pebAddr, 8); err == nil {
+		t.Error("expected error for unterminated environment")
+	}
+}
+
+func TestGetenv(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		ok    bool
+	}{
+		{"PATH", `C:\Windows`, true},
+		{"temp", `C:\Temp`, true},
+		{"=C:", `C:\work`, true},
+		{"HOME", "", false},
+	}
+
+	for _, test := range tests {
+		value, ok := Getenv(testEnv, test.name)
+		if value != test.value || ok != test.ok {
+			t.Errorf("Getenv(%q) = %q, %v", test.name, value, ok)
+		}
+	}
+}
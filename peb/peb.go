@@ -0,0 +1,394 @@
+//  ---------------------------------------------------------------------------
+//
+//  peb.go
+//
+//  Copyright (c) 2016, Jared Chavez.
+//  All rights reserved.
+//
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+//
+//  -----------
+
+// Package peb reads the process environment block of another process: its
+// command line, current directory, image path, environment and loaded
+// modules.
+package peb
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/xaevman/win32/procmem"
+)
+
+const (
+	// maxModules bounds the walk of the loader list, which a process that
+	// is corrupt or loading modules could leave circular.
+	maxModules = 4096
+
+	// maxEnvironment bounds the environment block, in bytes.
+	maxEnvironment = 1 << 20
+)
+
+// Module is an entry of the loader's in-load-order module list.
+type Module struct {
+	Base       uint64
+	Size       uint32
+	EntryPoint uint64
+	Name       string
+	Path       string
+}
+
+// Info is what Read decodes from a PEB.
+type Info struct {
+	// PEB is the address of the PEB, and PtrSize the pointer size of its
+	// layout: 4 for 32-bit and WOW64 processes, 8 for 64-bit ones.
+	PEB     uint64
+	PtrSize int
+
+	ImageBase        uint64
+	ImagePath        string
+	CommandLine      string
+	CurrentDirectory string
+
+	// Environment holds the NAME=value strings of the environment block,
+	// including the hidden "=C:=C:\dir" per-drive directories.
+	Environment []string
+
+	Modules []Module
+}
+
+// layout holds the field offsets of the structures Read walks. They are
+// the same from Windows Vista on.
+type layout struct {
+	ptrSize int
+
+	// PEB
+	imageBase  uint64
+	ldr        uint64
+	parameters uint64
+
+	// PEB_LDR_DATA
+	inLoadOrder uint64
+
+	// LDR_DATA_TABLE_ENTRY
+	dllBase     uint64
+	entryPoint  uint64
+	sizeOfImage uint64
+	fullDllName uint64
+	baseDllName uint64
+
+	// RTL_USER_PROCESS_PARAMETERS
+	paramsLength     uint64
+	currentDirectory uint64
+	imagePathName    uint64
+	commandLine      uint64
+	environment      uint64
+	environmentSize  uint64
+
+	// UNICODE_STRING
+	stringBuffer uint64
+}
+
+var layout32 = layout{
+	ptrSize:          4,
+	imageBase:        0x08,
+	ldr:              0x0c,
+	parameters:       0x10,
+	inLoadOrder:      0x0c,
+	dllBase:          0x18,
+	entryPoint:       0x1c,
+	sizeOfImage:      0x20,
+	fullDllName:      0x24,
+	baseDllName:      0x2c,
+	paramsLength:     0x04,
+	currentDirectory: 0x24,
+	imagePathName:    0x38,
+	commandLine:      0x40,
+	environment:      0x48,
+	environmentSize:  0x290,
+	stringBuffer:     0x04,
+}
+
+var layout64 = layout{
+	ptrSize:          8,
+	imageBase:        0x10,
+	ldr:              0x18,
+	parameters:       0x20,
+	inLoadOrder:      0x10,
+	dllBase:          0x30,
+	entryPoint:       0x38,
+	sizeOfImage:      0x40,
+	fullDllName:      0x48,
+	baseDllName:      0x58,
+	paramsLength:     0x04,
+	currentDirectory: 0x38,
+	imagePathName:    0x60,
+	commandLine:      0x70,
+	environment:      0x80,
+	environmentSize:  0x3f0,
+	stringBuffer:     0x08,
+}
+
+// reader decodes one layout from process memory.
+type reader struct {
+	mem *procmem.ProcessMemory
+	layout
+}
+
+// Read decodes the PEB at addr, with the layout of a process whose pointers
+// are ptrSize bytes. The memory of a running process can change while it is
+// read, so a module list caught mid-update may come back short.
+func Read(mem *procmem.ProcessMemory, addr uint64, ptrSize int) (*Info, error) {
+	r := reader{mem: mem}
+
+	switch ptrSize {
+	case 4:
+		r.layout = layout32
+	case 8:
+		r.layout = layout64
+	default:
+		return nil, fmt.Errorf("peb: bad pointer size %d", ptrSize)
+	}
+
+	info := &Info{PEB: addr, PtrSize: ptrSize}
+
+	var err error
+	if info.ImageBase, err = r.pointer(addr + r.imageBase); err != nil {
+		return nil, fmt.Errorf("peb: reading PEB: %v", err)
+	}
+
+	params, err := r.pointer(addr + r.parameters)
+	if err != nil {
+		return nil, fmt.Errorf("peb: reading PEB: %v", err)
+	}
+
+	if err := r.readParameters(info, params); err != nil {
+		return nil, fmt.Errorf("peb: reading process parameters: %v", err)
+	}
+
+	ldr, err := r.pointer(addr + r.ldr)
+	if err != nil {
+		return nil, fmt.Errorf("peb: reading PEB: %v", err)
+	}
+
+	// the loader data is not set up until the process has started
+	// initializing
+	if ldr != 0 {
+		if info.Modules, err = r.readModules(ldr + r.inLoadOrder); err != nil {
+			return nil, fmt.Errorf("peb: reading loader data: %v", err)
+		}
+	}
+
+	return info, nil
+}
+
+func (r *reader) pointer(addr uint64) (uint64, error) {
+	if r.ptrSize == 4 {
+		v, err := r.mem.ReadUint32(addr)
+		return uint64(v), err
+	}
+
+	return r.mem.ReadUint64(addr)
+}
+
+// unicodeString reads the UNICODE_STRING at addr.
+func (r *reader) unicodeString(addr uint64) (string, error) {
+	length, err := r.mem.ReadUint16(addr)
+	if err != nil {
+		return "", err
+	}
+
+	buffer, err := r.pointer(addr + r.stringBuffer)
+	if err != nil {
+		return "", err
+	}
+
+	if length == 0 || buffer == 0 {
+		return "", nil
+	}
+
+	return r.mem.ReadUTF16N(buffer, int(length)/2)
+}
+
+func (r *reader) readParameters(info *Info, params uint64) error {
+	strs := []struct {
+		offset uint64
+		dst    *string
+	}{
+		{r.imagePathName, &info.ImagePath},
+		{r.commandLine, &info.CommandLine},
+		{r.currentDirectory, &info.CurrentDirectory},
+	}
+
+	for _, str := range strs {
+		var err error
+		if *str.dst, err = r.unicodeString(params + str.offset); err != nil {
+			return err
+		}
+	}
+
+	env, err := r.pointer(params + r.environment)
+	if err != nil || env == 0 {
+		return err
+	}
+
+	block, err := r.readEnvironment(params, env)
+	if err != nil {
+		return fmt.Errorf("reading environment: %v", err)
+	}
+
+	info.Environment = splitEnvironment(block)
+
+	return nil
+}
+
+// readEnvironment reads the environment block at env, sized by
+// EnvironmentSize when the parameters are long enough to hold it.
+func (r *reader) readEnvironment(params, env uint64) ([]uint16, error) {
+	length, err := r.mem.ReadUint32(params + r.paramsLength)
+	if err != nil {
+		return nil, err
+	}
+
+	var size uint64
+	if uint64(length) >= r.environmentSize+uint64(r.ptrSize) {
+		if size, err = r.pointer(params + r.environmentSize); err != nil {
+			return nil, err
+		}
+	}
+
+	if size == 0 || size > maxEnvironment {
+		return r.scanEnvironment(env)
+	}
+
+	data, err := r.mem.ReadFull(env, int(size&^1))
+	if err != nil {
+		return nil, err
+	}
+
+	return bytesToUTF16(data), nil
+}
+
+// scanEnvironment reads the environment block at env up to its
+// terminating empty string.
+func (r *reader) scanEnvironment(env uint64) ([]uint16, error) {
+	var block []uint16
+
+	buf := make([]byte, procmem.PageSize)
+
+	for addr := env; len(block) < maxEnvironment/2; {
+		n, err := r.mem.ReadAt(buf[:procmem.PageSize-addr%procmem.PageSize], int64(addr))
+		if n == 0 {
+			return nil, err
+		}
+
+		for _, unit := range bytesToUTF16(buf[:n]) {
+			if unit == 0 && (len(block) == 0 || block[len(block)-1] == 0) {
+				return block, nil
+			}
+
+			block = append(block, unit)
+		}
+
+		addr += uint64(n &^ 1)
+	}
+
+	return block, nil
+}
+
+func (r *reader) readModules(head uint64) ([]Module, error) {
+	var modules []Module
+
+	entry, err := r.pointer(head)
+	if err != nil {
+		return nil, err
+	}
+
+	for entry != head && entry != 0 && len(modules) < maxModules {
+		var module Module
+
+		if module.Base, err = r.pointer(entry + r.dllBase); err != nil {
+			return modules, err
+		}
+
+		if module.EntryPoint, err = r.pointer(entry + r.entryPoint); err != nil {
+			return modules, err
+		}
+
+		if module.Size, err = r.mem.ReadUint32(entry + r.sizeOfImage); err != nil {
+			return modules, err
+		}
+
+		if module.Path, err = r.unicodeString(entry + r.fullDllName); err != nil {
+			return modules, err
+		}
+
+		if module.Name, err = r.unicodeString(entry + r.baseDllName); err != nil {
+			return modules, err
+		}
+
+		modules = append(modules, module)
+
+		// InLoadOrderLinks is the first field, so Flink is the next
+		// entry's address
+		if entry, err = r.pointer(entry); err != nil {
+			return modules, err
+		}
+	}
+
+	return modules, nil
+}
+
+func bytesToUTF16(data []byte) []uint16 {
+	units := make([]uint16, len(data)/2)
+	for i := range units {
+		units[i] = uint16(data[2*i]) | uint16(data[2*i+1])<<8
+	}
+
+	return units
+}
+
+// splitEnvironment splits a block of NUL-terminated strings, ending with an
+// empty one.
+func splitEnvironment(block []uint16) []string {
+	var env []string
+
+	for len(block) > 0 {
+		end := 0
+		for end < len(block) && block[end] != 0 {
+			end++
+		}
+
+		if end == 0 {
+			break
+		}
+
+		env = append(env, string(utf16.Decode(block[:end])))
+
+		if end == len(block) {
+			break
+		}
+
+		block = block[end+1:]
+	}
+
+	return env
+}
+
+// Getenv returns the value of the named variable in env, which holds
+// NAME=value strings. Names are matched without regard to case, as Windows
+// does.
+func Getenv(env []string, name string) (string, bool) {
+	for _, kv := range env {
+		// skip the leading '=' of the per-drive directories
+		eq := strings.IndexByte(kv[1:], '=') + 1
+		if eq > 0 && strings.EqualFold(kv[:eq], name) {
+			return kv[eq+1:], true
+		}
+	}
+
+	return "", false
+}
@@ -0,0 +1,83 @@
+//  ---------------------------------------------------------------------------
+//
+//  peb_windows.go
+//
+//  Copyright (c) 2016, Jared Chavez.
+//  All rights reserved.
+//
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+//
+//  -----------
+
+package peb
+
+import (
+	"errors"
+	"syscall"
+	"unsafe"
+
+	"github.com/xaevman/win32/kernel32"
+	"github.com/xaevman/win32/ntdll"
+	"github.com/xaevman/win32/procmem"
+)
+
+// ErrNativeFromWow64 is returned when a 32-bit process running under WOW64
+// reads a 64-bit process, whose PEB it cannot address.
+var ErrNativeFromWow64 = errors.New("peb: a WOW64 process cannot read a 64-bit PEB")
+
+// processAccess covers the NtQueryInformationProcess queries and reading the
+// structures.
+const processAccess = kernel32.PROCESS_QUERY_LIMITED_INFORMATION | kernel32.PROCESS_VM_READ
+
+// ReadProcess reads the PEB of process pid.
+func ReadProcess(pid uint32) (*Info, error) {
+	proc, err := kernel32.OpenProcess(pid, processAccess)
+	if err != nil {
+		return nil, err
+	}
+	defer syscall.CloseHandle(proc)
+
+	return ReadHandle(proc)
+}
+
+// ReadHandle reads the PEB of proc, which needs
+// PROCESS_QUERY_LIMITED_INFORMATION and PROCESS_VM_READ access. For a WOW64
+// process the 32-bit PEB is read, whose loader list holds the 32-bit
+// modules.
+func ReadHandle(proc syscall.Handle) (*Info, error) {
+	mem := procmem.FromHandle(proc, nil)
+
+	peb32, err := ntdll.QueryWow64Information(proc)
+	if err != nil {
+		return nil, err
+	}
+
+	if peb32 != 0 {
+		return Read(mem, uint64(peb32), 4)
+	}
+
+	ptrSize := int(unsafe.Sizeof(uintptr(0)))
+	if ptrSize == 4 {
+		current, err := syscall.GetCurrentProcess()
+		if err != nil {
+			return nil, err
+		}
+
+		self, err := ntdll.QueryWow64Information(current)
+		if err != nil {
+			return nil, err
+		}
+
+		if self != 0 {
+			return nil, ErrNativeFromWow64
+		}
+	}
+
+	basic, err := ntdll.QueryBasicInformation(proc)
+	if err != nil {
+		return nil, err
+	}
+
+	return Read(mem, uint64(basic.PebBaseAddress), ptrSize)
+}
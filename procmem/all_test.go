@@ -0,0 +1,231 @@
+//  ---------------------------------------------------------------------------
+//
+//  all_test.go
+//
+//  Copyright (c) 2016, Jared Chavez.
+//  All rights reserved.
+//
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+//
+//  -----------
+
+package procmem
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+var errPartialCopy = errors.New("partial copy")
+
+// fakeRaw is an address space of readable pages that, like
+// ReadProcessMemory, fails a whole read that touches an unreadable page.
+type fakeRaw struct {
+	pages map[uint64][]byte
+	reads int
+}
+
+func newFakeRaw() *fakeRaw {
+	return &fakeRaw{pages: make(map[uint64][]byte)}
+}
+
+// fill makes the pages of [addr, addr+len(data)) readable, holding data.
+func (f *fakeRaw) fill(addr uint64, data []byte) {
+	for i, b := range data {
+		a := addr + uint64(i)
+		base := a &^ (PageSize - 1)
+
+		page, ok := f.pages[base]
+		if !ok {
+			page = make([]byte, PageSize)
+			f.pages[base] = page
+		}
+
+		page[a-base] = b
+	}
+}
+
+func (f *fakeRaw) ReadMemory(addr uint64, p []byte) (int, error) {
+	f.reads++
+
+	for i := range p {
+		a := addr + uint64(i)
+		page, ok := f.pages[a&^(PageSize-1)]
+		if !ok {
+			return 0, errPartialCopy
+		}
+
+		p[i] = page[a%PageSize]
+	}
+
+	return len(p), nil
+}
+
+func TestReadAt(t *testing.T) {
+	raw := newFakeRaw()
+	raw.fill(0x10000, bytes.Repeat([]byte{0xaa}, 2*PageSize))
+
+	m := New(raw, nil)
+
+	buf := make([]byte, 16)
+	n, err := m.ReadAt(buf, 0x10010)
+	if err != nil || n != 16 || buf[0] != 0xaa {
+		t.Fatalf("ReadAt = %d, %v", n, err)
+	}
+
+	// runs 0x20 bytes past the last readable page
+	buf = make([]byte, 0x40)
+	n, err = m.ReadAt(buf, 0x12000-0x20)
+	if n != 0x20 {
+		t.Fatalf("partial ReadAt read %#x bytes, want 0x20", n)
+	}
+
+	var fault *FaultError
+	if !errors.As(err, &fault) || fault.Addr != 0x12000 || !errors.Is(err, errPartialCopy) {
+		t.Fatalf("partial ReadAt error = %v", err)
+	}
+
+	if _, err := m.ReadAt(buf, -1); err == nil {
+		t.Fatal("expected error for negative offset")
+	}
+}
+
+func TestReadAtCached(t *testing.T) {
+	raw := newFakeRaw()
+	raw.fill(0x10000, bytes.Repeat([]byte{1}, 3*PageSize))
+
+	m := New(raw, &Options{CachePages: 2})
+
+	buf := make([]byte, 8)
+	for i := 0; i < 10; i++ {
+		if _, err := m.ReadAt(buf, int64(0x10000+8*i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if raw.reads != 1 {
+		t.Fatalf("%d reads of a cached page, want 1", raw.reads)
+	}
+
+	// spanning two pages loads the second
+	buf = make([]byte, 0x20)
+	if _, err := m.ReadAt(buf, 0x11000-0x10); err != nil {
+		t.Fatal(err)
+	}
+
+	if raw.reads != 2 {
+		t.Fatalf("%d reads, want 2", raw.reads)
+	}
+
+	// a third page evicts the least recently used, the first
+	if _, err := m.ReadAt(buf[:8], 0x12000); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := m.ReadAt(buf[:8], 0x10000); err != nil {
+		t.Fatal(err)
+	}
+
+	if raw.reads != 4 {
+		t.Fatalf("%d reads after eviction, want 4", raw.reads)
+	}
+
+	// the cache serves stale data until flushed
+	raw.fill(0x10000, []byte{2})
+	if _, err := m.ReadAt(buf[:1], 0x10000); err != nil || buf[0] != 1 {
+		t.Fatalf("cached read = %d, %v", buf[0], err)
+	}
+
+	m.Flush()
+	if _, err := m.ReadAt(buf[:1], 0x10000); err != nil || buf[0] != 2 {
+		t.Fatalf("read after Flush = %d, %v", buf[0], err)
+	}
+
+	// unreadable pages fault, and are not cached
+	n, err := m.ReadAt(buf, 0x13000-0x10)
+	if n != 0x10 || err == nil {
+		t.Fatalf("partial cached read = %#x, %v", n, err)
+	}
+}
+
+func TestTypedReads(t *testing.T) {
+	raw := newFakeRaw()
+	raw.fill(0x1000, []byte{
+		0x01, 0x02, 0x03, 0x04, 0x05, 0x06, 0x07, 0x08,
+	})
+
+	m := New(raw, &Options{PtrSize: 4})
+
+	if v, err := m.ReadUint16(0x1000); err != nil || v != 0x0201 {
+		t.Errorf("ReadUint16 = %#x, %v", v, err)
+	}
+
+	if v, err := m.ReadUint32(0x1000); err != nil || v != 0x04030201 {
+		t.Errorf("ReadUint32 = %#x, %v", v, err)
+	}
+
+	if v, err := m.ReadUint64(0x1000); err != nil || v != 0x0807060504030201 {
+		t.Errorf("ReadUint64 = %#x, %v", v, err)
+	}
+
+	if v, err := m.ReadPointer(0x1000); err != nil || v != 0x04030201 {
+		t.Errorf("ReadPointer (4) = %#x, %v", v, err)
+	}
+
+	m = New(raw, &Options{PtrSize: 8})
+	if v, err := m.ReadPointer(0x1000); err != nil || v != 0x0807060504030201 {
+		t.Errorf("ReadPointer (8) = %#x, %v", v, err)
+	}
+
+	if _, err := m.ReadUint32(0x2000); err == nil {
+		t.Error("expected error reading an unreadable page")
+	}
+}
+
+func utf16le(s string) []byte {
+	var b []byte
+	for _, r := range s {
+		b = append(b, byte(r), byte(r>>8))
+	}
+
+	return b
+}
+
+func TestReadUTF16(t *testing.T) {
+	raw := newFakeRaw()
+
+	// a terminated string ending just before an unreadable page
+	str := append(utf16le("C:\\app.exe"), 0, 0)
+	raw.fill(0x2000-uint64(len(str)), str)
+
+	// an unterminated string running into an unreadable page
+	raw.fill(0x5000-8, utf16le("abcd"))
+
+	// an odd address, with a unit straddling the page boundary
+	raw.fill(0x7fff, append(utf16le("xyz"), 0, 0))
+
+	m := New(raw, nil)
+
+	s, err := m.ReadUTF16(0x2000-uint64(len(str)), 260)
+	if err != nil || s != `C:\app.exe` {
+		t.Fatalf("ReadUTF16 = %q, %v", s, err)
+	}
+
+	if s, err := m.ReadUTF16(0x5000-8, 2); err != nil || s != "ab" {
+		t.Fatalf("ReadUTF16 limited = %q, %v", s, err)
+	}
+
+	if _, err := m.ReadUTF16(0x5000-8, 260); err == nil {
+		t.Fatal("expected error for unterminated string")
+	}
+
+	if s, err := m.ReadUTF16(0x7fff, 260); err != nil || s != "xyz" {
+		t.Fatalf("ReadUTF16 straddling = %q, %v", s, err)
+	}
+
+	if s, err := m.ReadUTF16N(0x5000-8, 3); err != nil || s != "abc" {
+		t.Fatalf("ReadUTF16N = %q, %v", s, err)
+	}
+}
@@ -0,0 +1,73 @@
+//  ---------------------------------------------------------------------------
+//
+//  cache.go
+//
+//  Copyright (c) 2016, Jared Chavez.
+//  All rights reserved.
+//
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+//
+//  -----------
+
+package procmem
+
+import (
+	"container/list"
+)
+
+// pageCache is an LRU cache of pages keyed by base address.
+type pageCache struct {
+	max   int
+	order *list.List // of *cachedPage, most recently used first
+	pages map[uint64]*list.Element
+}
+
+type cachedPage struct {
+	base uint64
+	data []byte
+}
+
+func newPageCache(max int) *pageCache {
+	return &pageCache{
+		max:   max,
+		order: list.New(),
+		pages: make(map[uint64]*list.Element),
+	}
+}
+
+func (c *pageCache) get(base uint64) ([]byte, bool) {
+	elem, ok := c.pages[base]
+	if !ok {
+		return nil, false
+	}
+
+	c.order.MoveToFront(elem)
+
+	return elem.Value.(*cachedPage).data, true
+}
+
+// buffer returns a page buffer for a read that will be passed to put,
+// reusing the least recently used page once the cache is full.
+func (c *pageCache) buffer() []byte {
+	if c.order.Len() < c.max {
+		return make([]byte, PageSize)
+	}
+
+	elem := c.order.Back()
+	page := elem.Value.(*cachedPage)
+
+	c.order.Remove(elem)
+	delete(c.pages, page.base)
+
+	return page.data
+}
+
+func (c *pageCache) put(base uint64, data []byte) {
+	c.pages[base] = c.order.PushFront(&cachedPage{base: base, data: data})
+}
+
+func (c *pageCache) reset() {
+	c.order.Init()
+	c.pages = make(map[uint64]*list.Element)
+}
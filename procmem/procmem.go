@@ -0,0 +1,306 @@
+//  ---------------------------------------------------------------------------
+//
+//  procmem.go
+//
+//  Copyright (c) 2016, Jared Chavez.
+//  All rights reserved.
+//
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+//
+//  -----------
+
+// Package procmem reads the memory of another process through io.ReaderAt,
+// with offsets interpreted as virtual addresses.
+package procmem
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"unicode/utf16"
+	"unsafe"
+)
+
+// PageSize is the granularity at which memory is readable or not.
+const PageSize = 4096
+
+// RawReader reads memory with a single call, as ReadProcessMemory does: a
+// read that reaches an unreadable page fails, and may or may not report the
+// prefix it read.
+type RawReader interface {
+	ReadMemory(addr uint64, p []byte) (int, error)
+}
+
+// FaultError reports the first unreadable address of a read.
+type FaultError struct {
+	Addr uint64
+	Err  error
+}
+
+func (e *FaultError) Error() string {
+	return fmt.Sprintf("procmem: reading 0x%x: %v", e.Addr, e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *FaultError) Unwrap() error {
+	return e.Err
+}
+
+// Options configure a ProcessMemory. A nil *Options uses the defaults.
+type Options struct {
+	// PtrSize is the pointer size of the target, 4 or 8, which differs
+	// from ours for a WOW64 target. Zero means the size of ours.
+	PtrSize int
+
+	// CachePages, when positive, keeps up to that many pages in an LRU
+	// cache. Only cache a target that is suspended or stopped, and call
+	// Flush when it runs again.
+	CachePages int
+}
+
+// ProcessMemory reads the memory of a process. It is safe for concurrent
+// use.
+type ProcessMemory struct {
+	raw     RawReader
+	ptrSize int
+
+	mu    sync.Mutex
+	cache *pageCache
+}
+
+var _ io.ReaderAt = (*ProcessMemory)(nil)
+
+// New returns a ProcessMemory reading through raw.
+func New(raw RawReader, opts *Options) *ProcessMemory {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	m := &ProcessMemory{raw: raw, ptrSize: opts.PtrSize}
+	if m.ptrSize == 0 {
+		m.ptrSize = int(unsafe.Sizeof(uintptr(0)))
+	}
+
+	if opts.CachePages > 0 {
+		m.cache = newPageCache(opts.CachePages)
+	}
+
+	return m
+}
+
+// PtrSize returns the pointer size of the target.
+func (m *ProcessMemory) PtrSize() int {
+	return m.ptrSize
+}
+
+// Flush empties the page cache.
+func (m *ProcessMemory) Flush() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.cache != nil {
+		m.cache.reset()
+	}
+}
+
+// ReadAt reads len(p) bytes at address off. When part of the range is
+// unreadable, it returns the readable prefix and a *FaultError.
+func (m *ProcessMemory) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, errors.New("procmem: negative address")
+	}
+
+	addr := uint64(off)
+
+	if m.cache != nil {
+		return m.readCached(p, addr)
+	}
+
+	n, err := m.raw.ReadMemory(addr, p)
+	if err == nil && n == len(p) {
+		return n, nil
+	}
+
+	// the read crossed an unreadable page; retry a page at a time to find
+	// how much of it is readable
+	return m.readPages(p, addr)
+}
+
+func (m *ProcessMemory) readPages(p []byte, addr uint64) (int, error) {
+	n := 0
+
+	for n < len(p) {
+		page := p[n:pageEnd(addr+uint64(n), n, len(p))]
+
+		got, err := m.raw.ReadMemory(addr+uint64(n), page)
+		if err == nil && got < len(page) {
+			err = io.ErrUnexpectedEOF
+		}
+
+		if err != nil {
+			return n, &FaultError{Addr: addr + uint64(n), Err: err}
+		}
+
+		n += got
+	}
+
+	return n, nil
+}
+
+func (m *ProcessMemory) readCached(p []byte, addr uint64) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	n := 0
+
+	for n < len(p) {
+		cur := addr + uint64(n)
+		base := cur &^ (PageSize - 1)
+
+		data, err := m.page(base)
+		if err != nil {
+			return n, &FaultError{Addr: cur, Err: err}
+		}
+
+		n += copy(p[n:], data[cur-base:])
+	}
+
+	return n, nil
+}
+
+// page returns the page at base from the cache, reading it on a miss.
+// Unreadable pages are not cached.
+func (m *ProcessMemory) page(base uint64) ([]byte, error) {
+	if data, ok := m.cache.get(base); ok {
+		return data, nil
+	}
+
+	data := m.cache.buffer()
+
+	got, err := m.raw.ReadMemory(base, data)
+	if err == nil && got < len(data) {
+		err = io.ErrUnexpectedEOF
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	m.cache.put(base, data)
+
+	return data, nil
+}
+
+// pageEnd returns the index in p, at which address addr is read into p[n],
+// of the next page boundary or of the end of p.
+func pageEnd(addr uint64, n, size int) int {
+	end := n + int(PageSize-addr%PageSize)
+	if end > size {
+		end = size
+	}
+
+	return end
+}
+
+// ReadFull reads exactly size bytes at addr.
+func (m *ProcessMemory) ReadFull(addr uint64, size int) ([]byte, error) {
+	buf := make([]byte, size)
+
+	if _, err := m.ReadAt(buf, int64(addr)); err != nil {
+		return nil, err
+	}
+
+	return buf, nil
+}
+
+// ReadUint16 reads a little-endian uint16 at addr.
+func (m *ProcessMemory) ReadUint16(addr uint64) (uint16, error) {
+	var buf [2]byte
+	if _, err := m.ReadAt(buf[:], int64(addr)); err != nil {
+		return 0, err
+	}
+
+	return binary.LittleEndian.Uint16(buf[:]), nil
+}
+
+// ReadUint32 reads a little-endian uint32 at addr.
+func (m *ProcessMemory) ReadUint32(addr uint64) (uint32, error) {
+	var buf [4]byte
+	if _, err := m.ReadAt(buf[:], int64(addr)); err != nil {
+		return 0, err
+	}
+
+	return binary.LittleEndian.Uint32(buf[:]), nil
+}
+
+// ReadUint64 reads a little-endian uint64 at addr.
+func (m *ProcessMemory) ReadUint64(addr uint64) (uint64, error) {
+	var buf [8]byte
+	if _, err := m.ReadAt(buf[:], int64(addr)); err != nil {
+		return 0, err
+	}
+
+	return binary.LittleEndian.Uint64(buf[:]), nil
+}
+
+// ReadPointer reads a pointer of the target's size at addr.
+func (m *ProcessMemory) ReadPointer(addr uint64) (uint64, error) {
+	if m.ptrSize == 4 {
+		v, err := m.ReadUint32(addr)
+		return uint64(v), err
+	}
+
+	return m.ReadUint64(addr)
+}
+
+// ReadUTF16 reads a NUL-terminated UTF-16 string of at most maxChars code
+// units at addr. Memory past the terminator is not read beyond the page it
+// lies in, so a string at the end of a region can be read.
+func (m *ProcessMemory) ReadUTF16(addr uint64, maxChars int) (string, error) {
+	var units []uint16
+
+	for len(units) < maxChars {
+		cur := addr + uint64(2*len(units))
+
+		size := pageEnd(cur, 0, 2*(maxChars-len(units)))
+		if size < 2 {
+			// a unit straddling the page boundary
+			size = 2
+		}
+
+		buf := make([]byte, size&^1)
+		if _, err := m.ReadAt(buf, int64(cur)); err != nil {
+			return "", err
+		}
+
+		for i := 0; i < len(buf); i += 2 {
+			unit := binary.LittleEndian.Uint16(buf[i:])
+			if unit == 0 {
+				return string(utf16.Decode(units)), nil
+			}
+
+			units = append(units, unit)
+		}
+	}
+
+	return string(utf16.Decode(units)), nil
+}
+
+// ReadUTF16N reads a UTF-16 string of exactly chars code units at addr, as
+// described by a UNICODE_STRING.
+func (m *ProcessMemory) ReadUTF16N(addr uint64, chars int) (string, error) {
+	buf, err := m.ReadFull(addr, 2*chars)
+	if err != nil {
+		return "", err
+	}
+
+	units := make([]uint16, chars)
+	for i := range units {
+		units[i] = binary.LittleEndian.Uint16(buf[2*i:])
+	}
+
+	return string(utf16.Decode(units)), nil
+}
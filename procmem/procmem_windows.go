@@ -0,0 +1,33 @@
+//  ---------------------------------------------------------------------------
+//
+//  procmem_windows.go
+//
+//  Copyright (c) 2016, Jared Chavez.
+//  All rights reserved.
+//
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+//
+//  -----------
+
+package procmem
+
+import (
+	"syscall"
+
+	"github.com/xaevman/win32/kernel32"
+)
+
+// Handle is a RawReader over a process handle opened with PROCESS_VM_READ.
+type Handle syscall.Handle
+
+// ReadMemory implements RawReader.
+func (h Handle) ReadMemory(addr uint64, p []byte) (int, error) {
+	return kernel32.ReadProcessMemoryInto(syscall.Handle(h), addr, p)
+}
+
+// FromHandle returns a ProcessMemory reading the process proc, which must
+// have been opened with PROCESS_VM_READ.
+func FromHandle(proc syscall.Handle, opts *Options) *ProcessMemory {
+	return New(Handle(proc), opts)
+}
@@ -26,6 +26,7 @@ var (
 	psEnumProcessModulesEx = psapiDll.NewProc("EnumProcessModulesEx")
 	psGetModuleBaseName    = psapiDll.NewProc("GetModuleBaseNameW")
 	psGetModuleFileNameEx  = psapiDll.NewProc("GetModuleFileNameExW")
+	psGetMappedFileName    = psapiDll.NewProc("GetMappedFileNameW")
 	psGetModuleInformation = psapiDll.NewProc("GetModuleInformation")
 )
 
@@ -100,6 +101,33 @@ func GetModuleFileNameEx(proc, module syscall.Handle) (string, error) {
 	return syscall.UTF16ToString(buffer), nil
 }
 
+// DWORD WINAPI GetMappedFileName(
+//   _In_  HANDLE hProcess,
+//   _In_  LPVOID lpv,
+//   _Out_ LPTSTR lpFilename,
+//   _In_  DWORD  nSize
+// );
+// fail == 0
+//
+// The name is an NT device path such as
+// \Device\HarddiskVolume3\Windows\System32\ntdll.dll.
+func GetMappedFileName(proc syscall.Handle, addr uintptr) (string, error) {
+	buffer := make([]uint16, 1024)
+
+	ret, _, err := psGetMappedFileName.Call(
+		uintptr(proc),
+		addr,
+		uintptr(unsafe.Pointer(&buffer[0])),
+		uintptr(len(buffer)),
+	)
+
+	if ret == 0 {
+		return "", err
+	}
+
+	return syscall.UTF16ToString(buffer[:ret]), nil
+}
+
 // BOOL WINAPI GetModuleInformation(
 //   _In_  HANDLE       hProcess,
 //   _In_  HMODULE      hModule,
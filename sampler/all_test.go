@@ -0,0 +1,440 @@
+//  ---------------------------------------------------------------------------
+//
+//  all_test.go
+//
+//  Copyright (c) 2016, Jared Chavez.
+//  All rights reserved.
+//
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+//
+//  -----------
+
+package sampler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/xaevman/win32/stacks"
+)
+
+var symbols = map[uint64]stacks.Frame{
+	0x1000: {Module: "app", Function: "main"},
+	0x2000: {Module: "app", Function: "work"},
+	0x3000: {Module: "ntdll", Function: "NtWaitForSingleObject"},
+	0x4000: {Module: "app", Function: "std::map<int;int>::find", File: "map.h", Line: 12},
+}
+
+func resolve(pc uint64) stacks.Frame {
+	return symbols[pc]
+}
+
+func syntheticProfile() *Profile {
+	p := NewProfile(10 * time.Millisecond)
+
+	for i := 0; i < 5; i++ {
+		p.Add(1, []uint64{0x2000, 0x1000})
+	}
+	for i := 0; i < 3; i++ {
+		p.Add(2, []uint64{0x2000, 0x1000})
+	}
+	p.Add(1, []uint64{0x4000, 0x2000, 0x1000})
+	p.Add(2, []uint64{0x3000})
+	p.Add(2, []uint64{0x5000})
+	p.SetThreadName(1, "worker")
+
+	p.Symbolize(resolve)
+
+	return p
+}
+
+func TestAggregation(t *testing.T) {
+	p := syntheticProfile()
+
+	if p.Samples() != 11 {
+		t.Fatalf("Samples = %d, want 11", p.Samples())
+	}
+
+	// identical stacks are deduplicated per thread
+	if len(p.samples) != 5 {
+		t.Fatalf("distinct samples = %d, want 5", len(p.samples))
+	}
+
+	if pcs := p.PCs(); len(pcs) != 5 || pcs[0] != 0x1000 || pcs[4] != 0x5000 {
+		t.Fatalf("PCs = %x", pcs)
+	}
+}
+
+func TestWriteFolded(t *testing.T) {
+	var buf bytes.Buffer
+	err := syntheticProfile().WriteFolded(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "0x5000 1\n" +
+		"app!main;app!work 8\n" +
+		"app!main;app!work;app!std::map<int:int>::find 1\n" +
+		"ntdll!NtWaitForSingleObject 1\n"
+
+	if buf.String() != want {
+		t.Fatalf("folded output:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestWritePprof(t *testing.T) {
+	var buf bytes.Buffer
+	err := syntheticProfile().WritePprof(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	zr, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	prof := decodeProto(t, raw)
+
+	strs := make([]string, 0)
+	for _, s := range prof[fieldProfileStringTable] {
+		strs = append(strs, string(s.([]byte)))
+	}
+
+	if len(strs) == 0 || strs[0] != "" {
+		t.Fatalf("string table must start with empty string: %q", strs)
+	}
+
+	if n := len(prof[fieldProfileSampleType]); n != 2 {
+		t.Fatalf("sample types = %d, want 2", n)
+	}
+
+	if n := len(prof[fieldProfileLocation]); n != 5 {
+		t.Fatalf("locations = %d, want 5", n)
+	}
+
+	if n := len(prof[fieldProfileFunction]); n != 5 {
+		t.Fatalf("functions = %d, want 5", n)
+	}
+
+	if prof[fieldProfilePeriod][0].(uint64) != uint64(10*time.Millisecond) {
+		t.Fatalf("period = %v", prof[fieldProfilePeriod])
+	}
+
+	samples := prof[fieldProfileSample]
+	if len(samples) != 5 {
+		t.Fatalf("samples = %d, want 5", len(samples))
+	}
+
+	first := decodeProto(t, samples[0].([]byte))
+	locs := decodePacked(t, first[fieldSampleLocationID][0].([]byte))
+	vals := decodePacked(t, first[fieldSampleValue][0].([]byte))
+
+	if len(locs) != 2 || locs[0] != 1 || locs[1] != 2 {
+		t.Fatalf("first sample locations = %v", locs)
+	}
+
+	if len(vals) != 2 || vals[0] != 5 || vals[1] != uint64(50*time.Millisecond) {
+		t.Fatalf("first sample values = %v", vals)
+	}
+
+	label := decodeProto(t, first[fieldSampleLabel][0].([]byte))
+	key := strs[label[fieldLabelKey][0].(uint64)]
+	val := strs[label[fieldLabelStr][0].(uint64)]
+	if key != "thread" || val != "worker" {
+		t.Fatalf("thread label = %s:%s", key, val)
+	}
+
+	found := false
+	for _, s := range strs {
+		if s == "app!std::map<int;int>::find" {
+			found = true
+		}
+	}
+
+	if !found {
+		t.Fatalf("function name missing from string table: %q", strs)
+	}
+}
+
+func TestWritePprofCPU(t *testing.T) {
+	for _, cpu := range []bool{false, true} {
+		p := syntheticProfile()
+		p.CPU = cpu
+
+		var buf bytes.Buffer
+		if err := p.WritePprof(&buf); err != nil {
+			t.Fatal(err)
+		}
+
+		zr, err := gzip.NewReader(&buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		raw, err := ioutil.ReadAll(zr)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		prof := decodeProto(t, raw)
+
+		var strs []string
+		for _, s := range prof[fieldProfileStringTable] {
+			strs = append(strs, string(s.([]byte)))
+		}
+
+		want := "wall"
+		if cpu {
+			want = "cpu"
+		}
+
+		timeType := decodeProto(t, prof[fieldProfileSampleType][1].([]byte))
+		if got := strs[timeType[fieldValueTypeType][0].(uint64)]; got != want {
+			t.Errorf("CPU %v: sample type = %s, want %s", cpu, got, want)
+		}
+
+		period := decodeProto(t, prof[fieldProfilePeriodType][0].([]byte))
+		if got := strs[period[fieldValueTypeType][0].(uint64)]; got != want {
+			t.Errorf("CPU %v: period type = %s, want %s", cpu, got, want)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	proc := &fakeProcess{
+		threads: map[uint32]*fakeThread{
+			1: {name: "main", pcs: []uint64{0x2000, 0x1000}},
+			2: {pcs: []uint64{0x3000}},
+			3: {pcs: []uint64{0x4000}},
+		},
+		order: []uint32{1, 2, 3},
+	}
+
+	p, err := Run(context.Background(), &fakeBackend{proc}, 7, &Options{
+		Interval:   time.Millisecond,
+		MaxSamples: 4,
+		Filter:     func(tid uint32) bool { return tid != 3 },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p.Samples() != 8 {
+		t.Fatalf("Samples = %d, want 8", p.Samples())
+	}
+
+	if p.Frame(0x2000).Function != "work" {
+		t.Fatalf("profile was not symbolized: %+v", p.Frame(0x2000))
+	}
+
+	if p.threadLabel(1) != "main" {
+		t.Fatalf("thread name = %q", p.threadLabel(1))
+	}
+
+	for tid, th := range proc.threads {
+		if th.opened != th.closed {
+			t.Errorf("thread %d: opened %d, closed %d", tid, th.opened, th.closed)
+		}
+
+		if th.suspended != 0 {
+			t.Errorf("thread %d left suspended", tid)
+		}
+	}
+
+	if proc.threads[1].opened != 1 {
+		t.Errorf("thread handle reopened %d times", proc.threads[1].opened)
+	}
+
+	if !proc.closed {
+		t.Error("process not closed")
+	}
+}
+
+func TestRunOnlyRunning(t *testing.T) {
+	busy := &fakeThread{pcs: []uint64{0x2000}, cpuStep: time.Millisecond}
+	idle := &fakeThread{pcs: []uint64{0x3000}}
+
+	proc := &fakeProcess{
+		threads: map[uint32]*fakeThread{1: busy, 2: idle},
+		order:   []uint32{1, 2},
+	}
+
+	p, err := Run(context.Background(), &fakeBackend{proc}, 7, &Options{
+		Interval:    time.Millisecond,
+		MaxSamples:  3,
+		OnlyRunning: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the first round only establishes a CPU time baseline
+	if p.Samples() != 2 {
+		t.Fatalf("Samples = %d, want 2", p.Samples())
+	}
+
+	if idle.walks != 0 {
+		t.Fatalf("idle thread walked %d times", idle.walks)
+	}
+
+	if !p.CPU {
+		t.Error("profile of running threads not marked CPU")
+	}
+}
+
+func TestRunStopsOnContext(t *testing.T) {
+	proc := &fakeProcess{
+		threads: map[uint32]*fakeThread{1: {pcs: []uint64{1}}},
+		order:   []uint32{1},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	p, err := Run(ctx, &fakeBackend{proc}, 7, &Options{Interval: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if p.Samples() == 0 || p.Duration <= 0 {
+		t.Fatalf("Samples = %d, Duration = %v", p.Samples(), p.Duration)
+	}
+}
+
+func TestRunSelf(t *testing.T) {
+	proc := &fakeProcess{}
+
+	_, err := Run(context.Background(), &fakeBackend{proc}, uint32(os.Getpid()), nil)
+	if err != stacks.ErrSelf {
+		t.Fatalf("Run on own process = %v, want stacks.ErrSelf", err)
+	}
+
+	if proc.closed {
+		t.Error("own process was opened")
+	}
+}
+
+type fakeBackend struct {
+	proc *fakeProcess
+}
+
+func (b *fakeBackend) OpenProcess(pid uint32) (stacks.Process, error) {
+	return b.proc, nil
+}
+
+type fakeProcess struct {
+	threads map[uint32]*fakeThread
+	order   []uint32
+	closed  bool
+}
+
+func (p *fakeProcess) Threads() ([]uint32, error) { return p.order, nil }
+
+func (p *fakeProcess) OpenThread(tid uint32) (stacks.ThreadHandle, error) {
+	th := p.threads[tid]
+	th.opened++
+	return th, nil
+}
+
+func (p *fakeProcess) InitSymbols(string) error { return nil }
+func (p *fakeProcess) CleanupSymbols() error    { return nil }
+func (p *fakeProcess) Symbolize(pc uint64) stacks.Frame {
+	return resolve(pc)
+}
+
+func (p *fakeProcess) Close() error {
+	p.closed = true
+	return nil
+}
+
+type fakeThread struct {
+	name      string
+	pcs       []uint64
+	cpuStep   time.Duration
+	cpu       time.Duration
+	opened    int
+	closed    int
+	suspended int
+	walks     int
+}
+
+func (t *fakeThread) Name() (string, error) { return t.name, nil }
+func (t *fakeThread) Suspend() error        { t.suspended++; return nil }
+func (t *fakeThread) Resume() error         { t.suspended--; return nil }
+func (t *fakeThread) Close() error          { t.closed++; return nil }
+
+func (t *fakeThread) Walk(int) ([]uint64, error) {
+	t.walks++
+	return t.pcs, nil
+}
+
+func (t *fakeThread) CPUTime() (time.Duration, error) {
+	t.cpu += t.cpuStep
+	return t.cpu, nil
+}
+
+// decodeProto splits a protobuf message into its fields. Varints decode to
+// uint64 and length delimited fields to []byte.
+func decodeProto(t *testing.T, data []byte) map[int][]interface{} {
+	fields := make(map[int][]interface{})
+
+	for len(data) > 0 {
+		key, n := readVarint(t, data)
+		data = data[n:]
+
+		field := int(key >> 3)
+		switch key & 7 {
+		case wireVarint:
+			v, n := readVarint(t, data)
+			data = data[n:]
+			fields[field] = append(fields[field], v)
+		case wireBytes:
+			l, n := readVarint(t, data)
+			data = data[n:]
+			fields[field] = append(fields[field], data[:l])
+			data = data[l:]
+		default:
+			t.Fatalf("unexpected wire type %d", key&7)
+		}
+	}
+
+	return fields
+}
+
+func decodePacked(t *testing.T, data []byte) []uint64 {
+	var vals []uint64
+
+	for len(data) > 0 {
+		v, n := readVarint(t, data)
+		vals = append(vals, v)
+		data = data[n:]
+	}
+
+	return vals
+}
+
+func readVarint(t *testing.T, data []byte) (uint64, int) {
+	var x uint64
+
+	for i := 0; i < len(data) && i < 10; i++ {
+		x |= uint64(data[i]&0x7F) << (7 * uint(i))
+		if data[i] < 0x80 {
+			return x, i + 1
+		}
+	}
+
+	t.Fatal("truncated varint")
+	return 0, 0
+}
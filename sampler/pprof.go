@@ -0,0 +1,264 @@
+//  ---------------------------------------------------------------------------
+//
+//  pprof.go
+//
+//  Copyright (c) 2016, Jared Chavez.
+//  All rights reserved.
+//
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+//
+//  -----------
+
+package sampler
+
+import (
+	"compress/gzip"
+	"io"
+)
+
+// profile.proto field numbers, see
+// https://github.com/google/pprof/blob/master/proto/profile.proto
+const (
+	fieldProfileSampleType    = 1
+	fieldProfileSample        = 2
+	fieldProfileLocation      = 4
+	fieldProfileFunction      = 5
+	fieldProfileStringTable   = 6
+	fieldProfileTimeNanos     = 9
+	fieldProfileDurationNanos = 10
+	fieldProfilePeriodType    = 11
+	fieldProfilePeriod        = 12
+
+	fieldValueTypeType = 1
+	fieldValueTypeUnit = 2
+
+	fieldSampleLocationID = 1
+	fieldSampleValue      = 2
+	fieldSampleLabel      = 3
+
+	fieldLabelKey = 1
+	fieldLabelStr = 2
+	fieldLabelNum = 3
+
+	fieldLocationID      = 1
+	fieldLocationAddress = 3
+	fieldLocationLine    = 4
+
+	fieldLineFunctionID = 1
+	fieldLineLine       = 2
+
+	fieldFunctionID         = 1
+	fieldFunctionName       = 2
+	fieldFunctionSystemName = 3
+	fieldFunctionFilename   = 4
+)
+
+const (
+	wireVarint = 0
+	wireBytes  = 2
+)
+
+// WritePprof writes the profile as a gzip compressed profile.proto message,
+// as read by go tool pprof.
+func (p *Profile) WritePprof(w io.Writer) error {
+	zw := gzip.NewWriter(w)
+
+	_, err := zw.Write(p.encodeProto())
+	if err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
+
+func (p *Profile) encodeProto() []byte {
+	var b protoBuffer
+	strs := newStringTable()
+
+	// sample types: samples/count and wall/nanoseconds, or cpu/nanoseconds
+	// when only running threads were sampled
+	timeType := "wall"
+	if p.CPU {
+		timeType = "cpu"
+	}
+
+	for _, vt := range [][2]string{{"samples", "count"}, {timeType, "nanoseconds"}} {
+		var msg protoBuffer
+		msg.int64Field(fieldValueTypeType, strs.index(vt[0]))
+		msg.int64Field(fieldValueTypeUnit, strs.index(vt[1]))
+		b.bytesField(fieldProfileSampleType, msg.data)
+	}
+
+	// one location per distinct PC, one function per distinct name/file
+	locationIDs := make(map[uint64]uint64)
+	functionIDs := make(map[[2]string]uint64)
+	var locations, functions protoBuffer
+
+	for _, s := range p.samples {
+		for _, pc := range s.pcs {
+			if _, ok := locationIDs[pc]; ok {
+				continue
+			}
+
+			frame := p.Frame(pc)
+			name := functionName(frame)
+			fkey := [2]string{name, frame.File}
+
+			fid, ok := functionIDs[fkey]
+			if !ok {
+				fid = uint64(len(functionIDs) + 1)
+				functionIDs[fkey] = fid
+
+				var fn protoBuffer
+				fn.uint64Field(fieldFunctionID, fid)
+				fn.int64Field(fieldFunctionName, strs.index(name))
+				fn.int64Field(fieldFunctionSystemName, strs.index(name))
+				fn.int64Field(fieldFunctionFilename, strs.index(frame.File))
+				functions.bytesField(fieldProfileFunction, fn.data)
+			}
+
+			lid := uint64(len(locationIDs) + 1)
+			locationIDs[pc] = lid
+
+			var line protoBuffer
+			line.uint64Field(fieldLineFunctionID, fid)
+			line.int64Field(fieldLineLine, int64(frame.Line))
+
+			var loc protoBuffer
+			loc.uint64Field(fieldLocationID, lid)
+			loc.uint64Field(fieldLocationAddress, pc)
+			loc.bytesField(fieldLocationLine, line.data)
+			locations.bytesField(fieldProfileLocation, loc.data)
+		}
+	}
+
+	for _, s := range p.samples {
+		var msg protoBuffer
+
+		ids := make([]uint64, len(s.pcs))
+		for i, pc := range s.pcs {
+			ids[i] = locationIDs[pc]
+		}
+		msg.packedField(fieldSampleLocationID, ids)
+		msg.packedField(fieldSampleValue, []uint64{
+			uint64(s.count),
+			uint64(s.count * int64(p.Period)),
+		})
+
+		var label protoBuffer
+		label.int64Field(fieldLabelKey, strs.index("thread"))
+		label.int64Field(fieldLabelStr, strs.index(p.threadLabel(s.thread)))
+		msg.bytesField(fieldSampleLabel, label.data)
+
+		label = protoBuffer{}
+		label.int64Field(fieldLabelKey, strs.index("thread_id"))
+		label.int64Field(fieldLabelNum, int64(s.thread))
+		msg.bytesField(fieldSampleLabel, label.data)
+
+		b.bytesField(fieldProfileSample, msg.data)
+	}
+
+	b.append(locations.data)
+	b.append(functions.data)
+
+	var period protoBuffer
+	period.int64Field(fieldValueTypeType, strs.index(timeType))
+	period.int64Field(fieldValueTypeUnit, strs.index("nanoseconds"))
+
+	for _, str := range strs.strings {
+		b.stringField(fieldProfileStringTable, str)
+	}
+
+	if !p.Start.IsZero() {
+		b.int64Field(fieldProfileTimeNanos, p.Start.UnixNano())
+	}
+	b.int64Field(fieldProfileDurationNanos, int64(p.Duration))
+	b.bytesField(fieldProfilePeriodType, period.data)
+	b.int64Field(fieldProfilePeriod, int64(p.Period))
+
+	return b.data
+}
+
+// stringTable assigns indexes to strings. Index 0 is always the empty string
+// as profile.proto requires.
+type stringTable struct {
+	strings []string
+	indexes map[string]int64
+}
+
+func newStringTable() *stringTable {
+	return &stringTable{
+		strings: []string{""},
+		indexes: map[string]int64{"": 0},
+	}
+}
+
+func (t *stringTable) index(s string) int64 {
+	if idx, ok := t.indexes[s]; ok {
+		return idx
+	}
+
+	idx := int64(len(t.strings))
+	t.strings = append(t.strings, s)
+	t.indexes[s] = idx
+
+	return idx
+}
+
+// protoBuffer is a minimal protocol buffer encoder covering the field types
+// profile.proto uses.
+type protoBuffer struct {
+	data []byte
+}
+
+func (b *protoBuffer) append(data []byte) {
+	b.data = append(b.data, data...)
+}
+
+func (b *protoBuffer) varint(x uint64) {
+	for x >= 0x80 {
+		b.data = append(b.data, byte(x)|0x80)
+		x >>= 7
+	}
+
+	b.data = append(b.data, byte(x))
+}
+
+func (b *protoBuffer) tag(field, wireType int) {
+	b.varint(uint64(field)<<3 | uint64(wireType))
+}
+
+// uint64Field and int64Field omit zero values, which is how proto3 encodes
+// defaults.
+func (b *protoBuffer) uint64Field(field int, x uint64) {
+	if x == 0 {
+		return
+	}
+
+	b.tag(field, wireVarint)
+	b.varint(x)
+}
+
+func (b *protoBuffer) int64Field(field int, x int64) {
+	b.uint64Field(field, uint64(x))
+}
+
+func (b *protoBuffer) bytesField(field int, data []byte) {
+	b.tag(field, wireBytes)
+	b.varint(uint64(len(data)))
+	b.append(data)
+}
+
+func (b *protoBuffer) stringField(field int, s string) {
+	b.bytesField(field, []byte(s))
+}
+
+func (b *protoBuffer) packedField(field int, xs []uint64) {
+	var packed protoBuffer
+	for _, x := range xs {
+		packed.varint(x)
+	}
+
+	b.bytesField(field, packed.data)
+}
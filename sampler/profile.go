@@ -0,0 +1,202 @@
+//  ---------------------------------------------------------------------------
+//
+//  profile.go
+//
+//  Copyright (c) 2016, Jared Chavez.
+//  All rights reserved.
+//
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+//
+//  -----------
+
+package sampler
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/xaevman/win32/stacks"
+)
+
+// Profile aggregates stack samples. Identical stacks taken from the same
+// thread are stored once with a count.
+type Profile struct {
+	// Period is the sampling interval.
+	Period time.Duration
+
+	// Start and Duration bound the sampling session.
+	Start    time.Time
+	Duration time.Duration
+
+	// CPU says that only running threads were sampled, so that the sample
+	// times are CPU time rather than wall time.
+	CPU bool
+
+	samples     []*sample
+	index       map[string]*sample
+	threadNames map[uint32]string
+	frames      map[uint64]stacks.Frame
+}
+
+type sample struct {
+	thread uint32
+	pcs    []uint64
+	count  int64
+}
+
+// NewProfile returns an empty profile for the given sampling interval.
+func NewProfile(period time.Duration) *Profile {
+	return &Profile{
+		Period:      period,
+		index:       make(map[string]*sample),
+		threadNames: make(map[uint32]string),
+		frames:      make(map[uint64]stacks.Frame),
+	}
+}
+
+// Add records one sample of thread's stack. pcs are innermost first.
+func (p *Profile) Add(thread uint32, pcs []uint64) {
+	key := sampleKey(thread, pcs)
+
+	s, ok := p.index[key]
+	if !ok {
+		s = &sample{
+			thread: thread,
+			pcs:    append([]uint64(nil), pcs...),
+		}
+		p.index[key] = s
+		p.samples = append(p.samples, s)
+	}
+
+	s.count++
+}
+
+// SetThreadName records a name for the given thread ID.
+func (p *Profile) SetThreadName(thread uint32, name string) {
+	if name != "" {
+		p.threadNames[thread] = name
+	}
+}
+
+// Samples returns the total number of samples recorded.
+func (p *Profile) Samples() int64 {
+	var total int64
+	for _, s := range p.samples {
+		total += s.count
+	}
+
+	return total
+}
+
+// PCs returns every distinct program counter in the profile, sorted.
+func (p *Profile) PCs() []uint64 {
+	seen := make(map[uint64]bool)
+	var pcs []uint64
+
+	for _, s := range p.samples {
+		for _, pc := range s.pcs {
+			if !seen[pc] {
+				seen[pc] = true
+				pcs = append(pcs, pc)
+			}
+		}
+	}
+
+	sort.Slice(pcs, func(i, j int) bool { return pcs[i] < pcs[j] })
+
+	return pcs
+}
+
+// Symbolize resolves each distinct program counter once through resolve.
+func (p *Profile) Symbolize(resolve func(pc uint64) stacks.Frame) {
+	for _, pc := range p.PCs() {
+		if _, ok := p.frames[pc]; ok {
+			continue
+		}
+
+		frame := resolve(pc)
+		frame.PC = pc
+		p.frames[pc] = frame
+	}
+}
+
+// Frame returns the symbolized frame for pc, or a bare frame if pc has not
+// been symbolized.
+func (p *Profile) Frame(pc uint64) stacks.Frame {
+	if frame, ok := p.frames[pc]; ok {
+		return frame
+	}
+
+	return stacks.Frame{PC: pc}
+}
+
+// WriteFolded writes the profile in the folded stacks format consumed by
+// flamegraph.pl and similar tools: one line per distinct stack, frames
+// outermost first and separated by semicolons, followed by the sample count.
+func (p *Profile) WriteFolded(w io.Writer) error {
+	counts := make(map[string]int64)
+
+	for _, s := range p.samples {
+		names := make([]string, len(s.pcs))
+		for i, pc := range s.pcs {
+			names[len(s.pcs)-1-i] = foldedName(p.Frame(pc))
+		}
+
+		counts[strings.Join(names, ";")] += s.count
+	}
+
+	lines := make([]string, 0, len(counts))
+	for stack := range counts {
+		lines = append(lines, stack)
+	}
+	sort.Strings(lines)
+
+	bw := bufio.NewWriter(w)
+	for _, stack := range lines {
+		fmt.Fprintf(bw, "%s %d\n", stack, counts[stack])
+	}
+
+	return bw.Flush()
+}
+
+func (p *Profile) threadLabel(thread uint32) string {
+	if name, ok := p.threadNames[thread]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("%d", thread)
+}
+
+// functionName is the name a frame is reported under in both output formats.
+func functionName(frame stacks.Frame) string {
+	switch {
+	case frame.Function != "" && frame.Module != "":
+		return frame.Module + "!" + frame.Function
+	case frame.Function != "":
+		return frame.Function
+	case frame.Module != "":
+		return fmt.Sprintf("%s+0x%x", frame.Module, frame.Offset)
+	}
+
+	return fmt.Sprintf("0x%x", frame.PC)
+}
+
+func foldedName(frame stacks.Frame) string {
+	return strings.Replace(functionName(frame), ";", ":", -1)
+}
+
+func sampleKey(thread uint32, pcs []uint64) string {
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "%x", thread)
+	for _, pc := range pcs {
+		fmt.Fprintf(&sb, ",%x", pc)
+	}
+
+	return sb.String()
+}
@@ -0,0 +1,241 @@
+//  ---------------------------------------------------------------------------
+//
+//  sampler.go
+//
+//  Copyright (c) 2016, Jared Chavez.
+//  All rights reserved.
+//
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+//
+//  -----------
+
+// Package sampler is a sampling profiler for external processes. It
+// periodically suspends the target's threads, walks their stacks and
+// aggregates the results into pprof and folded-stack output.
+package sampler
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/xaevman/win32/stacks"
+)
+
+// DefaultInterval is the sampling interval used when Options.Interval is
+// zero.
+const DefaultInterval = 10 * time.Millisecond
+
+// Options control a sampling session. A nil *Options uses the defaults.
+type Options struct {
+	// Interval between samples.
+	Interval time.Duration
+
+	// Duration stops sampling once elapsed. Zero samples until the context
+	// is cancelled or MaxSamples is reached.
+	Duration time.Duration
+
+	// MaxSamples stops sampling after this many sampling rounds.
+	MaxSamples int
+
+	// Filter, when set, selects which thread IDs are sampled.
+	Filter func(tid uint32) bool
+
+	// MaxDepth limits the number of frames per sample.
+	MaxDepth int
+
+	// SymbolPath is passed to SymInitialize.
+	SymbolPath string
+
+	// OnlyRunning skips threads that consumed no CPU time since the
+	// previous round, turning a wall-clock profile into a CPU profile.
+	// It requires thread handles that implement CPUTimer.
+	OnlyRunning bool
+}
+
+// CPUTimer is implemented by thread handles that can report the CPU time a
+// thread has consumed.
+type CPUTimer interface {
+	CPUTime() (time.Duration, error)
+}
+
+// Run samples process pid through backend until ctx is done or a limit in
+// opts is reached, then symbolizes the result. All threads are resumed and
+// all handles closed before Run returns. pid must not be the calling
+// process, for which Run returns stacks.ErrSelf.
+func Run(
+	ctx context.Context,
+	backend stacks.Backend,
+	pid uint32,
+	opts *Options,
+) (*Profile, error) {
+	if pid == uint32(os.Getpid()) {
+		return nil, stacks.ErrSelf
+	}
+
+	if opts == nil {
+		opts = new(Options)
+	}
+
+	interval := opts.Interval
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+
+	maxDepth := opts.MaxDepth
+	if maxDepth <= 0 {
+		maxDepth = stacks.DefaultMaxDepth
+	}
+
+	if opts.Duration > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Duration)
+		defer cancel()
+	}
+
+	proc, err := backend.OpenProcess(pid)
+	if err != nil {
+		return nil, fmt.Errorf("opening process %d: %v", pid, err)
+	}
+	defer proc.Close()
+
+	err = proc.InitSymbols(opts.SymbolPath)
+	if err != nil {
+		return nil, fmt.Errorf("initializing symbols: %v", err)
+	}
+	defer proc.CleanupSymbols()
+
+	s := &session{
+		proc:     proc,
+		opts:     opts,
+		maxDepth: maxDepth,
+		profile:  NewProfile(interval),
+		threads:  make(map[uint32]*sampledThread),
+	}
+	defer s.close()
+
+	s.profile.Start = time.Now()
+	s.profile.CPU = opts.OnlyRunning
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+sampling:
+	for rounds := 1; ; rounds++ {
+		err = s.round()
+		if err != nil {
+			return nil, err
+		}
+
+		if opts.MaxSamples > 0 && rounds >= opts.MaxSamples {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			break sampling
+		case <-ticker.C:
+		}
+	}
+
+	s.profile.Duration = time.Since(s.profile.Start)
+	s.profile.Symbolize(proc.Symbolize)
+
+	return s.profile, nil
+}
+
+type session struct {
+	proc     stacks.Process
+	opts     *Options
+	maxDepth int
+	profile  *Profile
+	threads  map[uint32]*sampledThread
+}
+
+type sampledThread struct {
+	handle  stacks.ThreadHandle
+	cpuTime time.Duration
+	seen    bool
+}
+
+// round takes one sample of every thread. Thread handles stay open between
+// rounds and are closed once the thread disappears or the session ends.
+func (s *session) round() error {
+	tids, err := s.proc.Threads()
+	if err != nil {
+		return fmt.Errorf("listing threads: %v", err)
+	}
+
+	for _, t := range s.threads {
+		t.seen = false
+	}
+
+	for _, tid := range tids {
+		if s.opts.Filter != nil && !s.opts.Filter(tid) {
+			continue
+		}
+
+		t, ok := s.threads[tid]
+		if !ok {
+			handle, err := s.proc.OpenThread(tid)
+			if err != nil {
+				// threads routinely exit between listing and opening
+				continue
+			}
+
+			t = &sampledThread{handle: handle, cpuTime: -1}
+			s.threads[tid] = t
+
+			if name, err := handle.Name(); err == nil {
+				s.profile.SetThreadName(tid, name)
+			}
+		}
+		t.seen = true
+
+		if s.opts.OnlyRunning && !t.ran() {
+			continue
+		}
+
+		pcs, _ := stacks.Walk(t.handle, s.maxDepth)
+		if len(pcs) > 0 {
+			s.profile.Add(tid, pcs)
+		}
+	}
+
+	for tid, t := range s.threads {
+		if !t.seen {
+			t.handle.Close()
+			delete(s.threads, tid)
+		}
+	}
+
+	return nil
+}
+
+func (s *session) close() {
+	for tid, t := range s.threads {
+		t.handle.Close()
+		delete(s.threads, tid)
+	}
+}
+
+// ran reports whether the thread consumed CPU time since the last call.
+// Threads whose CPU time cannot be read are always sampled.
+func (t *sampledThread) ran() bool {
+	timer, ok := t.handle.(CPUTimer)
+	if !ok {
+		return true
+	}
+
+	cpu, err := timer.CPUTime()
+	if err != nil {
+		return true
+	}
+
+	prev := t.cpuTime
+	t.cpuTime = cpu
+
+	return prev >= 0 && cpu > prev
+}
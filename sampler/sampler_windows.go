@@ -0,0 +1,24 @@
+//  ---------------------------------------------------------------------------
+//
+//  sampler_windows.go
+//
+//  Copyright (c) 2016, Jared Chavez.
+//  All rights reserved.
+//
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+//
+//  -----------
+
+package sampler
+
+import (
+	"context"
+
+	"github.com/xaevman/win32/stacks"
+)
+
+// Sample profiles the live process pid. See Run.
+func Sample(ctx context.Context, pid uint32, opts *Options) (*Profile, error) {
+	return Run(ctx, stacks.NewBackend(), pid, opts)
+}
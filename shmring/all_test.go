@@ -0,0 +1,253 @@
+//  ---------------------------------------------------------------------------
+//
+//  all_test.go
+//
+//  Copyright (c) 2016, Jared Chavez.
+//  All rights reserved.
+//
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+//
+//  -----------
+
+package shmring
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"runtime"
+	"testing"
+)
+
+// newMemory returns zeroed memory for a ring of capacity bytes. The
+// allocator aligns it to 8 bytes, as a mapped view is.
+func newMemory(capacity int) []byte {
+	return make([]byte, Size(capacity))
+}
+
+func TestLayout(t *testing.T) {
+	mem := newMemory(256)
+
+	r, err := Init(mem)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(mem[0:4]) != "SRNG" {
+		t.Errorf("magic bytes = %q", mem[0:4])
+	}
+
+	le := binary.LittleEndian
+	if v := le.Uint32(mem[4:]); v != 1 {
+		t.Errorf("version = %d", v)
+	}
+
+	if c := le.Uint64(mem[8:]); c != 256 || r.Capacity() != 256 {
+		t.Errorf("capacity = %d, %d", c, r.Capacity())
+	}
+
+	if err := r.Push([]byte("hello")); err != nil {
+		t.Fatal(err)
+	}
+
+	// a 4-byte length, the payload, and padding to 8 bytes
+	if head := le.Uint64(mem[0x40:]); head != 16 {
+		t.Errorf("head = %d, want 16", head)
+	}
+
+	if n := le.Uint32(mem[HeaderSize:]); n != 5 || string(mem[HeaderSize+4:HeaderSize+9]) != "hello" {
+		t.Errorf("record = %d %q", n, mem[HeaderSize+4:HeaderSize+9])
+	}
+
+	if _, err := r.Pop(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if tail := le.Uint64(mem[0x80:]); tail != 16 {
+		t.Errorf("tail = %d, want 16", tail)
+	}
+}
+
+func TestInitCapacity(t *testing.T) {
+	// the data area is the largest power of two that fits
+	r, err := Init(make([]byte, Size(1000)))
+	if err != nil || r.Capacity() != 512 {
+		t.Errorf("Init = %v, %v", r, err)
+	}
+
+	if _, err := Init(make([]byte, Size(MinCapacity)-1)); err == nil {
+		t.Error("expected error for short memory")
+	}
+
+	mem := make([]byte, Size(128)+1)
+	if _, err := Init(mem[1:]); err == nil {
+		t.Error("expected error for unaligned memory")
+	}
+}
+
+func TestAttach(t *testing.T) {
+	mem := newMemory(128)
+
+	if _, err := Attach(mem); err == nil {
+		t.Error("expected error before Init")
+	}
+
+	producer, _ := Init(mem)
+	consumer, err := Attach(mem)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	producer.Push([]byte("shared"))
+	if got, err := consumer.Pop(nil); err != nil || string(got) != "shared" {
+		t.Errorf("Pop = %q, %v", got, err)
+	}
+
+	tests := map[string]func(b []byte){
+		"version":  func(b []byte) { binary.LittleEndian.PutUint32(b[4:], 2) },
+		"capacity": func(b []byte) { binary.LittleEndian.PutUint64(b[8:], 96) },
+		"too big":  func(b []byte) { binary.LittleEndian.PutUint64(b[8:], 256) },
+		"tail":     func(b []byte) { binary.LittleEndian.PutUint64(b[0x80:], 1<<40) },
+	}
+
+	for name, corrupt := range tests {
+		bad := append([]byte(nil), mem...)
+		corrupt(bad)
+
+		if _, err := Attach(bad); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+}
+
+func TestPushPop(t *testing.T) {
+	r, _ := Init(newMemory(128))
+
+	if _, err := r.Pop(nil); err != ErrEmpty {
+		t.Errorf("Pop on empty = %v", err)
+	}
+
+	if err := r.Push(make([]byte, r.MaxMessage()+1)); err != ErrTooLarge {
+		t.Errorf("Push too large = %v", err)
+	}
+
+	messages := [][]byte{[]byte("a"), {}, []byte("0123456789abcdef")}
+	for _, m := range messages {
+		if err := r.Push(m); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if r.Len() != 8+8+24 {
+		t.Errorf("Len = %d", r.Len())
+	}
+
+	buf := make([]byte, 0, 64)
+	for i, want := range messages {
+		got, err := r.Pop(buf)
+		if err != nil || !bytes.Equal(got, want) {
+			t.Errorf("message %d = %q, %v", i, got, err)
+		}
+	}
+
+	// fill an empty ring
+	r, _ = Init(newMemory(128))
+
+	var pushed int
+	for r.Push(make([]byte, 12)) == nil {
+		pushed++
+	}
+
+	if pushed != 128/16 || r.Push([]byte{}) != ErrFull {
+		t.Errorf("pushed %d 16-byte records into 128 bytes", pushed)
+	}
+}
+
+func TestWrap(t *testing.T) {
+	mem := newMemory(128)
+	r, _ := Init(mem)
+
+	// 48-byte records leave 32 bytes before the end on the second lap
+	for lap := 0; lap < 50; lap++ {
+		for i := 0; i < 2; i++ {
+			msg := []byte(fmt.Sprintf("lap %03d message %d.............................", lap, i))[:44]
+			if err := r.Push(msg); err != nil {
+				t.Fatalf("lap %d: %v", lap, err)
+			}
+
+			got, err := r.Pop(nil)
+			if err != nil || !bytes.Equal(got, msg) {
+				t.Fatalf("lap %d: Pop = %q, %v", lap, got, err)
+			}
+		}
+	}
+
+	// the third record would have started at 96, where 48 bytes did not
+	// fit, so the ring wrapped there
+	if marker := binary.LittleEndian.Uint32(mem[HeaderSize+96:]); marker != wrapMarker {
+		t.Errorf("no wrap marker at 96: %#x", marker)
+	}
+}
+
+func TestCorruptRecord(t *testing.T) {
+	mem := newMemory(128)
+	r, _ := Init(mem)
+
+	r.Push([]byte("abc"))
+	binary.LittleEndian.PutUint32(mem[HeaderSize:], 100)
+
+	if _, err := r.Pop(nil); err != ErrCorrupt {
+		t.Errorf("Pop = %v, want ErrCorrupt", err)
+	}
+}
+
+func TestConcurrent(t *testing.T) {
+	mem := newMemory(1024)
+	producer, _ := Init(mem)
+	consumer, _ := Attach(mem)
+
+	const count = 20000
+
+	go func() {
+		for i := 0; i < count; {
+			msg := []byte(fmt.Sprintf("%d:%s", i, bytes.Repeat([]byte("x"), i%100)))
+			if producer.Push(msg) == nil {
+				i++
+			} else {
+				runtime.Gosched()
+			}
+		}
+	}()
+
+	var buf []byte
+	for i := 0; i < count; {
+		var err error
+		buf, err = consumer.Pop(buf)
+		if err == ErrEmpty {
+			runtime.Gosched()
+			continue
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		want := fmt.Sprintf("%d:%s", i, bytes.Repeat([]byte("x"), i%100))
+		if string(buf) != want {
+			t.Fatalf("message %d = %q", i, buf)
+		}
+
+		i++
+	}
+}
+
+func TestEventNames(t *testing.T) {
+	if n := DataEventName(`Local\telemetry`); n != `Local\telemetry.data` {
+		t.Errorf("DataEventName = %q", n)
+	}
+
+	if n := SpaceEventName(`Local\telemetry`); n != `Local\telemetry.space` {
+		t.Errorf("SpaceEventName = %q", n)
+	}
+}
@@ -0,0 +1,310 @@
+//  ---------------------------------------------------------------------------
+//
+//  ring.go
+//
+//  Copyright (c) 2016, Jared Chavez.
+//  All rights reserved.
+//
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+//
+//  -----------
+
+// Package shmring is a single-producer, single-consumer ring buffer of
+// messages in shared memory, for streaming between processes written in
+// different languages.
+//
+// The ring is a header followed by the data area. All integers are little
+// endian, and the offsets are from the start of the shared memory:
+//
+//	0x00  uint32  magic, 0x474e5253 ("SRNG")
+//	0x04  uint32  version, 1
+//	0x08  uint64  capacity of the data area in bytes, a power of two
+//	0x40  uint64  head: bytes ever produced, written only by the producer
+//	0x80  uint64  tail: bytes ever consumed, written only by the consumer
+//	0xc0          data area
+//
+// The head and tail sit in cache lines of their own, and the rest of the
+// header is zero. A position p is at offset p mod capacity of the data
+// area. head - tail bytes are in use.
+//
+// A record starts at a multiple of 8 and is a uint32 payload length, the
+// payload, and padding to the next multiple of 8. A record never wraps: a
+// producer that reaches the end of the data area writes the length
+// 0xffffffff and continues at its start. A payload is at most half the
+// capacity less 4 bytes, so that it always fits once the ring drains.
+//
+// head and tail are read and written atomically with acquire and release
+// order: the producer writes a record, then stores head; the consumer loads
+// head, reads the record, then stores tail. In C++ that is
+// std::atomic_ref<uint64_t> or the Interlocked functions, which 32-bit x86
+// needs for 64-bit atomicity.
+//
+// The Windows Shared type adds the section and two auto-reset events, set
+// when data is produced and when space is freed; see DataEventName and
+// SpaceEventName.
+package shmring
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"unsafe"
+)
+
+// header values; see the package documentation for the layout
+const (
+	Magic      = 0x474e5253
+	Version    = 1
+	HeaderSize = 0xc0
+
+	// MinCapacity is the smallest data area.
+	MinCapacity = 64
+
+	offMagic    = 0x00
+	offVersion  = 0x04
+	offCapacity = 0x08
+	offHead     = 0x40
+	offTail     = 0x80
+
+	recordAlign  = 8
+	recordHeader = 4
+	wrapMarker   = 0xffffffff
+)
+
+var (
+	// ErrFull is returned by Push when the message does not fit in the
+	// free space.
+	ErrFull = errors.New("shmring: ring full")
+
+	// ErrEmpty is returned by Pop when there is no message.
+	ErrEmpty = errors.New("shmring: ring empty")
+
+	// ErrTooLarge is returned by Push for a message longer than
+	// MaxMessage.
+	ErrTooLarge = errors.New("shmring: message too large")
+
+	// ErrBadCapacity is returned for a capacity that is not a power of
+	// two of at least MinCapacity.
+	ErrBadCapacity = errors.New("shmring: capacity is not a power of two of at least MinCapacity")
+
+	// ErrCorrupt is returned when the positions or a record are
+	// inconsistent, such as after a peer wrote out of turn.
+	ErrCorrupt = errors.New("shmring: ring corrupt")
+)
+
+// Size returns the shared memory size of a ring with capacity bytes of
+// data.
+func Size(capacity int) int {
+	return HeaderSize + capacity
+}
+
+// DataEventName is the name of the event set when a message is produced
+// into the ring in the section name.
+func DataEventName(name string) string {
+	return name + ".data"
+}
+
+// SpaceEventName is the name of the event set when a message is consumed
+// from the ring in the section name.
+func SpaceEventName(name string) string {
+	return name + ".space"
+}
+
+// Ring is a view of a ring in memory. One goroutine may Push and another
+// Pop, each standing for one side of the ring; the producer and consumer
+// may also be in different processes.
+type Ring struct {
+	data []byte
+	mask uint64
+	head *uint64
+	tail *uint64
+}
+
+// Init writes an empty ring into mem, with the largest power of two data
+// area that fits, and returns it. Peers must not attach before Init
+// returns; the magic is stored last.
+func Init(mem []byte) (*Ring, error) {
+	if err := checkMemory(mem); err != nil {
+		return nil, err
+	}
+
+	capacity := uint64(MinCapacity)
+	for capacity*2 <= uint64(len(mem)-HeaderSize) {
+		capacity *= 2
+	}
+
+	for i := range mem[:HeaderSize] {
+		mem[i] = 0
+	}
+
+	binary.LittleEndian.PutUint32(mem[offVersion:], Version)
+	binary.LittleEndian.PutUint64(mem[offCapacity:], capacity)
+
+	atomic.StoreUint32(uint32At(mem, offMagic), Magic)
+
+	return newRing(mem, capacity), nil
+}
+
+// Attach returns the ring that Init wrote into mem.
+func Attach(mem []byte) (*Ring, error) {
+	if err := checkMemory(mem); err != nil {
+		return nil, err
+	}
+
+	if magic := atomic.LoadUint32(uint32At(mem, offMagic)); magic != Magic {
+		return nil, fmt.Errorf("shmring: bad magic %#x", magic)
+	}
+
+	if version := binary.LittleEndian.Uint32(mem[offVersion:]); version != Version {
+		return nil, fmt.Errorf("shmring: unsupported version %d", version)
+	}
+
+	capacity := binary.LittleEndian.Uint64(mem[offCapacity:])
+	if capacity < MinCapacity || capacity&(capacity-1) != 0 || capacity > uint64(len(mem)-HeaderSize) {
+		return nil, fmt.Errorf("shmring: bad capacity %d for %d bytes", capacity, len(mem))
+	}
+
+	r := newRing(mem, capacity)
+	if r.used() > capacity {
+		return nil, ErrCorrupt
+	}
+
+	return r, nil
+}
+
+func checkMemory(mem []byte) error {
+	if len(mem) < Size(MinCapacity) {
+		return fmt.Errorf("shmring: %d bytes is too small for a ring", len(mem))
+	}
+
+	// the 64-bit atomics need aligned positions, which 32-bit platforms
+	// do not guarantee
+	if uintptr(unsafe.Pointer(&mem[0]))%8 != 0 {
+		return errors.New("shmring: memory is not 8-byte aligned")
+	}
+
+	return nil
+}
+
+func newRing(mem []byte, capacity uint64) *Ring {
+	return &Ring{
+		data: mem[HeaderSize : HeaderSize+capacity],
+		mask: capacity - 1,
+		head: uint64At(mem, offHead),
+		tail: uint64At(mem, offTail),
+	}
+}
+
+func uint32At(mem []byte, off int) *uint32 {
+	return (*uint32)(unsafe.Pointer(&mem[off]))
+}
+
+func uint64At(mem []byte, off int) *uint64 {
+	return (*uint64)(unsafe.Pointer(&mem[off]))
+}
+
+// Capacity returns the size of the data area.
+func (r *Ring) Capacity() int {
+	return len(r.data)
+}
+
+// MaxMessage returns the longest message Push accepts.
+func (r *Ring) MaxMessage() int {
+	return len(r.data)/2 - recordHeader
+}
+
+// Len returns the bytes in use, including record headers and padding.
+func (r *Ring) Len() int {
+	return int(r.used())
+}
+
+func (r *Ring) used() uint64 {
+	return atomic.LoadUint64(r.head) - atomic.LoadUint64(r.tail)
+}
+
+func recordSize(payload int) uint64 {
+	return uint64(recordHeader+payload+recordAlign-1) &^ (recordAlign - 1)
+}
+
+// Push appends message p. It never blocks: it returns ErrFull when there is
+// not room, and may then be retried once the consumer frees space. Only the
+// producer may call Push.
+func (r *Ring) Push(p []byte) error {
+	if len(p) > r.MaxMessage() {
+		return ErrTooLarge
+	}
+
+	head := *r.head // only the producer writes head
+	tail := atomic.LoadUint64(r.tail)
+
+	used := head - tail
+	if used > uint64(len(r.data)) {
+		return ErrCorrupt
+	}
+
+	size := recordSize(len(p))
+	off := head & r.mask
+
+	// a record that would cross the end starts over at the beginning
+	var pad uint64
+	if off+size > uint64(len(r.data)) {
+		pad = uint64(len(r.data)) - off
+	}
+
+	if used+pad+size > uint64(len(r.data)) {
+		return ErrFull
+	}
+
+	if pad > 0 {
+		binary.LittleEndian.PutUint32(r.data[off:], wrapMarker)
+		off = 0
+	}
+
+	binary.LittleEndian.PutUint32(r.data[off:], uint32(len(p)))
+	copy(r.data[off+recordHeader:], p)
+
+	atomic.StoreUint64(r.head, head+pad+size)
+
+	return nil
+}
+
+// Pop removes the oldest message and returns it appended to buf[:0]. It
+// returns ErrEmpty when there is none. Only the consumer may call Pop.
+func (r *Ring) Pop(buf []byte) ([]byte, error) {
+	tail := *r.tail // only the consumer writes tail
+	head := atomic.LoadUint64(r.head)
+
+	for {
+		used := head - tail
+		if used == 0 {
+			// store a skipped wrap marker
+			atomic.StoreUint64(r.tail, tail)
+			return buf[:0], ErrEmpty
+		}
+
+		if used > uint64(len(r.data)) || used%recordAlign != 0 {
+			return buf[:0], ErrCorrupt
+		}
+
+		off := tail & r.mask
+		length := binary.LittleEndian.Uint32(r.data[off:])
+
+		if length == wrapMarker {
+			tail += uint64(len(r.data)) - off
+			continue
+		}
+
+		if int64(length) > int64(r.MaxMessage()) || recordSize(int(length)) > used {
+			return buf[:0], ErrCorrupt
+		}
+
+		start := off + recordHeader
+		buf = append(buf[:0], r.data[start:start+uint64(length)]...)
+
+		atomic.StoreUint64(r.tail, tail+recordSize(int(length)))
+
+		return buf, nil
+	}
+}
@@ -0,0 +1,162 @@
+//  ---------------------------------------------------------------------------
+//
+//  shmring_windows.go
+//
+//  Copyright (c) 2016, Jared Chavez.
+//  All rights reserved.
+//
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+//
+//  -----------
+
+package shmring
+
+import (
+	"context"
+	"syscall"
+
+	"github.com/xaevman/win32/kernel32"
+)
+
+// Shared is a ring in a named section, with the events that signal it.
+type Shared struct {
+	*Ring
+
+	mem   *kernel32.SharedMemory
+	data  *kernel32.Event
+	space *kernel32.Event
+}
+
+// Create creates the section name, such as `Local\telemetry`, holding a
+// ring with capacity bytes of data, which must be a power of two, along
+// with its events. If the section already exists its ring is attached
+// rather than reset. sa, which may be nil, is applied to the section and
+// the events.
+func Create(name string, capacity int, sa *syscall.SecurityAttributes) (*Shared, error) {
+	if capacity < MinCapacity || capacity&(capacity-1) != 0 {
+		return nil, ErrBadCapacity
+	}
+
+	attrs := kernel32.ObjectAttributes{Security: sa}
+
+	mem, err := kernel32.NewSharedMemory(name, Size(capacity), &attrs)
+	if err != nil {
+		return nil, err
+	}
+
+	var ring *Ring
+	if mem.Existed() {
+		ring, err = Attach(mem.Bytes())
+	} else {
+		ring, err = Init(mem.Bytes())
+	}
+
+	if err != nil {
+		mem.Close()
+		return nil, err
+	}
+
+	s := &Shared{Ring: ring, mem: mem}
+
+	if s.data, err = kernel32.NewEvent(DataEventName(name), &kernel32.EventOptions{ObjectAttributes: attrs}); err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	if s.space, err = kernel32.NewEvent(SpaceEventName(name), &kernel32.EventOptions{ObjectAttributes: attrs}); err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Open attaches to the ring in the existing section name.
+func Open(name string) (*Shared, error) {
+	mem, err := kernel32.OpenSharedMemory(name, kernel32.FILE_MAP_READ|kernel32.FILE_MAP_WRITE, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	ring, err := Attach(mem.Bytes())
+	if err != nil {
+		mem.Close()
+		return nil, err
+	}
+
+	s := &Shared{Ring: ring, mem: mem}
+
+	const access = kernel32.SYNCHRONIZE | kernel32.EVENT_MODIFY_STATE
+
+	if s.data, err = kernel32.OpenNamedEvent(DataEventName(name), access); err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	if s.space, err = kernel32.OpenNamedEvent(SpaceEventName(name), access); err != nil {
+		s.Close()
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Send pushes p, waiting for space until ctx is done, and signals the
+// consumer.
+func (s *Shared) Send(ctx context.Context, p []byte) error {
+	for {
+		err := s.Push(p)
+		if err == nil {
+			return s.data.Set()
+		}
+
+		if err != ErrFull {
+			return err
+		}
+
+		if err := s.space.Wait(ctx); err != nil {
+			return err
+		}
+	}
+}
+
+// Receive pops a message into buf, waiting for one until ctx is done, and
+// signals the producer.
+func (s *Shared) Receive(ctx context.Context, buf []byte) ([]byte, error) {
+	for {
+		message, err := s.Pop(buf)
+		if err == nil {
+			return message, s.space.Set()
+		}
+
+		if err != ErrEmpty {
+			return nil, err
+		}
+
+		if err := s.data.Wait(ctx); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Close unmaps the ring and closes the section and event handles.
+func (s *Shared) Close() error {
+	var err error
+
+	for _, event := range []*kernel32.Event{s.data, s.space} {
+		if event != nil {
+			if closeErr := event.Close(); err == nil {
+				err = closeErr
+			}
+		}
+	}
+
+	if closeErr := s.mem.Close(); err == nil {
+		err = closeErr
+	}
+
+	s.Ring = nil
+
+	return err
+}
@@ -0,0 +1,213 @@
+//  ---------------------------------------------------------------------------
+//
+//  all_test.go
+//
+//  Copyright (c) 2016, Jared Chavez.
+//  All rights reserved.
+//
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+//
+//  -----------
+
+package signature
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/xaevman/win32/exception"
+	"github.com/xaevman/win32/stacks"
+)
+
+func frame(module, function string) stacks.Frame {
+	return stacks.Frame{Module: module, Function: function, Offset: 0x42}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"main", "main"},
+		{"std::vector<int,std::allocator<int> >::push_back", "std::vector<T>::push_back"},
+		{"Cache<Key,Value>::Get(const Key &) const", "Cache<T>::Get"},
+		{"`anonymous namespace'::Parse", "(anonymous)::Parse"},
+		{"(anonymous namespace)::Parse(char const*)", "(anonymous)::Parse"},
+		{"{anonymous}::Parse", "(anonymous)::Parse"},
+		{"Widget::operator<", "Widget::operator<"},
+		{"Stream::operator<<<int>", "Stream::operator<<<T>"},
+		{"Ptr<Node>::operator->", "Ptr<T>::operator->"},
+		{"Ptr<Node>::operator()(int)", "Ptr<T>::operator()"},
+		{"App::Run::<lambda_9f3c2e1d0b>::operator()", "App::Run::<lambda>::operator()"},
+		{"std::_Func_impl<<lambda_1a2b>,void>::_Do_call", "std::_Func_impl<T>::_Do_call"},
+		{"main.(*Server).handle", "main.(*Server).handle"},
+		{"main.main.func1", "main.main.func1"},
+		{"Broken<int", "Broken<int"},
+	}
+
+	for _, test := range tests {
+		if got := Normalize(test.in); got != test.want {
+			t.Errorf("Normalize(%q) = %q, want %q", test.in, got, test.want)
+		}
+	}
+}
+
+func TestFrameName(t *testing.T) {
+	tests := []struct {
+		frame stacks.Frame
+		want  string
+	}{
+		{frame("App", "Parser::Read"), "app!Parser::Read"},
+		{frame(`C:\Program Files\App\App.EXE`, "main"), "app!main"},
+		{stacks.Frame{Module: "plugin.dll", Offset: 0x1234}, "plugin+0x1234"},
+		{stacks.Frame{PC: 0x7ff612340000}, "??"},
+		{frame("", "runtime.sigpanic"), "runtime.sigpanic"},
+	}
+
+	for _, test := range tests {
+		if got := FrameName(test.frame); got != test.want {
+			t.Errorf("FrameName(%+v) = %q, want %q", test.frame, got, test.want)
+		}
+	}
+}
+
+func TestGenerate(t *testing.T) {
+	tests := []struct {
+		name   string
+		code   exception.Code
+		frames []stacks.Frame
+		opts   *Options
+		want   string
+	}{
+		{
+			name: "access violation",
+			code: exception.AccessViolation,
+			frames: []stacks.Frame{
+				frame("app", "Parser::Read"),
+				frame("app", "Document::Load"),
+				frame("app", "main"),
+				frame("kernel32", "BaseThreadInitThunk"),
+			},
+			want: "EXCEPTION_ACCESS_VIOLATION | app!Parser::Read | app!Document::Load | app!main",
+		},
+		{
+			name: "heap corruption skips allocator",
+			code: exception.HeapCorruption,
+			frames: []stacks.Frame{
+				frame("ntdll", "RtlReportCriticalFailure"),
+				frame("ntdll", "RtlpHeapHandleError"),
+				frame("ntdll", "RtlFreeHeap"),
+				frame("ucrtbase", "_free_base"),
+				frame("app", "Buffer::~Buffer"),
+				frame("app", "main"),
+			},
+			want: "STATUS_HEAP_CORRUPTION | app!Buffer::~Buffer | app!main",
+		},
+		{
+			name: "crt abort",
+			code: exception.StackBufferOverrun,
+			frames: []stacks.Frame{
+				frame("ucrtbase", "abort"),
+				frame("app", "std::vector<int,std::allocator<int> >::at"),
+				frame("app", "`anonymous namespace'::Check"),
+			},
+			want: "STATUS_STACK_BUFFER_OVERRUN | app!std::vector<T>::at | app!(anonymous)::Check",
+		},
+		{
+			name: "go panic",
+			code: exception.AccessViolation,
+			frames: []stacks.Frame{
+				frame("svc", "runtime.raise"),
+				frame("svc", "runtime.fatalpanic"),
+				frame("svc", "runtime.gopanic"),
+				frame("svc", "runtime.panicmem"),
+				frame("svc", "runtime.sigpanic"),
+				frame("svc", "main.(*Server).handle"),
+			},
+			opts: &Options{Frames: 1},
+			want: "EXCEPTION_ACCESS_VIOLATION | svc!main.(*Server).handle",
+		},
+		{
+			name: "custom skip",
+			code: exception.CPlusPlus,
+			frames: []stacks.Frame{
+				frame("kernelbase", "RaiseException"),
+				frame("app", "Log::Fatal"),
+				frame("app", "Run"),
+			},
+			opts: &Options{Skip: []string{"app!Log::*"}},
+			want: "CPP_EH_EXCEPTION | kernelbase!RaiseException | app!Run",
+		},
+		{
+			name: "everything skipped",
+			code: exception.Breakpoint,
+			frames: []stacks.Frame{
+				frame("ucrtbase", "abort"),
+				frame("ucrtbase", "terminate"),
+			},
+			want: "EXCEPTION_BREAKPOINT | ucrtbase!abort | ucrtbase!terminate",
+		},
+		{
+			name: "no frames",
+			code: 0xE0001234,
+			want: "0xE0001234",
+		},
+	}
+
+	for _, test := range tests {
+		sig := Generate(test.code, test.frames, test.opts)
+		if sig.Text != test.want {
+			t.Errorf("%s: Text = %q, want %q", test.name, sig.Text, test.want)
+		}
+
+		if len(sig.Key) != 40 {
+			t.Errorf("%s: Key = %q", test.name, sig.Key)
+		}
+	}
+}
+
+func TestKeyStable(t *testing.T) {
+	a := Generate(exception.AccessViolation, []stacks.Frame{
+		{Module: "App.dll", Function: "Cache<int,Widget>::Get", Offset: 0x10, PC: 0x1000},
+		{Module: "App.dll", Function: "main", Offset: 0x20, PC: 0x2000},
+	}, nil)
+
+	b := Generate(exception.AccessViolation, []stacks.Frame{
+		{Module: "app", Function: "Cache<long,Gadget>::Get", Offset: 0x30, PC: 0x9000},
+		{Module: "app", Function: "main", Offset: 0x44, PC: 0xa000},
+	}, nil)
+
+	if a.Key != b.Key || !reflect.DeepEqual(a.Frames, b.Frames) {
+		t.Fatalf("keys differ: %v %v", a, b)
+	}
+
+	c := Generate(exception.IllegalInstruction, []stacks.Frame{
+		{Module: "app", Function: "Cache<long,Gadget>::Get"},
+		{Module: "app", Function: "main"},
+	}, nil)
+
+	if c.Key == a.Key {
+		t.Fatal("different codes share a key")
+	}
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		pattern, s string
+		want       bool
+	}{
+		{"abort", "abort", true},
+		{"*!abort", "ucrtbase!abort", true},
+		{"ntdll!rtlp*heap*", "ntdll!rtlpfreeheapinternal", true},
+		{"ntdll!rtlp*heap*", "ntdll!rtlpwaitoncriticalsection", false},
+		{"runtime.panic*", "runtime.panicindex", true},
+		{"a*b*c", "axxbyyc", true},
+		{"a*b*c", "axxbyy", false},
+	}
+
+	for _, test := range tests {
+		if got := match(test.pattern, test.s); got != test.want {
+			t.Errorf("match(%q, %q) = %v", test.pattern, test.s, got)
+		}
+	}
+}
@@ -0,0 +1,386 @@
+//  ---------------------------------------------------------------------------
+//
+//  signature.go
+//
+//  Copyright (c) 2016, Jared Chavez.
+//  All rights reserved.
+//
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+//
+//  -----------
+
+// Package signature generates crash signatures from symbolized stacks, so
+// that duplicate crashes can be grouped into buckets.
+package signature
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/xaevman/win32/exception"
+	"github.com/xaevman/win32/stacks"
+)
+
+// DefaultFrames is the number of frames in a signature when
+// Options.Frames is zero.
+const DefaultFrames = 3
+
+// DefaultSkip lists frames that describe how a crash was reported rather
+// than where it happened: allocators, CRT abort and fail fast paths,
+// exception dispatch and Go runtime panics.
+var DefaultSkip = []string{
+	// heap and allocators
+	"ntdll!RtlAllocateHeap",
+	"ntdll!RtlFreeHeap",
+	"ntdll!RtlReAllocateHeap",
+	"ntdll!Rtlp*Heap*",
+	"ntdll!RtlReportCriticalFailure",
+	"*!malloc",
+	"*!_malloc_base",
+	"*!calloc",
+	"*!_calloc_base",
+	"*!realloc",
+	"*!_realloc_base",
+	"*!free",
+	"*!_free_base",
+	"*!operator new",
+	"*!operator new[]",
+	"*!operator delete",
+	"*!operator delete[]",
+
+	// CRT abort and fail fast
+	"*!abort",
+	"*!raise",
+	"*!terminate",
+	"*!_invoke_watson",
+	"*!_invalid_parameter*",
+	"*!__report_gsfailure",
+	"*!__fastfail",
+
+	// exception dispatch
+	"ntdll!KiUserExceptionDispatcher",
+	"ntdll!RtlRaiseException",
+	"ntdll!RtlDispatchException",
+	"kernelbase!RaiseException",
+	"kernelbase!RaiseFailFastException",
+	"kernelbase!UnhandledExceptionFilter",
+	"*!_CxxThrowException",
+
+	// Go runtime panics
+	"runtime.gopanic",
+	"runtime.goPanic*",
+	"runtime.panic*",
+	"runtime.sigpanic",
+	"runtime.throw",
+	"runtime.fatal*",
+	"runtime.crash",
+	"runtime.raise*",
+	"runtime.dieFromSignal",
+	"runtime.abort",
+	"runtime.sighandler",
+	"runtime.sigtramp*",
+	"runtime.exceptionhandler",
+	"runtime.lastcontinuehandler",
+}
+
+// Options control Generate. A nil *Options uses the defaults.
+type Options struct {
+	// Frames is the number of frames kept after skipping.
+	Frames int
+
+	// Skip lists patterns of frames to leave out of the signature. A
+	// pattern is "module!function", or just "function" to match any
+	// module, where * matches any run of characters. Matching ignores
+	// case and applies to normalized names. Nil uses DefaultSkip.
+	Skip []string
+}
+
+// Signature identifies a crash bucket.
+type Signature struct {
+	Code exception.Code
+
+	// Frames are the normalized frames the signature was built from.
+	Frames []string
+
+	// Key is a stable hash of the code and frames, suitable as a bucket
+	// identifier.
+	Key string
+
+	// Text is the readable form, for example
+	// "EXCEPTION_ACCESS_VIOLATION | app!Parser::Read | app!main".
+	Text string
+}
+
+func (s *Signature) String() string {
+	return s.Text
+}
+
+// Generate builds the signature of a crash from its exception code and
+// the symbolized frames of the faulting thread, innermost first.
+func Generate(code exception.Code, frames []stacks.Frame, opts *Options) *Signature {
+	if opts == nil {
+		opts = new(Options)
+	}
+
+	count := opts.Frames
+	if count <= 0 {
+		count = DefaultFrames
+	}
+
+	skip := opts.Skip
+	if skip == nil {
+		skip = DefaultSkip
+	}
+
+	var kept []string
+	for _, frame := range frames {
+		name := FrameName(frame)
+		if matchAny(skip, name) {
+			continue
+		}
+
+		kept = append(kept, name)
+		if len(kept) == count {
+			break
+		}
+	}
+
+	if len(kept) == 0 {
+		// everything was skipped; a signature of skipped frames is more
+		// useful than one of just the code
+		for i := 0; i < len(frames) && i < count; i++ {
+			kept = append(kept, FrameName(frames[i]))
+		}
+	}
+
+	parts := append([]string{code.String()}, kept...)
+
+	hash := sha1.New()
+	fmt.Fprintf(hash, "%08x\n", uint32(code))
+	for _, name := range kept {
+		fmt.Fprintln(hash, name)
+	}
+
+	return &Signature{
+		Code:   code,
+		Frames: kept,
+		Key:    hex.EncodeToString(hash.Sum(nil)),
+		Text:   strings.Join(parts, " | "),
+	}
+}
+
+// FrameName returns the normalized name of a frame: "module!function" for
+// symbolized frames, "module+0xoffset" for frames with only a module, and
+// "??" otherwise, since absolute addresses vary between runs. Module names
+// are lowercased and lose their extension.
+func FrameName(frame stacks.Frame) string {
+	module := normalizeModule(frame.Module)
+
+	switch {
+	case frame.Function != "":
+		if module == "" {
+			return Normalize(frame.Function)
+		}
+		return module + "!" + Normalize(frame.Function)
+	case module != "":
+		return fmt.Sprintf("%s+0x%x", module, frame.Offset)
+	}
+
+	return "??"
+}
+
+func normalizeModule(module string) string {
+	module = strings.ToLower(module)
+
+	if i := strings.LastIndexAny(module, `\/`); i >= 0 {
+		module = module[i+1:]
+	}
+
+	for _, ext := range []string{".dll", ".exe", ".sys"} {
+		module = strings.TrimSuffix(module, ext)
+	}
+
+	return module
+}
+
+// anonymous namespace spellings of MSVC, clang and gcc.
+var anonymousNamespaces = []string{
+	"`anonymous namespace'",
+	"(anonymous namespace)",
+	"{anonymous}",
+}
+
+// Normalize rewrites a function name so that it does not vary with
+// template arguments, compilers or builds: template argument lists become
+// <T>, anonymous namespaces become (anonymous), MSVC lambda hashes are
+// dropped, and parameter lists and trailing qualifiers are removed.
+func Normalize(function string) string {
+	name := strings.TrimSpace(function)
+
+	for _, anon := range anonymousNamespaces {
+		name = strings.Replace(name, anon, "(anonymous)", -1)
+	}
+
+	name = stripParameters(name)
+	name = collapseTemplates(name)
+
+	return name
+}
+
+// stripParameters removes a trailing parameter list and the qualifiers
+// following it, such as "(int, char *) const".
+func stripParameters(name string) string {
+	end := len(name)
+	for _, suffix := range []string{" const", " volatile", " &&", " &"} {
+		if strings.HasSuffix(name[:end], suffix) {
+			end -= len(suffix)
+		}
+	}
+
+	if end == 0 || name[end-1] != ')' {
+		return name
+	}
+
+	depth := 0
+	for i := end - 1; i >= 0; i-- {
+		switch name[i] {
+		case ')':
+			depth++
+		case '(':
+			depth--
+			if depth == 0 {
+				if i == 0 || isOperatorCall(name[:i]) || name[i:end] == "(anonymous)" {
+					return name
+				}
+				return strings.TrimSpace(name[:i])
+			}
+		}
+	}
+
+	return name
+}
+
+// isOperatorCall reports whether a parameter list that starts after
+// prefix is the "()" of operator().
+func isOperatorCall(prefix string) bool {
+	return strings.HasSuffix(prefix, "operator")
+}
+
+// collapseTemplates replaces each outermost template argument list with
+// <T>, leaving comparison and shift operators and lambda names intact.
+func collapseTemplates(name string) string {
+	var b strings.Builder
+
+	for i := 0; i < len(name); {
+		if strings.HasPrefix(name[i:], "operator") {
+			op := operatorLength(name[i+len("operator"):])
+			b.WriteString(name[i : i+len("operator")+op])
+			i += len("operator") + op
+			continue
+		}
+
+		if strings.HasPrefix(name[i:], "<lambda_") {
+			end := strings.IndexByte(name[i:], '>')
+			if end > 0 {
+				b.WriteString("<lambda>")
+				i += end + 1
+				continue
+			}
+		}
+
+		if name[i] != '<' {
+			b.WriteByte(name[i])
+			i++
+			continue
+		}
+
+		end := matchAngle(name, i)
+		if end < 0 {
+			b.WriteString(name[i:])
+			break
+		}
+
+		b.WriteString("<T>")
+		i = end + 1
+	}
+
+	return b.String()
+}
+
+// operatorLength returns how many characters after "operator" belong to
+// the operator symbol, so that operator< and operator<< are not taken as
+// template argument lists.
+func operatorLength(rest string) int {
+	for _, op := range []string{"<<=", ">>=", "<=>", "<<", ">>", "<=", ">=", "->", "<", ">", "()"} {
+		if strings.HasPrefix(rest, op) {
+			return len(op)
+		}
+	}
+
+	return 0
+}
+
+// matchAngle returns the index of the '>' closing the '<' at start, or -1.
+func matchAngle(name string, start int) int {
+	depth := 0
+	for i := start; i < len(name); i++ {
+		switch name[i] {
+		case '<':
+			depth++
+		case '>':
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+
+	return -1
+}
+
+func matchAny(patterns []string, name string) bool {
+	lower := strings.ToLower(name)
+	function := lower
+	if i := strings.IndexByte(lower, '!'); i >= 0 {
+		function = lower[i+1:]
+	}
+
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+
+		if strings.IndexByte(pattern, '!') >= 0 {
+			if match(pattern, lower) {
+				return true
+			}
+		} else if match(pattern, function) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// match reports whether s matches pattern, in which * matches any run of
+// characters and everything else matches itself.
+func match(pattern, s string) bool {
+	star := strings.IndexByte(pattern, '*')
+	if star < 0 {
+		return pattern == s
+	}
+
+	if !strings.HasPrefix(s, pattern[:star]) {
+		return false
+	}
+
+	rest := pattern[star+1:]
+	for i := star; i <= len(s); i++ {
+		if match(rest, s[i:]) {
+			return true
+		}
+	}
+
+	return false
+}
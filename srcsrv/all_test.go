@@ -0,0 +1,261 @@
+//  ---------------------------------------------------------------------------
+//
+//  all_test.go
+//
+//  Copyright (c) 2016, Jared Chavez.
+//  All rights reserved.
+//
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+//
+//  -----------
+
+package srcsrv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+const gitStream = "SRCSRV: ini ------------------------------------------------\r\n" +
+	"VERSION=2\r\n" +
+	"VERCTRL=http\r\n" +
+	"SRCSRV: variables ------------------------------------------\r\n" +
+	"REPO=https://git.example.com/org/app\r\n" +
+	"SRCSRVVERCTRL=http\r\n" +
+	"HTTP_EXTRACT_TARGET=%REPO%/raw/%var2%/%var3%\r\n" +
+	"SRCSRVTRG=%HTTP_EXTRACT_TARGET%\r\n" +
+	"SRCSRV: source files ---------------------------------------\r\n" +
+	"D:\\agent\\_work\\1\\s\\src\\main.cpp*0123abcd*src/main.cpp\r\n" +
+	"D:\\agent\\_work\\1\\s\\lib\\util.h*0123abcd*lib/util.h\r\n" +
+	"SRCSRV: end ------------------------------------------------\r\n"
+
+func TestParseAndTarget(t *testing.T) {
+	stream, err := Parse(strings.NewReader(gitStream))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(stream.Ini) != 2 || len(stream.Variables) != 4 || len(stream.Files) != 2 {
+		t.Fatalf("unexpected section sizes: %+v", stream)
+	}
+
+	url, err := stream.Target("d:/AGENT/_work/1/s/src/main.cpp")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "https://git.example.com/org/app/raw/0123abcd/src/main.cpp"
+	if url != want {
+		t.Fatalf("Target = %q, want %q", url, want)
+	}
+
+	cmd, err := stream.Command("D:\\agent\\_work\\1\\s\\src\\main.cpp")
+	if err != nil || cmd != "" {
+		t.Fatalf("Command = %q, %v; want empty", cmd, err)
+	}
+
+	if _, err := stream.Target("C:\\other.cpp"); err == nil {
+		t.Fatal("expected error for unindexed file")
+	}
+}
+
+func TestExpandFunctions(t *testing.T) {
+	stream := &Stream{Targ: "C:\\src"}
+	stream.SetVar("TFS", "tf.exe get %fnvar%(%var2%_SERVER)")
+	stream.SetVar("PROD_SERVER", "http://tfs")
+	stream.SetVar(VarTarget, "%targ%\\%var2%\\%fnbksl%(%var3%)\\%fnfile%(%var1%)")
+	stream.SetVar(VarCommand, "git show %var4%:%var3%%fnchar%(62)\"%srcsrvtrg%\"")
+	stream.AddFile("/build/src/a.c", "PROD", "src/dir", "deadbeef")
+
+	entry, _ := stream.Find("\\build\\src\\a.c")
+
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"%tfs%", "tf.exe get http://tfs"},
+		{"%fnfile%(%var1%)", "a.c"},
+		{"%fnbksl%(a/b/c)", "a\\b\\c"},
+		{"%fnchar%(37)", "%"},
+		{"100% literal", "100% literal"},
+	}
+
+	for _, test := range tests {
+		got, err := stream.Expand(entry, test.text)
+		if err != nil {
+			t.Fatalf("Expand(%q): %v", test.text, err)
+		}
+
+		if got != test.want {
+			t.Errorf("Expand(%q) = %q, want %q", test.text, got, test.want)
+		}
+	}
+
+	cmd, err := stream.Command("/build/src/a.c")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "git show deadbeef:src/dir>\"C:\\src\\PROD\\src\\dir\\a.c\""
+	if cmd != want {
+		t.Fatalf("Command = %q, want %q", cmd, want)
+	}
+}
+
+func TestExpandErrors(t *testing.T) {
+	stream := new(Stream)
+	stream.SetVar("LOOP", "%loop%")
+	stream.AddFile("a.c")
+	entry := stream.Files[0]
+
+	for _, text := range []string{"%loop%", "%missing%", "%var9%", "%fnnope%(x)", "%fnfile%x"} {
+		if _, err := stream.Expand(entry, text); err == nil {
+			t.Errorf("Expand(%q) succeeded, want error", text)
+		}
+	}
+}
+
+func TestWriteRoundTrip(t *testing.T) {
+	stream := new(Stream)
+	stream.SetIni("VERSION", "2")
+	stream.SetIni("VERCTRL", "http")
+	stream.SetVar(VarVerCtrl, "http")
+	stream.SetVar(VarTarget, "https://example.com/%var2%/%var3%")
+	stream.AddFile("C:\\b\\x.go", "abc", "x.go")
+
+	var buf bytes.Buffer
+	n, err := stream.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n != int64(buf.Len()) {
+		t.Fatalf("WriteTo returned %d, wrote %d", n, buf.Len())
+	}
+
+	parsed, err := Parse(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	url, err := parsed.Target("c:\\b\\x.go")
+	if err != nil || url != "https://example.com/abc/x.go" {
+		t.Fatalf("Target = %q, %v", url, err)
+	}
+}
+
+func TestReadPDBStream(t *testing.T) {
+	pdb := buildPDB(t, 512, map[string][]byte{
+		"/names":   []byte("ignored"),
+		StreamName: []byte(gitStream),
+	})
+
+	data, err := ReadPDBStream(bytes.NewReader(pdb), StreamName)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(data) != gitStream {
+		t.Fatalf("unexpected stream contents: %q", data)
+	}
+
+	if _, err := ReadPDBStream(bytes.NewReader(pdb), "missing"); err == nil {
+		t.Fatal("expected error for missing stream")
+	}
+
+	if _, err := ReadPDBStream(bytes.NewReader(pdb[:100]), StreamName); err == nil {
+		t.Fatal("expected error for truncated file")
+	}
+}
+
+// buildPDB lays out a minimal MSF 7.00 file whose info stream maps the given
+// names to streams holding the given contents.
+func buildPDB(t *testing.T, blockSize uint32, named map[string][]byte) []byte {
+	le := binary.LittleEndian
+
+	// stream 0 is the old directory, stream 1 the PDB info stream
+	var strBuf, pairs bytes.Buffer
+	streams := [][]byte{nil, nil}
+	present := uint32(0)
+	bucket := 0
+
+	for name, data := range named {
+		binary.Write(&pairs, le, uint32(strBuf.Len()))
+		binary.Write(&pairs, le, uint32(len(streams)))
+		strBuf.WriteString(name)
+		strBuf.WriteByte(0)
+		streams = append(streams, data)
+		present |= 1 << uint(bucket)
+		bucket += 2
+	}
+
+	var info bytes.Buffer
+	binary.Write(&info, le, [7]uint32{20000404, 1, 1})
+	binary.Write(&info, le, uint32(strBuf.Len()))
+	info.Write(strBuf.Bytes())
+	binary.Write(&info, le, [2]uint32{uint32(len(named)), 32})
+	binary.Write(&info, le, [2]uint32{1, present})
+	binary.Write(&info, le, uint32(0))
+	info.Write(pairs.Bytes())
+	streams[pdbInfoStream] = info.Bytes()
+
+	// block 0 is the superblock, 1-2 the free block maps, 3 the block map
+	blocks := [][]byte{nil, nil, nil, nil}
+	alloc := func(data []byte) []uint32 {
+		var idx []uint32
+		for len(data) > 0 {
+			n := int(blockSize)
+			if n > len(data) {
+				n = len(data)
+			}
+			idx = append(idx, uint32(len(blocks)))
+			blocks = append(blocks, data[:n])
+			data = data[n:]
+		}
+		return idx
+	}
+
+	var dir bytes.Buffer
+	binary.Write(&dir, le, uint32(len(streams)))
+	var streamBlocks [][]uint32
+	for i, s := range streams {
+		if i == 0 {
+			binary.Write(&dir, le, uint32(msfNilStream))
+			streamBlocks = append(streamBlocks, nil)
+			continue
+		}
+		binary.Write(&dir, le, uint32(len(s)))
+		streamBlocks = append(streamBlocks, alloc(s))
+	}
+	for _, sb := range streamBlocks {
+		binary.Write(&dir, le, sb)
+	}
+
+	dirBlocks := alloc(dir.Bytes())
+	var blockMap bytes.Buffer
+	binary.Write(&blockMap, le, dirBlocks)
+	blocks[3] = blockMap.Bytes()
+
+	sb := msfSuperBlock{
+		BlockSize:         blockSize,
+		FreeBlockMapBlock: 1,
+		NumBlocks:         uint32(len(blocks)),
+		NumDirectoryBytes: uint32(dir.Len()),
+		BlockMapAddr:      3,
+	}
+	copy(sb.Magic[:], msfMagic)
+
+	var hdr bytes.Buffer
+	binary.Write(&hdr, le, sb)
+	blocks[0] = hdr.Bytes()
+
+	out := make([]byte, len(blocks)*int(blockSize))
+	for i, b := range blocks {
+		copy(out[i*int(blockSize):], b)
+	}
+
+	return out
+}
@@ -0,0 +1,324 @@
+//  ---------------------------------------------------------------------------
+//
+//  pdb.go
+//
+//  Copyright (c) 2016, Jared Chavez.
+//  All rights reserved.
+//
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+//
+//  -----------
+
+package srcsrv
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+)
+
+const (
+	// StreamName is the name of the srcsrv stream in the PDB named stream
+	// map, as passed to pdbstr -s:srcsrv.
+	StreamName = "srcsrv"
+
+	msfMagic = "Microsoft C/C++ MSF 7.00\r\n\x1aDS\x00\x00\x00"
+
+	msfNilStream   = 0xFFFFFFFF
+	pdbInfoStream  = 1
+	maxStreamCount = 1 << 16
+)
+
+// msfSuperBlock is the header at the start of an MSF 7.00 (PDB) file.
+type msfSuperBlock struct {
+	Magic             [32]byte
+	BlockSize         uint32
+	FreeBlockMapBlock uint32
+	NumBlocks         uint32
+	NumDirectoryBytes uint32
+	Unknown           uint32
+	BlockMapAddr      uint32
+}
+
+// ReadPDB extracts and parses the srcsrv stream of the PDB at path.
+func ReadPDB(path string) (*Stream, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	data, err := ReadPDBStream(f, StreamName)
+	if err != nil {
+		return nil, err
+	}
+
+	return Parse(bytes.NewReader(data))
+}
+
+// ReadPDBStream returns the contents of the named stream of an MSF 7.00 PDB.
+func ReadPDBStream(r io.ReaderAt, name string) ([]byte, error) {
+	msf, err := openMSF(r)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := msf.stream(pdbInfoStream)
+	if err != nil {
+		return nil, fmt.Errorf("reading PDB info stream: %v", err)
+	}
+
+	names, err := parseNamedStreams(info)
+	if err != nil {
+		return nil, err
+	}
+
+	idx, ok := names[name]
+	if !ok {
+		return nil, fmt.Errorf("PDB has no %q stream", name)
+	}
+
+	return msf.stream(idx)
+}
+
+type msfFile struct {
+	r           io.ReaderAt
+	blockSize   uint32
+	numBlocks   uint32
+	streamSizes []uint32
+	streamPages [][]uint32
+}
+
+func openMSF(r io.ReaderAt) (*msfFile, error) {
+	var sb msfSuperBlock
+
+	err := binary.Read(io.NewSectionReader(r, 0, 56), binary.LittleEndian, &sb)
+	if err != nil {
+		return nil, fmt.Errorf("reading MSF superblock: %v", err)
+	}
+
+	if string(sb.Magic[:]) != msfMagic {
+		return nil, fmt.Errorf("not an MSF 7.00 file")
+	}
+
+	switch sb.BlockSize {
+	case 512, 1024, 2048, 4096:
+	default:
+		return nil, fmt.Errorf("invalid MSF block size %d", sb.BlockSize)
+	}
+
+	msf := &msfFile{
+		r:         r,
+		blockSize: sb.BlockSize,
+		numBlocks: sb.NumBlocks,
+	}
+
+	// The block map is a list of the blocks that hold the stream directory.
+	dirBlocks := msf.blockCount(sb.NumDirectoryBytes)
+	blockMap, err := msf.readUint32s(int64(sb.BlockMapAddr)*int64(sb.BlockSize), dirBlocks)
+	if err != nil {
+		return nil, fmt.Errorf("reading MSF block map: %v", err)
+	}
+
+	dir, err := msf.readBlocks(blockMap, sb.NumDirectoryBytes)
+	if err != nil {
+		return nil, fmt.Errorf("reading MSF directory: %v", err)
+	}
+
+	return msf, msf.parseDirectory(dir)
+}
+
+func (msf *msfFile) parseDirectory(dir []byte) error {
+	if len(dir) < 4 {
+		return fmt.Errorf("truncated MSF directory")
+	}
+
+	numStreams := binary.LittleEndian.Uint32(dir)
+	dir = dir[4:]
+
+	if numStreams > maxStreamCount || uint64(len(dir)) < uint64(numStreams)*4 {
+		return fmt.Errorf("invalid MSF stream count %d", numStreams)
+	}
+
+	msf.streamSizes = make([]uint32, numStreams)
+	for i := range msf.streamSizes {
+		msf.streamSizes[i] = binary.LittleEndian.Uint32(dir[i*4:])
+	}
+	dir = dir[numStreams*4:]
+
+	msf.streamPages = make([][]uint32, numStreams)
+	for i, size := range msf.streamSizes {
+		if size == msfNilStream {
+			continue
+		}
+
+		count := msf.blockCount(size)
+		if len(dir) < int(count)*4 {
+			return fmt.Errorf("truncated MSF directory")
+		}
+
+		pages := make([]uint32, count)
+		for j := range pages {
+			pages[j] = binary.LittleEndian.Uint32(dir[j*4:])
+		}
+
+		msf.streamPages[i] = pages
+		dir = dir[count*4:]
+	}
+
+	return nil
+}
+
+func (msf *msfFile) stream(idx uint32) ([]byte, error) {
+	if int(idx) >= len(msf.streamSizes) {
+		return nil, fmt.Errorf("stream %d does not exist", idx)
+	}
+
+	size := msf.streamSizes[idx]
+	if size == msfNilStream {
+		return nil, nil
+	}
+
+	return msf.readBlocks(msf.streamPages[idx], size)
+}
+
+func (msf *msfFile) blockCount(size uint32) uint32 {
+	return (size + msf.blockSize - 1) / msf.blockSize
+}
+
+func (msf *msfFile) readBlocks(blocks []uint32, size uint32) ([]byte, error) {
+	if uint64(len(blocks))*uint64(msf.blockSize) < uint64(size) {
+		return nil, fmt.Errorf("stream size %d exceeds its blocks", size)
+	}
+
+	buffer := make([]byte, size)
+	for i := uint32(0); i*msf.blockSize < size; i++ {
+		if blocks[i] >= msf.numBlocks {
+			return nil, fmt.Errorf("block %d out of range", blocks[i])
+		}
+
+		chunk := buffer[i*msf.blockSize:]
+		if uint32(len(chunk)) > msf.blockSize {
+			chunk = chunk[:msf.blockSize]
+		}
+
+		_, err := msf.r.ReadAt(chunk, int64(blocks[i])*int64(msf.blockSize))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return buffer, nil
+}
+
+func (msf *msfFile) readUint32s(offset int64, count uint32) ([]uint32, error) {
+	vals := make([]uint32, count)
+	err := binary.Read(
+		io.NewSectionReader(msf.r, offset, int64(count)*4),
+		binary.LittleEndian,
+		vals,
+	)
+
+	return vals, err
+}
+
+// parseNamedStreams decodes the named stream map that follows the header of
+// the PDB info stream.
+func parseNamedStreams(info []byte) (map[string]uint32, error) {
+	// Version, Signature, Age, Guid
+	const headerSize = 4 + 4 + 4 + 16
+
+	rd := &leReader{buf: info}
+	rd.skip(headerSize)
+
+	strSize := rd.uint32()
+	strBuf := rd.bytes(strSize)
+
+	size := rd.uint32()
+	rd.uint32() // capacity
+
+	present := rd.bitVector()
+	rd.bitVector() // deleted
+
+	if rd.err != nil {
+		return nil, fmt.Errorf("reading named stream map: %v", rd.err)
+	}
+
+	names := make(map[string]uint32, size)
+	for i := 0; i < len(present)*32; i++ {
+		if present[i/32]&(1<<uint(i%32)) == 0 {
+			continue
+		}
+
+		key := rd.uint32()
+		val := rd.uint32()
+		if rd.err != nil {
+			return nil, fmt.Errorf("reading named stream map: %v", rd.err)
+		}
+
+		if key >= uint32(len(strBuf)) {
+			return nil, fmt.Errorf("named stream offset %d out of range", key)
+		}
+
+		name := strBuf[key:]
+		if end := bytes.IndexByte(name, 0); end >= 0 {
+			name = name[:end]
+		}
+
+		names[string(name)] = val
+	}
+
+	return names, nil
+}
+
+type leReader struct {
+	buf []byte
+	err error
+}
+
+func (rd *leReader) skip(n int) {
+	rd.bytes(uint32(n))
+}
+
+func (rd *leReader) bytes(n uint32) []byte {
+	if rd.err != nil {
+		return nil
+	}
+
+	if uint64(n) > uint64(len(rd.buf)) {
+		rd.err = io.ErrUnexpectedEOF
+		return nil
+	}
+
+	b := rd.buf[:n]
+	rd.buf = rd.buf[n:]
+
+	return b
+}
+
+func (rd *leReader) uint32() uint32 {
+	b := rd.bytes(4)
+	if b == nil {
+		return 0
+	}
+
+	return binary.LittleEndian.Uint32(b)
+}
+
+func (rd *leReader) bitVector() []uint32 {
+	words := rd.uint32()
+	if uint64(words)*4 > uint64(len(rd.buf)) {
+		rd.err = io.ErrUnexpectedEOF
+		return nil
+	}
+
+	vec := make([]uint32, words)
+	for i := range vec {
+		vec[i] = rd.uint32()
+	}
+
+	return vec
+}
@@ -0,0 +1,429 @@
+//  ---------------------------------------------------------------------------
+//
+//  srcsrv.go
+//
+//  Copyright (c) 2016, Jared Chavez.
+//  All rights reserved.
+//
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+//
+//  -----------
+
+// Package srcsrv reads and writes the source server (srcsrv) stream that
+// source-indexed PDBs carry, and expands its entries into the command or URL
+// used to retrieve each source file from version control.
+package srcsrv
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Section header prefixes.
+const (
+	headerIni       = "SRCSRV: ini"
+	headerVariables = "SRCSRV: variables"
+	headerFiles     = "SRCSRV: source files"
+	headerEnd       = "SRCSRV: end"
+
+	headerFill = " ------------------------------------------------"
+)
+
+// Well known variable names.
+const (
+	VarTarget  = "SRCSRVTRG"
+	VarCommand = "SRCSRVCMD"
+	VarEnv     = "SRCSRVENV"
+	VarVerCtrl = "SRCSRVVERCTRL"
+	VarErrDesc = "SRCSRVERRDESC"
+	VarErrVar  = "SRCSRVERRVAR"
+)
+
+// maxExpandDepth bounds recursive variable expansion so that
+// self-referencing variables fail instead of looping forever.
+const maxExpandDepth = 32
+
+// Var is a single NAME=value pair from the ini or variables section.
+type Var struct {
+	Name  string
+	Value string
+}
+
+// Entry is a single record from the source files section. Entry[0] is the
+// build machine path of the file (%var1%), the remaining fields are
+// %var2%..%varN%.
+type Entry []string
+
+// Path returns the build machine path of the source file.
+func (e Entry) Path() string {
+	if len(e) == 0 {
+		return ""
+	}
+
+	return e[0]
+}
+
+// Stream is a parsed srcsrv stream.
+type Stream struct {
+	Ini       []Var
+	Variables []Var
+	Files     []Entry
+
+	// Targ is substituted for %targ%, the local directory that the
+	// debugger extracts source files into.
+	Targ string
+}
+
+// Parse reads a srcsrv stream.
+func Parse(r io.Reader) (*Stream, error) {
+	const (
+		secNone = iota
+		secIni
+		secVariables
+		secFiles
+		secEnd
+	)
+
+	stream := new(Stream)
+	section := secNone
+	lineNum := 0
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4096), 1024*1024)
+
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimRight(scanner.Text(), "\r")
+
+		if strings.HasPrefix(line, "SRCSRV:") {
+			switch {
+			case strings.HasPrefix(line, headerIni):
+				section = secIni
+			case strings.HasPrefix(line, headerVariables):
+				section = secVariables
+			case strings.HasPrefix(line, headerFiles):
+				section = secFiles
+			case strings.HasPrefix(line, headerEnd):
+				section = secEnd
+			default:
+				return nil, fmt.Errorf("line %d: unknown section %q", lineNum, line)
+			}
+			continue
+		}
+
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		switch section {
+		case secIni, secVariables:
+			idx := strings.IndexByte(line, '=')
+			if idx < 1 {
+				return nil, fmt.Errorf("line %d: malformed variable %q", lineNum, line)
+			}
+
+			v := Var{Name: strings.TrimSpace(line[:idx]), Value: line[idx+1:]}
+			if section == secIni {
+				stream.Ini = append(stream.Ini, v)
+			} else {
+				stream.Variables = append(stream.Variables, v)
+			}
+		case secFiles:
+			stream.Files = append(stream.Files, Entry(strings.Split(line, "*")))
+		case secEnd:
+			// trailing data after the end marker is ignored
+		default:
+			return nil, fmt.Errorf("line %d: data outside of a section", lineNum)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if section == secNone {
+		return nil, fmt.Errorf("no srcsrv sections found")
+	}
+
+	return stream, nil
+}
+
+// Get returns the value of the named ini setting or variable. Variable names
+// are case insensitive, and variables take precedence over ini settings.
+func (s *Stream) Get(name string) (string, bool) {
+	for i := range s.Variables {
+		if strings.EqualFold(s.Variables[i].Name, name) {
+			return s.Variables[i].Value, true
+		}
+	}
+
+	for i := range s.Ini {
+		if strings.EqualFold(s.Ini[i].Name, name) {
+			return s.Ini[i].Value, true
+		}
+	}
+
+	return "", false
+}
+
+// SetIni sets an ini setting, replacing any existing value.
+func (s *Stream) SetIni(name, value string) {
+	s.Ini = setVar(s.Ini, name, value)
+}
+
+// SetVar sets a variable, replacing any existing value.
+func (s *Stream) SetVar(name, value string) {
+	s.Variables = setVar(s.Variables, name, value)
+}
+
+// AddFile appends a source file entry. path becomes %var1% and fields
+// become %var2% onward.
+func (s *Stream) AddFile(path string, fields ...string) {
+	entry := make(Entry, 0, len(fields)+1)
+	entry = append(entry, path)
+	entry = append(entry, fields...)
+
+	s.Files = append(s.Files, entry)
+}
+
+// Find returns the entry for the given build machine path. Paths are
+// compared case insensitively and without regard to slash direction, which
+// matches how the debugger looks them up.
+func (s *Stream) Find(path string) (Entry, bool) {
+	want := normalizePath(path)
+
+	for i := range s.Files {
+		if normalizePath(s.Files[i].Path()) == want {
+			return s.Files[i], true
+		}
+	}
+
+	return nil, false
+}
+
+// Target returns the expanded SRCSRVTRG value for the given build machine
+// path. For http based indexes this is the URL of the file.
+func (s *Stream) Target(path string) (string, error) {
+	return s.expandVar(path, VarTarget)
+}
+
+// Command returns the expanded SRCSRVCMD value for the given build machine
+// path. Indexes that retrieve files over http have no command, in which case
+// an empty string is returned.
+func (s *Stream) Command(path string) (string, error) {
+	if _, ok := s.Get(VarCommand); !ok {
+		return "", nil
+	}
+
+	return s.expandVar(path, VarCommand)
+}
+
+// Expand expands all %variable% and %fn...%() references in text in the
+// context of the given entry.
+func (s *Stream) Expand(entry Entry, text string) (string, error) {
+	return s.expand(entry, text, 0)
+}
+
+// WriteTo writes the stream in the format expected by pdbstr and the
+// debugger.
+func (s *Stream) WriteTo(w io.Writer) (int64, error) {
+	bw := bufio.NewWriter(w)
+	cw := &countWriter{w: bw}
+
+	fmt.Fprint(cw, headerIni+headerFill+"\r\n")
+	for i := range s.Ini {
+		fmt.Fprintf(cw, "%s=%s\r\n", s.Ini[i].Name, s.Ini[i].Value)
+	}
+
+	fmt.Fprint(cw, headerVariables+headerFill+"\r\n")
+	for i := range s.Variables {
+		fmt.Fprintf(cw, "%s=%s\r\n", s.Variables[i].Name, s.Variables[i].Value)
+	}
+
+	fmt.Fprint(cw, headerFiles+headerFill+"\r\n")
+	for i := range s.Files {
+		fmt.Fprintf(cw, "%s\r\n", strings.Join(s.Files[i], "*"))
+	}
+
+	fmt.Fprint(cw, headerEnd+headerFill+"\r\n")
+
+	if cw.err != nil {
+		return cw.n, cw.err
+	}
+
+	return cw.n, bw.Flush()
+}
+
+func (s *Stream) expandVar(path, name string) (string, error) {
+	entry, ok := s.Find(path)
+	if !ok {
+		return "", fmt.Errorf("%s is not source indexed", path)
+	}
+
+	text, ok := s.Get(name)
+	if !ok {
+		return "", fmt.Errorf("%s is not defined", name)
+	}
+
+	return s.Expand(entry, text)
+}
+
+func (s *Stream) expand(entry Entry, text string, depth int) (string, error) {
+	if depth > maxExpandDepth {
+		return "", fmt.Errorf("variable expansion too deep in %q", text)
+	}
+
+	var out strings.Builder
+
+	for len(text) > 0 {
+		start := strings.IndexByte(text, '%')
+		if start < 0 {
+			out.WriteString(text)
+			break
+		}
+
+		end := strings.IndexByte(text[start+1:], '%')
+		if end < 0 {
+			out.WriteString(text)
+			break
+		}
+		end += start + 1
+
+		out.WriteString(text[:start])
+		name := text[start+1 : end]
+		text = text[end+1:]
+
+		if strings.HasPrefix(strings.ToLower(name), "fn") {
+			arg, rest, err := splitFnArg(text)
+			if err != nil {
+				return "", fmt.Errorf("%%%s%%: %v", name, err)
+			}
+			text = rest
+
+			arg, err = s.expand(entry, arg, depth+1)
+			if err != nil {
+				return "", err
+			}
+
+			val, err := s.callFn(entry, name, arg, depth)
+			if err != nil {
+				return "", err
+			}
+
+			out.WriteString(val)
+			continue
+		}
+
+		val, err := s.lookup(entry, name, depth)
+		if err != nil {
+			return "", err
+		}
+
+		out.WriteString(val)
+	}
+
+	return out.String(), nil
+}
+
+func (s *Stream) lookup(entry Entry, name string, depth int) (string, error) {
+	lower := strings.ToLower(name)
+
+	if lower == "targ" {
+		return s.Targ, nil
+	}
+
+	if strings.HasPrefix(lower, "var") {
+		if n, err := strconv.Atoi(lower[3:]); err == nil {
+			if n < 1 || n > len(entry) {
+				return "", fmt.Errorf("%%%s%% out of range for %s", name, entry.Path())
+			}
+
+			return entry[n-1], nil
+		}
+	}
+
+	val, ok := s.Get(name)
+	if !ok {
+		return "", fmt.Errorf("%%%s%% is not defined", name)
+	}
+
+	return s.expand(entry, val, depth+1)
+}
+
+func (s *Stream) callFn(entry Entry, name, arg string, depth int) (string, error) {
+	switch strings.ToLower(name) {
+	case "fnvar":
+		return s.lookup(entry, arg, depth+1)
+	case "fnbksl":
+		return strings.Replace(arg, "/", "\\", -1), nil
+	case "fnfile":
+		idx := strings.LastIndexAny(arg, "\\/")
+		return arg[idx+1:], nil
+	case "fnchar":
+		n, err := strconv.Atoi(strings.TrimSpace(arg))
+		if err != nil || n < 0 || n > 0xFF {
+			return "", fmt.Errorf("%%%s%%: invalid character code %q", name, arg)
+		}
+		return string(rune(n)), nil
+	}
+
+	return "", fmt.Errorf("unknown function %%%s%%", name)
+}
+
+func splitFnArg(text string) (string, string, error) {
+	if len(text) == 0 || text[0] != '(' {
+		return "", "", fmt.Errorf("missing argument list")
+	}
+
+	depth := 0
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return text[1:i], text[i+1:], nil
+			}
+		}
+	}
+
+	return "", "", fmt.Errorf("unterminated argument list")
+}
+
+func normalizePath(path string) string {
+	return strings.ToLower(strings.Replace(path, "/", "\\", -1))
+}
+
+func setVar(vars []Var, name, value string) []Var {
+	for i := range vars {
+		if strings.EqualFold(vars[i].Name, name) {
+			vars[i].Value = value
+			return vars
+		}
+	}
+
+	return append(vars, Var{Name: name, Value: value})
+}
+
+type countWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	if cw.err != nil {
+		return 0, cw.err
+	}
+
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	cw.err = err
+
+	return n, err
+}
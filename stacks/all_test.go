@@ -0,0 +1,296 @@
+//  ---------------------------------------------------------------------------
+//
+//  all_test.go
+//
+//  Copyright (c) 2016, Jared Chavez.
+//  All rights reserved.
+//
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+//
+//  -----------
+
+package stacks
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"testing"
+)
+
+type fakeBackend struct {
+	proc    *fakeProcess
+	openErr error
+}
+
+func (b *fakeBackend) OpenProcess(pid uint32) (Process, error) {
+	if b.openErr != nil {
+		return nil, b.openErr
+	}
+
+	b.proc.open = true
+	return b.proc, nil
+}
+
+type fakeProcess struct {
+	threads  map[uint32]*fakeThread
+	order    []uint32
+	open     bool
+	symInit  bool
+	symPath  string
+	openErrs map[uint32]error
+}
+
+func newFakeProcess() *fakeProcess {
+	return &fakeProcess{
+		threads:  make(map[uint32]*fakeThread),
+		openErrs: make(map[uint32]error),
+	}
+}
+
+func (p *fakeProcess) add(t *fakeThread) {
+	p.threads[t.id] = t
+	p.order = append(p.order, t.id)
+}
+
+func (p *fakeProcess) Threads() ([]uint32, error) { return p.order, nil }
+
+func (p *fakeProcess) OpenThread(tid uint32) (ThreadHandle, error) {
+	if err := p.openErrs[tid]; err != nil {
+		return nil, err
+	}
+
+	t := p.threads[tid]
+	t.open = true
+	return t, nil
+}
+
+func (p *fakeProcess) InitSymbols(path string) error {
+	p.symInit = true
+	p.symPath = path
+	return nil
+}
+
+func (p *fakeProcess) CleanupSymbols() error {
+	p.symInit = false
+	return nil
+}
+
+func (p *fakeProcess) Symbolize(pc uint64) Frame {
+	return Frame{PC: pc, Module: "app", Function: "fn", Offset: pc & 0xFF}
+}
+
+func (p *fakeProcess) Close() error {
+	p.open = false
+	return nil
+}
+
+type fakeThread struct {
+	id         uint32
+	name       string
+	pcs        []uint64
+	walkErr    error
+	suspendErr error
+	panicWalk  bool
+
+	open        bool
+	suspended   int
+	maxSuspends int
+}
+
+func (t *fakeThread) Name() (string, error) { return t.name, nil }
+
+func (t *fakeThread) Suspend() error {
+	if t.suspendErr != nil {
+		return t.suspendErr
+	}
+
+	t.suspended++
+	if t.suspended > t.maxSuspends {
+		t.maxSuspends = t.suspended
+	}
+	return nil
+}
+
+func (t *fakeThread) Resume() error {
+	t.suspended--
+	return nil
+}
+
+func (t *fakeThread) Walk(maxDepth int) ([]uint64, error) {
+	if t.panicWalk {
+		panic("walk exploded")
+	}
+
+	return t.pcs, t.walkErr
+}
+
+func (t *fakeThread) Close() error {
+	t.open = false
+	return nil
+}
+
+func checkReleased(t *testing.T, proc *fakeProcess) {
+	if proc.open {
+		t.Error("process handle left open")
+	}
+
+	if proc.symInit {
+		t.Error("symbols not cleaned up")
+	}
+
+	for _, th := range proc.threads {
+		if th.open {
+			t.Errorf("thread %d handle left open", th.id)
+		}
+
+		if th.suspended != 0 {
+			t.Errorf("thread %d left suspended (%d)", th.id, th.suspended)
+		}
+	}
+}
+
+func TestCapture(t *testing.T) {
+	proc := newFakeProcess()
+	proc.add(&fakeThread{id: 1, name: "main", pcs: []uint64{0x1010, 0x2020, 0x3030}})
+	proc.add(&fakeThread{id: 2, pcs: []uint64{0x4040}, walkErr: errors.New("bad frame")})
+	proc.add(&fakeThread{id: 3, suspendErr: errors.New("access denied")})
+	proc.add(&fakeThread{id: 4, pcs: []uint64{0x5050}})
+	proc.openErrs[4] = errors.New("gone")
+
+	threads, err := Capture(&fakeBackend{proc: proc}, 42, &Options{SymbolPath: "srv*c:\\sym"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	checkReleased(t, proc)
+
+	if proc.symPath != "srv*c:\\sym" {
+		t.Errorf("symbol path = %q", proc.symPath)
+	}
+
+	if len(threads) != 4 {
+		t.Fatalf("got %d threads, want 4", len(threads))
+	}
+
+	if threads[0].Name != "main" || len(threads[0].Frames) != 3 || threads[0].Error != nil {
+		t.Errorf("thread 1 = %+v", threads[0])
+	}
+
+	if threads[0].Frames[1].String() != "app!fn+0x20" {
+		t.Errorf("frame string = %q", threads[0].Frames[1].String())
+	}
+
+	if threads[1].Error == nil || len(threads[1].Frames) != 1 {
+		t.Errorf("thread 2 should keep partial frames and report error: %+v", threads[1])
+	}
+
+	if threads[2].Error == nil || len(threads[2].Frames) != 0 {
+		t.Errorf("thread 3 should report suspend error: %+v", threads[2])
+	}
+
+	if threads[3].Error == nil {
+		t.Errorf("thread 4 should report open error: %+v", threads[3])
+	}
+
+	for _, th := range proc.threads {
+		if th.maxSuspends > 1 {
+			t.Errorf("thread %d suspended %d times", th.id, th.maxSuspends)
+		}
+	}
+}
+
+func TestCaptureOptions(t *testing.T) {
+	proc := newFakeProcess()
+	proc.add(&fakeThread{id: 1, pcs: []uint64{1, 2, 3, 4, 5}})
+	proc.add(&fakeThread{id: 2, pcs: []uint64{1}})
+
+	threads, err := Capture(&fakeBackend{proc: proc}, 42, &Options{
+		Filter:   func(tid uint32) bool { return tid == 1 },
+		MaxDepth: 2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(threads) != 1 || threads[0].ID != 1 {
+		t.Fatalf("filter not applied: %+v", threads)
+	}
+
+	if len(threads[0].Frames) != 2 {
+		t.Fatalf("got %d frames, want 2", len(threads[0].Frames))
+	}
+
+	if proc.threads[2].open || proc.threads[2].maxSuspends != 0 {
+		t.Error("filtered thread was touched")
+	}
+}
+
+func TestCapturePanicReleases(t *testing.T) {
+	proc := newFakeProcess()
+	proc.add(&fakeThread{id: 1, pcs: []uint64{1}})
+	proc.add(&fakeThread{id: 2, panicWalk: true})
+
+	func() {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Error("expected panic to propagate")
+			}
+		}()
+
+		Capture(&fakeBackend{proc: proc}, 42, nil)
+	}()
+
+	checkReleased(t, proc)
+}
+
+func TestCaptureOpenError(t *testing.T) {
+	_, err := Capture(&fakeBackend{openErr: errors.New("denied")}, 42, nil)
+	if err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestCaptureSelf(t *testing.T) {
+	proc := &fakeProcess{}
+
+	_, err := Capture(&fakeBackend{proc: proc}, uint32(os.Getpid()), nil)
+	if err != ErrSelf {
+		t.Fatalf("Capture of own process = %v, want ErrSelf", err)
+	}
+
+	if proc.open {
+		t.Error("own process was opened")
+	}
+}
+
+func TestFormat(t *testing.T) {
+	threads := []Thread{
+		{
+			ID:   4,
+			Name: "main",
+			Frames: []Frame{
+				{Module: "app", Function: "wait", Offset: 0x10},
+				{Module: "app", Function: "main", Offset: 0x2a, File: "main.c", Line: 12},
+			},
+		},
+		{ID: 8, Error: errors.New("suspending thread: denied")},
+	}
+
+	var buf bytes.Buffer
+	if err := Format(&buf, threads); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "thread 4 (main):\n" +
+		"  #0   app!wait+0x10\n" +
+		"  #1   app!main+0x2a [main.c @ 12]\n" +
+		"\n" +
+		"thread 8:\n" +
+		"  error: suspending thread: denied\n"
+
+	if buf.String() != want {
+		t.Fatalf("Format:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
@@ -0,0 +1,241 @@
+//  ---------------------------------------------------------------------------
+//
+//  stacks.go
+//
+//  Copyright (c) 2016, Jared Chavez.
+//  All rights reserved.
+//
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+//
+//  -----------
+
+// Package stacks captures symbolized stack traces for every thread of a
+// running process.
+package stacks
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// ErrSelf is returned for the calling process. Capturing it would suspend
+// the capturing thread, which never resumes, and the Go runtime threads,
+// which can deadlock the capture.
+var ErrSelf = errors.New("stacks: cannot capture the calling process")
+
+// DefaultMaxDepth is the frame limit used when Options.MaxDepth is zero.
+const DefaultMaxDepth = 128
+
+// Frame is a single symbolized stack frame.
+type Frame struct {
+	PC       uint64
+	Module   string
+	Function string
+	Offset   uint64
+	File     string
+	Line     uint32
+}
+
+func (f Frame) String() string {
+	var str string
+
+	switch {
+	case f.Function != "":
+		str = fmt.Sprintf("%s!%s+0x%x", f.Module, f.Function, f.Offset)
+	case f.Module != "":
+		str = fmt.Sprintf("%s+0x%x", f.Module, f.Offset)
+	default:
+		str = fmt.Sprintf("0x%x", f.PC)
+	}
+
+	if f.File != "" {
+		str += fmt.Sprintf(" [%s @ %d]", f.File, f.Line)
+	}
+
+	return str
+}
+
+// Thread is the captured stack of a single thread. Error is set when the
+// thread could not be suspended or walked; any frames captured before the
+// failure are kept.
+type Thread struct {
+	ID     uint32
+	Name   string
+	Frames []Frame
+	Error  error
+}
+
+// Options control a capture. A nil *Options uses the defaults.
+type Options struct {
+	// Filter, when set, selects which thread IDs are captured.
+	Filter func(tid uint32) bool
+
+	// MaxDepth limits the number of frames per thread.
+	MaxDepth int
+
+	// SymbolPath is passed to SymInitialize. An empty path uses the
+	// default search path.
+	SymbolPath string
+}
+
+// Backend opens processes for capture. The Windows implementation is built
+// on kernel32 and dbgHelp; tests substitute a fake.
+type Backend interface {
+	OpenProcess(pid uint32) (Process, error)
+}
+
+// Process is an open target process.
+type Process interface {
+	// Threads lists the IDs of the threads owned by the process.
+	Threads() ([]uint32, error)
+
+	// OpenThread opens one thread of the process.
+	OpenThread(tid uint32) (ThreadHandle, error)
+
+	// InitSymbols prepares symbol resolution for the process.
+	InitSymbols(searchPath string) error
+
+	// CleanupSymbols releases what InitSymbols acquired.
+	CleanupSymbols() error
+
+	// Symbolize resolves a program counter.
+	Symbolize(pc uint64) Frame
+
+	Close() error
+}
+
+// ThreadHandle is an open thread of the target process.
+type ThreadHandle interface {
+	Name() (string, error)
+	Suspend() error
+	Resume() error
+
+	// Walk returns the program counters of the suspended thread's stack,
+	// innermost first, up to maxDepth entries.
+	Walk(maxDepth int) ([]uint64, error)
+
+	Close() error
+}
+
+// Capture captures the stacks of the threads of process pid through the
+// given backend. Every thread that is suspended is resumed, and every handle
+// that is opened is closed, before Capture returns or panics. pid must not
+// be the calling process; see ErrSelf.
+func Capture(backend Backend, pid uint32, opts *Options) ([]Thread, error) {
+	if pid == uint32(os.Getpid()) {
+		return nil, ErrSelf
+	}
+
+	if opts == nil {
+		opts = new(Options)
+	}
+
+	maxDepth := opts.MaxDepth
+	if maxDepth <= 0 {
+		maxDepth = DefaultMaxDepth
+	}
+
+	proc, err := backend.OpenProcess(pid)
+	if err != nil {
+		return nil, fmt.Errorf("opening process %d: %v", pid, err)
+	}
+	defer proc.Close()
+
+	err = proc.InitSymbols(opts.SymbolPath)
+	if err != nil {
+		return nil, fmt.Errorf("initializing symbols: %v", err)
+	}
+	defer proc.CleanupSymbols()
+
+	tids, err := proc.Threads()
+	if err != nil {
+		return nil, fmt.Errorf("listing threads: %v", err)
+	}
+
+	threads := make([]Thread, 0, len(tids))
+	for _, tid := range tids {
+		if opts.Filter != nil && !opts.Filter(tid) {
+			continue
+		}
+
+		thread := Thread{ID: tid}
+		pcs, err := captureThread(proc, &thread, maxDepth)
+		thread.Error = err
+
+		// symbolize after the thread has been resumed
+		for _, pc := range pcs {
+			thread.Frames = append(thread.Frames, proc.Symbolize(pc))
+		}
+
+		threads = append(threads, thread)
+	}
+
+	return threads, nil
+}
+
+func captureThread(proc Process, thread *Thread, maxDepth int) ([]uint64, error) {
+	handle, err := proc.OpenThread(thread.ID)
+	if err != nil {
+		return nil, fmt.Errorf("opening thread: %v", err)
+	}
+	defer handle.Close()
+
+	name, err := handle.Name()
+	if err == nil {
+		thread.Name = name
+	}
+
+	return Walk(handle, maxDepth)
+}
+
+// Walk suspends the thread behind handle, walks its stack and resumes it
+// again. The thread is resumed even if the walk panics.
+func Walk(handle ThreadHandle, maxDepth int) ([]uint64, error) {
+	err := handle.Suspend()
+	if err != nil {
+		return nil, fmt.Errorf("suspending thread: %v", err)
+	}
+	defer handle.Resume()
+
+	pcs, err := handle.Walk(maxDepth)
+	if len(pcs) > maxDepth {
+		pcs = pcs[:maxDepth]
+	}
+
+	if err != nil {
+		return pcs, fmt.Errorf("walking stack: %v", err)
+	}
+
+	return pcs, nil
+}
+
+// Format writes threads in a plain text report, one frame per line.
+func Format(w io.Writer, threads []Thread) error {
+	bw := bufio.NewWriter(w)
+
+	for i, thread := range threads {
+		if i > 0 {
+			fmt.Fprintln(bw)
+		}
+
+		fmt.Fprintf(bw, "thread %d", thread.ID)
+		if thread.Name != "" {
+			fmt.Fprintf(bw, " (%s)", thread.Name)
+		}
+		fmt.Fprintln(bw, ":")
+
+		for n, frame := range thread.Frames {
+			fmt.Fprintf(bw, "  #%-3d %v\n", n, frame)
+		}
+
+		if thread.Error != nil {
+			fmt.Fprintf(bw, "  error: %v\n", thread.Error)
+		}
+	}
+
+	return bw.Flush()
+}
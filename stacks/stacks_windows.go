@@ -0,0 +1,191 @@
+//  ---------------------------------------------------------------------------
+//
+//  stacks_windows.go
+//
+//  Copyright (c) 2016, Jared Chavez.
+//  All rights reserved.
+//
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+//
+//  -----------
+
+package stacks
+
+import (
+	"syscall"
+	"time"
+
+	"github.com/xaevman/win32/dbgHelp"
+	"github.com/xaevman/win32/kernel32"
+)
+
+// CaptureStacks captures the stacks of the threads of process pid.
+func CaptureStacks(pid uint32, opts *Options) ([]Thread, error) {
+	return Capture(NewBackend(), pid, opts)
+}
+
+// NewBackend returns the Backend that operates on live Windows processes.
+func NewBackend() Backend {
+	return winBackend{}
+}
+
+type winBackend struct{}
+
+// processAccess covers SymInitialize, which enumerates the modules, and
+// StackWalk64, which reads the stacks.
+const processAccess = kernel32.PROCESS_QUERY_INFORMATION | kernel32.PROCESS_VM_READ
+
+// threadAccess covers suspending, reading the context, and the
+// description and times queries.
+const threadAccess = kernel32.THREAD_SUSPEND_RESUME |
+	kernel32.THREAD_GET_CONTEXT |
+	kernel32.THREAD_QUERY_LIMITED_INFORMATION
+
+func (winBackend) OpenProcess(pid uint32) (Process, error) {
+	proc, err := kernel32.OpenProcess(pid, processAccess)
+	if err != nil {
+		return nil, err
+	}
+
+	return &winProcess{pid: pid, handle: proc}, nil
+}
+
+type winProcess struct {
+	pid    uint32
+	handle syscall.Handle
+}
+
+func (p *winProcess) Threads() ([]uint32, error) {
+	snapshot, err := kernel32.NewSnapshot(kernel32.TH32CS_SNAPTHREAD, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer snapshot.Close()
+
+	var tids []uint32
+
+	it := snapshot.Threads()
+	for it.Next() {
+		if entry := it.Entry(); entry.OwnerProcessID == p.pid {
+			tids = append(tids, entry.ThreadID)
+		}
+	}
+
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+
+	return tids, nil
+}
+
+func (p *winProcess) OpenThread(tid uint32) (ThreadHandle, error) {
+	handle, err := kernel32.OpenThread(tid, threadAccess)
+	if err != nil {
+		return nil, err
+	}
+
+	return &winThread{proc: p.handle, handle: handle}, nil
+}
+
+func (p *winProcess) InitSymbols(searchPath string) error {
+	dbg.SymSetOptions(
+		dbg.SYMOPT_UNDNAME |
+			dbg.SYMOPT_DEFERRED_LOADS |
+			dbg.SYMOPT_LOAD_LINES |
+			dbg.SYMOPT_FAIL_CRITICAL_ERRORS,
+	)
+
+	return dbg.SymInitialize(p.handle, searchPath, true)
+}
+
+func (p *winProcess) CleanupSymbols() error {
+	return dbg.SymCleanup(p.handle)
+}
+
+func (p *winProcess) Symbolize(pc uint64) Frame {
+	frame := Frame{PC: pc}
+
+	modInfo, err := dbg.SymGetModuleInfoW64(p.handle, pc)
+	if err == nil {
+		frame.Module = syscall.UTF16ToString(modInfo.ModuleName[:])
+		frame.Offset = pc - modInfo.BaseOfImage
+	}
+
+	var sym dbg.SymbolInfo
+	if dbg.SymFromAddr(p.handle, pc, &sym) == nil && sym.Name != "" {
+		frame.Function = sym.Name
+		frame.Offset = sym.Offset
+	}
+
+	var line dbg.SymbolInfo
+	if dbg.SymGetLineFromAddr64(p.handle, pc, &line) == nil {
+		frame.File = line.FileName
+		frame.Line = line.LineNumber
+	}
+
+	return frame
+}
+
+func (p *winProcess) Close() error {
+	return syscall.CloseHandle(p.handle)
+}
+
+type winThread struct {
+	proc   syscall.Handle
+	handle uintptr
+}
+
+func (t *winThread) Name() (string, error) {
+	return kernel32.GetThreadDescription(t.handle)
+}
+
+func (t *winThread) Suspend() error {
+	return kernel32.SuspendThread(t.handle)
+}
+
+func (t *winThread) Resume() error {
+	return kernel32.ResumeThread(t.handle)
+}
+
+func (t *winThread) Walk(maxDepth int) ([]uint64, error) {
+	context, err := kernel32.GetThreadContext(t.handle)
+	if err != nil {
+		return nil, err
+	}
+
+	frame := dbg.NewStackFrame(context)
+
+	var pcs []uint64
+	for len(pcs) < maxDepth {
+		err = dbg.StackWalk64(t.proc, t.handle, frame, context)
+		if err != nil || frame.AddrPC.Offset == 0 {
+			break
+		}
+
+		pcs = append(pcs, frame.AddrPC.Offset)
+	}
+
+	return pcs, nil
+}
+
+// CPUTime returns the kernel and user time consumed by the thread.
+func (t *winThread) CPUTime() (time.Duration, error) {
+	_, _, kernel, user, err := kernel32.GetThreadTimes(t.handle)
+	if err != nil {
+		return 0, err
+	}
+
+	ticks := filetimeTicks(kernel) + filetimeTicks(user)
+
+	return time.Duration(ticks) * 100, nil
+}
+
+func (t *winThread) Close() error {
+	return kernel32.CloseHandle(t.handle)
+}
+
+// filetimeTicks returns a FILETIME duration in 100ns units.
+func filetimeTicks(ft syscall.Filetime) int64 {
+	return int64(ft.HighDateTime)<<32 | int64(ft.LowDateTime)
+}
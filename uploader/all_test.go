@@ -0,0 +1,437 @@
+//  ---------------------------------------------------------------------------
+//
+//  all_test.go
+//
+//  Copyright (c) 2016, Jared Chavez.
+//  All rights reserved.
+//
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+//
+//  -----------
+
+package uploader
+
+import (
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/xaevman/win32/dumpstore"
+)
+
+// submission is a request received by the test collector.
+type submission struct {
+	fields   map[string]string
+	filename string
+	dump     string
+	gzipped  bool
+}
+
+// collector stands in for a crash collector. It answers with the queued
+// status codes, then 200.
+type collector struct {
+	mu          sync.Mutex
+	statuses    []int
+	submissions []submission
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	status := http.StatusOK
+	if len(c.statuses) > 0 {
+		status, c.statuses = c.statuses[0], c.statuses[1:]
+	}
+
+	if status != http.StatusOK {
+		http.Error(w, "try later", status)
+		return
+	}
+
+	var sub submission
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		r.Body = ioutil.NopCloser(zr)
+		sub.gzipped = true
+	}
+
+	if err := r.ParseMultipartForm(1 << 20); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	sub.fields = make(map[string]string)
+	for k, v := range r.MultipartForm.Value {
+		sub.fields[k] = v[0]
+	}
+
+	f, header, err := r.FormFile(DumpField)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer f.Close()
+
+	data, _ := ioutil.ReadAll(f)
+	sub.filename = header.Filename
+	sub.dump = string(data)
+
+	c.submissions = append(c.submissions, sub)
+	fmt.Fprintf(w, "CrashID=bp-%d\n", len(c.submissions))
+}
+
+func newCollector(t *testing.T, statuses ...int) (*collector, *httptest.Server) {
+	c := &collector{statuses: statuses}
+	return c, httptest.NewServer(c)
+}
+
+func tempDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "uploader")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+func writeDump(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestUpload(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	path := writeDump(t, dir, "app.dmp", "MDMP")
+
+	for _, gz := range []bool{false, true} {
+		c, srv := newCollector(t)
+
+		u := &Uploader{
+			URL:    srv.URL,
+			Gzip:   gz,
+			Fields: map[string]string{"prod": "app", "ver": "1.0"},
+		}
+
+		id, err := u.Upload(context.Background(), path, map[string]string{"ver": "1.1", "guid": "abc"})
+		srv.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if id != "bp-1" {
+			t.Errorf("report id %q", id)
+		}
+
+		if len(c.submissions) != 1 {
+			t.Fatalf("%d submissions", len(c.submissions))
+		}
+
+		sub := c.submissions[0]
+		if sub.gzipped != gz || sub.dump != "MDMP" || sub.filename != "app.dmp" {
+			t.Errorf("submission %+v", sub)
+		}
+		if sub.fields["prod"] != "app" || sub.fields["ver"] != "1.1" || sub.fields["guid"] != "abc" {
+			t.Errorf("fields %v", sub.fields)
+		}
+	}
+}
+
+func TestUploadErrors(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	path := writeDump(t, dir, "app.dmp", strings.Repeat("x", 100))
+
+	u := &Uploader{URL: "http://unused", MaxSize: 99}
+	if _, err := u.Upload(context.Background(), path, nil); err != ErrTooLarge || !IsPermanent(err) {
+		t.Fatalf("err = %v, want ErrTooLarge", err)
+	}
+
+	tests := []struct {
+		status    int
+		permanent bool
+	}{
+		{http.StatusServiceUnavailable, false},
+		{http.StatusTooManyRequests, false},
+		{http.StatusBadRequest, true},
+		{http.StatusRequestEntityTooLarge, true},
+	}
+
+	for _, test := range tests {
+		_, srv := newCollector(t, test.status)
+		u := &Uploader{URL: srv.URL}
+
+		_, err := u.Upload(context.Background(), path, nil)
+		srv.Close()
+
+		status, ok := err.(*StatusError)
+		if !ok || status.StatusCode != test.status {
+			t.Errorf("%d: err = %v", test.status, err)
+			continue
+		}
+		if IsPermanent(err) != test.permanent {
+			t.Errorf("%d: permanent = %v", test.status, !test.permanent)
+		}
+	}
+}
+
+func TestParseReportID(t *testing.T) {
+	tests := map[string]string{
+		"CrashID=bp-1234":            "bp-1234",
+		"Status=OK\nCrashID=bp-5678": "bp-5678",
+		"3c8a2f0e":                   "3c8a2f0e",
+	}
+
+	for text, want := range tests {
+		if got := parseReportID(text); got != want {
+			t.Errorf("parseReportID(%q) = %q, want %q", text, got, want)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	b := Backoff{Initial: time.Second, Max: 10 * time.Second}
+	want := []time.Duration{0, time.Second, 2 * time.Second, 4 * time.Second, 8 * time.Second, 10 * time.Second, 10 * time.Second}
+
+	for attempts, d := range want {
+		if got := b.Delay(attempts); got != d {
+			t.Errorf("Delay(%d) = %v, want %v", attempts, got, d)
+		}
+	}
+}
+
+// newQueue returns a queue whose clock stands still until moved.
+func newQueue(t *testing.T, dir, url string) (*Queue, *time.Time) {
+	q, err := OpenQueue(filepath.Join(dir, "queue"), &Uploader{URL: url})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Date(2016, 3, 1, 12, 0, 0, 0, time.UTC)
+	q.Now = func() time.Time { return now }
+	q.Backoff = Backoff{Initial: time.Minute, Max: time.Hour}
+
+	return q, &now
+}
+
+func TestQueueRetry(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	c, srv := newCollector(t, 503, 503)
+	defer srv.Close()
+
+	q, now := newQueue(t, dir, srv.URL)
+
+	var results []string
+	q.OnResult = func(job *Job, id string, err error) {
+		results = append(results, fmt.Sprintf("%s %v", id, err))
+	}
+
+	path := writeDump(t, dir, "a.dmp", "MDMP")
+	if _, err := q.Enqueue(path, map[string]string{"prod": "app"}); err != nil {
+		t.Fatal(err)
+	}
+
+	steps := []struct {
+		advance  time.Duration
+		uploaded int
+		attempts int
+	}{
+		{0, 0, 1},
+		{30 * time.Second, 0, 1}, // not due yet
+		{30 * time.Second, 0, 2},
+		{time.Minute, 0, 2}, // second delay is two minutes
+		{time.Minute, 1, 0},
+	}
+
+	for i, step := range steps {
+		*now = now.Add(step.advance)
+
+		n, err := q.Process(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != step.uploaded {
+			t.Errorf("step %d: uploaded %d, want %d", i, n, step.uploaded)
+		}
+
+		jobs, _ := q.Jobs()
+		if step.attempts == 0 {
+			if len(jobs) != 0 {
+				t.Errorf("step %d: %d jobs left", i, len(jobs))
+			}
+			continue
+		}
+		if len(jobs) != 1 || jobs[0].Attempts != step.attempts {
+			t.Errorf("step %d: jobs %+v", i, jobs)
+		}
+	}
+
+	if len(results) != 1 || results[0] != "bp-1 <nil>" {
+		t.Errorf("results %v", results)
+	}
+	if len(c.submissions) != 1 || c.submissions[0].fields["prod"] != "app" {
+		t.Errorf("submissions %+v", c.submissions)
+	}
+}
+
+func TestQueueDrop(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	_, srv := newCollector(t, 400, 503, 503)
+	defer srv.Close()
+
+	q, now := newQueue(t, dir, srv.URL)
+	q.MaxAttempts = 2
+	q.RemoveDumps = true
+
+	var dropped int
+	q.OnResult = func(job *Job, id string, err error) {
+		if err == nil {
+			t.Errorf("job %s uploaded", job.ID)
+		}
+		dropped++
+	}
+
+	// rejected outright
+	bad := writeDump(t, dir, "bad.dmp", "MDMP")
+	q.Enqueue(bad, nil)
+	q.Process(context.Background())
+
+	// out of attempts
+	flaky := writeDump(t, dir, "flaky.dmp", "MDMP")
+	q.Enqueue(flaky, nil)
+	q.Process(context.Background())
+	*now = now.Add(time.Hour)
+	q.Process(context.Background())
+
+	// missing dump
+	q.Enqueue(filepath.Join(dir, "missing.dmp"), nil)
+	q.Process(context.Background())
+
+	if dropped != 3 {
+		t.Errorf("%d dropped, want 3", dropped)
+	}
+
+	if jobs, _ := q.Jobs(); len(jobs) != 0 {
+		t.Errorf("jobs left: %+v", jobs)
+	}
+
+	for _, path := range []string{bad, flaky} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("%s not removed", path)
+		}
+	}
+}
+
+func TestQueuePersists(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	c, srv := newCollector(t)
+	defer srv.Close()
+
+	store, err := dumpstore.Open(filepath.Join(dir, "dumps"), dumpstore.Policy{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry, err := store.Save(&dumpstore.Meta{
+		Process:       "svc.exe",
+		PID:           42,
+		ExceptionCode: 0xC0000005,
+		Build:         map[string]string{"ver": "2.0"},
+		Annotations:   map[string]string{"channel": "beta"},
+	}, strings.NewReader("MDMP"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first, _ := newQueue(t, dir, srv.URL)
+	if _, err := first.EnqueueEntry(entry); err != nil {
+		t.Fatal(err)
+	}
+
+	// a new queue over the same directory, as after a restart
+	second, _ := newQueue(t, dir, srv.URL)
+	if n, err := second.Process(context.Background()); n != 1 || err != nil {
+		t.Fatalf("Process = %d, %v", n, err)
+	}
+
+	fields := c.submissions[0].fields
+	want := map[string]string{
+		"process":        "svc.exe",
+		"pid":            "42",
+		"ver":            "2.0",
+		"channel":        "beta",
+		"exception_code": "0xC0000005",
+		"exception":      "EXCEPTION_ACCESS_VIOLATION",
+	}
+	for k, v := range want {
+		if fields[k] != v {
+			t.Errorf("field %s = %q, want %q", k, fields[k], v)
+		}
+	}
+}
+
+func TestQueueRun(t *testing.T) {
+	dir, cleanup := tempDir(t)
+	defer cleanup()
+
+	c, srv := newCollector(t)
+	defer srv.Close()
+
+	q, err := OpenQueue(filepath.Join(dir, "queue"), &Uploader{URL: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- q.Run(ctx, 10*time.Millisecond) }()
+
+	q.Enqueue(writeDump(t, dir, "a.dmp", "MDMP"), nil)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		c.mu.Lock()
+		n := len(c.submissions)
+		c.mu.Unlock()
+		if n == 1 {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("Run = %v", err)
+	}
+
+	if len(c.submissions) != 1 {
+		t.Fatalf("%d submissions", len(c.submissions))
+	}
+}
@@ -0,0 +1,387 @@
+//  ---------------------------------------------------------------------------
+//
+//  queue.go
+//
+//  Copyright (c) 2016, Jared Chavez.
+//  All rights reserved.
+//
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+//
+//  -----------
+
+package uploader
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/xaevman/win32/dumpstore"
+)
+
+const (
+	jobExt     = ".job"
+	tempPrefix = ".tmp-"
+
+	// DefaultMaxAttempts is used when Queue.MaxAttempts is zero.
+	DefaultMaxAttempts = 10
+)
+
+// DefaultBackoff is used when Queue.Backoff is zero.
+var DefaultBackoff = Backoff{Initial: 30 * time.Second, Max: time.Hour}
+
+// Backoff doubles the delay after each failed attempt, from Initial up to
+// Max.
+type Backoff struct {
+	Initial time.Duration
+	Max     time.Duration
+}
+
+// Delay returns the wait after the given number of failed attempts.
+func (b Backoff) Delay(attempts int) time.Duration {
+	if attempts < 1 {
+		return 0
+	}
+
+	delay := b.Initial
+	for i := 1; i < attempts; i++ {
+		delay *= 2
+		if b.Max > 0 && delay >= b.Max {
+			return b.Max
+		}
+	}
+
+	if b.Max > 0 && delay > b.Max {
+		return b.Max
+	}
+
+	return delay
+}
+
+// Job is a queued upload. It is stored as a JSON file in the queue
+// directory, so it survives restarts of the uploading process.
+type Job struct {
+	ID     string            `json:"id"`
+	Path   string            `json:"path"`
+	Fields map[string]string `json:"fields,omitempty"`
+
+	Created     time.Time `json:"created"`
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"next_attempt"`
+	LastError   string    `json:"last_error,omitempty"`
+}
+
+// Queue is a directory of pending uploads.
+type Queue struct {
+	Dir      string
+	Uploader *Uploader
+
+	// Backoff spaces out retries. Zero uses DefaultBackoff.
+	Backoff Backoff
+
+	// MaxAttempts is the number of attempts before a job is dropped. Zero
+	// uses DefaultMaxAttempts.
+	MaxAttempts int
+
+	// RemoveDumps deletes the dump file once its job is done, whether it
+	// was uploaded or dropped.
+	RemoveDumps bool
+
+	// OnResult, when set, is called when a job is done. err is nil if the
+	// dump was uploaded.
+	OnResult func(job *Job, reportID string, err error)
+
+	// Now returns the current time. Nil uses time.Now.
+	Now func() time.Time
+}
+
+// OpenQueue returns a queue for dir, creating the directory if needed.
+// Jobs left by a previous run are picked up where they left off.
+func OpenQueue(dir string, u *Uploader) (*Queue, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+
+	return &Queue{Dir: dir, Uploader: u}, nil
+}
+
+func (q *Queue) now() time.Time {
+	if q.Now != nil {
+		return q.Now()
+	}
+
+	return time.Now()
+}
+
+func (q *Queue) maxAttempts() int {
+	if q.MaxAttempts > 0 {
+		return q.MaxAttempts
+	}
+
+	return DefaultMaxAttempts
+}
+
+func (q *Queue) backoff() Backoff {
+	if q.Backoff.Initial > 0 {
+		return q.Backoff
+	}
+
+	return DefaultBackoff
+}
+
+// Enqueue adds an upload of the dump at path. It is due immediately.
+func (q *Queue) Enqueue(path string, fields map[string]string) (*Job, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+
+	now := q.now()
+	job := &Job{
+		ID:          id,
+		Path:        abs,
+		Fields:      fields,
+		Created:     now,
+		NextAttempt: now,
+	}
+
+	if err := q.save(job); err != nil {
+		return nil, err
+	}
+
+	return job, nil
+}
+
+// EnqueueEntry adds an upload of a dump from a dumpstore, sending its
+// metadata as form fields.
+func (q *Queue) EnqueueEntry(entry *dumpstore.Entry) (*Job, error) {
+	return q.Enqueue(entry.Path, MetaFields(entry.Meta))
+}
+
+// MetaFields converts dump metadata into form fields. Build and annotation
+// keys are sent as they are; meta may be nil.
+func MetaFields(meta *dumpstore.Meta) map[string]string {
+	fields := make(map[string]string)
+	if meta == nil {
+		return fields
+	}
+
+	for k, v := range meta.Build {
+		fields[k] = v
+	}
+	for k, v := range meta.Annotations {
+		fields[k] = v
+	}
+
+	if meta.Process != "" {
+		fields["process"] = meta.Process
+	}
+	if meta.PID != 0 {
+		fields["pid"] = fmt.Sprint(meta.PID)
+	}
+	if !meta.Time.IsZero() {
+		fields["ptime"] = fmt.Sprint(meta.Time.Unix())
+	}
+	if meta.Reason != "" {
+		fields["reason"] = meta.Reason
+	}
+	if meta.ExceptionCode != 0 {
+		fields["exception_code"] = fmt.Sprintf("0x%08X", meta.ExceptionCode)
+	}
+	if meta.Exception != "" {
+		fields["exception"] = meta.Exception
+	}
+
+	return fields
+}
+
+func newID() (string, error) {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b[:]), nil
+}
+
+// save writes a job atomically.
+func (q *Queue) save(job *Job) error {
+	data, err := json.MarshalIndent(job, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	temp := filepath.Join(q.Dir, tempPrefix+job.ID+jobExt)
+	if err := ioutil.WriteFile(temp, append(data, '\n'), 0644); err != nil {
+		os.Remove(temp)
+		return err
+	}
+
+	return os.Rename(temp, filepath.Join(q.Dir, job.ID+jobExt))
+}
+
+// Jobs returns the queued jobs, oldest first. Unreadable job files are
+// skipped.
+func (q *Queue) Jobs() ([]Job, error) {
+	infos, err := ioutil.ReadDir(q.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var jobs []Job
+	for _, info := range infos {
+		name := info.Name()
+		if info.IsDir() || strings.HasPrefix(name, tempPrefix) || filepath.Ext(name) != jobExt {
+			continue
+		}
+
+		data, err := ioutil.ReadFile(filepath.Join(q.Dir, name))
+		if err != nil {
+			continue
+		}
+
+		var job Job
+		if err := json.Unmarshal(data, &job); err != nil || job.ID == "" {
+			continue
+		}
+
+		jobs = append(jobs, job)
+	}
+
+	sort.SliceStable(jobs, func(i, j int) bool {
+		if jobs[i].Created.Equal(jobs[j].Created) {
+			return jobs[i].ID < jobs[j].ID
+		}
+		return jobs[i].Created.Before(jobs[j].Created)
+	})
+
+	return jobs, nil
+}
+
+// Process attempts every job that is due, once, and returns the number
+// uploaded. Failed jobs are rescheduled with backoff, or dropped when the
+// failure is permanent or they run out of attempts.
+func (q *Queue) Process(ctx context.Context) (int, error) {
+	jobs, err := q.Jobs()
+	if err != nil {
+		return 0, err
+	}
+
+	uploaded := 0
+	for i := range jobs {
+		if err := ctx.Err(); err != nil {
+			return uploaded, err
+		}
+
+		job := &jobs[i]
+		if q.now().Before(job.NextAttempt) {
+			continue
+		}
+
+		id, err := q.Uploader.Upload(ctx, job.Path, job.Fields)
+		if err != nil && ctx.Err() != nil {
+			// cancelled; not the job's fault
+			return uploaded, ctx.Err()
+		}
+		if err == nil {
+			uploaded++
+		}
+
+		if err := q.finish(job, id, err); err != nil {
+			return uploaded, err
+		}
+	}
+
+	return uploaded, nil
+}
+
+// finish records the outcome of an attempt.
+func (q *Queue) finish(job *Job, reportID string, err error) error {
+	job.Attempts++
+
+	if err != nil && !IsPermanent(err) && !os.IsNotExist(err) && job.Attempts < q.maxAttempts() {
+		job.LastError = err.Error()
+		job.NextAttempt = q.now().Add(q.backoff().Delay(job.Attempts))
+		return q.save(job)
+	}
+
+	if rerr := os.Remove(filepath.Join(q.Dir, job.ID+jobExt)); rerr != nil && !os.IsNotExist(rerr) {
+		return rerr
+	}
+
+	if q.RemoveDumps {
+		os.Remove(job.Path)
+	}
+
+	if q.OnResult != nil {
+		q.OnResult(job, reportID, err)
+	}
+
+	return nil
+}
+
+// Next returns the time the earliest job is due, or false if the queue is
+// empty.
+func (q *Queue) Next() (time.Time, bool, error) {
+	jobs, err := q.Jobs()
+	if err != nil || len(jobs) == 0 {
+		return time.Time{}, false, err
+	}
+
+	next := jobs[0].NextAttempt
+	for _, job := range jobs[1:] {
+		if job.NextAttempt.Before(next) {
+			next = job.NextAttempt
+		}
+	}
+
+	return next, true, nil
+}
+
+// Run processes the queue until ctx is done. It wakes when a job is due,
+// or after poll to pick up jobs enqueued by other processes.
+func (q *Queue) Run(ctx context.Context, poll time.Duration) error {
+	for {
+		if _, err := q.Process(ctx); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+
+		wait := poll
+		if next, ok, err := q.Next(); err != nil {
+			return err
+		} else if ok {
+			if d := next.Sub(q.now()); d < wait {
+				wait = d
+			}
+		}
+
+		if wait < 0 {
+			wait = 0
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
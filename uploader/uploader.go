@@ -0,0 +1,212 @@
+//  ---------------------------------------------------------------------------
+//
+//  uploader.go
+//
+//  Copyright (c) 2016, Jared Chavez.
+//  All rights reserved.
+//
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+//
+//  -----------
+
+// Package uploader submits crash dumps to a crash collector in the
+// Breakpad/Crashpad format, through a persistent queue that retries with
+// backoff and survives restarts.
+package uploader
+
+import (
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// DumpField is the form field carrying the minidump, as expected by
+// Breakpad and Crashpad collectors.
+const DumpField = "upload_file_minidump"
+
+// maxResponse bounds how much of a collector response is read.
+const maxResponse = 64 * 1024
+
+// ErrTooLarge is returned for dumps over Uploader.MaxSize. It is permanent.
+var ErrTooLarge = errors.New("uploader: dump exceeds size cap")
+
+// StatusError is a non-2xx collector response.
+type StatusError struct {
+	StatusCode int
+	Body       string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("uploader: collector returned %d: %s", e.StatusCode, e.Body)
+}
+
+// Temporary reports whether the request may succeed if retried: server
+// errors, timeouts and rate limiting.
+func (e *StatusError) Temporary() bool {
+	return e.StatusCode >= 500 ||
+		e.StatusCode == http.StatusRequestTimeout ||
+		e.StatusCode == http.StatusTooManyRequests
+}
+
+// IsPermanent reports whether err means the submission will never
+// succeed, so retrying is pointless.
+func IsPermanent(err error) bool {
+	if err == ErrTooLarge {
+		return true
+	}
+
+	if status, ok := err.(*StatusError); ok {
+		return !status.Temporary()
+	}
+
+	return false
+}
+
+// Uploader submits single dumps.
+type Uploader struct {
+	// URL is the collector's submission endpoint.
+	URL string
+
+	// Client is used for requests. Nil uses http.DefaultClient.
+	Client *http.Client
+
+	// Gzip compresses the request body with Content-Encoding: gzip, as
+	// Crashpad does.
+	Gzip bool
+
+	// MaxSize caps the size of a dump file. Zero is unlimited.
+	MaxSize int64
+
+	// Fields are sent with every dump, typically "prod" and "ver".
+	Fields map[string]string
+}
+
+// Upload submits the dump at path along with fields, which are merged over
+// u.Fields, and returns the report ID from the collector's response.
+func (u *Uploader) Upload(ctx context.Context, path string, fields map[string]string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+
+	if u.MaxSize > 0 && info.Size() > u.MaxSize {
+		return "", ErrTooLarge
+	}
+
+	merged := make(map[string]string, len(u.Fields)+len(fields))
+	for k, v := range u.Fields {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+
+	body, writer := io.Pipe()
+	defer body.Close()
+
+	var out io.Writer = writer
+	var zw *gzip.Writer
+	if u.Gzip {
+		zw = gzip.NewWriter(writer)
+		out = zw
+	}
+
+	form := multipart.NewWriter(out)
+	go func() {
+		err := writeForm(form, path, merged)
+		if err == nil && zw != nil {
+			err = zw.Close()
+		}
+		writer.CloseWithError(err)
+	}()
+
+	req, err := http.NewRequest(http.MethodPost, u.URL, body)
+	if err != nil {
+		return "", err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", form.FormDataContentType())
+	if u.Gzip {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+
+	client := u.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxResponse))
+	if err != nil {
+		return "", err
+	}
+
+	text := strings.TrimSpace(string(data))
+
+	if resp.StatusCode/100 != 2 {
+		return "", &StatusError{StatusCode: resp.StatusCode, Body: text}
+	}
+
+	return parseReportID(text), nil
+}
+
+// writeForm writes the fields, in sorted order, and then the dump file.
+func writeForm(form *multipart.Writer, path string, fields map[string]string) error {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		if err := form.WriteField(k, fields[k]); err != nil {
+			return err
+		}
+	}
+
+	part, err := form.CreateFormFile(DumpField, filepath.Base(path))
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(part, f); err != nil {
+		return err
+	}
+
+	return form.Close()
+}
+
+// parseReportID extracts the report ID from a collector response. Breakpad
+// style collectors return "CrashID=bp-<uuid>"; Crashpad style return the
+// bare ID.
+func parseReportID(text string) string {
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "CrashID=") {
+			return strings.TrimPrefix(line, "CrashID=")
+		}
+	}
+
+	return text
+}
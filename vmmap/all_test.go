@@ -0,0 +1,227 @@
+//  ---------------------------------------------------------------------------
+//
+//  all_test.go
+//
+//  Copyright (c) 2016, Jared Chavez.
+//  All rights reserved.
+//
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+//
+//  -----------
+
+package vmmap
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+type fakeQuerier struct {
+	regions []Region
+	names   map[uint64]string
+	lookups int
+}
+
+func (q *fakeQuerier) Query(addr uint64) (Region, error) {
+	for _, region := range q.regions {
+		if addr >= region.Base && (addr < region.End() || region.End() == 0) {
+			return region, nil
+		}
+	}
+
+	return Region{}, ErrEnd
+}
+
+func (q *fakeQuerier) MappedFileName(addr uint64) (string, error) {
+	q.lookups++
+
+	name, ok := q.names[addr]
+	if !ok {
+		return "", errors.New("not mapped")
+	}
+
+	return name, nil
+}
+
+func testRegions() []Region {
+	return []Region{
+		{Base: 0, Size: 0x10000, State: StateFree, Protect: ProtectNoAccess},
+		{Base: 0x10000, AllocationBase: 0x10000, Size: 0x1000, State: StateCommit, Protect: ProtectReadWrite, Type: TypePrivate},
+		{Base: 0x11000, AllocationBase: 0x10000, Size: 0xf000, State: StateReserve, Type: TypePrivate},
+		{Base: 0x20000, AllocationBase: 0x20000, Size: 0x1000, State: StateCommit, Protect: ProtectReadOnly, Type: TypeImage},
+		{Base: 0x21000, AllocationBase: 0x20000, Size: 0x2000, State: StateCommit, Protect: ProtectExecuteRead, Type: TypeImage},
+		{Base: 0x23000, AllocationBase: 0x20000, Size: 0x1000, State: StateCommit, Protect: ProtectWriteCopy, Type: TypeImage},
+		{Base: 0x24000, AllocationBase: 0x24000, Size: 0x4000, State: StateCommit, Protect: ProtectReadOnly, Type: TypeMapped},
+		{Base: 0x28000, AllocationBase: 0x10000, Size: 0x1000, State: StateCommit, Protect: ProtectReadWrite | ProtectGuard, Type: TypePrivate},
+	}
+}
+
+func TestRegions(t *testing.T) {
+	q := &fakeQuerier{
+		regions: testRegions(),
+		names:   map[uint64]string{0x20000: `C:\app\app.exe`},
+	}
+
+	regions, err := Regions(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(regions) != len(q.regions) {
+		t.Fatalf("got %d regions, want %d", len(regions), len(q.regions))
+	}
+
+	for i, region := range regions {
+		want := q.regions[i].Path
+		if region.Type == TypeImage {
+			want = `C:\app\app.exe`
+		}
+
+		if region.Path != want {
+			t.Errorf("region %d: Path = %q, want %q", i, region.Path, want)
+		}
+	}
+
+	// one lookup for the image, one for the mapped section
+	if q.lookups != 2 {
+		t.Errorf("MappedFileName called %d times, want 2", q.lookups)
+	}
+}
+
+func TestRegionsTopOfAddressSpace(t *testing.T) {
+	q := &fakeQuerier{regions: []Region{
+		{Base: 0, Size: 0x10000, State: StateFree},
+		{Base: 0x10000, Size: ^uint64(0) - 0xffff, State: StateFree},
+	}}
+
+	regions, err := Regions(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(regions) != 2 {
+		t.Fatalf("got %d regions, want 2", len(regions))
+	}
+}
+
+// stuckQuerier describes every address as an empty region.
+type stuckQuerier struct{}
+
+func (stuckQuerier) Query(addr uint64) (Region, error) {
+	return Region{Base: addr, State: StateFree}, nil
+}
+
+func (stuckQuerier) MappedFileName(addr uint64) (string, error) {
+	return "", errors.New("not mapped")
+}
+
+func TestRegionsNoProgress(t *testing.T) {
+	if _, err := Regions(stuckQuerier{}); err == nil {
+		t.Fatal("expected error")
+	}
+}
+
+func TestProtect(t *testing.T) {
+	tests := []struct {
+		protect  Protect
+		perms    string
+		str      string
+		readable bool
+	}{
+		{0, "----", "0", false},
+		{ProtectNoAccess, "----", "PAGE_NOACCESS", false},
+		{ProtectReadOnly, "r---", "PAGE_READONLY", true},
+		{ProtectReadWrite, "rw--", "PAGE_READWRITE", true},
+		{ProtectWriteCopy, "rw-c", "PAGE_WRITECOPY", true},
+		{ProtectExecuteRead, "r-x-", "PAGE_EXECUTE_READ", true},
+		{ProtectExecuteWriteCopy, "rwxc", "PAGE_EXECUTE_WRITECOPY", true},
+		{ProtectReadWrite | ProtectGuard, "rw--g", "PAGE_READWRITE|PAGE_GUARD", false},
+		{ProtectReadWrite | ProtectNoCache, "rw--", "PAGE_READWRITE|PAGE_NOCACHE", true},
+		{0x10000, "----", "0x10000", false},
+	}
+
+	for _, test := range tests {
+		if perms := test.protect.Perms(); perms != test.perms {
+			t.Errorf("%#x: Perms = %q, want %q", uint32(test.protect), perms, test.perms)
+		}
+
+		if str := test.protect.String(); str != test.str {
+			t.Errorf("%#x: String = %q, want %q", uint32(test.protect), str, test.str)
+		}
+
+		if readable := test.protect.Readable(); readable != test.readable {
+			t.Errorf("%#x: Readable = %v, want %v", uint32(test.protect), readable, test.readable)
+		}
+	}
+}
+
+func TestFormat(t *testing.T) {
+	regions := testRegions()
+	regions[3].Path = `C:\app\app.exe`
+
+	var buf bytes.Buffer
+	if err := Format(&buf, regions[:4]); err != nil {
+		t.Fatal(err)
+	}
+
+	want := "0000000000010000-0000000000011000 rw--  commit  private         4K\n" +
+		"0000000000011000-0000000000020000 ----  reserve private        60K\n" +
+		"0000000000020000-0000000000021000 r---  commit  image           4K C:\\app\\app.exe\n"
+
+	if buf.String() != want {
+		t.Fatalf("Format:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	summary := Summarize(testRegions())
+
+	want := Summary{
+		Private: Totals{Committed: 0x2000, Reserved: 0xf000},
+		Mapped:  Totals{Committed: 0x4000},
+		Image:   Totals{Committed: 0x4000},
+		Free:    0x10000,
+	}
+
+	if summary != want {
+		t.Fatalf("Summarize = %+v, want %+v", summary, want)
+	}
+
+	var buf bytes.Buffer
+	if err := summary.Format(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	wantText := "type        committed     reserved\n" +
+		"private            8K          60K\n" +
+		"mapped            16K           0K\n" +
+		"image             16K           0K\n" +
+		"total             40K          60K\n" +
+		"free              64K\n"
+
+	if buf.String() != wantText {
+		t.Fatalf("Summary.Format:\n%s\nwant:\n%s", buf.String(), wantText)
+	}
+}
+
+func TestDosPath(t *testing.T) {
+	devices := map[string]string{`\device\harddiskvolume3`: "C:"}
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{`\Device\HarddiskVolume3\Windows\System32\ntdll.dll`, `C:\Windows\System32\ntdll.dll`},
+		{`\Device\HarddiskVolume30\app.exe`, `\Device\HarddiskVolume30\app.exe`},
+		{`\Device\Mup\server\share\app.exe`, `\Device\Mup\server\share\app.exe`},
+		{`\Device\HarddiskVolume3`, `\Device\HarddiskVolume3`},
+	}
+
+	for _, test := range tests {
+		if got := dosPath(devices, test.path); got != test.want {
+			t.Errorf("dosPath(%q) = %q, want %q", test.path, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,137 @@
+//  ---------------------------------------------------------------------------
+//
+//  format.go
+//
+//  Copyright (c) 2016, Jared Chavez.
+//  All rights reserved.
+//
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+//
+//  -----------
+
+package vmmap
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// Format writes regions as a table in the style of /proc/pid/maps, one
+// region per line:
+//
+//	00007ff6a0e10000-00007ff6a0e11000 r--- commit  image        4K C:\app\app.exe
+//
+// Free regions are omitted. Addresses are printed 64 bits wide regardless
+// of the target's bitness, and sizes in KiB.
+func Format(w io.Writer, regions []Region) error {
+	bw := bufio.NewWriter(w)
+
+	for _, region := range regions {
+		if region.State == StateFree {
+			continue
+		}
+
+		fmt.Fprintf(
+			bw,
+			"%016x-%016x %-5s %-7s %-7s %9dK",
+			region.Base,
+			region.End(),
+			region.Protect.Perms(),
+			region.State,
+			region.Type,
+			region.Size/1024,
+		)
+
+		if region.Path != "" {
+			fmt.Fprintf(bw, " %s", region.Path)
+		}
+
+		fmt.Fprintln(bw)
+	}
+
+	return bw.Flush()
+}
+
+// Totals are the bytes of an address space in each state.
+type Totals struct {
+	Committed uint64
+	Reserved  uint64
+}
+
+// Summary totals an address space by region type. Comparing the private
+// committed bytes of successive summaries shows heap growth.
+type Summary struct {
+	Private Totals
+	Mapped  Totals
+	Image   Totals
+	Free    uint64
+}
+
+// Summarize totals regions by type and state.
+func Summarize(regions []Region) Summary {
+	var summary Summary
+
+	for _, region := range regions {
+		if region.State == StateFree {
+			summary.Free += region.Size
+			continue
+		}
+
+		var totals *Totals
+		switch region.Type {
+		case TypePrivate:
+			totals = &summary.Private
+		case TypeMapped:
+			totals = &summary.Mapped
+		case TypeImage:
+			totals = &summary.Image
+		default:
+			continue
+		}
+
+		switch region.State {
+		case StateCommit:
+			totals.Committed += region.Size
+		case StateReserve:
+			totals.Reserved += region.Size
+		}
+	}
+
+	return summary
+}
+
+// Format writes the summary as a small table, sizes in KiB.
+func (s Summary) Format(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+
+	fmt.Fprintf(bw, "%-8s %12s %12s\n", "type", "committed", "reserved")
+
+	rows := []struct {
+		name   string
+		totals Totals
+	}{
+		{"private", s.Private},
+		{"mapped", s.Mapped},
+		{"image", s.Image},
+		{"total", Totals{
+			Committed: s.Private.Committed + s.Mapped.Committed + s.Image.Committed,
+			Reserved:  s.Private.Reserved + s.Mapped.Reserved + s.Image.Reserved,
+		}},
+	}
+
+	for _, row := range rows {
+		fmt.Fprintf(
+			bw,
+			"%-8s %11dK %11dK\n",
+			row.name,
+			row.totals.Committed/1024,
+			row.totals.Reserved/1024,
+		)
+	}
+
+	fmt.Fprintf(bw, "%-8s %11dK\n", "free", s.Free/1024)
+
+	return bw.Flush()
+}
@@ -0,0 +1,326 @@
+//  ---------------------------------------------------------------------------
+//
+//  vmmap.go
+//
+//  Copyright (c) 2016, Jared Chavez.
+//  All rights reserved.
+//
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+//
+//  -----------
+
+// Package vmmap walks the virtual address space of a process region by
+// region, in the manner of /proc/pid/maps.
+package vmmap
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrEnd is returned by Querier.Query for addresses past the highest
+// user-mode address.
+var ErrEnd = errors.New("vmmap: end of address space")
+
+// State is the MEMORY_BASIC_INFORMATION State of a region.
+type State uint32
+
+const (
+	StateCommit  State = 0x00001000
+	StateReserve State = 0x00002000
+	StateFree    State = 0x00010000
+)
+
+func (s State) String() string {
+	switch s {
+	case StateCommit:
+		return "commit"
+	case StateReserve:
+		return "reserve"
+	case StateFree:
+		return "free"
+	}
+
+	return fmt.Sprintf("State(0x%x)", uint32(s))
+}
+
+// Type is the MEMORY_BASIC_INFORMATION Type of a region. It is zero for
+// free regions.
+type Type uint32
+
+const (
+	TypePrivate Type = 0x00020000
+	TypeMapped  Type = 0x00040000
+	TypeImage   Type = 0x01000000
+)
+
+func (t Type) String() string {
+	switch t {
+	case 0:
+		return "-"
+	case TypePrivate:
+		return "private"
+	case TypeMapped:
+		return "mapped"
+	case TypeImage:
+		return "image"
+	}
+
+	return fmt.Sprintf("Type(0x%x)", uint32(t))
+}
+
+// Protect is a PAGE_* memory protection. It is zero for reserved and free
+// regions.
+type Protect uint32
+
+const (
+	ProtectNoAccess         Protect = 0x001
+	ProtectReadOnly         Protect = 0x002
+	ProtectReadWrite        Protect = 0x004
+	ProtectWriteCopy        Protect = 0x008
+	ProtectExecute          Protect = 0x010
+	ProtectExecuteRead      Protect = 0x020
+	ProtectExecuteReadWrite Protect = 0x040
+	ProtectExecuteWriteCopy Protect = 0x080
+
+	ProtectGuard        Protect = 0x100
+	ProtectNoCache      Protect = 0x200
+	ProtectWriteCombine Protect = 0x400
+
+	protectModifiers = ProtectGuard | ProtectNoCache | ProtectWriteCombine
+)
+
+var protectNames = []struct {
+	bit  Protect
+	name string
+}{
+	{ProtectNoAccess, "PAGE_NOACCESS"},
+	{ProtectReadOnly, "PAGE_READONLY"},
+	{ProtectReadWrite, "PAGE_READWRITE"},
+	{ProtectWriteCopy, "PAGE_WRITECOPY"},
+	{ProtectExecute, "PAGE_EXECUTE"},
+	{ProtectExecuteRead, "PAGE_EXECUTE_READ"},
+	{ProtectExecuteReadWrite, "PAGE_EXECUTE_READWRITE"},
+	{ProtectExecuteWriteCopy, "PAGE_EXECUTE_WRITECOPY"},
+	{ProtectGuard, "PAGE_GUARD"},
+	{ProtectNoCache, "PAGE_NOCACHE"},
+	{ProtectWriteCombine, "PAGE_WRITECOMBINE"},
+}
+
+// String returns the PAGE_* names of p joined with "|".
+func (p Protect) String() string {
+	if p == 0 {
+		return "0"
+	}
+
+	var names []string
+	for _, protect := range protectNames {
+		if p&protect.bit != 0 {
+			names = append(names, protect.name)
+			p &^= protect.bit
+		}
+	}
+
+	if p != 0 {
+		names = append(names, fmt.Sprintf("0x%x", uint32(p)))
+	}
+
+	return strings.Join(names, "|")
+}
+
+// Readable reports whether the pages can be read without faulting.
+func (p Protect) Readable() bool {
+	if p&ProtectGuard != 0 {
+		return false
+	}
+
+	switch p &^ protectModifiers {
+	case ProtectReadOnly, ProtectReadWrite, ProtectWriteCopy,
+		ProtectExecuteRead, ProtectExecuteReadWrite, ProtectExecuteWriteCopy:
+		return true
+	}
+
+	return false
+}
+
+// Perms returns the protection in the style of /proc/pid/maps: "r", "w"
+// and "x" or "-", then "c" for copy-on-write pages or "-", then "g" if the
+// pages are guard pages.
+func (p Protect) Perms() string {
+	perms := []byte("----")
+
+	switch p &^ protectModifiers {
+	case ProtectReadOnly:
+		perms[0] = 'r'
+	case ProtectReadWrite:
+		perms[0], perms[1] = 'r', 'w'
+	case ProtectWriteCopy:
+		perms[0], perms[1], perms[3] = 'r', 'w', 'c'
+	case ProtectExecute:
+		perms[2] = 'x'
+	case ProtectExecuteRead:
+		perms[0], perms[2] = 'r', 'x'
+	case ProtectExecuteReadWrite:
+		perms[0], perms[1], perms[2] = 'r', 'w', 'x'
+	case ProtectExecuteWriteCopy:
+		perms[0], perms[1], perms[2], perms[3] = 'r', 'w', 'x', 'c'
+	}
+
+	if p&ProtectGuard != 0 {
+		perms = append(perms, 'g')
+	}
+
+	return string(perms)
+}
+
+// Region is one run of pages with the same state, protection and type, as
+// described by VirtualQueryEx.
+type Region struct {
+	Base              uint64
+	AllocationBase    uint64
+	AllocationProtect Protect
+	Size              uint64
+	State             State
+	Protect           Protect
+	Type              Type
+
+	// Path is the file mapped at the region, for image and mapped
+	// regions, when it could be resolved.
+	Path string
+}
+
+// End returns the address just past the region.
+func (r Region) End() uint64 {
+	return r.Base + r.Size
+}
+
+// Querier describes the address space of one process. The Windows
+// implementation is built on kernel32.VirtualQueryEx and
+// psapi.GetMappedFileName; tests substitute a fake.
+type Querier interface {
+	// Query returns the region containing addr, without Path, or ErrEnd.
+	Query(addr uint64) (Region, error)
+
+	// MappedFileName returns the path of the file mapped at addr.
+	MappedFileName(addr uint64) (string, error)
+}
+
+// Iter iterates over the regions of an address space in address order:
+//
+//	it := vmmap.NewIter(q)
+//	for it.Next() {
+//	    region := it.Region()
+//	}
+//	err := it.Err()
+type Iter struct {
+	q      Querier
+	addr   uint64
+	done   bool
+	err    error
+	region Region
+
+	// paths caches MappedFileName by allocation base, since an image is
+	// described by one region per section.
+	paths map[uint64]string
+}
+
+// NewIter returns an iterator over the address space described by q,
+// starting at address zero.
+func NewIter(q Querier) *Iter {
+	return &Iter{q: q, paths: make(map[uint64]string)}
+}
+
+// Next advances to the next region.
+func (it *Iter) Next() bool {
+	if it.done || it.err != nil {
+		return false
+	}
+
+	region, err := it.q.Query(it.addr)
+	if err == ErrEnd {
+		it.done = true
+		return false
+	}
+
+	if err != nil {
+		it.err = fmt.Errorf("vmmap: querying 0x%x: %v", it.addr, err)
+		return false
+	}
+
+	end := region.End()
+	if region.Size == 0 || (end <= it.addr && end != 0) {
+		it.err = fmt.Errorf("vmmap: region at 0x%x does not advance", it.addr)
+		return false
+	}
+
+	// a region running to the top of the address space wraps End to zero
+	it.done = end == 0
+
+	it.addr = end
+
+	if region.Type == TypeImage || region.Type == TypeMapped {
+		region.Path = it.path(region.AllocationBase)
+	}
+
+	it.region = region
+
+	return true
+}
+
+func (it *Iter) path(base uint64) string {
+	if path, ok := it.paths[base]; ok {
+		return path
+	}
+
+	// an unresolvable name, such as that of a pagefile-backed section,
+	// leaves the region unannotated
+	path, _ := it.q.MappedFileName(base)
+	it.paths[base] = path
+
+	return path
+}
+
+// Region returns the current region.
+func (it *Iter) Region() Region {
+	return it.region
+}
+
+// Err returns the error that ended the iteration, if any.
+func (it *Iter) Err() error {
+	return it.err
+}
+
+// Regions returns every region of the address space described by q.
+func Regions(q Querier) ([]Region, error) {
+	var regions []Region
+
+	it := NewIter(q)
+	for it.Next() {
+		regions = append(regions, it.Region())
+	}
+
+	return regions, it.Err()
+}
+
+// dosPath rewrites an NT device path on a device in devices, which maps
+// lower-cased device names to drive letters, to its DOS form.
+func dosPath(devices map[string]string, path string) string {
+	// \Device\HarddiskVolume3\Windows -> device \Device\HarddiskVolume3
+	if !strings.HasPrefix(path, `\Device\`) {
+		return path
+	}
+
+	sep := strings.IndexByte(path[len(`\Device\`):], '\\')
+	if sep < 0 {
+		return path
+	}
+
+	sep += len(`\Device\`)
+	if drive, ok := devices[strings.ToLower(path[:sep])]; ok {
+		return drive + path[sep:]
+	}
+
+	return path
+}
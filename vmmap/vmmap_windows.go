@@ -0,0 +1,130 @@
+//  ---------------------------------------------------------------------------
+//
+//  vmmap_windows.go
+//
+//  Copyright (c) 2016, Jared Chavez.
+//  All rights reserved.
+//
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+//
+//  -----------
+
+package vmmap
+
+import (
+	"strings"
+	"syscall"
+
+	"github.com/xaevman/win32/kernel32"
+	"github.com/xaevman/win32/psapi"
+)
+
+// processAccess covers VirtualQueryEx and GetMappedFileName.
+const processAccess = kernel32.PROCESS_QUERY_INFORMATION
+
+// Process is a Querier for a live process.
+type Process struct {
+	handle syscall.Handle
+	owned  bool
+
+	// devices maps NT device names, such as \Device\HarddiskVolume3, to
+	// the drive letters they are mounted at.
+	devices map[string]string
+}
+
+// Open opens process pid for querying.
+func Open(pid uint32) (*Process, error) {
+	proc, err := kernel32.OpenProcess(pid, processAccess)
+	if err != nil {
+		return nil, err
+	}
+
+	p := NewProcess(proc)
+	p.owned = true
+
+	return p, nil
+}
+
+// NewProcess returns a Querier for an already open process handle with
+// PROCESS_QUERY_INFORMATION access. Close does not close the handle.
+func NewProcess(proc syscall.Handle) *Process {
+	return &Process{handle: proc, devices: dosDevices()}
+}
+
+// Close closes the process handle if it was opened by Open.
+func (p *Process) Close() error {
+	if !p.owned || p.handle == 0 {
+		return nil
+	}
+
+	err := syscall.CloseHandle(p.handle)
+	p.handle = 0
+
+	return err
+}
+
+// Query implements Querier.
+func (p *Process) Query(addr uint64) (Region, error) {
+	if uint64(uintptr(addr)) != addr {
+		return Region{}, ErrEnd
+	}
+
+	info, err := kernel32.VirtualQueryEx(p.handle, uintptr(addr))
+	if err == kernel32.ERROR_INVALID_PARAMETER {
+		return Region{}, ErrEnd
+	}
+
+	if err != nil {
+		return Region{}, err
+	}
+
+	return Region{
+		Base:              info.BaseAddress,
+		AllocationBase:    info.AllocationBase,
+		AllocationProtect: Protect(info.AllocationProtect),
+		Size:              info.RegionSize,
+		State:             State(info.State),
+		Protect:           Protect(info.Protect),
+		Type:              Type(info.Type),
+	}, nil
+}
+
+// MappedFileName implements Querier. Paths on a lettered drive are
+// returned in their DOS form, and others as NT device paths.
+func (p *Process) MappedFileName(addr uint64) (string, error) {
+	path, err := psapi.GetMappedFileName(p.handle, uintptr(addr))
+	if err != nil {
+		return "", err
+	}
+
+	return dosPath(p.devices, path), nil
+}
+
+// Walk returns every region of process pid.
+func Walk(pid uint32) ([]Region, error) {
+	p, err := Open(pid)
+	if err != nil {
+		return nil, err
+	}
+	defer p.Close()
+
+	return Regions(p)
+}
+
+func dosDevices() map[string]string {
+	devices := make(map[string]string)
+
+	for letter := 'A'; letter <= 'Z'; letter++ {
+		drive := string(letter) + ":"
+
+		target, err := kernel32.QueryDosDevice(drive)
+		if err != nil {
+			continue
+		}
+
+		devices[strings.ToLower(target)] = drive
+	}
+
+	return devices
+}
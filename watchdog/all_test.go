@@ -0,0 +1,277 @@
+//  ---------------------------------------------------------------------------
+//
+//  all_test.go
+//
+//  Copyright (c) 2016, Jared Chavez.
+//  All rights reserved.
+//
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+//
+//  -----------
+
+package watchdog
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func newFakeClock() *fakeClock {
+	return &fakeClock{now: time.Date(2016, 1, 1, 0, 0, 0, 0, time.UTC)}
+}
+
+func (c *fakeClock) Now() time.Time        { return c.now }
+func (c *fakeClock) Sleep(d time.Duration) { c.now = c.now.Add(d) }
+
+var ladder = []Step{
+	{After: 10 * time.Second, Action: ActionStacks},
+	{After: 30 * time.Second, Action: ActionDump},
+}
+
+// event is a scripted step: advance the clock, optionally beat, then check.
+type event struct {
+	advance time.Duration
+	beat    bool
+	want    []Action
+}
+
+func TestPolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		cfg    Config
+		events []event
+		stats  Stats
+	}{
+		{
+			name: "healthy",
+			cfg:  Config{Ladder: ladder},
+			events: []event{
+				{advance: 5 * time.Second, beat: true},
+				{advance: 9 * time.Second, beat: true},
+				{advance: 9 * time.Second},
+			},
+		},
+		{
+			name: "escalation",
+			cfg:  Config{Ladder: ladder},
+			events: []event{
+				{advance: 10 * time.Second, want: []Action{ActionStacks}},
+				{advance: 10 * time.Second},
+				{advance: 10 * time.Second, want: []Action{ActionDump}},
+				{advance: time.Hour},
+			},
+			stats: Stats{Hangs: 1, Actions: 2},
+		},
+		{
+			name: "late check fires every rung once",
+			cfg:  Config{Ladder: ladder},
+			events: []event{
+				{advance: time.Minute, want: []Action{ActionStacks, ActionDump}},
+				{advance: time.Minute},
+			},
+			stats: Stats{Hangs: 1, Actions: 2},
+		},
+		{
+			name: "recovery restarts the ladder",
+			cfg:  Config{Ladder: ladder},
+			events: []event{
+				{advance: 10 * time.Second, want: []Action{ActionStacks}},
+				{advance: time.Second, beat: true},
+				{advance: 10 * time.Second, want: []Action{ActionStacks}},
+			},
+			stats: Stats{Hangs: 2, Actions: 2},
+		},
+		{
+			name: "cooldown",
+			cfg:  Config{Ladder: ladder, Cooldown: time.Minute},
+			events: []event{
+				{advance: 10 * time.Second, want: []Action{ActionStacks}},
+				{advance: time.Second, beat: true},
+				// within the cooldown: both rungs are skipped
+				{advance: 30 * time.Second},
+				{advance: time.Second, beat: true},
+				// after the cooldown
+				{advance: 40 * time.Second, want: []Action{ActionStacks, ActionDump}},
+			},
+			stats: Stats{Hangs: 3, Actions: 3, Suppressed: 2},
+		},
+		{
+			name: "rate limit",
+			cfg:  Config{Ladder: ladder, MaxActions: 2, Window: 10 * time.Minute},
+			events: []event{
+				{advance: 30 * time.Second, want: []Action{ActionStacks, ActionDump}},
+				{advance: time.Second, beat: true},
+				{advance: 30 * time.Second},
+				{advance: 10 * time.Minute, beat: true},
+				{advance: 10 * time.Second, want: []Action{ActionStacks}},
+			},
+			stats: Stats{Hangs: 3, Actions: 3, Suppressed: 2},
+		},
+	}
+
+	for _, test := range tests {
+		clock := newFakeClock()
+		policy := NewPolicy(test.cfg, clock)
+
+		for i, ev := range test.events {
+			clock.Sleep(ev.advance)
+			if ev.beat {
+				policy.Beat()
+			}
+
+			got := policy.Check()
+			if !reflect.DeepEqual(got, ev.want) {
+				t.Errorf("%s: event %d: got %v, want %v", test.name, i, got, ev.want)
+			}
+		}
+
+		if stats := policy.Stats(); stats != test.stats {
+			t.Errorf("%s: stats %+v, want %+v", test.name, stats, test.stats)
+		}
+	}
+}
+
+func TestPolicySilence(t *testing.T) {
+	clock := newFakeClock()
+	policy := NewPolicy(Config{}, clock)
+
+	clock.Sleep(45 * time.Second)
+	if policy.Silence() != 45*time.Second {
+		t.Fatalf("Silence = %v", policy.Silence())
+	}
+
+	if got := policy.Check(); !reflect.DeepEqual(got, []Action{ActionStacks}) || !policy.Hung() {
+		t.Fatalf("default ladder at 45s: %v", got)
+	}
+
+	policy.Beat()
+	if policy.Hung() || policy.Silence() != 0 {
+		t.Fatal("beat did not reset the hang")
+	}
+}
+
+// scriptSource replays heartbeats, advancing a fake clock by the timeout
+// on every silent wait.
+type scriptSource struct {
+	clock *fakeClock
+	beats []bool
+	err   error
+}
+
+func (s *scriptSource) Wait(timeout time.Duration) (bool, error) {
+	if s.err != nil {
+		return false, s.err
+	}
+
+	if len(s.beats) == 0 {
+		s.clock.Sleep(timeout)
+		return false, nil
+	}
+
+	beat := s.beats[0]
+	s.beats = s.beats[1:]
+	if !beat {
+		s.clock.Sleep(timeout)
+	}
+
+	return beat, nil
+}
+
+func TestWatchdogStep(t *testing.T) {
+	clock := newFakeClock()
+	source := &scriptSource{clock: clock, beats: []bool{true, false, true}}
+
+	type call struct {
+		action  Action
+		silence time.Duration
+	}
+	var calls []call
+
+	w := &Watchdog{
+		Source: source,
+		Policy: NewPolicy(Config{Ladder: ladder}, clock),
+		Act: func(action Action, silence time.Duration) error {
+			calls = append(calls, call{action, silence})
+			return errors.New("dump failed")
+		},
+	}
+
+	var errs int
+	w.OnError = func(err error) { errs++ }
+
+	for i := 0; i < 10; i++ {
+		w.Step(5 * time.Second)
+	}
+
+	// beat, silent, beat, then silent from 5s: stacks at 10s, dump at 30s
+	want := []call{
+		{ActionStacks, 10 * time.Second},
+		{ActionDump, 30 * time.Second},
+	}
+
+	if !reflect.DeepEqual(calls, want) {
+		t.Fatalf("calls %v, want %v", calls, want)
+	}
+
+	if errs != 2 {
+		t.Fatalf("errors reported %d", errs)
+	}
+}
+
+func TestWatchdogSourceError(t *testing.T) {
+	clock := newFakeClock()
+	start := clock.Now()
+
+	var reported error
+	w := &Watchdog{
+		Source:  &scriptSource{clock: clock, err: errors.New("wait failed")},
+		Policy:  NewPolicy(Config{Ladder: ladder}, clock),
+		OnError: func(err error) { reported = err },
+	}
+
+	w.Step(time.Second)
+
+	if reported == nil || clock.Now().Sub(start) != time.Second {
+		t.Fatalf("reported %v after %v", reported, clock.Now().Sub(start))
+	}
+}
+
+func TestPollSource(t *testing.T) {
+	clock := newFakeClock()
+	alive := false
+	source := &PollSource{Alive: func() bool { return alive }, Clock: clock}
+
+	if beat, _ := source.Wait(time.Second); beat {
+		t.Fatal("beat while not alive")
+	}
+
+	alive = true
+	if beat, _ := source.Wait(time.Second); !beat {
+		t.Fatal("no beat while alive")
+	}
+
+	if clock.Now().Sub(newFakeClock().Now()) != 2*time.Second {
+		t.Fatal("PollSource did not sleep")
+	}
+}
+
+func TestBeater(t *testing.T) {
+	b := NewBeater()
+	b.Beat()
+	b.Beat()
+
+	if beat, _ := b.Wait(time.Second); !beat {
+		t.Fatal("missed beat")
+	}
+
+	if beat, _ := b.Wait(time.Millisecond); beat {
+		t.Fatal("beats were not coalesced")
+	}
+}
@@ -0,0 +1,205 @@
+//  ---------------------------------------------------------------------------
+//
+//  policy.go
+//
+//  Copyright (c) 2016, Jared Chavez.
+//  All rights reserved.
+//
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+//
+//  -----------
+
+package watchdog
+
+import (
+	"fmt"
+	"time"
+)
+
+// Clock abstracts time so the policy can be tested with a fake clock.
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+}
+
+// SystemClock is the real clock.
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time        { return time.Now() }
+func (SystemClock) Sleep(d time.Duration) { time.Sleep(d) }
+
+// Action is something to do about a hang.
+type Action int
+
+const (
+	// ActionStacks captures the stacks of every thread.
+	ActionStacks Action = iota + 1
+
+	// ActionDump writes a minidump.
+	ActionDump
+)
+
+func (a Action) String() string {
+	switch a {
+	case ActionStacks:
+		return "stacks"
+	case ActionDump:
+		return "dump"
+	}
+
+	return fmt.Sprintf("Action(%d)", int(a))
+}
+
+// Step is a rung of the escalation ladder: Action is taken once the
+// heartbeat has been silent for After.
+type Step struct {
+	After  time.Duration
+	Action Action
+}
+
+// DefaultLadder captures stacks after 30 seconds of silence and writes a
+// dump after a minute.
+var DefaultLadder = []Step{
+	{After: 30 * time.Second, Action: ActionStacks},
+	{After: 60 * time.Second, Action: ActionDump},
+}
+
+// Config controls a Policy.
+type Config struct {
+	// Ladder is the escalation ladder, in increasing order of After. Nil
+	// uses DefaultLadder.
+	Ladder []Step
+
+	// Cooldown is how long after a hang ends, by the heartbeat resuming,
+	// before a new hang is acted on. It keeps a process that flaps between
+	// hung and healthy from producing a dump every cycle.
+	Cooldown time.Duration
+
+	// MaxActions limits how many actions are taken within Window; further
+	// actions are suppressed. Zero disables the limit.
+	MaxActions int
+	Window     time.Duration
+}
+
+// Stats counts what a Policy has done.
+type Stats struct {
+	Hangs      int
+	Actions    int
+	Suppressed int
+}
+
+// Policy decides when to act on a silent heartbeat. It is driven by Beat
+// and Check and keeps no goroutines, so it is deterministic under a fake
+// clock.
+type Policy struct {
+	cfg   Config
+	clock Clock
+
+	lastBeat      time.Time
+	step          int
+	acted         bool
+	cooldownUntil time.Time
+	history       []time.Time
+	stats         Stats
+}
+
+// NewPolicy returns a policy that treats the current time as the last
+// heartbeat.
+func NewPolicy(cfg Config, clock Clock) *Policy {
+	if cfg.Ladder == nil {
+		cfg.Ladder = DefaultLadder
+	}
+
+	if clock == nil {
+		clock = SystemClock{}
+	}
+
+	return &Policy{
+		cfg:      cfg,
+		clock:    clock,
+		lastBeat: clock.Now(),
+	}
+}
+
+// Beat records a heartbeat. If a hang that was acted on was in progress it
+// is over, and the cooldown starts.
+func (p *Policy) Beat() {
+	now := p.clock.Now()
+
+	if p.acted {
+		p.cooldownUntil = now.Add(p.cfg.Cooldown)
+	}
+
+	p.lastBeat = now
+	p.step = 0
+	p.acted = false
+}
+
+// Silence returns how long the heartbeat has been silent.
+func (p *Policy) Silence() time.Duration {
+	return p.clock.Now().Sub(p.lastBeat)
+}
+
+// Hung reports whether at least one rung of the ladder has been reached
+// since the last heartbeat.
+func (p *Policy) Hung() bool {
+	return p.step > 0
+}
+
+// Stats returns the counters.
+func (p *Policy) Stats() Stats {
+	return p.stats
+}
+
+// Check returns the actions that are due. Each rung fires at most once per
+// hang; rungs that fall due while cooling down or rate limited are
+// skipped rather than deferred, so stale actions never pile up.
+func (p *Policy) Check() []Action {
+	now := p.clock.Now()
+	silence := now.Sub(p.lastBeat)
+
+	var due []Action
+	for p.step < len(p.cfg.Ladder) && silence >= p.cfg.Ladder[p.step].After {
+		action := p.cfg.Ladder[p.step].Action
+		if p.step == 0 {
+			p.stats.Hangs++
+		}
+		p.step++
+
+		if now.Before(p.cooldownUntil) || !p.allow(now) {
+			p.stats.Suppressed++
+			continue
+		}
+
+		p.stats.Actions++
+		p.acted = true
+		due = append(due, action)
+	}
+
+	return due
+}
+
+// allow applies the rate limit, recording the action when allowed.
+func (p *Policy) allow(now time.Time) bool {
+	if p.cfg.MaxActions <= 0 {
+		return true
+	}
+
+	cutoff := now.Add(-p.cfg.Window)
+
+	kept := p.history[:0]
+	for _, t := range p.history {
+		if t.After(cutoff) {
+			kept = append(kept, t)
+		}
+	}
+	p.history = kept
+
+	if len(p.history) >= p.cfg.MaxActions {
+		return false
+	}
+
+	p.history = append(p.history, now)
+	return true
+}
@@ -0,0 +1,144 @@
+//  ---------------------------------------------------------------------------
+//
+//  watchdog.go
+//
+//  Copyright (c) 2016, Jared Chavez.
+//  All rights reserved.
+//
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+//
+//  -----------
+
+// Package watchdog detects hung processes by watching a heartbeat, and
+// captures thread stacks and minidumps of them when the heartbeat stops.
+//
+// The escalation policy is portable; the named event heartbeat and the
+// stack and dump actions are Windows only.
+package watchdog
+
+import (
+	"context"
+	"time"
+)
+
+// Source delivers heartbeats.
+type Source interface {
+	// Wait blocks until a heartbeat arrives or timeout passes, and
+	// reports whether a heartbeat arrived.
+	Wait(timeout time.Duration) (bool, error)
+}
+
+// PollSource is a Source backed by a callback that reports whether the
+// process is alive. Wait sleeps for the timeout and then calls Alive.
+type PollSource struct {
+	Alive func() bool
+	Clock Clock
+}
+
+func (s *PollSource) Wait(timeout time.Duration) (bool, error) {
+	clock := s.Clock
+	if clock == nil {
+		clock = SystemClock{}
+	}
+
+	clock.Sleep(timeout)
+	return s.Alive(), nil
+}
+
+// Beater is an in-process Source: code that makes progress calls Beat.
+type Beater struct {
+	beats chan struct{}
+}
+
+// NewBeater returns a Beater.
+func NewBeater() *Beater {
+	return &Beater{beats: make(chan struct{}, 1)}
+}
+
+// Beat records a heartbeat. It never blocks.
+func (b *Beater) Beat() {
+	select {
+	case b.beats <- struct{}{}:
+	default:
+	}
+}
+
+func (b *Beater) Wait(timeout time.Duration) (bool, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-b.beats:
+		return true, nil
+	case <-timer.C:
+		return false, nil
+	}
+}
+
+// Watchdog ties a Source to a Policy and performs the policy's actions.
+type Watchdog struct {
+	Source Source
+	Policy *Policy
+
+	// Interval is the longest Run waits for a heartbeat before checking
+	// the policy. Zero uses one second.
+	Interval time.Duration
+
+	// Act performs an action; silence is how long the heartbeat has been
+	// silent. Errors are passed to OnError and do not stop the watchdog.
+	Act func(action Action, silence time.Duration) error
+
+	// OnError, when set, receives errors from Source and Act.
+	OnError func(err error)
+}
+
+// Run watches the heartbeat until ctx is done.
+func (w *Watchdog) Run(ctx context.Context) error {
+	interval := w.Interval
+	if interval <= 0 {
+		interval = time.Second
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		default:
+		}
+
+		w.Step(interval)
+	}
+}
+
+// Step waits once for a heartbeat and performs any actions that fall due.
+// Run calls it in a loop; it is exported for callers that drive their own
+// loop.
+func (w *Watchdog) Step(interval time.Duration) {
+	beat, err := w.Source.Wait(interval)
+	if err != nil {
+		// don't spin on a source that fails immediately
+		w.report(err)
+		w.Policy.clock.Sleep(interval)
+	}
+
+	if beat {
+		w.Policy.Beat()
+	}
+
+	for _, action := range w.Policy.Check() {
+		if w.Act == nil {
+			continue
+		}
+
+		if err := w.Act(action, w.Policy.Silence()); err != nil {
+			w.report(err)
+		}
+	}
+}
+
+func (w *Watchdog) report(err error) {
+	if w.OnError != nil {
+		w.OnError(err)
+	}
+}
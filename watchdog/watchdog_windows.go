@@ -0,0 +1,184 @@
+//  ---------------------------------------------------------------------------
+//
+//  watchdog_windows.go
+//
+//  Copyright (c) 2016, Jared Chavez.
+//  All rights reserved.
+//
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+//
+//  -----------
+
+package watchdog
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/xaevman/win32/dbgHelp"
+	"github.com/xaevman/win32/kernel32"
+	"github.com/xaevman/win32/stacks"
+)
+
+// EventSource receives heartbeats through a named auto-reset event, which
+// the watched process signals with an EventBeater.
+type EventSource struct {
+	event uintptr
+}
+
+// NewEventSource creates the named event, such as `Global\myservice-beat`.
+func NewEventSource(name string) (*EventSource, error) {
+	event, err := kernel32.CreateEvent(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &EventSource{event: event}, nil
+}
+
+func (s *EventSource) Wait(timeout time.Duration) (bool, error) {
+	ret, err := syscall.WaitForSingleObject(
+		syscall.Handle(s.event),
+		uint32(timeout/time.Millisecond),
+	)
+
+	switch ret {
+	case syscall.WAIT_OBJECT_0:
+		return true, nil
+	case syscall.WAIT_TIMEOUT:
+		return false, nil
+	}
+
+	return false, err
+}
+
+func (s *EventSource) Close() error {
+	return kernel32.CloseHandle(s.event)
+}
+
+// EventBeater signals an EventSource from the watched process.
+type EventBeater struct {
+	event uintptr
+}
+
+// OpenEventBeater opens the event created by NewEventSource.
+func OpenEventBeater(name string) (*EventBeater, error) {
+	event, err := kernel32.OpenEvent(false, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return &EventBeater{event: event}, nil
+}
+
+// Beat signals a heartbeat.
+func (b *EventBeater) Beat() error {
+	return kernel32.SetEvent(b.event)
+}
+
+func (b *EventBeater) Close() error {
+	return kernel32.CloseHandle(b.event)
+}
+
+// ProcessActions performs watchdog actions on another process, writing
+// stack reports and dumps to Dir.
+type ProcessActions struct {
+	PID uint32
+	Dir string
+
+	// DumpType is a MINIDUMP_TYPE. Zero uses MiniDumpWithThreadInfo and
+	// MiniDumpWithIndirectlyReferencedMemory.
+	DumpType uint32
+
+	// Snapshot dumps from a PssCaptureSnapshot clone, so the process is
+	// only paused while the clone is taken rather than for the whole dump.
+	Snapshot bool
+
+	// Stacks is passed to stacks.CaptureStacks.
+	Stacks *stacks.Options
+
+	// OnFile, when set, is called with each file written.
+	OnFile func(action Action, path string)
+}
+
+// Act implements Watchdog.Act.
+func (a *ProcessActions) Act(action Action, silence time.Duration) error {
+	base := filepath.Join(
+		a.Dir,
+		fmt.Sprintf("hang-%d-%s", a.PID, time.Now().Format("20060102-150405")),
+	)
+
+	var path string
+	var err error
+
+	switch action {
+	case ActionStacks:
+		path = base + ".txt"
+		err = a.writeStacks(path, silence)
+	case ActionDump:
+		path = base + ".dmp"
+		err = a.writeDump(path)
+	default:
+		return fmt.Errorf("unsupported action %v", action)
+	}
+
+	if err != nil {
+		return fmt.Errorf("%v for process %d: %v", action, a.PID, err)
+	}
+
+	if a.OnFile != nil {
+		a.OnFile(action, path)
+	}
+
+	return nil
+}
+
+func (a *ProcessActions) writeStacks(path string, silence time.Duration) error {
+	threads, err := stacks.CaptureStacks(a.PID, a.Stacks)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	fmt.Fprintf(f, "process %d, heartbeat silent for %v\n\n", a.PID, silence)
+
+	return stacks.Format(f, threads)
+}
+
+func (a *ProcessActions) writeDump(path string) error {
+	access := dbg.MiniDumpAccess
+	if a.Snapshot {
+		access = dbg.SnapshotAccess
+	}
+
+	proc, err := kernel32.OpenProcess(a.PID, access)
+	if err != nil {
+		return err
+	}
+	defer syscall.CloseHandle(proc)
+
+	dumpType := a.DumpType
+	if dumpType == 0 {
+		dumpType = dbg.MiniDumpWithThreadInfo | dbg.MiniDumpWithIndirectlyReferencedMemory
+	}
+
+	if a.Snapshot {
+		err = dbg.WriteMiniDumpSnapshot(proc, a.PID, dumpType, path, nil)
+	} else {
+		err = dbg.WriteMiniDump(proc, a.PID, dumpType, path)
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+
+	return err
+}
@@ -0,0 +1,224 @@
+//  ---------------------------------------------------------------------------
+//
+//  all_test.go
+//
+//  Copyright (c) 2016, Jared Chavez.
+//  All rights reserved.
+//
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+//
+//  -----------
+
+package wer
+
+import (
+	"strings"
+	"testing"
+)
+
+func count(n uint32) *uint32             { return &n }
+func dumpType(t DumpType) *DumpType      { return &t }
+func flags(f MinidumpType) *MinidumpType { return &f }
+
+func TestMinidumpType(t *testing.T) {
+	tests := []struct {
+		flags MinidumpType
+		text  string
+	}{
+		{MiniDumpNormal, "MiniDumpNormal"},
+		{MiniDumpWithFullMemory, "MiniDumpWithFullMemory"},
+		{MiniDumpWithDataSegs | MiniDumpWithHandleData, "MiniDumpWithDataSegs|MiniDumpWithHandleData"},
+		{MiniDumpWithThreadInfo | 0x80000000, "MiniDumpWithThreadInfo|0x80000000"},
+	}
+
+	for _, test := range tests {
+		if got := test.flags.String(); got != test.text {
+			t.Errorf("String(%#x) = %q, want %q", uint32(test.flags), got, test.text)
+		}
+
+		parsed, err := ParseMinidumpType(test.text)
+		if err != nil || parsed != test.flags {
+			t.Errorf("Parse(%q) = %#x, %v", test.text, uint32(parsed), err)
+		}
+	}
+
+	if _, err := ParseMinidumpType("MiniDumpWithEverything"); err == nil {
+		t.Error("expected error for unknown flag")
+	}
+
+	if f, _ := ParseMinidumpType("minidumpwithdatasegs | 0x4"); f != MiniDumpWithDataSegs|MiniDumpWithHandleData {
+		t.Errorf("case-insensitive parse = %s", f)
+	}
+}
+
+func TestDumpType(t *testing.T) {
+	for _, want := range []DumpType{DumpCustom, DumpMini, DumpFull} {
+		got, err := ParseDumpType(strings.ToUpper(want.String()))
+		if err != nil || got != want {
+			t.Errorf("ParseDumpType(%s) = %v, %v", want, got, err)
+		}
+	}
+
+	if s := DumpType(7).String(); s != "DumpType(7)" {
+		t.Errorf("String = %q", s)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		settings Settings
+		ok       bool
+	}{
+		{Settings{}, true},
+		{Settings{DumpCount: count(5), DumpType: dumpType(DumpFull)}, true},
+		{Settings{DumpType: dumpType(DumpCustom), CustomDumpFlags: flags(MiniDumpWithFullMemory)}, true},
+		{Settings{CustomDumpFlags: flags(MiniDumpWithThreadInfo)}, true},
+		{Settings{DumpCount: count(0)}, false},
+		{Settings{DumpType: dumpType(3)}, false},
+		{Settings{DumpType: dumpType(DumpMini), CustomDumpFlags: flags(MiniDumpWithThreadInfo)}, false},
+		{Settings{CustomDumpFlags: flags(0x01000000)}, false},
+	}
+
+	for _, test := range tests {
+		if err := test.settings.Validate(); (err == nil) != test.ok {
+			t.Errorf("Validate(%s) = %v", &test.settings, err)
+		}
+	}
+}
+
+func TestSetApp(t *testing.T) {
+	reg := NewMemRegistry()
+	l := &LocalDumps{Registry: reg}
+
+	err := l.SetApp("app.exe", &Settings{
+		DumpFolder:      `%ProgramData%\app\dumps`,
+		DumpCount:       count(3),
+		DumpType:        dumpType(DumpCustom),
+		CustomDumpFlags: flags(MiniDumpWithDataSegs | MiniDumpWithThreadInfo),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := LocalDumpsKey + `\app.exe`
+	want := map[string]Value{
+		"DumpFolder":      {Type: ExpandString, Str: `%ProgramData%\app\dumps`},
+		"DumpCount":       {Type: DWORD, Num: 3},
+		"DumpType":        {Type: DWORD, Num: 0},
+		"CustomDumpFlags": {Type: DWORD, Num: 0x1001},
+	}
+	for name, v := range want {
+		got, err := reg.GetValue(key, name)
+		if err != nil || got != v {
+			t.Errorf("%s = %+v, %v; want %+v", name, got, err, v)
+		}
+	}
+
+	s, err := l.App("APP.EXE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.String() != `DumpFolder=%ProgramData%\app\dumps DumpCount=3 DumpType=custom CustomDumpFlags=MiniDumpWithDataSegs|MiniDumpWithThreadInfo` {
+		t.Errorf("App = %s", s)
+	}
+
+	// replacing drops values that are no longer set
+	if err := l.SetApp("app.exe", &Settings{DumpType: dumpType(DumpFull)}); err != nil {
+		t.Fatal(err)
+	}
+	if s, _ = l.App("app.exe"); s.String() != "DumpType=full" {
+		t.Errorf("App after replace = %s", s)
+	}
+
+	if err := l.SetApp("app.exe", &Settings{DumpCount: count(0)}); err == nil {
+		t.Error("expected validation error")
+	}
+	if err := l.SetApp(`C:\bin\app.exe`, &Settings{}); err == nil {
+		t.Error("expected error for a path")
+	}
+}
+
+func TestEffective(t *testing.T) {
+	reg := NewMemRegistry()
+	l := &LocalDumps{Registry: reg}
+
+	s, enabled, err := l.Effective("app.exe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if enabled || s.String() != Defaults().String() {
+		t.Errorf("empty registry: enabled %v, %s", enabled, s)
+	}
+
+	// an empty app key enables dumps with defaults
+	if err := l.SetApp("app.exe", &Settings{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, enabled, _ = l.Effective("app.exe"); !enabled {
+		t.Error("app key did not enable dumps")
+	}
+
+	if err := l.SetGlobal(&Settings{DumpFolder: `D:\dumps`, DumpCount: count(50)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.SetApp("app.exe", &Settings{DumpCount: count(2), DumpType: dumpType(DumpFull)}); err != nil {
+		t.Fatal(err)
+	}
+
+	s, enabled, err = l.Effective("app.exe")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `DumpFolder=D:\dumps DumpCount=2 DumpType=full CustomDumpFlags=` + DefaultCustomDumpFlags.String()
+	if !enabled || s.String() != want {
+		t.Errorf("Effective = %v %s, want %s", enabled, s, want)
+	}
+
+	// another executable only gets the global settings
+	s, enabled, _ = l.Effective("other.exe")
+	if !enabled || *s.DumpCount != 50 || *s.DumpType != DefaultDumpType {
+		t.Errorf("other.exe: %v %s", enabled, s)
+	}
+}
+
+func TestRemoveApp(t *testing.T) {
+	l := &LocalDumps{Registry: NewMemRegistry()}
+
+	for _, exe := range []string{"b.exe", "a.exe"} {
+		if err := l.SetApp(exe, &Settings{DumpCount: count(1)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	apps, err := l.Apps()
+	if err != nil || strings.Join(apps, ",") != "a.exe,b.exe" {
+		t.Fatalf("Apps = %v, %v", apps, err)
+	}
+
+	if err := l.RemoveApp("a.exe"); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.RemoveApp("missing.exe"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := l.App("a.exe"); err != ErrNotConfigured {
+		t.Errorf("App after remove: %v", err)
+	}
+
+	if apps, _ = l.Apps(); strings.Join(apps, ",") != "b.exe" {
+		t.Errorf("Apps = %v", apps)
+	}
+}
+
+func TestWrongValueType(t *testing.T) {
+	reg := NewMemRegistry()
+	l := &LocalDumps{Registry: reg}
+
+	reg.SetValue(LocalDumpsKey+`\app.exe`, "DumpCount", Value{Type: String, Str: "10"})
+
+	if _, err := l.App("app.exe"); err == nil {
+		t.Error("expected type error")
+	}
+}
@@ -0,0 +1,161 @@
+//  ---------------------------------------------------------------------------
+//
+//  dumptype.go
+//
+//  Copyright (c) 2016, Jared Chavez.
+//  All rights reserved.
+//
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+//
+//  -----------
+
+package wer
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DumpType is the LocalDumps DumpType value.
+type DumpType uint32
+
+const (
+	// DumpCustom writes a dump of the type given by CustomDumpFlags.
+	DumpCustom DumpType = 0
+	DumpMini   DumpType = 1
+	DumpFull   DumpType = 2
+)
+
+var dumpTypeNames = []string{"custom", "mini", "full"}
+
+func (t DumpType) String() string {
+	if int(t) < len(dumpTypeNames) {
+		return dumpTypeNames[t]
+	}
+
+	return fmt.Sprintf("DumpType(%d)", uint32(t))
+}
+
+// ParseDumpType parses a name returned by DumpType.String.
+func ParseDumpType(s string) (DumpType, error) {
+	for i, name := range dumpTypeNames {
+		if strings.EqualFold(s, name) {
+			return DumpType(i), nil
+		}
+	}
+
+	return 0, fmt.Errorf("wer: unknown dump type %q", s)
+}
+
+// MinidumpType is a set of MINIDUMP_TYPE flags, for CustomDumpFlags. The
+// values match the dbg package's, which only builds on Windows.
+type MinidumpType uint32
+
+const (
+	MiniDumpNormal                         MinidumpType = 0x00000000
+	MiniDumpWithDataSegs                   MinidumpType = 0x00000001
+	MiniDumpWithFullMemory                 MinidumpType = 0x00000002
+	MiniDumpWithHandleData                 MinidumpType = 0x00000004
+	MiniDumpFilterMemory                   MinidumpType = 0x00000008
+	MiniDumpScanMemory                     MinidumpType = 0x00000010
+	MiniDumpWithUnloadedModules            MinidumpType = 0x00000020
+	MiniDumpWithIndirectlyReferencedMemory MinidumpType = 0x00000040
+	MiniDumpFilterModulePaths              MinidumpType = 0x00000080
+	MiniDumpWithProcessThreadData          MinidumpType = 0x00000100
+	MiniDumpWithPrivateReadWriteMemory     MinidumpType = 0x00000200
+	MiniDumpWithoutOptionalData            MinidumpType = 0x00000400
+	MiniDumpWithFullMemoryInfo             MinidumpType = 0x00000800
+	MiniDumpWithThreadInfo                 MinidumpType = 0x00001000
+	MiniDumpWithCodeSegs                   MinidumpType = 0x00002000
+	MiniDumpWithoutAuxiliaryState          MinidumpType = 0x00004000
+	MiniDumpWithFullAuxiliaryState         MinidumpType = 0x00008000
+	MiniDumpWithPrivateWriteCopyMemory     MinidumpType = 0x00010000
+	MiniDumpIgnoreInaccessibleMemory       MinidumpType = 0x00020000
+	MiniDumpWithTokenInformation           MinidumpType = 0x00040000
+	MiniDumpWithModuleHeaders              MinidumpType = 0x00080000
+	MiniDumpFilterTriage                   MinidumpType = 0x00100000
+	MiniDumpWithAvxXStateContext           MinidumpType = 0x00200000
+	MiniDumpValidTypeFlags                 MinidumpType = 0x003fffff
+)
+
+var minidumpFlagNames = []struct {
+	flag MinidumpType
+	name string
+}{
+	{MiniDumpWithDataSegs, "MiniDumpWithDataSegs"},
+	{MiniDumpWithFullMemory, "MiniDumpWithFullMemory"},
+	{MiniDumpWithHandleData, "MiniDumpWithHandleData"},
+	{MiniDumpFilterMemory, "MiniDumpFilterMemory"},
+	{MiniDumpScanMemory, "MiniDumpScanMemory"},
+	{MiniDumpWithUnloadedModules, "MiniDumpWithUnloadedModules"},
+	{MiniDumpWithIndirectlyReferencedMemory, "MiniDumpWithIndirectlyReferencedMemory"},
+	{MiniDumpFilterModulePaths, "MiniDumpFilterModulePaths"},
+	{MiniDumpWithProcessThreadData, "MiniDumpWithProcessThreadData"},
+	{MiniDumpWithPrivateReadWriteMemory, "MiniDumpWithPrivateReadWriteMemory"},
+	{MiniDumpWithoutOptionalData, "MiniDumpWithoutOptionalData"},
+	{MiniDumpWithFullMemoryInfo, "MiniDumpWithFullMemoryInfo"},
+	{MiniDumpWithThreadInfo, "MiniDumpWithThreadInfo"},
+	{MiniDumpWithCodeSegs, "MiniDumpWithCodeSegs"},
+	{MiniDumpWithoutAuxiliaryState, "MiniDumpWithoutAuxiliaryState"},
+	{MiniDumpWithFullAuxiliaryState, "MiniDumpWithFullAuxiliaryState"},
+	{MiniDumpWithPrivateWriteCopyMemory, "MiniDumpWithPrivateWriteCopyMemory"},
+	{MiniDumpIgnoreInaccessibleMemory, "MiniDumpIgnoreInaccessibleMemory"},
+	{MiniDumpWithTokenInformation, "MiniDumpWithTokenInformation"},
+	{MiniDumpWithModuleHeaders, "MiniDumpWithModuleHeaders"},
+	{MiniDumpFilterTriage, "MiniDumpFilterTriage"},
+	{MiniDumpWithAvxXStateContext, "MiniDumpWithAvxXStateContext"},
+}
+
+// String returns the flag names joined by "|", with any unknown bits in
+// hex, or "MiniDumpNormal" for zero.
+func (t MinidumpType) String() string {
+	if t == MiniDumpNormal {
+		return "MiniDumpNormal"
+	}
+
+	var names []string
+	rest := t
+	for _, f := range minidumpFlagNames {
+		if t&f.flag != 0 {
+			names = append(names, f.name)
+			rest &^= f.flag
+		}
+	}
+
+	if rest != 0 {
+		names = append(names, fmt.Sprintf("0x%x", uint32(rest)))
+	}
+
+	return strings.Join(names, "|")
+}
+
+// ParseMinidumpType parses flag names or numbers joined by "|", as
+// returned by MinidumpType.String.
+func ParseMinidumpType(s string) (MinidumpType, error) {
+	var t MinidumpType
+
+parts:
+	for _, part := range strings.Split(s, "|") {
+		part = strings.TrimSpace(part)
+		if strings.EqualFold(part, "MiniDumpNormal") {
+			continue
+		}
+
+		for _, f := range minidumpFlagNames {
+			if strings.EqualFold(part, f.name) {
+				t |= f.flag
+				continue parts
+			}
+		}
+
+		n, err := strconv.ParseUint(part, 0, 32)
+		if err != nil {
+			return 0, fmt.Errorf("wer: unknown minidump flag %q", part)
+		}
+		t |= MinidumpType(n)
+	}
+
+	return t, nil
+}
@@ -0,0 +1,350 @@
+//  ---------------------------------------------------------------------------
+//
+//  localdumps.go
+//
+//  Copyright (c) 2016, Jared Chavez.
+//  All rights reserved.
+//
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+//
+//  -----------
+
+// Package wer manages the Windows Error Reporting LocalDumps settings,
+// which make WER write a dump when a process crashes. Settings live under
+// HKLM\SOFTWARE\Microsoft\Windows\Windows Error Reporting\LocalDumps, in a
+// subkey per executable name, and values missing from an executable's key
+// are inherited from the LocalDumps key itself.
+package wer
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// LocalDumpsKey is the LocalDumps key, relative to HKEY_LOCAL_MACHINE.
+const LocalDumpsKey = `SOFTWARE\Microsoft\Windows\Windows Error Reporting\LocalDumps`
+
+// registry value names
+const (
+	valueDumpFolder      = "DumpFolder"
+	valueDumpCount       = "DumpCount"
+	valueDumpType        = "DumpType"
+	valueCustomDumpFlags = "CustomDumpFlags"
+)
+
+// WER's defaults for values that are set nowhere.
+const (
+	DefaultDumpFolder      = `%LOCALAPPDATA%\CrashDumps`
+	DefaultDumpCount       = 10
+	DefaultDumpType        = DumpMini
+	DefaultCustomDumpFlags = MiniDumpWithDataSegs |
+		MiniDumpWithUnloadedModules |
+		MiniDumpWithProcessThreadData
+)
+
+// ErrNotConfigured is returned for an executable without a LocalDumps key.
+var ErrNotConfigured = errors.New("wer: executable has no LocalDumps settings")
+
+// Settings are LocalDumps values. Empty and nil fields are not set, and
+// are inherited.
+type Settings struct {
+	// DumpFolder may contain environment variables; it is stored as
+	// REG_EXPAND_SZ.
+	DumpFolder string
+
+	// DumpCount is the number of dumps kept in DumpFolder.
+	DumpCount *uint32
+
+	DumpType *DumpType
+
+	// CustomDumpFlags is used when DumpType is DumpCustom.
+	CustomDumpFlags *MinidumpType
+}
+
+// Validate checks the settings for values WER would not accept.
+func (s *Settings) Validate() error {
+	if s.DumpCount != nil && *s.DumpCount == 0 {
+		return errors.New("wer: DumpCount must be at least 1")
+	}
+
+	if s.DumpType != nil && *s.DumpType > DumpFull {
+		return fmt.Errorf("wer: invalid DumpType %d", uint32(*s.DumpType))
+	}
+
+	if s.CustomDumpFlags != nil {
+		if *s.CustomDumpFlags&^MiniDumpValidTypeFlags != 0 {
+			return fmt.Errorf("wer: invalid CustomDumpFlags %s", *s.CustomDumpFlags)
+		}
+
+		if s.DumpType != nil && *s.DumpType != DumpCustom {
+			return fmt.Errorf("wer: CustomDumpFlags set with DumpType %s", *s.DumpType)
+		}
+	}
+
+	return nil
+}
+
+// String formats the settings that are set, for logs and tools.
+func (s *Settings) String() string {
+	var parts []string
+	if s.DumpFolder != "" {
+		parts = append(parts, "DumpFolder="+s.DumpFolder)
+	}
+	if s.DumpCount != nil {
+		parts = append(parts, fmt.Sprintf("DumpCount=%d", *s.DumpCount))
+	}
+	if s.DumpType != nil {
+		parts = append(parts, "DumpType="+s.DumpType.String())
+	}
+	if s.CustomDumpFlags != nil {
+		parts = append(parts, "CustomDumpFlags="+s.CustomDumpFlags.String())
+	}
+
+	return strings.Join(parts, " ")
+}
+
+// merge fills the fields s does not set from other.
+func (s *Settings) merge(other *Settings) {
+	if s.DumpFolder == "" {
+		s.DumpFolder = other.DumpFolder
+	}
+	if s.DumpCount == nil {
+		s.DumpCount = other.DumpCount
+	}
+	if s.DumpType == nil {
+		s.DumpType = other.DumpType
+	}
+	if s.CustomDumpFlags == nil {
+		s.CustomDumpFlags = other.CustomDumpFlags
+	}
+}
+
+// Defaults returns WER's built-in settings.
+func Defaults() *Settings {
+	count := uint32(DefaultDumpCount)
+	dumpType := DefaultDumpType
+	flags := DefaultCustomDumpFlags
+
+	return &Settings{
+		DumpFolder:      DefaultDumpFolder,
+		DumpCount:       &count,
+		DumpType:        &dumpType,
+		CustomDumpFlags: &flags,
+	}
+}
+
+// LocalDumps reads and writes LocalDumps settings through a Registry
+// rooted at HKEY_LOCAL_MACHINE.
+type LocalDumps struct {
+	Registry Registry
+}
+
+// appKey returns the key for an executable, which must be a bare file name
+// such as "app.exe".
+func appKey(exe string) (string, error) {
+	if exe == "" || strings.ContainsAny(exe, `\/`) {
+		return "", fmt.Errorf("wer: %q is not an executable name", exe)
+	}
+
+	return LocalDumpsKey + `\` + exe, nil
+}
+
+// Global returns the settings on the LocalDumps key, which apply to every
+// executable. It is not an error for the key to be missing.
+func (l *LocalDumps) Global() (*Settings, error) {
+	return l.read(LocalDumpsKey)
+}
+
+// SetGlobal replaces the settings on the LocalDumps key. Settings that are
+// not set are removed.
+func (l *LocalDumps) SetGlobal(s *Settings) error {
+	return l.write(LocalDumpsKey, s)
+}
+
+// App returns the settings set for an executable, or ErrNotConfigured.
+func (l *LocalDumps) App(exe string) (*Settings, error) {
+	key, err := appKey(exe)
+	if err != nil {
+		return nil, err
+	}
+
+	exists, err := l.Registry.KeyExists(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if !exists {
+		return nil, ErrNotConfigured
+	}
+
+	return l.read(key)
+}
+
+// SetApp replaces the settings for an executable, creating its key.
+// Settings that are not set are removed, so that they are inherited.
+func (l *LocalDumps) SetApp(exe string, s *Settings) error {
+	key, err := appKey(exe)
+	if err != nil {
+		return err
+	}
+
+	return l.write(key, s)
+}
+
+// RemoveApp deletes the key for an executable, so that it falls back to
+// the global settings.
+func (l *LocalDumps) RemoveApp(exe string) error {
+	key, err := appKey(exe)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range []string{valueDumpFolder, valueDumpCount, valueDumpType, valueCustomDumpFlags} {
+		if err := l.Registry.DeleteValue(key, name); err != nil {
+			return err
+		}
+	}
+
+	return l.Registry.DeleteKey(key)
+}
+
+// Apps returns the executables that have their own key.
+func (l *LocalDumps) Apps() ([]string, error) {
+	apps, err := l.Registry.SubKeys(LocalDumpsKey)
+	if err == ErrNotExist {
+		return nil, nil
+	}
+
+	return apps, err
+}
+
+// Effective returns the settings WER uses for an executable: its own,
+// then the global ones, then the defaults. Local dumps are only written
+// for an executable with its own key or when the LocalDumps key exists;
+// enabled reports which.
+func (l *LocalDumps) Effective(exe string) (settings *Settings, enabled bool, err error) {
+	settings, err = l.App(exe)
+	switch err {
+	case nil:
+		enabled = true
+	case ErrNotConfigured:
+		settings = &Settings{}
+		if enabled, err = l.Registry.KeyExists(LocalDumpsKey); err != nil {
+			return nil, false, err
+		}
+	default:
+		return nil, false, err
+	}
+
+	global, err := l.Global()
+	if err != nil {
+		return nil, false, err
+	}
+
+	settings.merge(global)
+	settings.merge(Defaults())
+
+	return settings, enabled, nil
+}
+
+func (l *LocalDumps) read(key string) (*Settings, error) {
+	var s Settings
+
+	v, err := l.getValue(key, valueDumpFolder, String, ExpandString)
+	if err != nil {
+		return nil, err
+	}
+	if v != nil {
+		s.DumpFolder = v.Str
+	}
+
+	if v, err = l.getValue(key, valueDumpCount, DWORD); err != nil {
+		return nil, err
+	}
+	if v != nil {
+		count := v.Num
+		s.DumpCount = &count
+	}
+
+	if v, err = l.getValue(key, valueDumpType, DWORD); err != nil {
+		return nil, err
+	}
+	if v != nil {
+		dumpType := DumpType(v.Num)
+		s.DumpType = &dumpType
+	}
+
+	if v, err = l.getValue(key, valueCustomDumpFlags, DWORD); err != nil {
+		return nil, err
+	}
+	if v != nil {
+		flags := MinidumpType(v.Num)
+		s.CustomDumpFlags = &flags
+	}
+
+	return &s, nil
+}
+
+// getValue returns nil for a missing value and an error for one of the
+// wrong type.
+func (l *LocalDumps) getValue(key, name string, types ...ValueType) (*Value, error) {
+	v, err := l.Registry.GetValue(key, name)
+	if err == ErrNotExist {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	for _, t := range types {
+		if v.Type == t {
+			return &v, nil
+		}
+	}
+
+	return nil, fmt.Errorf("wer: %s\\%s has unexpected type %d", key, name, v.Type)
+}
+
+func (l *LocalDumps) write(key string, s *Settings) error {
+	if err := s.Validate(); err != nil {
+		return err
+	}
+
+	set := func(name string, present bool, v Value) error {
+		if !present {
+			return l.Registry.DeleteValue(key, name)
+		}
+		return l.Registry.SetValue(key, name, v)
+	}
+
+	var count, dumpType, flags uint32
+	if s.DumpCount != nil {
+		count = *s.DumpCount
+	}
+	if s.DumpType != nil {
+		dumpType = uint32(*s.DumpType)
+	}
+	if s.CustomDumpFlags != nil {
+		flags = uint32(*s.CustomDumpFlags)
+	}
+
+	// the key must exist even when nothing is set: its presence is what
+	// enables local dumps
+	if err := l.Registry.CreateKey(key); err != nil {
+		return err
+	}
+
+	if err := set(valueDumpFolder, s.DumpFolder != "", Value{Type: ExpandString, Str: s.DumpFolder}); err != nil {
+		return err
+	}
+	if err := set(valueDumpCount, s.DumpCount != nil, Value{Type: DWORD, Num: count}); err != nil {
+		return err
+	}
+	if err := set(valueDumpType, s.DumpType != nil, Value{Type: DWORD, Num: dumpType}); err != nil {
+		return err
+	}
+
+	return set(valueCustomDumpFlags, s.CustomDumpFlags != nil, Value{Type: DWORD, Num: flags})
+}
@@ -0,0 +1,208 @@
+//  ---------------------------------------------------------------------------
+//
+//  registry.go
+//
+//  Copyright (c) 2016, Jared Chavez.
+//  All rights reserved.
+//
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+//
+//  -----------
+
+package wer
+
+import (
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrNotExist is returned by a Registry for a missing key or value.
+var ErrNotExist = errors.New("wer: registry key or value does not exist")
+
+// ValueType is a registry value type. Only the types WER settings use are
+// supported.
+type ValueType uint32
+
+const (
+	String       ValueType = 1 // REG_SZ
+	ExpandString ValueType = 2 // REG_EXPAND_SZ
+	DWORD        ValueType = 4 // REG_DWORD
+)
+
+// Value is a registry value. Str holds String and ExpandString data, Num
+// DWORD data.
+type Value struct {
+	Type ValueType
+	Str  string
+	Num  uint32
+}
+
+// Registry is access to one registry hive. Key paths are relative to the
+// hive, separated by backslashes, and like key and value names compare
+// case-insensitively.
+type Registry interface {
+	// GetValue returns ErrNotExist when the key or value is missing.
+	GetValue(key, name string) (Value, error)
+
+	// SetValue creates the key, and any missing parents, if needed.
+	SetValue(key, name string, value Value) error
+
+	// DeleteValue does nothing when the key or value is missing.
+	DeleteValue(key, name string) error
+
+	// CreateKey creates key, and any missing parents, if needed.
+	CreateKey(key string) error
+
+	// KeyExists reports whether key exists.
+	KeyExists(key string) (bool, error)
+
+	// DeleteKey removes a key that has no subkeys. It does nothing when
+	// the key is missing.
+	DeleteKey(key string) error
+
+	// SubKeys returns the names of the immediate subkeys of key, or
+	// ErrNotExist when it is missing.
+	SubKeys(key string) ([]string, error)
+}
+
+// MemRegistry is an in-memory Registry, for tests.
+type MemRegistry struct {
+	mu   sync.Mutex
+	keys map[string]*memKey
+}
+
+type memKey struct {
+	name   string
+	values map[string]memValue
+}
+
+type memValue struct {
+	name  string
+	value Value
+}
+
+// NewMemRegistry returns an empty registry.
+func NewMemRegistry() *MemRegistry {
+	return &MemRegistry{keys: make(map[string]*memKey)}
+}
+
+func fold(s string) string {
+	return strings.ToLower(strings.Trim(s, `\`))
+}
+
+func (r *MemRegistry) GetValue(key, name string) (Value, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	k, ok := r.keys[fold(key)]
+	if !ok {
+		return Value{}, ErrNotExist
+	}
+
+	v, ok := k.values[strings.ToLower(name)]
+	if !ok {
+		return Value{}, ErrNotExist
+	}
+
+	return v.value, nil
+}
+
+func (r *MemRegistry) SetValue(key, name string, value Value) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	k := r.create(key)
+	k.values[strings.ToLower(name)] = memValue{name: name, value: value}
+
+	return nil
+}
+
+// create makes key and its parents, keeping the case of existing keys.
+func (r *MemRegistry) create(key string) *memKey {
+	parts := strings.Split(strings.Trim(key, `\`), `\`)
+
+	var k *memKey
+	for i := range parts {
+		path := strings.Join(parts[:i+1], `\`)
+
+		var ok bool
+		if k, ok = r.keys[fold(path)]; !ok {
+			k = &memKey{name: parts[i], values: make(map[string]memValue)}
+			r.keys[fold(path)] = k
+		}
+	}
+
+	return k
+}
+
+func (r *MemRegistry) CreateKey(key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.create(key)
+	return nil
+}
+
+func (r *MemRegistry) DeleteValue(key, name string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if k, ok := r.keys[fold(key)]; ok {
+		delete(k.values, strings.ToLower(name))
+	}
+
+	return nil
+}
+
+func (r *MemRegistry) KeyExists(key string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	_, ok := r.keys[fold(key)]
+	return ok, nil
+}
+
+func (r *MemRegistry) DeleteKey(key string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	path := fold(key)
+	if _, ok := r.keys[path]; !ok {
+		return nil
+	}
+
+	if len(r.subKeys(path)) > 0 {
+		return errors.New("wer: key has subkeys")
+	}
+
+	delete(r.keys, path)
+	return nil
+}
+
+func (r *MemRegistry) SubKeys(key string) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	path := fold(key)
+	if _, ok := r.keys[path]; !ok {
+		return nil, ErrNotExist
+	}
+
+	return r.subKeys(path), nil
+}
+
+func (r *MemRegistry) subKeys(path string) []string {
+	var names []string
+	for p, k := range r.keys {
+		rest := strings.TrimPrefix(p, path+`\`)
+		if rest != p && !strings.Contains(rest, `\`) {
+			names = append(names, k.name)
+		}
+	}
+	sort.Strings(names)
+
+	return names
+}
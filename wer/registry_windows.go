@@ -0,0 +1,195 @@
+//  ---------------------------------------------------------------------------
+//
+//  registry_windows.go
+//
+//  Copyright (c) 2016, Jared Chavez.
+//  All rights reserved.
+//
+//  Use of this source code is governed by a BSD-style
+//  license that can be found in the LICENSE file.
+//
+//  -----------
+
+package wer
+
+import (
+	"encoding/binary"
+	"fmt"
+	"syscall"
+	"unicode/utf16"
+
+	"github.com/xaevman/win32/advapi32"
+)
+
+// SystemRegistry is the HKEY_LOCAL_MACHINE hive. It always uses the 64-bit
+// view, which is where WER reads its settings, even from a 32-bit process.
+// Writing requires administrator rights.
+type SystemRegistry struct{}
+
+// System returns the machine's LocalDumps settings.
+func System() *LocalDumps {
+	return &LocalDumps{Registry: SystemRegistry{}}
+}
+
+func regErr(err error) error {
+	if err == advapi32.ERROR_FILE_NOT_FOUND {
+		return ErrNotExist
+	}
+
+	return err
+}
+
+func (SystemRegistry) open(key string, access uint32) (syscall.Handle, error) {
+	h, err := advapi32.RegOpenKeyEx(
+		advapi32.HKEY_LOCAL_MACHINE,
+		key,
+		access|advapi32.KEY_WOW64_64KEY,
+	)
+
+	return h, regErr(err)
+}
+
+func (r SystemRegistry) GetValue(key, name string) (Value, error) {
+	h, err := r.open(key, advapi32.KEY_QUERY_VALUE)
+	if err != nil {
+		return Value{}, err
+	}
+	defer advapi32.RegCloseKey(h)
+
+	valType, data, err := advapi32.RegQueryValueEx(h, name)
+	if err != nil {
+		return Value{}, regErr(err)
+	}
+
+	v := Value{Type: ValueType(valType)}
+	switch valType {
+	case advapi32.REG_SZ, advapi32.REG_EXPAND_SZ:
+		u := make([]uint16, len(data)/2)
+		for i := range u {
+			u[i] = binary.LittleEndian.Uint16(data[2*i:])
+		}
+		for len(u) > 0 && u[len(u)-1] == 0 {
+			u = u[:len(u)-1]
+		}
+		v.Str = string(utf16.Decode(u))
+
+	case advapi32.REG_DWORD:
+		if len(data) < 4 {
+			return Value{}, fmt.Errorf("wer: short DWORD value %s", name)
+		}
+		v.Num = binary.LittleEndian.Uint32(data)
+	}
+
+	return v, nil
+}
+
+func (r SystemRegistry) SetValue(key, name string, value Value) error {
+	h, err := advapi32.RegCreateKeyEx(
+		advapi32.HKEY_LOCAL_MACHINE,
+		key,
+		advapi32.KEY_SET_VALUE|advapi32.KEY_WOW64_64KEY,
+	)
+	if err != nil {
+		return err
+	}
+	defer advapi32.RegCloseKey(h)
+
+	var data []byte
+	switch value.Type {
+	case String, ExpandString:
+		u, err := syscall.UTF16FromString(value.Str)
+		if err != nil {
+			return err
+		}
+		data = make([]byte, 2*len(u))
+		for i, c := range u {
+			binary.LittleEndian.PutUint16(data[2*i:], c)
+		}
+
+	case DWORD:
+		data = make([]byte, 4)
+		binary.LittleEndian.PutUint32(data, value.Num)
+
+	default:
+		return fmt.Errorf("wer: unsupported value type %d", value.Type)
+	}
+
+	return advapi32.RegSetValueEx(h, name, uint32(value.Type), data)
+}
+
+func (r SystemRegistry) DeleteValue(key, name string) error {
+	h, err := r.open(key, advapi32.KEY_SET_VALUE)
+	if err == ErrNotExist {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer advapi32.RegCloseKey(h)
+
+	if err := regErr(advapi32.RegDeleteValue(h, name)); err != ErrNotExist {
+		return err
+	}
+
+	return nil
+}
+
+func (r SystemRegistry) CreateKey(key string) error {
+	h, err := advapi32.RegCreateKeyEx(
+		advapi32.HKEY_LOCAL_MACHINE,
+		key,
+		advapi32.KEY_CREATE_SUB_KEY|advapi32.KEY_WOW64_64KEY,
+	)
+	if err != nil {
+		return err
+	}
+
+	return advapi32.RegCloseKey(h)
+}
+
+func (r SystemRegistry) KeyExists(key string) (bool, error) {
+	h, err := r.open(key, advapi32.KEY_QUERY_VALUE)
+	if err == ErrNotExist {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	advapi32.RegCloseKey(h)
+	return true, nil
+}
+
+func (r SystemRegistry) DeleteKey(key string) error {
+	err := regErr(advapi32.RegDeleteKeyEx(
+		advapi32.HKEY_LOCAL_MACHINE,
+		key,
+		advapi32.KEY_WOW64_64KEY,
+	))
+	if err == ErrNotExist {
+		return nil
+	}
+
+	return err
+}
+
+func (r SystemRegistry) SubKeys(key string) ([]string, error) {
+	h, err := r.open(key, advapi32.KEY_ENUMERATE_SUB_KEYS)
+	if err != nil {
+		return nil, err
+	}
+	defer advapi32.RegCloseKey(h)
+
+	var names []string
+	for i := uint32(0); ; i++ {
+		name, err := advapi32.RegEnumKeyEx(h, i)
+		if err == advapi32.ERROR_NO_MORE_ITEMS {
+			return names, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		names = append(names, name)
+	}
+}